@@ -13,6 +13,7 @@ import {
   Receipt,
   SendMessageRequest,
   GetMessagesRequest,
+  MessagePage,
   SendReceiptRequest,
   UpdateProfileRequest,
   CreateGroupRequest,
@@ -234,8 +235,8 @@ class ApiService {
     });
   }
 
-  async getBootstrapKeys(userId: string): Promise<{ device_keys: DeviceKey[]; one_time_keys: OneTimeKey[] }> {
-    return this.request<{ device_keys: DeviceKey[]; one_time_keys: OneTimeKey[] }>(`/keys/bootstrap?user_id=${userId}`);
+  async getBootstrapKeys(userId: string): Promise<{ device_keys: DeviceKey[]; one_time_key: OneTimeKey | null }> {
+    return this.request<{ device_keys: DeviceKey[]; one_time_key: OneTimeKey | null }>(`/keys/bootstrap?user_id=${userId}`);
   }
 
   // Message endpoints
@@ -246,15 +247,16 @@ class ApiService {
     });
   }
 
-  async getMessages(params: GetMessagesRequest): Promise<Message[]> {
+  async getMessages(params: GetMessagesRequest): Promise<MessagePage> {
     const searchParams = new URLSearchParams({
       ...(params.recipient_id && { recipient_id: params.recipient_id }),
       ...(params.group_id && { group_id: params.group_id }),
-      ...(params.since && { since: params.since }),
+      ...(params.before && { before: params.before }),
+      ...(params.after && { after: params.after }),
       ...(params.limit && { limit: params.limit.toString() }),
     });
 
-    return this.request<Message[]>(`/messages?${searchParams}`);
+    return this.request<MessagePage>(`/messages?${searchParams}`);
   }
 
   async sendReceipt(data: SendReceiptRequest): Promise<Receipt> {
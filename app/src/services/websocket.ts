@@ -73,6 +73,11 @@ class WebSocketService {
       }
       
       this.eventHandlers.onMessage?.(message);
+
+      // Confirm delivery, or the server keeps redelivering the event
+      if (message.id) {
+        this.sendAck(message.id);
+      }
     } catch (error) {
       console.error('Failed to parse WebSocket message:', error);
       this.eventHandlers.onError?.(error as Error);
@@ -172,6 +177,13 @@ class WebSocketService {
     this.setStatus('disconnected');
   }
 
+  private sendAck(id: string) {
+    this.send({
+      type: 'ack',
+      payload: { id },
+    });
+  }
+
   // Public methods for sending specific message types
   sendMessageReceived(messageId: string) {
     this.send({
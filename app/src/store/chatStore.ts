@@ -16,7 +16,7 @@ interface ChatState {
 
   // Actions
   loadChats: () => Promise<void>;
-  loadMessages: (params: { recipientId?: string; groupId?: string; since?: string }) => Promise<void>;
+  loadMessages: (params: { recipientId?: string; groupId?: string; after?: string }) => Promise<void>;
   sendMessage: (targetId: string, content: string, type: 'text' | 'file' | 'system', isGroup: boolean) => Promise<void>;
   sendFileMessage: (targetId: string, fileUri: string, fileName: string, fileType: string, isGroup: boolean) => Promise<void>;
   setCurrentChat: (chat: Chat | null) => void;
@@ -55,16 +55,17 @@ export const useChatStore = create<ChatState>((set, get) => ({
     }
   },
 
-  loadMessages: async (params: { recipientId?: string; groupId?: string; since?: string }) => {
+  loadMessages: async (params: { recipientId?: string; groupId?: string; after?: string }) => {
     set({ isLoading: true, error: null });
     
     try {
       const { user } = useAuthStore.getState();
-      const messages = await apiService.getMessages({ 
+      const page = await apiService.getMessages({ 
         recipient_id: params.recipientId, 
         group_id: params.groupId, 
+        after: params.after,
         limit: 50 });
-      const processedMessages = (messages || []).map(msg => {
+      const processedMessages = (page?.messages || []).map(msg => {
         // If the message is from the other person, we don't show a status.
         // If it's our message, we can assume 'delivered' if it's coming from the server.
         // A more robust system would store this on the server.
@@ -1,4 +1,4 @@
-import { User } from './index';
+import { Message, User } from './index';
 
 export interface DeviceKey {
   id: string;
@@ -67,16 +67,25 @@ export interface SendMessageRequest {
 export interface GetMessagesRequest {
   recipient_id?: string;
   group_id?: string;
-  since?: string;
+  // An RFC3339 timestamp, a message ID or a next_cursor
+  before?: string;
+  after?: string;
   limit?: number;
 }
 
+export interface MessagePage {
+  messages: Message[];
+  next_cursor?: string;
+}
+
 export interface SendReceiptRequest {
   message_id: string;
   type: 'delivered' | 'read';
 }
 
 export interface WebSocketMessage {
-  type: 'new_message' | 'message_receipt' | 'message_received' | 'message_delivered' | 'message_read' | 'ping' | 'pong';
+  // Envelope ID the server expects back in an 'ack' to stop redelivering the event
+  id?: string;
+  type: 'new_message' | 'message_receipt' | 'message_received' | 'message_delivered' | 'message_read' | 'ping' | 'pong' | 'ack';
   payload: any;
 }
\ No newline at end of file
@@ -0,0 +1,82 @@
+package apperrors
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Error is a domain-level error with a stable machine-readable code and the
+// HTTP status it should be reported with
+type Error struct {
+	code    string
+	status  int
+	message string
+}
+
+// New creates a new domain error
+func New(code string, status int, message string) *Error {
+	return &Error{code: code, status: status, message: message}
+}
+
+// Error returns the human-readable message
+func (e *Error) Error() string {
+	return e.message
+}
+
+// Code returns the machine-readable error code
+func (e *Error) Code() string {
+	return e.code
+}
+
+// HTTPStatus returns the HTTP status code for the error
+func (e *Error) HTTPStatus() int {
+	return e.status
+}
+
+// Sentinel errors for the data layer. Wrap them with fmt.Errorf("...: %w", err)
+// to add context; errors.Is and the helpers below still see through the wrapping.
+var (
+	ErrInvalidInput      = New("invalid_input", http.StatusBadRequest, "Invalid input")
+	ErrUnauthorized      = New("unauthorized", http.StatusUnauthorized, "Not authenticated")
+	ErrForbidden         = New("forbidden", http.StatusForbidden, "You are not allowed to do this")
+	ErrNotFound          = New("not_found", http.StatusNotFound, "Resource not found")
+	ErrConflict          = New("conflict", http.StatusConflict, "Resource already exists")
+	ErrUserNotFound      = New("user_not_found", http.StatusNotFound, "User not found")
+	ErrRecipientNotFound = New("recipient_not_found", http.StatusNotFound, "Recipient not found")
+	ErrMessageNotFound   = New("message_not_found", http.StatusNotFound, "Message not found")
+	ErrGroupNotFound     = New("group_not_found", http.StatusNotFound, "Group not found")
+	ErrNotGroupMember    = New("not_group_member", http.StatusForbidden, "You are not a member of this group")
+	ErrPinLimitReached   = New("pin_limit_reached", http.StatusConflict, "Too many pinned conversations, unpin one first")
+	ErrReactionLimit     = New("reaction_limit_reached", http.StatusConflict, "Too many reactions to this message, remove one first")
+	ErrKeysExhausted     = New("keys_exhausted", http.StatusNotFound, "No unused one-time keys available")
+	ErrDeviceNotFound    = New("device_not_found", http.StatusNotFound, "Device not found")
+	ErrRateLimited       = New("rate_limited", http.StatusTooManyRequests, "Too many requests, try again later")
+	ErrInternal          = New("internal_error", http.StatusInternalServerError, "Internal server error")
+	ErrUnavailable       = New("service_unavailable", http.StatusServiceUnavailable, "Service temporarily unavailable, try again later")
+)
+
+// lookup finds the domain error in err's chain, falling back to ErrInternal
+func lookup(err error) *Error {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	return ErrInternal
+}
+
+// HTTPStatus maps err to an HTTP status code. Errors outside the domain
+// vocabulary are treated as internal errors.
+func HTTPStatus(err error) int {
+	return lookup(err).HTTPStatus()
+}
+
+// Code maps err to its machine-readable code
+func Code(err error) string {
+	return lookup(err).Code()
+}
+
+// Message returns the message that is safe to show to clients. Internal errors
+// never leak their underlying cause.
+func Message(err error) string {
+	return lookup(err).Error()
+}
@@ -0,0 +1,66 @@
+package apperrors
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestSentinelMapping(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{ErrInvalidInput, http.StatusBadRequest, "invalid_input"},
+		{ErrUnauthorized, http.StatusUnauthorized, "unauthorized"},
+		{ErrForbidden, http.StatusForbidden, "forbidden"},
+		{ErrNotFound, http.StatusNotFound, "not_found"},
+		{ErrConflict, http.StatusConflict, "conflict"},
+		{ErrUserNotFound, http.StatusNotFound, "user_not_found"},
+		{ErrRecipientNotFound, http.StatusNotFound, "recipient_not_found"},
+		{ErrMessageNotFound, http.StatusNotFound, "message_not_found"},
+		{ErrGroupNotFound, http.StatusNotFound, "group_not_found"},
+		{ErrNotGroupMember, http.StatusForbidden, "not_group_member"},
+		{ErrPinLimitReached, http.StatusConflict, "pin_limit_reached"},
+		{ErrKeysExhausted, http.StatusNotFound, "keys_exhausted"},
+		{ErrRateLimited, http.StatusTooManyRequests, "rate_limited"},
+		{ErrInternal, http.StatusInternalServerError, "internal_error"},
+		{ErrUnavailable, http.StatusServiceUnavailable, "service_unavailable"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := HTTPStatus(tt.err); got != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, got)
+			}
+			if got := Code(tt.err); got != tt.code {
+				t.Errorf("Expected code %s, got %s", tt.code, got)
+			}
+
+			wrapped := fmt.Errorf("loading group: %w", tt.err)
+			if !errors.Is(wrapped, tt.err) {
+				t.Error("Expected wrapped error to match its sentinel")
+			}
+			if got := HTTPStatus(wrapped); got != tt.status {
+				t.Errorf("Expected wrapped status %d, got %d", tt.status, got)
+			}
+		})
+	}
+}
+
+func TestUnknownErrorsAreInternal(t *testing.T) {
+	err := fmt.Errorf("query failed: %w", sql.ErrConnDone)
+
+	if got := HTTPStatus(err); got != http.StatusInternalServerError {
+		t.Errorf("Expected status %d, got %d", http.StatusInternalServerError, got)
+	}
+	if got := Code(err); got != "internal_error" {
+		t.Errorf("Expected code internal_error, got %s", got)
+	}
+	if got := Message(err); got != ErrInternal.Error() {
+		t.Errorf("Expected the underlying cause to stay hidden, got %q", got)
+	}
+}
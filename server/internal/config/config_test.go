@@ -0,0 +1,91 @@
+package config
+
+import "testing"
+
+func TestMessageLimit(t *testing.T) {
+	cfg := &Config{DefaultMessageLimit: 20, MaxMessageLimit: 40}
+
+	tests := []struct {
+		name      string
+		requested int
+		expected  int
+	}{
+		{"no limit requested", 0, 20},
+		{"negative limit", -5, 20},
+		{"within bounds", 30, 30},
+		{"at the max", 40, 40},
+		{"above the max is clamped", 500, 40},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cfg.MessageLimit(tt.requested); got != tt.expected {
+				t.Errorf("Expected limit %d, got %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestLoadMessageLimits(t *testing.T) {
+	t.Setenv("MESSAGE_LIMIT_DEFAULT", "25")
+	t.Setenv("MESSAGE_LIMIT_MAX", "200")
+
+	cfg := Load()
+	if cfg.DefaultMessageLimit != 25 || cfg.MaxMessageLimit != 200 {
+		t.Errorf("Expected limits 25/200, got %d/%d", cfg.DefaultMessageLimit, cfg.MaxMessageLimit)
+	}
+
+	t.Setenv("MESSAGE_LIMIT_DEFAULT", "500")
+	cfg = Load()
+	if cfg.DefaultMessageLimit > cfg.MaxMessageLimit {
+		t.Errorf("Expected default %d to be capped by max %d", cfg.DefaultMessageLimit, cfg.MaxMessageLimit)
+	}
+}
+
+func TestLoadBlockedAttachmentExtensions(t *testing.T) {
+	t.Setenv("ATTACHMENT_BLOCKED_EXTENSIONS", " EXE, .Sh ,,")
+	cfg := Load()
+	if len(cfg.BlockedAttachmentExtensions) != 2 || cfg.BlockedAttachmentExtensions[0] != ".exe" || cfg.BlockedAttachmentExtensions[1] != ".sh" {
+		t.Errorf("Expected [.exe .sh], got %v", cfg.BlockedAttachmentExtensions)
+	}
+
+	t.Setenv("ATTACHMENT_BLOCKED_EXTENSIONS", "none")
+	if cfg := Load(); len(cfg.BlockedAttachmentExtensions) != 0 {
+		t.Errorf("Expected no blocked extensions, got %v", cfg.BlockedAttachmentExtensions)
+	}
+}
+
+func TestLoadUsernamePolicy(t *testing.T) {
+	t.Setenv("USERNAME_PATTERN", "([")
+	t.Setenv("USERNAME_RESERVED", "Admin, Staff")
+	cfg := Load()
+	if cfg.UsernamePattern.String() != defaultUsernamePattern {
+		t.Errorf("Expected an invalid pattern to fall back to the default, got %s", cfg.UsernamePattern)
+	}
+	if len(cfg.ReservedUsernames) != 2 || cfg.ReservedUsernames[0] != "admin" || cfg.ReservedUsernames[1] != "staff" {
+		t.Errorf("Expected [admin staff], got %v", cfg.ReservedUsernames)
+	}
+}
+
+func TestCheckStorageDirs(t *testing.T) {
+	tests := []struct {
+		name        string
+		avatars     string
+		attachments string
+		wantErr     bool
+	}{
+		{"defaults", "./uploads/avatars", "./uploads/attachments", false},
+		{"attachments inside avatars", "./uploads", "./uploads/attachments", true},
+		{"same directory", "./uploads", "uploads/", true},
+		{"sibling sharing a prefix", "./uploads", "./uploads-private", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{AvatarsDir: tt.avatars, AttachmentsDir: tt.attachments}
+			if err := cfg.CheckStorageDirs(); (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
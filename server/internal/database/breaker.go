@@ -0,0 +1,109 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"e2ee-messenger/server/internal/apperrors"
+
+	"github.com/lib/pq"
+)
+
+// ErrUnavailable is returned, without contacting the database, while the
+// circuit breaker is open
+var ErrUnavailable = fmt.Errorf("database unavailable: %w", apperrors.ErrUnavailable)
+
+// breaker is a circuit breaker around the connection pool. After threshold
+// consecutive connection failures it opens for cooldown, during which calls
+// fail fast; the first call after that is let through as a probe, closing the
+// breaker on success and reopening it on failure. A nil breaker never opens.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// open reports whether calls should fail fast, and for how much longer
+func (b *breaker) open() (time.Duration, bool) {
+	if b == nil {
+		return 0, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wait := time.Until(b.openUntil)
+	return wait, wait > 0
+}
+
+// record counts the outcome of a call. Only connection failures count against
+// the database; query errors such as constraint violations mean it is up.
+func (b *breaker) record(ctx context.Context, err error) {
+	if b == nil || ctx.Err() != nil {
+		// The caller gave up, which says nothing about the database
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !isConnectionError(err) {
+		if b.failures >= b.threshold {
+			log.Printf("Database is reachable again, closing the circuit breaker")
+		}
+		b.failures = 0
+		b.openUntil = time.Time{}
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		if b.failures == b.threshold {
+			log.Printf("Database unreachable after %d attempts, failing fast for %s: %v", b.failures, b.cooldown, err)
+		}
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// isConnectionError reports whether err means the database could not be
+// reached, as opposed to it rejecting a statement
+func isConnectionError(err error) bool {
+	if err == nil || errors.Is(err, sql.ErrNoRows) || errors.Is(err, sql.ErrTxDone) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// Class 08 is connection exceptions; 57P01-57P03 mean the server is shutting down or starting
+		return pqErr.Code.Class() == "08" || pqErr.Code == "57P01" || pqErr.Code == "57P02" || pqErr.Code == "57P03"
+	}
+	return false
+}
+
+// Unavailable reports whether the circuit breaker is open, and when it is, how
+// long until the database is tried again
+func (db *DB) Unavailable() (time.Duration, bool) {
+	return db.breaker.open()
+}
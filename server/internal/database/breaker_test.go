@@ -0,0 +1,109 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"e2ee-messenger/server/internal/apperrors"
+
+	"github.com/lib/pq"
+)
+
+// downDriver is a database driver whose statements fail as if the server
+// were unreachable, counting how often it is called
+type downDriver struct{ calls *atomic.Int32 }
+
+type downConn struct{ calls *atomic.Int32 }
+
+func (d downDriver) Open(string) (driver.Conn, error) { return downConn(d), nil }
+
+func (downConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (downConn) Close() error                        { return nil }
+func (downConn) Begin() (driver.Tx, error)           { return nil, driver.ErrSkip }
+
+func (c downConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	c.calls.Add(1)
+	return nil, &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+}
+
+var downCalls atomic.Int32
+
+func init() {
+	sql.Register("down", downDriver{calls: &downCalls})
+}
+
+func TestRepeatedFailuresTripTheBreaker(t *testing.T) {
+	conn, err := sql.Open("down", "")
+	if err != nil {
+		t.Fatalf("Failed to open test driver: %v", err)
+	}
+	defer conn.Close()
+	db := &DB{DB: conn, breaker: newBreaker(3, time.Minute)}
+	downCalls.Store(0)
+
+	for i := 0; i < 3; i++ {
+		if _, err := db.Exec("SELECT 1"); err == nil || errors.Is(err, ErrUnavailable) {
+			t.Fatalf("Expected attempt %d to reach the database and fail, got %v", i+1, err)
+		}
+	}
+	if _, down := db.Unavailable(); !down {
+		t.Fatal("Expected the breaker to open after 3 failures")
+	}
+
+	// Calls now fail fast without touching the database
+	calls := downCalls.Load()
+	_, err = db.Exec("SELECT 1")
+	if !errors.Is(err, ErrUnavailable) || apperrors.HTTPStatus(err) != 503 {
+		t.Fatalf("Expected a fast 503 error, got %v", err)
+	}
+	if _, err := db.BeginTx(context.Background(), nil); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected BeginTx to fail fast, got %v", err)
+	}
+	if err := db.QueryRow("SELECT 1").Scan(new(int)); err == nil {
+		t.Error("Expected QueryRow to fail while the breaker is open")
+	}
+	if got := downCalls.Load(); got != calls {
+		t.Errorf("Expected no calls to reach the database, got %d", got-calls)
+	}
+	if retryAfter, _ := db.Unavailable(); retryAfter <= 0 || retryAfter > time.Minute {
+		t.Errorf("Expected a retry-after within the cooldown, got %s", retryAfter)
+	}
+}
+
+func TestBreakerIgnoresQueryErrors(t *testing.T) {
+	b := newBreaker(1, time.Minute)
+	ctx := context.Background()
+
+	b.record(ctx, &pq.Error{Code: "23505"})
+	b.record(ctx, sql.ErrNoRows)
+	if _, open := b.open(); open {
+		t.Fatal("Expected query errors not to open the breaker")
+	}
+
+	// A cancelled caller says nothing about the database either
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	b.record(cancelled, driver.ErrBadConn)
+	if _, open := b.open(); open {
+		t.Fatal("Expected a cancelled call not to open the breaker")
+	}
+
+	b.record(ctx, &pq.Error{Code: "57P01"})
+	if _, open := b.open(); !open {
+		t.Fatal("Expected a server shutdown to open the breaker")
+	}
+
+	// A successful probe closes it again
+	b.openUntil = time.Now()
+	b.record(ctx, nil)
+	if _, open := b.open(); open || b.failures != 0 {
+		t.Error("Expected a success to close the breaker")
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"strconv"
+	"time"
 
 	_ "github.com/lib/pq"
 )
@@ -11,10 +13,42 @@ import (
 // DB wraps the database connection
 type DB struct {
 	*sql.DB
+
+	// Queries at least this slow are logged; zero disables the log
+	slowQueryThreshold time.Duration
+	// Fails calls fast while the database is unreachable; nil never does
+	breaker *breaker
+}
+
+// Options tunes a database connection
+type Options struct {
+	// Server-side limit on every statement; zero leaves the server default
+	StatementTimeout time.Duration
+	// Queries at least this slow are logged; zero disables the log
+	SlowQueryThreshold time.Duration
+	// After this many consecutive connection failures calls fail fast with
+	// ErrUnavailable for BreakerCooldown; zero disables the circuit breaker
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
 }
 
-// New creates a new database connection
+// New creates a new database connection with default options
 func New(databaseURL string) (*DB, error) {
+	return Open(databaseURL, Options{})
+}
+
+// Open creates a new database connection
+func Open(databaseURL string, opts Options) (*DB, error) {
+	// Sessions run in UTC so timestamps are read back, and default to, UTC
+	params := map[string]string{"TimeZone": "UTC"}
+	if opts.StatementTimeout > 0 {
+		params["statement_timeout"] = strconv.FormatInt(opts.StatementTimeout.Milliseconds(), 10)
+	}
+	databaseURL, err := withRuntimeParams(databaseURL, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply connection parameters: %w", err)
+	}
+
 	db, err := sql.Open("postgres", databaseURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
@@ -28,7 +62,11 @@ func New(databaseURL string) (*DB, error) {
 	db.SetMaxOpenConns(25)
 	db.SetMaxIdleConns(25)
 
-	return &DB{db}, nil
+	return &DB{
+		DB:                 db,
+		slowQueryThreshold: opts.SlowQueryThreshold,
+		breaker:            newBreaker(opts.BreakerThreshold, opts.BreakerCooldown),
+	}, nil
 }
 
 // Migrate runs database migrations
@@ -42,6 +80,45 @@ func Migrate(db *DB) error {
 		createMessagesTable,
 		createReceiptsTable,
 		createAttachmentsTable,
+		createSessionsTable,
+		createOutboxTable,
+		addNotificationLevels,
+		addOneTimeKeyDevices,
+		createBlocksTable,
+		createConversationSettingsTable,
+		addReadReceiptPreference,
+		createPinnedConversationsTable,
+		addDeliveryStatus,
+		createMessageDeliveriesTable,
+		createPushTokensTable,
+		createGroupAuditLogTable,
+		addKeyBootstrapPreference,
+		addSystemMessagePayload,
+		addSystemMessagesWithoutSender,
+		addMessageDeletedAt,
+		createAttachmentBlobsTable,
+		createConversationCryptoTable,
+		addMessagePriority,
+		addTokensValidAfter,
+		createArchivedConversationsTable,
+		addGroupEncryptedMetadata,
+		addOutboxTraceParent,
+		createDeadLettersTable,
+		createInviteCodesTable,
+		addDeviceKeyChangedAt,
+		addPinnedPosition,
+		addMessageViewOnce,
+		createGroupInvitationsTable,
+		createRevokedTokensTable,
+		createRefreshTokensTable,
+		createMessageEditsTable,
+		addMessageQueuedAt,
+		addLastSeen,
+		addSessionInstance,
+		createReactionsTable,
+		createMessageExpiry,
+		copyMessageTTLs,
+		createMaintenanceTable,
 		createIndexes,
 	}
 
@@ -50,11 +127,109 @@ func Migrate(db *DB) error {
 			return fmt.Errorf("failed to execute migration: %w", err)
 		}
 	}
+	if err := createCaseInsensitiveIndexes(db); err != nil {
+		return err
+	}
+	if err := createTrigramIndexes(db); err != nil {
+		return err
+	}
 
 	log.Println("Database migrations completed successfully")
 	return nil
 }
 
+// caseInsensitiveIndexes make usernames and emails unique regardless of case
+var caseInsensitiveIndexes = []struct{ name, column string }{
+	{"idx_users_username_lower", "username"},
+	{"idx_users_email_lower", "email"},
+}
+
+// createCaseInsensitiveIndexes creates the unique LOWER() indexes on users.
+// Accounts created before they existed may differ only by case, which would
+// fail the index and with it every boot. Such an index is left out instead,
+// and the accounts logged for an operator to merge or rename; signup and
+// profile updates compare case-insensitively, so no new duplicates appear in
+// the meantime.
+func createCaseInsensitiveIndexes(db *DB) error {
+	for _, index := range caseInsensitiveIndexes {
+		var exists bool
+		if err := db.QueryRow("SELECT to_regclass($1) IS NOT NULL", index.name).Scan(&exists); err != nil {
+			return fmt.Errorf("failed to look up index %s: %w", index.name, err)
+		}
+		if exists {
+			continue
+		}
+
+		rows, err := db.Query(`
+			SELECT LOWER(` + index.column + `), string_agg(id::text, ', ' ORDER BY created_at)
+			FROM users GROUP BY 1 HAVING COUNT(*) > 1
+		`)
+		if err != nil {
+			return fmt.Errorf("failed to look for duplicate %ss: %w", index.column, err)
+		}
+		duplicates := 0
+		for rows.Next() {
+			var value, userIDs string
+			if err := rows.Scan(&value, &userIDs); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan duplicate %s: %w", index.column, err)
+			}
+			log.Printf("Warning: users %s share the %s %q apart from case", userIDs, index.column, value)
+			duplicates++
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to look for duplicate %ss: %w", index.column, err)
+		}
+		if duplicates > 0 {
+			log.Printf("Warning: not creating %s until %d duplicate %ss are resolved", index.name, duplicates, index.column)
+			continue
+		}
+
+		if _, err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS ` + index.name + ` ON users(LOWER(` + index.column + `))`); err != nil {
+			return fmt.Errorf("failed to create index %s: %w", index.name, err)
+		}
+	}
+	return nil
+}
+
+// trigramIndexes let username and group name searches use ILIKE '%...%'
+// without a sequential scan
+const trigramIndexes = `
+CREATE INDEX IF NOT EXISTS idx_users_username_trgm ON users USING gin (username gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_groups_name_trgm ON groups USING gin (name gin_trgm_ops);
+`
+
+// createTrigramIndexes enables the pg_trgm extension and creates the
+// trigram indexes. Creating the extension takes privileges the database
+// user may not have; without them, and unless a superuser has already
+// created it, the indexes are left out and searches scan the tables.
+func createTrigramIndexes(db *DB) error {
+	if _, err := db.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm"); err != nil {
+		log.Printf("Warning: not creating trigram search indexes, pg_trgm could not be enabled: %v", err)
+		return nil
+	}
+	if _, err := db.Exec(trigramIndexes); err != nil {
+		return fmt.Errorf("failed to create trigram indexes: %w", err)
+	}
+	return nil
+}
+
+// CloseOrphanedSessions marks sessions left open by a previous run of the
+// instance as closed. No connection survives a restart, so none of them can
+// still be active; other instances' sessions are left alone. Sessions
+// recorded before instances were, which no instance claims, are closed too.
+func CloseOrphanedSessions(db *DB, instanceID string) error {
+	_, err := db.Exec(`
+		UPDATE sessions SET closed_at = NOW()
+		WHERE closed_at IS NULL AND (instance_id = $1 OR instance_id IS NULL)
+	`, instanceID)
+	if err != nil {
+		return fmt.Errorf("failed to close orphaned sessions: %w", err)
+	}
+	return nil
+}
+
 const createUsersTable = `
 CREATE TABLE IF NOT EXISTS users (
     id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
@@ -150,6 +325,367 @@ CREATE TABLE IF NOT EXISTS attachments (
 );
 `
 
+const createSessionsTable = `
+CREATE TABLE IF NOT EXISTS sessions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    remote_addr VARCHAR(255) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    origin TEXT NOT NULL DEFAULT '',
+    connected_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    last_active_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    closed_at TIMESTAMP WITH TIME ZONE
+);
+`
+
+const createOutboxTable = `
+CREATE TABLE IF NOT EXISTS outbox (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    event_type VARCHAR(50) NOT NULL,
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    delivered_at TIMESTAMP WITH TIME ZONE
+);
+`
+
+const addNotificationLevels = `
+ALTER TABLE group_members ADD COLUMN IF NOT EXISTS notification_level VARCHAR(20) NOT NULL DEFAULT 'all';
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS mentioned_user_ids UUID[];
+`
+
+const addOneTimeKeyDevices = `
+ALTER TABLE one_time_keys ADD COLUMN IF NOT EXISTS device_id VARCHAR(255);
+`
+
+const createBlocksTable = `
+CREATE TABLE IF NOT EXISTS blocks (
+    blocker_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    blocked_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (blocker_id, blocked_id)
+);
+`
+
+const createConversationSettingsTable = `
+CREATE TABLE IF NOT EXISTS conversation_settings (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    conversation_id UUID NOT NULL,
+    muted_until TIMESTAMP WITH TIME ZONE,
+    message_ttl_seconds INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (user_id, conversation_id)
+);
+`
+
+const addReadReceiptPreference = `
+ALTER TABLE users ADD COLUMN IF NOT EXISTS send_read_receipts BOOLEAN NOT NULL DEFAULT TRUE;
+`
+
+const createPinnedConversationsTable = `
+CREATE TABLE IF NOT EXISTS pinned_conversations (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    conversation_type VARCHAR(10) NOT NULL,
+    conversation_id UUID NOT NULL,
+    pinned_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (user_id, conversation_id)
+);
+`
+
+const addDeliveryStatus = `
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS delivery_status VARCHAR(20) NOT NULL DEFAULT 'sent';
+`
+
+const createMessageDeliveriesTable = `
+CREATE TABLE IF NOT EXISTS message_deliveries (
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_attempt_at TIMESTAMP WITH TIME ZONE,
+    pushed_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (message_id, user_id)
+);
+`
+
+const createPushTokensTable = `
+CREATE TABLE IF NOT EXISTS push_tokens (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device_id VARCHAR(255) NOT NULL,
+    platform VARCHAR(10) NOT NULL,
+    token TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (user_id, device_id)
+);
+`
+
+const createGroupAuditLogTable = `
+CREATE TABLE IF NOT EXISTS group_audit_log (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    group_id UUID NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    actor_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    action VARCHAR(50) NOT NULL,
+    target_id UUID,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+`
+
+const addKeyBootstrapPreference = `
+ALTER TABLE users ADD COLUMN IF NOT EXISTS allow_key_bootstrap BOOLEAN NOT NULL DEFAULT TRUE;
+`
+
+const addSystemMessagePayload = `
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS system_payload JSONB;
+`
+
+// System messages are authored by the server, not by the member whose action
+// they describe, so they have no sender and outlive that member's account.
+// The actor is kept in system_payload.
+const addSystemMessagesWithoutSender = `
+ALTER TABLE messages ALTER COLUMN sender_id DROP NOT NULL;
+UPDATE messages SET sender_id = NULL WHERE message_type = 'system' AND sender_id IS NOT NULL;
+`
+
+const addMessageDeletedAt = `
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+`
+
+const createAttachmentBlobsTable = `
+CREATE TABLE IF NOT EXISTS attachment_blobs (
+    content_hash CHAR(64) PRIMARY KEY,
+    storage_path TEXT NOT NULL,
+    ref_count INTEGER NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS content_hash CHAR(64);
+`
+
+// A conversation's encryption scheme is keyed by its group, or for a direct
+// conversation by its two users in ascending order
+const createConversationCryptoTable = `
+CREATE TABLE IF NOT EXISTS conversation_crypto (
+    group_id UUID REFERENCES groups(id) ON DELETE CASCADE,
+    user_a UUID REFERENCES users(id) ON DELETE CASCADE,
+    user_b UUID REFERENCES users(id) ON DELETE CASCADE,
+    scheme VARCHAR(64) NOT NULL,
+    negotiated_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    negotiated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CHECK ((group_id IS NOT NULL AND user_a IS NULL AND user_b IS NULL) OR (group_id IS NULL AND user_a < user_b))
+);
+`
+
+const addMessagePriority = `
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS priority VARCHAR(10) NOT NULL DEFAULT 'normal';
+`
+
+// Tokens issued at or before tokens_valid_after (whole seconds, matching
+// the JWT iat claim) are rejected; logging out everywhere moves it forward
+const addTokensValidAfter = `
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tokens_valid_after TIMESTAMP WITH TIME ZONE;
+`
+
+const createArchivedConversationsTable = `
+CREATE TABLE IF NOT EXISTS archived_conversations (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    conversation_type VARCHAR(10) NOT NULL,
+    conversation_id UUID NOT NULL,
+    archived_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (user_id, conversation_id)
+);
+`
+
+const addGroupEncryptedMetadata = `
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS encrypted_metadata TEXT;
+`
+
+const addOutboxTraceParent = `
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS trace_parent TEXT;
+`
+
+const createDeadLettersTable = `
+CREATE TABLE IF NOT EXISTS dead_letters (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    event_id VARCHAR(64) NOT NULL,
+    event_type VARCHAR(50) NOT NULL,
+    message_id UUID,
+    reason VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+`
+
+const createInviteCodesTable = `
+CREATE TABLE IF NOT EXISTS invite_codes (
+    code VARCHAR(64) PRIMARY KEY,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    max_uses INTEGER NOT NULL DEFAULT 1,
+    use_count INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+`
+
+// key_changed_at records when a device last uploaded a different public key
+// than it had before, which peers see as an identity key change
+const addDeviceKeyChangedAt = `
+ALTER TABLE device_keys ADD COLUMN IF NOT EXISTS key_changed_at TIMESTAMP WITH TIME ZONE;
+`
+
+// Pinned conversations are listed by ascending position, then most recently
+// pinned first
+const addPinnedPosition = `
+ALTER TABLE pinned_conversations ADD COLUMN IF NOT EXISTS position INTEGER NOT NULL DEFAULT 0;
+`
+
+// View-once messages have their content wiped and consumed_at set when the
+// recipient reads them
+const addMessageViewOnce = `
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS view_once BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS consumed_at TIMESTAMP WITH TIME ZONE;
+`
+
+// A group admin's invitation for a user to join, pending until the user
+// accepts or declines it
+const createGroupInvitationsTable = `
+CREATE TABLE IF NOT EXISTS group_invitations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    group_id UUID NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    invitee_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    invited_by UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE(group_id, invitee_id)
+);
+`
+
+// Tokens revoked one at a time by logging out, keyed by their jti claim.
+// Rows are only needed until the token would have expired anyway.
+const createRevokedTokensTable = `
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    token_id VARCHAR(255) PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+`
+
+// Refresh tokens are stored as SHA-256 hashes. Each refresh rotates the token
+// within its family, the chain of tokens descending from one login; a token
+// that is used again after being rotated revokes the whole family.
+const createRefreshTokensTable = `
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device_id VARCHAR(255) NOT NULL,
+    family_id UUID NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    rotated_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+`
+
+// Edited messages keep every ciphertext they replaced. edited_at on the
+// message is the time of its latest edit.
+const createMessageEditsTable = `
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS edited_at TIMESTAMP WITH TIME ZONE;
+
+CREATE TABLE IF NOT EXISTS message_edits (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    previous_content TEXT NOT NULL,
+    edited_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+`
+
+// When a direct message was queued on one of its recipient's connections;
+// those without one are replayed when the recipient reconnects. Messages
+// from before the migration count as queued.
+const addMessageQueuedAt = `
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS queued_at TIMESTAMP WITH TIME ZONE DEFAULT NOW();
+ALTER TABLE messages ALTER COLUMN queued_at DROP DEFAULT;
+CREATE INDEX IF NOT EXISTS idx_messages_pending_direct ON messages(recipient_id, created_at)
+    WHERE group_id IS NULL AND queued_at IS NULL;
+`
+
+// When the user's last websocket connection went away
+const addLastSeen = `
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_seen TIMESTAMP WITH TIME ZONE;
+`
+
+// Each session records the instance holding its connection
+const addSessionInstance = `
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS instance_id VARCHAR(255);
+`
+
+// Emoji reactions to messages. The emoji is plaintext, not end-to-end encrypted.
+const createReactionsTable = `
+CREATE TABLE IF NOT EXISTS reactions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    emoji VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE(message_id, user_id, emoji)
+);
+`
+
+// Disappearing messages: a conversation's timer, keyed like
+// conversation_crypto, sets expires_at on the messages sent into it. It
+// supersedes the per-user conversation_settings.message_ttl_seconds, which
+// the other participants never saw.
+const createMessageExpiry = `
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP WITH TIME ZONE;
+
+CREATE TABLE IF NOT EXISTS conversation_ttls (
+    group_id UUID REFERENCES groups(id) ON DELETE CASCADE,
+    user_a UUID REFERENCES users(id) ON DELETE CASCADE,
+    user_b UUID REFERENCES users(id) ON DELETE CASCADE,
+    ttl_seconds INTEGER NOT NULL,
+    set_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CHECK ((group_id IS NOT NULL AND user_a IS NULL AND user_b IS NULL) OR (group_id IS NULL AND user_a < user_b))
+);
+`
+
+// Copies the per-user timers of conversation_settings.message_ttl_seconds
+// into conversation_ttls, where the most recently set one wins, and clears
+// them so a timer turned off later is not copied back
+const copyMessageTTLs = `
+INSERT INTO conversation_ttls (group_id, user_a, user_b, ttl_seconds, set_by, updated_at)
+SELECT DISTINCT ON (k.group_id, k.user_a, k.user_b)
+    k.group_id, k.user_a, k.user_b, LEAST(cs.message_ttl_seconds, 31536000), cs.user_id, cs.updated_at
+FROM conversation_settings cs
+CROSS JOIN LATERAL (
+    SELECT g.id AS group_id, NULL::uuid AS user_a, NULL::uuid AS user_b
+    FROM groups g WHERE g.id = cs.conversation_id
+    UNION ALL
+    SELECT NULL, LEAST(cs.user_id, u.id), GREATEST(cs.user_id, u.id)
+    FROM users u WHERE u.id = cs.conversation_id AND u.id != cs.user_id
+) k
+WHERE cs.message_ttl_seconds > 0
+  AND NOT EXISTS (
+    SELECT 1 FROM conversation_ttls t
+    WHERE t.group_id = k.group_id
+       OR (t.group_id IS NULL AND k.group_id IS NULL AND t.user_a = k.user_a AND t.user_b = k.user_b)
+  )
+ORDER BY k.group_id, k.user_a, k.user_b, cs.updated_at DESC;
+
+UPDATE conversation_settings SET message_ttl_seconds = 0 WHERE message_ttl_seconds > 0;
+`
+
+// The maintenance switch lives in a single row so every instance sees the
+// same state
+const createMaintenanceTable = `
+CREATE TABLE IF NOT EXISTS maintenance (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    message TEXT NOT NULL DEFAULT '',
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+INSERT INTO maintenance (id) VALUES (TRUE) ON CONFLICT (id) DO NOTHING;
+`
+
 const createIndexes = `
 -- Indexes for better performance
 CREATE INDEX IF NOT EXISTS idx_messages_sender_recipient ON messages(sender_id, recipient_id);
@@ -159,5 +695,24 @@ CREATE INDEX IF NOT EXISTS idx_one_time_keys_user_id ON one_time_keys(user_id);
 CREATE INDEX IF NOT EXISTS idx_one_time_keys_used ON one_time_keys(used);
 CREATE INDEX IF NOT EXISTS idx_receipts_message_id ON receipts(message_id);
 CREATE INDEX IF NOT EXISTS idx_group_members_group_id ON group_members(group_id);
+CREATE INDEX IF NOT EXISTS idx_group_invitations_invitee_id ON group_invitations(invitee_id);
 CREATE INDEX IF NOT EXISTS idx_attachments_message_id ON attachments(message_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id, connected_at DESC);
+CREATE INDEX IF NOT EXISTS idx_outbox_pending ON outbox(created_at) WHERE delivered_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_messages_undelivered ON messages(created_at) WHERE recipient_id IS NOT NULL AND delivery_status IN ('sent', 'delivery_pending');
+CREATE INDEX IF NOT EXISTS idx_group_audit_log_group_id ON group_audit_log(group_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_push_tokens_token ON push_tokens(token);
+CREATE INDEX IF NOT EXISTS idx_dead_letters_user_id ON dead_letters(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_message_deliveries_missed ON message_deliveries(user_id) WHERE pushed_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_conversation_crypto_group ON conversation_crypto(group_id) WHERE group_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_conversation_crypto_dm ON conversation_crypto(user_a, user_b) WHERE group_id IS NULL;
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens(expires_at);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens(family_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens(user_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_expires_at ON refresh_tokens(expires_at);
+CREATE INDEX IF NOT EXISTS idx_message_edits_message_id ON message_edits(message_id, edited_at);
+CREATE INDEX IF NOT EXISTS idx_messages_direct_recipient ON messages(recipient_id, created_at) WHERE group_id IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_conversation_ttls_group ON conversation_ttls(group_id) WHERE group_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_conversation_ttls_dm ON conversation_ttls(user_a, user_b) WHERE group_id IS NULL;
+CREATE INDEX IF NOT EXISTS idx_messages_expires_at ON messages(expires_at) WHERE expires_at IS NOT NULL;
 `
@@ -0,0 +1,107 @@
+package database_test
+
+import (
+	"testing"
+
+	"e2ee-messenger/server/internal/database"
+	"e2ee-messenger/server/internal/testutil"
+)
+
+func TestMigrateLeavesOutCaseInsensitiveIndexesOverDuplicates(t *testing.T) {
+	db := testutil.NewDB(t)
+	t.Cleanup(func() {
+		// Put the indexes back for the tests that follow
+		db.Exec("TRUNCATE users CASCADE")
+		database.Migrate(db)
+	})
+
+	if _, err := db.Exec("DROP INDEX idx_users_username_lower, idx_users_email_lower"); err != nil {
+		t.Fatalf("Failed to drop indexes: %v", err)
+	}
+	testutil.CreateUser(t, db, "alice")
+	testutil.CreateUser(t, db, "Alice")
+
+	if err := database.Migrate(db); err != nil {
+		t.Fatalf("Expected migrations to succeed despite duplicates, got %v", err)
+	}
+	var exists bool
+	if err := db.QueryRow("SELECT to_regclass('idx_users_username_lower') IS NOT NULL").Scan(&exists); err != nil {
+		t.Fatalf("Failed to look up index: %v", err)
+	}
+	if exists {
+		t.Error("Expected the username index to be left out while duplicates exist")
+	}
+}
+
+func TestCloseOrphanedSessionsLeavesOtherInstancesAlone(t *testing.T) {
+	db := testutil.NewDB(t)
+	alice := testutil.CreateUser(t, db, "alice")
+
+	seed := "INSERT INTO sessions (user_id, instance_id) VALUES ($1, 'web-1'), ($1, 'web-2'), ($1, NULL)"
+	if _, err := db.Exec(seed, alice.ID); err != nil {
+		t.Fatalf("Failed to seed sessions: %v", err)
+	}
+
+	if err := database.CloseOrphanedSessions(db, "web-1"); err != nil {
+		t.Fatalf("CloseOrphanedSessions failed: %v", err)
+	}
+
+	rows, err := db.Query("SELECT COALESCE(instance_id, ''), closed_at IS NOT NULL FROM sessions WHERE user_id = $1", alice.ID)
+	if err != nil {
+		t.Fatalf("Failed to load sessions: %v", err)
+	}
+	defer rows.Close()
+	closed := map[string]bool{}
+	for rows.Next() {
+		var instanceID string
+		var isClosed bool
+		if err := rows.Scan(&instanceID, &isClosed); err != nil {
+			t.Fatalf("Failed to scan session: %v", err)
+		}
+		closed[instanceID] = isClosed
+	}
+	if !closed["web-1"] || closed["web-2"] || !closed[""] {
+		t.Errorf("Expected web-1's and unclaimed sessions closed and web-2's open, got %v", closed)
+	}
+}
+
+func TestMigrateCopiesPerUserMessageTTLs(t *testing.T) {
+	db := testutil.NewDB(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+
+	// Both sides set a timer before it was shared; bob's is the latest
+	seed := `
+		INSERT INTO conversation_settings (user_id, conversation_id, message_ttl_seconds, updated_at)
+		VALUES ($1, $2, 3600, NOW() - INTERVAL '1 hour'), ($2, $1, 60, NOW())
+	`
+	if _, err := db.Exec(seed, alice.ID, bob.ID); err != nil {
+		t.Fatalf("Failed to seed settings: %v", err)
+	}
+
+	if err := database.Migrate(db); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	var ttl int
+	if err := db.QueryRow("SELECT ttl_seconds FROM conversation_ttls WHERE group_id IS NULL").Scan(&ttl); err != nil {
+		t.Fatalf("Failed to load the copied timer: %v", err)
+	}
+	if ttl != 60 {
+		t.Errorf("Expected the latest timer of 60s, got %d", ttl)
+	}
+
+	// Turned off afterwards, it stays off through the next migration
+	if _, err := db.Exec("DELETE FROM conversation_ttls"); err != nil {
+		t.Fatalf("Failed to turn off the timer: %v", err)
+	}
+	if err := database.Migrate(db); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM conversation_ttls").Scan(&count); err != nil {
+		t.Fatalf("Failed to count timers: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("Expected the timer to stay off, found %d", count)
+	}
+}
@@ -0,0 +1,33 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// uniqueViolation is the Postgres error code for a unique constraint violation
+	uniqueViolation = "23505"
+	// foreignKeyViolation is the Postgres error code for a row referencing a
+	// row that does not exist
+	foreignKeyViolation = "23503"
+)
+
+// IsUniqueViolation reports whether err was caused by a unique constraint or
+// unique index rejecting a row
+func IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolation
+}
+
+// ForeignKeyViolation reports whether err was caused by a foreign key
+// constraint rejecting a row, and returns the constraint's name, such as
+// "messages_recipient_id_fkey"
+func ForeignKeyViolation(err error) (string, bool) {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == foreignKeyViolation {
+		return pqErr.Constraint, true
+	}
+	return "", false
+}
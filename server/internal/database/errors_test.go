@@ -0,0 +1,18 @@
+package database
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestForeignKeyViolation(t *testing.T) {
+	err := fmt.Errorf("failed to insert message: %w", &pq.Error{Code: "23503", Constraint: "messages_recipient_id_fkey"})
+	if constraint, ok := ForeignKeyViolation(err); !ok || constraint != "messages_recipient_id_fkey" {
+		t.Errorf("Expected a violation of messages_recipient_id_fkey, got %q (%v)", constraint, ok)
+	}
+	if _, ok := ForeignKeyViolation(&pq.Error{Code: "23505"}); ok {
+		t.Error("Expected a unique violation not to count as a foreign key violation")
+	}
+}
@@ -0,0 +1,169 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"e2ee-messenger/server/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type labelKey struct{}
+
+// WithLabel names the queries run with ctx in the slow-query log
+func WithLabel(ctx context.Context, label string) context.Context {
+	return context.WithValue(ctx, labelKey{}, label)
+}
+
+// queryLabel returns the context's label, or the start of the query when there is none
+func queryLabel(ctx context.Context, query string) string {
+	if label, ok := ctx.Value(labelKey{}).(string); ok {
+		return label
+	}
+	label := strings.Join(strings.Fields(query), " ")
+	if len(label) > 60 {
+		label = label[:60] + "..."
+	}
+	return label
+}
+
+// observe logs the query if it took longer than the slow-query threshold. For
+// Query calls this covers the time to the first row, not reading all of them.
+func (db *DB) observe(ctx context.Context, query string, start time.Time) {
+	if db.slowQueryThreshold <= 0 {
+		return
+	}
+	if elapsed := time.Since(start); elapsed >= db.slowQueryThreshold {
+		log.Printf("Slow query [%s] took %s", queryLabel(ctx, query), elapsed.Round(time.Millisecond))
+	}
+}
+
+// startSpan starts a client span for a database call, named after its label
+func startSpan(ctx context.Context, operation, query string) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, "db "+queryLabel(ctx, query),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.operation", operation),
+		),
+	)
+}
+
+// The methods below shadow those of the embedded *sql.DB so every query run
+// directly on the pool is timed, traced and guarded by the circuit breaker.
+// Statements inside transactions are not; only starting one is guarded.
+
+// Query runs a query that returns rows
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.QueryContext(context.Background(), query, args...)
+}
+
+// QueryContext runs a query that returns rows
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if _, open := db.breaker.open(); open {
+		return nil, ErrUnavailable
+	}
+	ctx, span := startSpan(ctx, "query", query)
+	defer db.observe(ctx, query, time.Now())
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	db.breaker.record(ctx, err)
+	tracing.End(span, err)
+	return rows, err
+}
+
+// QueryRow runs a query that returns at most one row
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.QueryRowContext(context.Background(), query, args...)
+}
+
+// QueryRowContext runs a query that returns at most one row
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if _, open := db.breaker.open(); open {
+		// A *sql.Row cannot carry ErrUnavailable, so the query is cancelled
+		// before it reaches the pool instead
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		return db.DB.QueryRowContext(cancelled, query, args...)
+	}
+	ctx, span := startSpan(ctx, "query", query)
+	defer db.observe(ctx, query, time.Now())
+	row := db.DB.QueryRowContext(ctx, query, args...)
+	db.breaker.record(ctx, row.Err())
+	tracing.End(span, row.Err())
+	return row
+}
+
+// Exec runs a statement that returns no rows
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.ExecContext(context.Background(), query, args...)
+}
+
+// ExecContext runs a statement that returns no rows
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if _, open := db.breaker.open(); open {
+		return nil, ErrUnavailable
+	}
+	ctx, span := startSpan(ctx, "exec", query)
+	defer db.observe(ctx, query, time.Now())
+	result, err := db.DB.ExecContext(ctx, query, args...)
+	db.breaker.record(ctx, err)
+	tracing.End(span, err)
+	return result, err
+}
+
+// Begin starts a transaction
+func (db *DB) Begin() (*sql.Tx, error) {
+	return db.BeginTx(context.Background(), nil)
+}
+
+// BeginTx starts a transaction
+func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	if _, open := db.breaker.open(); open {
+		return nil, ErrUnavailable
+	}
+	tx, err := db.DB.BeginTx(ctx, opts)
+	db.breaker.record(ctx, err)
+	return tx, err
+}
+
+// withRuntimeParams adds Postgres run-time parameters (such as
+// statement_timeout or TimeZone) to a connection string; the server then
+// applies them to every pooled connection. Parameters already present in the
+// string win.
+func withRuntimeParams(databaseURL string, params map[string]string) (string, error) {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	if strings.HasPrefix(databaseURL, "postgres://") || strings.HasPrefix(databaseURL, "postgresql://") {
+		u, err := url.Parse(databaseURL)
+		if err != nil {
+			return "", err
+		}
+		q := u.Query()
+		for _, key := range keys {
+			if q.Get(key) == "" {
+				q.Set(key, params[key])
+			}
+		}
+		u.RawQuery = q.Encode()
+		return u.String(), nil
+	}
+
+	// key=value connection string
+	for _, key := range keys {
+		if !strings.Contains(databaseURL, key+"=") {
+			databaseURL = strings.TrimSpace(databaseURL + " " + key + "=" + params[key])
+		}
+	}
+	return databaseURL, nil
+}
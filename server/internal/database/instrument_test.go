@@ -0,0 +1,104 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sleepDriver is a database driver whose statements take as long as the
+// duration given as their query text
+type sleepDriver struct{}
+
+type sleepConn struct{}
+
+func (sleepDriver) Open(string) (driver.Conn, error) { return sleepConn{}, nil }
+
+func (sleepConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (sleepConn) Close() error                        { return nil }
+func (sleepConn) Begin() (driver.Tx, error)           { return nil, driver.ErrSkip }
+
+func (sleepConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	d, err := time.ParseDuration(strings.Fields(query)[0])
+	if err != nil {
+		return nil, err
+	}
+	time.Sleep(d)
+	return driver.RowsAffected(0), nil
+}
+
+func init() {
+	sql.Register("sleep", sleepDriver{})
+}
+
+// captureLog redirects the standard logger for the duration of the test
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(previous) })
+	return &buf
+}
+
+func TestSlowQueriesAreLogged(t *testing.T) {
+	conn, err := sql.Open("sleep", "")
+	if err != nil {
+		t.Fatalf("Failed to open test driver: %v", err)
+	}
+	defer conn.Close()
+	db := &DB{DB: conn, slowQueryThreshold: 20 * time.Millisecond}
+	logs := captureLog(t)
+
+	if _, err := db.Exec("1ms -- fast"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("Expected a fast query not to be logged, got %q", logs.String())
+	}
+
+	if _, err := db.ExecContext(WithLabel(context.Background(), "nap"), "30ms -- slow"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if !strings.Contains(logs.String(), "Slow query [nap] took") {
+		t.Errorf("Expected the slow query to be logged with its label, got %q", logs.String())
+	}
+
+	logs.Reset()
+	if _, err := db.Exec("30ms   --\n  unlabeled"); err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if !strings.Contains(logs.String(), "Slow query [30ms -- unlabeled] took") {
+		t.Errorf("Expected the query text as the label, got %q", logs.String())
+	}
+}
+
+func TestWithRuntimeParams(t *testing.T) {
+	params := map[string]string{"statement_timeout": "1500", "TimeZone": "UTC"}
+	tests := []struct {
+		name     string
+		dsn      string
+		expected string
+	}{
+		{"url", "postgres://u:p@localhost:5432/db?sslmode=disable", "postgres://u:p@localhost:5432/db?TimeZone=UTC&sslmode=disable&statement_timeout=1500"},
+		{"url keeps explicit values", "postgres://localhost/db?statement_timeout=10", "postgres://localhost/db?TimeZone=UTC&statement_timeout=10"},
+		{"key=value", "host=localhost dbname=db", "host=localhost dbname=db TimeZone=UTC statement_timeout=1500"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := withRuntimeParams(tt.dsn, params)
+			if err != nil {
+				t.Fatalf("withRuntimeParams failed: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
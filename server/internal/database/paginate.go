@@ -0,0 +1,153 @@
+package database
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks the last row of a page in a list ordered newest first by
+// (created_at, id). The id breaks ties between rows created at the same time.
+type Cursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// EncodeCursor returns the opaque form of c handed to clients
+func EncodeCursor(c Cursor) string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor parses a cursor produced by EncodeCursor
+func DecodeCursor(s string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	createdAtStr, idStr, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return Cursor{}, ErrInvalidCursor
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, createdAtStr)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return Cursor{CreatedAt: createdAt, ID: id}, nil
+}
+
+// Paginator turns the "limit" and "cursor" query parameters of a list
+// endpoint into a Page
+type Paginator struct {
+	// Used when no limit, or an invalid one, is requested
+	DefaultLimit int
+	// Larger requested limits are clamped to this
+	MaxLimit int
+}
+
+// Limit turns a requested page size into the one to use: the default when
+// none (or an invalid one) was requested, clamped to the max
+func (p Paginator) Limit(requested int) int {
+	if requested <= 0 {
+		return p.DefaultLimit
+	}
+	if requested > p.MaxLimit {
+		return p.MaxLimit
+	}
+	return requested
+}
+
+// Parse reads the page requested by query. It fails with ErrInvalidCursor
+// when the cursor is malformed; a malformed limit falls back to the default.
+func (p Paginator) Parse(query url.Values) (Page, error) {
+	requested, _ := strconv.Atoi(query.Get("limit"))
+	page := Page{Limit: p.Limit(requested)}
+	if s := query.Get("cursor"); s != "" {
+		cursor, err := DecodeCursor(s)
+		if err != nil {
+			return Page{}, err
+		}
+		page.After = &cursor
+	}
+	return page, nil
+}
+
+// Page is one page of a list ordered newest first by (created_at, id), or
+// oldest first when Ascending is set
+type Page struct {
+	Limit int
+	// The last row of the previous page, or nil for the first page
+	After *Cursor
+	// Rows from this one on, in list order, are left out; nil for no bound
+	Until *Cursor
+	// List oldest first
+	Ascending bool
+}
+
+// Where returns the condition restricting rows to those after the cursor
+// and before Until, using placeholders starting at $next, along with their
+// arguments. It returns "TRUE" and no arguments when neither is set.
+func (pg Page) Where(createdAtColumn, idColumn string, next int) (string, []interface{}) {
+	afterOp, untilOp := "<", ">"
+	if pg.Ascending {
+		afterOp, untilOp = ">", "<"
+	}
+
+	var conditions []string
+	var args []interface{}
+	for _, bound := range []struct {
+		cursor *Cursor
+		op     string
+	}{{pg.After, afterOp}, {pg.Until, untilOp}} {
+		if bound.cursor == nil {
+			continue
+		}
+		n := next + len(args)
+		conditions = append(conditions, fmt.Sprintf("(%s, %s) %s ($%d, $%d)", createdAtColumn, idColumn, bound.op, n, n+1))
+		args = append(args, bound.cursor.CreatedAt, bound.cursor.ID)
+	}
+	if len(conditions) == 0 {
+		return "TRUE", nil
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// OrderBy returns the ORDER BY clause listing rows in the page's order
+func (pg Page) OrderBy(createdAtColumn, idColumn string) string {
+	direction := "DESC"
+	if pg.Ascending {
+		direction = "ASC"
+	}
+	return fmt.Sprintf("ORDER BY %s %s, %s %s", createdAtColumn, direction, idColumn, direction)
+}
+
+// LimitClause returns the LIMIT clause, using placeholder $next, and its
+// argument. One row more than the page holds is fetched so Next can tell
+// whether another page follows.
+func (pg Page) LimitClause(next int) (string, interface{}) {
+	return fmt.Sprintf("LIMIT $%d", next), pg.Limit + 1
+}
+
+// Next trims the rows fetched with LimitClause to the page and returns the
+// cursor of the following page, or "" when this is the last one. key
+// returns the cursor of a row.
+func Next[T any](pg Page, rows []T, key func(T) Cursor) ([]T, string) {
+	if len(rows) <= pg.Limit {
+		return rows, ""
+	}
+	rows = rows[:pg.Limit]
+	return rows, EncodeCursor(key(rows[len(rows)-1]))
+}
@@ -0,0 +1,152 @@
+package database
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	want := Cursor{
+		CreatedAt: time.Date(2024, 3, 1, 12, 30, 0, 123456000, time.FixedZone("CET", 3600)),
+		ID:        uuid.New(),
+	}
+
+	got, err := DecodeCursor(EncodeCursor(want))
+	if err != nil {
+		t.Fatalf("Failed to decode cursor: %v", err)
+	}
+	if !got.CreatedAt.Equal(want.CreatedAt) || got.ID != want.ID {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
+
+// encodeRaw encodes an arbitrary cursor body the way EncodeCursor does
+func encodeRaw(raw string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func TestDecodeCursorRejectsMalformedCursors(t *testing.T) {
+	tests := map[string]string{
+		"not base64":   "!!!",
+		"no separator": encodeRaw("2024-03-01T12:30:00Z"),
+		"bad time":     encodeRaw("yesterday|" + uuid.NewString()),
+		"bad id":       encodeRaw("2024-03-01T12:30:00Z|42"),
+	}
+	for name, cursor := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := DecodeCursor(cursor); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("Expected ErrInvalidCursor, got %v", err)
+			}
+		})
+	}
+}
+
+func TestPaginatorLimit(t *testing.T) {
+	p := Paginator{DefaultLimit: 50, MaxLimit: 100}
+
+	tests := []struct {
+		name      string
+		requested string
+		expected  int
+	}{
+		{"no limit uses the default", "", 50},
+		{"invalid limit uses the default", "lots", 50},
+		{"negative limit uses the default", "-5", 50},
+		{"limit within bounds is kept", "20", 20},
+		{"limit at the max is kept", "100", 100},
+		{"limit above the max is clamped", "1000", 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := p.Parse(url.Values{"limit": {tt.requested}})
+			if err != nil {
+				t.Fatalf("Failed to parse page: %v", err)
+			}
+			if page.Limit != tt.expected {
+				t.Errorf("Expected limit %d, got %d", tt.expected, page.Limit)
+			}
+		})
+	}
+}
+
+func TestPaginatorParseCursor(t *testing.T) {
+	p := Paginator{DefaultLimit: 50, MaxLimit: 100}
+
+	page, err := p.Parse(url.Values{})
+	if err != nil {
+		t.Fatalf("Failed to parse page: %v", err)
+	}
+	if clause, args := page.Where("created_at", "id", 3); clause != "TRUE" || len(args) != 0 {
+		t.Errorf("Expected no condition on the first page, got %q %v", clause, args)
+	}
+
+	cursor := Cursor{CreatedAt: time.Now().UTC(), ID: uuid.New()}
+	page, err = p.Parse(url.Values{"cursor": {EncodeCursor(cursor)}})
+	if err != nil {
+		t.Fatalf("Failed to parse page: %v", err)
+	}
+	clause, args := page.Where("m.created_at", "m.id", 3)
+	if clause != "(m.created_at, m.id) < ($3, $4)" {
+		t.Errorf("Unexpected condition %q", clause)
+	}
+	if len(args) != 2 || args[1] != cursor.ID {
+		t.Errorf("Unexpected condition arguments %v", args)
+	}
+	if limit, arg := page.LimitClause(5); limit != "LIMIT $5" || arg != 51 {
+		t.Errorf("Expected LIMIT $5 fetching 51 rows, got %q %v", limit, arg)
+	}
+
+	if _, err := p.Parse(url.Values{"cursor": {"garbage"}}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestPageBoundsFollowOrder(t *testing.T) {
+	after := Cursor{CreatedAt: time.Now().UTC(), ID: uuid.New()}
+	until := Cursor{CreatedAt: after.CreatedAt.Add(-time.Hour), ID: uuid.New()}
+
+	page := Page{Limit: 10, After: &after, Until: &until}
+	clause, args := page.Where("created_at", "id", 2)
+	if clause != "(created_at, id) < ($2, $3) AND (created_at, id) > ($4, $5)" || len(args) != 4 {
+		t.Errorf("Unexpected newest first condition %q %v", clause, args)
+	}
+	if order := page.OrderBy("created_at", "id"); order != "ORDER BY created_at DESC, id DESC" {
+		t.Errorf("Unexpected newest first order %q", order)
+	}
+
+	page = Page{Limit: 10, After: &until, Ascending: true}
+	if clause, _ := page.Where("created_at", "id", 2); clause != "(created_at, id) > ($2, $3)" {
+		t.Errorf("Unexpected oldest first condition %q", clause)
+	}
+	if order := page.OrderBy("created_at", "id"); order != "ORDER BY created_at ASC, id ASC" {
+		t.Errorf("Unexpected oldest first order %q", order)
+	}
+}
+
+func TestNextTrimsPageAndEncodesCursor(t *testing.T) {
+	page := Page{Limit: 2}
+	base := time.Now().UTC()
+	rows := []Cursor{
+		{CreatedAt: base, ID: uuid.New()},
+		{CreatedAt: base.Add(-time.Second), ID: uuid.New()},
+		{CreatedAt: base.Add(-2 * time.Second), ID: uuid.New()},
+	}
+	key := func(c Cursor) Cursor { return c }
+
+	trimmed, next := Next(page, rows, key)
+	if len(trimmed) != 2 {
+		t.Fatalf("Expected 2 rows, got %d", len(trimmed))
+	}
+	if decoded, err := DecodeCursor(next); err != nil || decoded.ID != rows[1].ID {
+		t.Errorf("Expected the next cursor to point at the last row of the page, got %+v (%v)", decoded, err)
+	}
+
+	if _, next := Next(page, rows[:2], key); next != "" {
+		t.Errorf("Expected no next cursor on the last page, got %q", next)
+	}
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"e2ee-messenger/server/internal/middleware"
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/websocket"
+
+	"github.com/google/uuid"
+)
+
+// GetHubStats returns a snapshot of the websocket hub's connections and
+// buffers for diagnosing stuck connections and leaks. Admins only.
+func (h *Handlers) GetHubStats(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, h.hub.Stats())
+}
+
+// GetMaintenance reports whether the server is in maintenance mode. Admins only.
+func (h *Handlers) GetMaintenance(w http.ResponseWriter, r *http.Request) {
+	enabled, message := h.maintenance.Status()
+	respondJSON(w, http.StatusOK, models.MaintenanceStatus{Enabled: enabled, Message: message})
+}
+
+// SetMaintenance turns maintenance mode on or off, telling every connected
+// client when it changes. It is stored so the other instances follow within
+// MaintenanceSyncInterval. Admins only.
+func (h *Handlers) SetMaintenance(w http.ResponseWriter, r *http.Request) {
+	var req models.SetMaintenanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	var changed bool
+	if h.svc != nil {
+		stored, err := h.svc.SetMaintenance(r.Context(), req.Enabled, req.Message)
+		if err != nil {
+			respondWithAppError(w, err)
+			return
+		}
+		changed = stored
+	}
+	if h.maintenance.Set(req.Enabled, req.Message) {
+		changed = true
+	}
+	enabled, message := h.maintenance.Status()
+	if changed && h.hub != nil {
+		h.hub.Broadcast(websocket.MaintenanceEvent(enabled, message))
+	}
+
+	respondJSON(w, http.StatusOK, models.MaintenanceStatus{Enabled: enabled, Message: message})
+}
+
+// CreateInviteCode creates a code that lets people sign up while the server
+// is invite-only. Admins only.
+func (h *Handlers) CreateInviteCode(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	var req models.CreateInviteCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	invite, err := h.svc.CreateInviteCode(r.Context(), userID, req.MaxUses, req.ExpiresAt)
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, invite)
+}
+
+// ListInviteCodes lists every invite code with how often it was used. Admins only.
+func (h *Handlers) ListInviteCodes(w http.ResponseWriter, r *http.Request) {
+	invites, err := h.svc.ListInviteCodes(r.Context())
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, invites)
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"e2ee-messenger/server/internal/middleware"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// blockedAttachment reports why an upload is refused, or "" if it is allowed.
+//
+// Attachments are encrypted by the client before upload, so their content
+// says nothing about the file and is not inspected: any leading bytes are as
+// likely in ciphertext as in an executable. The gate is the declared file
+// name and MIME type, which an honest client sets from the original file and
+// a malicious client can fake.
+func (h *Handlers) blockedAttachment(fileName, mimeType string) string {
+	// Trailing dots and spaces are ignored by Windows, so "evil.exe." is still an .exe
+	name := strings.ToLower(strings.TrimRight(fileName, ". "))
+	if ext := filepath.Ext(name); ext != "" && slices.Contains(h.cfg.BlockedAttachmentExtensions, ext) {
+		return "File extension " + ext + " is not allowed"
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(mimeType); err == nil && slices.Contains(h.cfg.BlockedAttachmentMimeTypes, mediaType) {
+		return "File type " + mediaType + " is not allowed"
+	}
+
+	return ""
+}
+
+// canAccessMessage reports whether the user is part of the conversation a
+// message belongs to: a member of its group, or its sender or recipient
+func (h *Handlers) canAccessMessage(ctx context.Context, userID uuid.UUID, senderID, recipientID, groupID sql.NullString) bool {
+	if groupID.Valid { // Group Message
+		return h.svc.RequireGroupMember(ctx, uuid.MustParse(groupID.String), userID) == nil
+	}
+	if senderID.Valid && recipientID.Valid { // Direct Message
+		return senderID.String == userID.String() || recipientID.String == userID.String()
+	}
+	return false
+}
+
+// DownloadAttachmentArchive streams a zip of all of a message's attachments.
+// The files are encrypted by the client, so the archive holds ciphertext and
+// is stored without compression.
+func (h *Handlers) DownloadAttachmentArchive(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	messageID, err := uuid.Parse(chi.URLParam(r, "messageID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid messageID format")
+		return
+	}
+
+	var senderID, recipientID, groupID sql.NullString
+	err = h.db.QueryRow(`
+		SELECT sender_id, recipient_id, group_id FROM messages
+		WHERE id = $1 AND deleted_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+	`, messageID).Scan(&senderID, &recipientID, &groupID)
+	if err == sql.ErrNoRows {
+		respondWithError(w, http.StatusNotFound, "Message not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching message %s: %v", messageID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve attachments")
+		return
+	}
+	if !h.canAccessMessage(r.Context(), userID, senderID, recipientID, groupID) {
+		respondWithError(w, http.StatusForbidden, "You are not authorized to download these attachments")
+		return
+	}
+
+	rows, err := h.db.Query(`
+		SELECT file_name, storage_path FROM attachments WHERE message_id = $1 ORDER BY created_at ASC
+	`, messageID)
+	if err != nil {
+		log.Printf("Error fetching attachments of message %s: %v", messageID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve attachments")
+		return
+	}
+	type archiveEntry struct{ name, path string }
+	var entries []archiveEntry
+	for rows.Next() {
+		var entry archiveEntry
+		if err := rows.Scan(&entry.name, &entry.path); err != nil {
+			rows.Close()
+			respondWithError(w, http.StatusInternalServerError, "Failed to retrieve attachments")
+			return
+		}
+		entries = append(entries, entry)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve attachments")
+		return
+	}
+	if len(entries) == 0 {
+		respondWithError(w, http.StatusNotFound, "Attachment not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+messageID.String()+`.zip"`)
+
+	// Once streaming starts the status is sent, so failures can only cut the archive short
+	archive := zip.NewWriter(w)
+	used := make(map[string]bool)
+	for _, entry := range entries {
+		name := archiveName(entry.name, used)
+		dst, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			log.Printf("Failed to add %s to the archive of message %s: %v", name, messageID, err)
+			return
+		}
+		if err := copyFile(dst, entry.path); err != nil {
+			log.Printf("Failed to add %s to the archive of message %s: %v", name, messageID, err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		log.Printf("Failed to finish the archive of message %s: %v", messageID, err)
+	}
+}
+
+// archiveName turns a client-supplied file name into a unique, flat entry
+// name, so the archive cannot write outside the directory it is extracted to
+func archiveName(fileName string, used map[string]bool) string {
+	name := filepath.Base(filepath.Clean("/" + strings.ReplaceAll(fileName, "\\", "/")))
+	if name == "/" || name == "." {
+		name = "attachment"
+	}
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 2; used[name]; i++ {
+		name = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+	used[name] = true
+	return name
+}
+
+// copyFile writes the contents of the file at path to dst
+func copyFile(dst io.Writer, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	_, err = io.Copy(dst, src)
+	return err
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"net/http"
+
+	"e2ee-messenger/server/internal/middleware"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// BlockUser blocks the user in the URL for the current user
+func (h *Handlers) BlockUser(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	blockedID, err := uuid.Parse(chi.URLParam(r, "userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid userID format")
+		return
+	}
+
+	if err := h.svc.BlockUser(r.Context(), userID, blockedID); err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UnblockUser lifts the current user's block on the user in the URL
+func (h *Handlers) UnblockUser(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	blockedID, err := uuid.Parse(chi.URLParam(r, "userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid userID format")
+		return
+	}
+
+	if err := h.svc.UnblockUser(r.Context(), userID, blockedID); err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+
+	"e2ee-messenger/server/internal/models"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// protocolVersion is bumped whenever the API changes incompatibly
+const protocolVersion = 1
+
+// messageTypes are the message types SendMessage accepts
+var messageTypes = []string{"text", "file"}
+
+// routeFeature is an optional feature served by an API route
+type routeFeature struct {
+	name    string
+	method  string
+	pattern string
+}
+
+// routeFeatures are advertised once RegisterRoutes finds their route on the
+// router
+var routeFeatures = []routeFeature{
+	{"archived_conversations", http.MethodPost, "/v1/conversations/{conversationID}/archive"},
+	{"attachment_archives", http.MethodGet, "/v1/messages/{messageID}/attachments/archive"},
+	{"attachments", http.MethodPost, "/v1/messages/attachment"},
+	{"blocking", http.MethodPost, "/v1/users/{userID}/block"},
+	{"bulk_message_deletion", http.MethodPost, "/v1/messages/delete"},
+	{"conversation_media", http.MethodGet, "/v1/conversations/{conversationID}/media"},
+	{"device_revocation", http.MethodDelete, "/v1/keys/devices/{deviceID}"},
+	{"group_invitations", http.MethodPost, "/v1/groups/{groupID}/invitations"},
+	{"group_ownership_transfer", http.MethodPost, "/v1/groups/{groupID}/transfer-ownership"},
+	{"leave_group", http.MethodPost, "/v1/groups/{groupID}/leave"},
+	{"link_previews", http.MethodGet, "/v1/link-preview"},
+	{"logout", http.MethodPost, "/v1/auth/logout"},
+	{"logout_all", http.MethodPost, "/v1/auth/logout-all"},
+	{"message_edits", http.MethodPut, "/v1/messages/{messageID}"},
+	{"message_redelivery", http.MethodPost, "/v1/messages/{messageID}/redeliver"},
+	{"message_unsend", http.MethodDelete, "/v1/messages/{messageID}"},
+	{"one_time_key_rotation", http.MethodPost, "/v1/keys/one-time/rotate"},
+	{"pinned_conversation_order", http.MethodPut, "/v1/conversations/pins"},
+	{"pinned_conversations", http.MethodPut, "/v1/conversations/{conversationID}/pin"},
+	{"presence", http.MethodGet, "/v1/presence"},
+	{"promote_to_group", http.MethodPost, "/v1/conversations/{conversationID}/promote-to-group"},
+	{"push_notifications", http.MethodPost, "/v1/devices/{deviceID}/push-token"},
+	{"reactions", http.MethodPost, "/v1/messages/{messageID}/reactions"},
+	{"read_receipt_privacy", http.MethodPut, "/v1/profile/privacy"},
+	{"read_receipts", http.MethodPost, "/v1/receipts"},
+	{"search", http.MethodGet, "/v1/search"},
+	{"session_resumption", http.MethodGet, "/v1/ws/resume"},
+	{"sessions", http.MethodGet, "/v1/sessions"},
+	{"token_refresh", http.MethodPost, "/v1/auth/refresh"},
+	{"username_availability", http.MethodGet, "/v1/auth/username-available"},
+}
+
+// protocolFeatures are carried by message and WebSocket payloads rather than
+// a route of their own, and are always available
+var protocolFeatures = []string{
+	"delivery_status",
+	"disappearing_messages",
+	"encrypted_group_metadata",
+	"encryption_scheme_negotiation",
+	"group_descriptions",
+	"mentions",
+	"message_priority",
+	"system_messages",
+	"typing_indicators",
+	"view_once_messages",
+	"websocket_acks",
+}
+
+// configFeatures are the features the configuration turns on
+func (h *Handlers) configFeatures() []string {
+	var features []string
+	if h.cfg.DeleteOnRead {
+		features = append(features, "delete_on_read")
+	}
+	if h.cfg.MessageRetention > 0 || h.cfg.MediaRetention > 0 {
+		features = append(features, "message_retention")
+	}
+	// Delivery waits only see receipts sent to this instance
+	if h.cfg.RedisURL == "" {
+		features = append(features, "wait_for_delivery")
+	}
+	return features
+}
+
+// RegisterRoutes records which optional features routes serves, so
+// GetCapabilities only advertises what is mounted. Call it once every route
+// has been added.
+func (h *Handlers) RegisterRoutes(routes chi.Routes) error {
+	registered := make(map[string]bool)
+	err := chi.Walk(routes, func(method, pattern string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		registered[method+" "+pattern] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var features []string
+	for _, feature := range routeFeatures {
+		if registered[feature.method+" "+feature.pattern] {
+			features = append(features, feature.name)
+		}
+	}
+	h.routeFeatures = features
+	return nil
+}
+
+// features lists the optional features this server implements, sorted
+func (h *Handlers) features() []string {
+	features := append([]string{}, protocolFeatures...)
+	features = append(features, h.routeFeatures...)
+	features = append(features, h.configFeatures()...)
+	sort.Strings(features)
+	return features
+}
+
+// GetCapabilities describes the server's limits and features. It is public so
+// clients can check it before signing up.
+func (h *Handlers) GetCapabilities(w http.ResponseWriter, r *http.Request) {
+	capabilities := models.Capabilities{
+		ProtocolVersion:        protocolVersion,
+		MaxAvatarSize:          h.cfg.MaxAvatarSize,
+		MaxAttachmentSize:      h.cfg.MaxAttachmentSize,
+		MaxGroupMembers:        h.cfg.MaxGroupMembers,
+		MaxMessageLimit:        h.cfg.MaxMessageLimit,
+		MaxPinnedConversations: h.cfg.MaxPinnedConversations,
+		MessageTypes:           messageTypes,
+		Features:               h.features(),
+		InviteOnly:             h.cfg.InviteOnly,
+	}
+
+	respondJSON(w, http.StatusOK, capabilities)
+}
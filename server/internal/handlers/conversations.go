@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"e2ee-messenger/server/internal/middleware"
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/service"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// GetConversationSettings returns the current user's effective settings for a conversation
+func (h *Handlers) GetConversationSettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	conversationID, err := uuid.Parse(chi.URLParam(r, "conversationID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid conversationID format")
+		return
+	}
+
+	settings, err := h.svc.ConversationSettings(r.Context(), userID, conversationID)
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, settings)
+}
+
+// UpdateConversationSettings changes the current user's mute and TTL settings for a conversation
+func (h *Handlers) UpdateConversationSettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	conversationID, err := uuid.Parse(chi.URLParam(r, "conversationID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid conversationID format")
+		return
+	}
+
+	var req models.UpdateConversationSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	settings, err := h.svc.UpdateConversationSettings(r.Context(), userID, conversationID, req)
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, settings)
+}
+
+// SetEncryptionScheme records the encryption scheme a conversation uses
+func (h *Handlers) SetEncryptionScheme(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	conversationID, err := uuid.Parse(chi.URLParam(r, "conversationID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid conversationID format")
+		return
+	}
+
+	var req models.SetEncryptionSchemeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	settings, err := h.svc.SetEncryptionScheme(r.Context(), userID, conversationID, req.Scheme)
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, settings)
+}
+
+// PinConversation pins a conversation to the top of the current user's chat list
+func (h *Handlers) PinConversation(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	conversationID, err := uuid.Parse(chi.URLParam(r, "conversationID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid conversationID format")
+		return
+	}
+
+	if err := h.svc.PinConversation(r.Context(), userID, conversationID); err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UnpinConversation unpins a conversation from the current user's chat list
+func (h *Handlers) UnpinConversation(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	conversationID, err := uuid.Parse(chi.URLParam(r, "conversationID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid conversationID format")
+		return
+	}
+
+	if err := h.svc.UnpinConversation(r.Context(), userID, conversationID); err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ReorderPinnedConversations sets the order of the current user's pinned conversations
+func (h *Handlers) ReorderPinnedConversations(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	var req models.ReorderPinnedConversationsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.svc.ReorderPinnedConversations(r.Context(), userID, req.ConversationIDs); err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ArchiveConversation hides a conversation from the current user's chat list
+// until a new message arrives
+func (h *Handlers) ArchiveConversation(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	conversationID, err := uuid.Parse(chi.URLParam(r, "conversationID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid conversationID format")
+		return
+	}
+
+	if err := h.svc.ArchiveConversation(r.Context(), userID, conversationID); err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UnarchiveConversation returns a conversation to the current user's chat list
+func (h *Handlers) UnarchiveConversation(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	conversationID, err := uuid.Parse(chi.URLParam(r, "conversationID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid conversationID format")
+		return
+	}
+
+	if err := h.svc.UnarchiveConversation(r.Context(), userID, conversationID); err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PromoteConversationToGroup turns a direct conversation into a new group
+// with both participants and the added members
+func (h *Handlers) PromoteConversationToGroup(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	peerID, err := uuid.Parse(chi.URLParam(r, "conversationID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid conversationID format")
+		return
+	}
+
+	var req models.PromoteConversationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	input := service.PromoteConversationInput{
+		UserID:            userID,
+		PeerID:            peerID,
+		Name:              req.Name,
+		Description:       req.Description,
+		EncryptedMetadata: req.EncryptedMetadata,
+	}
+	for _, idStr := range req.MemberIDs {
+		memberID, err := uuid.Parse(idStr)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid member_ids format")
+			return
+		}
+		input.MemberIDs = append(input.MemberIDs, memberID)
+	}
+
+	group, err := h.svc.PromoteConversationToGroup(r.Context(), input)
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, group)
+}
+
+// GetConversationMedia returns a page of a conversation's image and video
+// messages, newest first
+func (h *Handlers) GetConversationMedia(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	conversationID, err := uuid.Parse(chi.URLParam(r, "conversationID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid conversationID format")
+		return
+	}
+	page, err := h.cfg.MessagePages().Parse(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+
+	media, err := h.svc.ConversationMedia(r.Context(), userID, conversationID, page)
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, media)
+}
+
+// GetSessionStatus returns diagnostics about the other participants' key
+// material, so clients can decide whether to bootstrap their sessions again
+func (h *Handlers) GetSessionStatus(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	conversationID, err := uuid.Parse(chi.URLParam(r, "conversationID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid conversationID format")
+		return
+	}
+
+	status, err := h.svc.SessionStatus(r.Context(), userID, conversationID)
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, status)
+}
@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"e2ee-messenger/server/internal/middleware"
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/service"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// GetGroup returns a group's details to one of its members
+func (h *Handlers) GetGroup(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	groupID, err := uuid.Parse(chi.URLParam(r, "groupID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid groupID format")
+		return
+	}
+
+	group, err := h.svc.GetGroup(r.Context(), groupID, userID)
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, group)
+}
+
+// ListGroups returns the current user's groups, most recently active first
+func (h *Handlers) ListGroups(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	groups, err := h.svc.ListGroups(r.Context(), userID)
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, groups)
+}
+
+// ListGroupMembers returns a group's members and their roles to one of its members
+func (h *Handlers) ListGroupMembers(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	groupID, err := uuid.Parse(chi.URLParam(r, "groupID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid groupID format")
+		return
+	}
+
+	members, err := h.svc.ListGroupMembers(r.Context(), groupID, userID)
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, members)
+}
+
+// UpdateGroup changes a group's name and/or description (admins only)
+func (h *Handlers) UpdateGroup(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	groupID, err := uuid.Parse(chi.URLParam(r, "groupID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid groupID format")
+		return
+	}
+
+	var req models.UpdateGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	group, err := h.svc.UpdateGroup(r.Context(), service.UpdateGroupInput{
+		GroupID:           groupID,
+		UserID:            userID,
+		Name:              req.Name,
+		Description:       req.Description,
+		EncryptedMetadata: req.EncryptedMetadata,
+	})
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, group)
+}
+
+// AddGroupMembers adds users to a group (admins only)
+func (h *Handlers) AddGroupMembers(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	groupID, err := uuid.Parse(chi.URLParam(r, "groupID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid groupID format")
+		return
+	}
+
+	var req models.AddGroupMembersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	var memberIDs []uuid.UUID
+	for _, idStr := range req.UserIDs {
+		memberID, err := uuid.Parse(idStr)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid user_ids format")
+			return
+		}
+		memberIDs = append(memberIDs, memberID)
+	}
+
+	added, err := h.svc.AddGroupMembers(r.Context(), groupID, userID, memberIDs)
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, models.AddGroupMembersResponse{AddedUserIDs: added})
+}
+
+// RemoveGroupMember removes a member from a group (admins only)
+func (h *Handlers) RemoveGroupMember(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	groupID, err := uuid.Parse(chi.URLParam(r, "groupID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid groupID format")
+		return
+	}
+	memberID, err := uuid.Parse(chi.URLParam(r, "userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid userID format")
+		return
+	}
+
+	if err := h.svc.RemoveGroupMember(r.Context(), groupID, userID, memberID); err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LeaveGroup removes the current user from a group
+func (h *Handlers) LeaveGroup(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	groupID, err := uuid.Parse(chi.URLParam(r, "groupID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid groupID format")
+		return
+	}
+
+	if err := h.svc.LeaveGroup(r.Context(), groupID, userID); err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetGroupNotificationLevel changes how the current user is notified about a group
+func (h *Handlers) SetGroupNotificationLevel(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	groupID, err := uuid.Parse(chi.URLParam(r, "groupID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid groupID format")
+		return
+	}
+
+	var req models.NotificationLevelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.svc.SetNotificationLevel(r.Context(), groupID, userID, req.Level); err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TransferGroupOwnership hands a group over to another member (owner only)
+func (h *Handlers) TransferGroupOwnership(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	groupID, err := uuid.Parse(chi.URLParam(r, "groupID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid groupID format")
+		return
+	}
+
+	var req models.TransferOwnershipRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	newOwnerID, err := uuid.Parse(req.NewOwnerID)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid new_owner_id format")
+		return
+	}
+
+	group, err := h.svc.TransferGroupOwnership(r.Context(), service.TransferOwnershipInput{
+		GroupID:             groupID,
+		UserID:              userID,
+		NewOwnerID:          newOwnerID,
+		DemotePreviousOwner: req.DemotePreviousOwner,
+	})
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, group)
+}
+
+// InviteToGroup invites users to join a group (admins only)
+func (h *Handlers) InviteToGroup(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	groupID, err := uuid.Parse(chi.URLParam(r, "groupID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid groupID format")
+		return
+	}
+
+	var req models.InviteToGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	var inviteeIDs []uuid.UUID
+	for _, idStr := range req.UserIDs {
+		inviteeID, err := uuid.Parse(idStr)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid user_ids format")
+			return
+		}
+		inviteeIDs = append(inviteeIDs, inviteeID)
+	}
+
+	invited, err := h.svc.InviteToGroup(r.Context(), groupID, userID, inviteeIDs)
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, models.InviteToGroupResponse{InvitedUserIDs: invited})
+}
+
+// ListGroupInvitations lists the current user's pending group invitations
+func (h *Handlers) ListGroupInvitations(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	invitations, err := h.svc.ListGroupInvitations(r.Context(), userID)
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, invitations)
+}
+
+// AcceptGroupInvitation joins the group the current user was invited to
+func (h *Handlers) AcceptGroupInvitation(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	invitationID, err := uuid.Parse(chi.URLParam(r, "invitationID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid invitationID format")
+		return
+	}
+
+	group, err := h.svc.AcceptGroupInvitation(r.Context(), userID, invitationID)
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, group)
+}
+
+// DeclineGroupInvitation discards one of the current user's group invitations
+func (h *Handlers) DeclineGroupInvitation(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	invitationID, err := uuid.Parse(chi.URLParam(r, "invitationID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid invitationID format")
+		return
+	}
+
+	if err := h.svc.DeclineGroupInvitation(r.Context(), userID, invitationID); err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
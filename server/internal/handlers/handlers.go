@@ -1,11 +1,16 @@
 package handlers
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -14,15 +19,20 @@ import (
 
 	"github.com/go-chi/chi/v5"
 
+	"e2ee-messenger/server/internal/apperrors"
 	"e2ee-messenger/server/internal/config"
 	"e2ee-messenger/server/internal/database"
+	"e2ee-messenger/server/internal/linkpreview"
 	"e2ee-messenger/server/internal/middleware"
 	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/passwordhash"
+	"e2ee-messenger/server/internal/ratelimit"
+	"e2ee-messenger/server/internal/service"
+	"e2ee-messenger/server/internal/webhook"
 	"e2ee-messenger/server/internal/websocket"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/argon2"
 )
 
 // Handlers contains all HTTP handlers
@@ -30,65 +40,92 @@ type Handlers struct {
 	db  *database.DB
 	hub *websocket.Hub
 	cfg *config.Config
+	svc *service.Service
+
+	// Limits unauthenticated username lookups per client address
+	usernameLimiter *ratelimit.Limiter
+
+	// Fetches link previews, limited per user
+	linkPreviews       *linkpreview.Fetcher
+	linkPreviewLimiter *ratelimit.Limiter
+
+	// Read-only switch for maintenance windows
+	maintenance *middleware.Maintenance
+
+	// Optional features whose routes RegisterRoutes found
+	routeFeatures []string
 }
 
 // New creates a new handlers instance
-func New(db *database.DB, hub *websocket.Hub, cfg *config.Config) *Handlers {
+func New(db *database.DB, hub *websocket.Hub, cfg *config.Config, svc *service.Service) *Handlers {
 	return &Handlers{
 		db:  db,
 		hub: hub,
 		cfg: cfg,
+		svc: svc,
+
+		usernameLimiter: ratelimit.New(usernameCheckLimit, time.Minute),
+		linkPreviews: linkpreview.New(linkpreview.Config{
+			Timeout:      cfg.LinkPreviewTimeout,
+			MaxBytes:     cfg.LinkPreviewMaxBytes,
+			MaxRedirects: cfg.LinkPreviewMaxRedirects,
+			CacheTTL:     cfg.LinkPreviewCacheTTL,
+		}),
+		linkPreviewLimiter: ratelimit.New(linkPreviewLimit, time.Minute),
+		maintenance:        middleware.NewMaintenance(cfg.MaintenanceMode, cfg.MaintenanceMessage),
 	}
 }
 
-// notifyNewMessage sends a "new_message" WebSocket event to the relevant recipients.
-func (h *Handlers) notifyNewMessage(message models.Message) {
-	// For group messages, we need to fetch sender info to include in the payload
-	if message.GroupID != nil {
-		var sender models.User
-		var avatarURL sql.NullString
-		// Use message.SenderID to fetch the sender's details
-		err := h.db.QueryRow("SELECT id, username, avatar_url FROM users WHERE id = $1", message.SenderID).Scan(&sender.ID, &sender.Username, &avatarURL)
-		if err != nil {
-			log.Printf("Could not fetch sender info for group notification: %v", err)
-			// Proceed without sender info if it fails
-		} else {
-			if avatarURL.Valid {
-				sender.AvatarURL = avatarURL.String
-			}
-			message.Sender = &sender
-		}
-
-		// Get all members of the group to notify them (except the sender)
-		rows, err := h.db.Query("SELECT user_id FROM group_members WHERE group_id = $1 AND user_id != $2", message.GroupID, message.SenderID)
-		if err != nil {
-			log.Printf("Failed to get group members for notification: %v", err)
-			return
-		}
-		defer rows.Close()
+// Maintenance returns the maintenance-mode switch the admin endpoints control
+func (h *Handlers) Maintenance() *middleware.Maintenance {
+	return h.maintenance
+}
 
-		notification := websocket.Message{Type: "new_message", Payload: message}
-		for rows.Next() {
-			var memberID string
-			if err := rows.Scan(&memberID); err == nil {
-				h.hub.SendToUser(memberID, notification)
-			}
-		}
-	} else if message.RecipientID != nil {
-		// For direct messages, the payload is simpler
-		notification := websocket.Message{
-			Type:    "new_message",
-			Payload: message,
-		}
-		h.hub.SendToUser((*message.RecipientID).String(), notification)
+// respondJSON writes payload as the JSON body of a response with the given
+// status. The payload is encoded before anything is written, so a value that
+// fails to encode produces a 500 instead of a truncated body with the
+// intended status.
+func respondJSON(w http.ResponseWriter, code int, payload interface{}) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Failed to encode %T response: %v", payload, err)
+		code = http.StatusInternalServerError
+		body = []byte(`{"message":"Failed to encode response"}`)
 	}
+	// Match json.Encoder, which the handlers used to write with
+	body = append(body, '\n')
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(code)
+	w.Write(body)
 }
 
 // respondWithError is a helper to send a JSON error response.
 func respondWithError(w http.ResponseWriter, code int, message string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	json.NewEncoder(w).Encode(map[string]string{"message": message})
+	respondJSON(w, code, map[string]string{"message": message})
+}
+
+// clientIP returns the address the request came from, without its port
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// respondWithAppError translates a domain error into a JSON error response.
+// Errors outside the apperrors vocabulary are logged and reported as 500s.
+func respondWithAppError(w http.ResponseWriter, err error) {
+	status := apperrors.HTTPStatus(err)
+	if status == http.StatusInternalServerError {
+		log.Printf("Internal error: %v", err)
+	}
+
+	respondJSON(w, status, map[string]string{
+		"code":    apperrors.Code(err),
+		"message": apperrors.Message(err),
+	})
 }
 
 // Signup handles user registration
@@ -98,17 +135,38 @@ func (h *Handlers) Signup(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
+	if err := validateStruct(&req); err != nil {
+		respondWithValidationError(w, err)
+		return
+	}
+	if reason := h.invalidUsername(req.Username); reason != "" {
+		respondWithError(w, http.StatusBadRequest, reason)
+		return
+	}
+	if reason := h.invalidPassword(req.Password, req.Username); reason != "" {
+		respondWithError(w, http.StatusBadRequest, reason)
+		return
+	}
+	if h.cfg.InviteOnly && req.InviteCode == "" {
+		respondWithError(w, http.StatusForbidden, "An invite code is required to sign up")
+		return
+	}
 
-	// Check if user already exists
+	// Check if user already exists; usernames and emails are unique regardless of case
 	var existingUser models.User
-	err := h.db.QueryRow("SELECT id FROM users WHERE email = $1 OR username = $2", req.Email, req.Username).Scan(&existingUser.ID)
+	err := h.db.QueryRow("SELECT id FROM users WHERE LOWER(email) = LOWER($1) OR LOWER(username) = LOWER($2)", req.Email, req.Username).Scan(&existingUser.ID)
 	if err == nil {
 		respondWithError(w, http.StatusConflict, "A user with this email or username already exists")
 		return
 	}
 
 	// Hash password
-	hashedPassword := hashPassword(req.Password)
+	hashedPassword, err := passwordhash.Hash(req.Password)
+	if err != nil {
+		log.Printf("Signup password hashing error: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to create user")
+		return
+	}
 
 	// Create user
 	user := models.User{
@@ -116,35 +174,61 @@ func (h *Handlers) Signup(w http.ResponseWriter, r *http.Request) {
 		Username:  req.Username,
 		Email:     req.Email,
 		Password:  hashedPassword,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		CreatedAt: time.Now().UTC(),
+		UpdatedAt: time.Now().UTC(),
 	}
 
-	_, err = h.db.Exec(`
+	tx, err := h.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to create user")
+		return
+	}
+	defer tx.Rollback()
+
+	if h.cfg.InviteOnly {
+		// The row lock taken here keeps concurrent signups from overusing a code
+		result, err := tx.ExecContext(r.Context(), `
+			UPDATE invite_codes SET use_count = use_count + 1
+			WHERE code = $1 AND use_count < max_uses AND (expires_at IS NULL OR expires_at > NOW())
+		`, req.InviteCode)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Failed to create user")
+			return
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			respondWithError(w, http.StatusForbidden, "Invalid, expired or used up invite code")
+			return
+		}
+	}
+
+	_, err = tx.ExecContext(r.Context(), `
 		INSERT INTO users (id, username, email, password, created_at, updated_at)
 		VALUES ($1, $2, $3, $4, $5, $6)
 	`, user.ID, user.Username, user.Email, user.Password, user.CreatedAt, user.UpdatedAt)
 
+	if database.IsUniqueViolation(err) {
+		// Someone else registered the same name or email since the check above
+		respondWithError(w, http.StatusConflict, "A user with this email or username already exists")
+		return
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to create user")
 		return
 	}
+	h.svc.Webhooks().Emit(webhook.EventUserSignedUp, map[string]interface{}{"user_id": user.ID})
 
-	// Generate JWT token
-	token, err := h.generateToken(user.ID)
+	response := models.AuthResponse{User: user, DeviceID: uuid.New().String()}
+	response.Token, response.RefreshToken, err = h.issueTokens(r.Context(), user.ID, response.DeviceID)
 	if err != nil {
+		log.Printf("Signup token generation error: %v", err)
 		respondWithError(w, http.StatusInternalServerError, "Failed to generate token")
 		return
 	}
 
-	response := models.AuthResponse{
-		Token:    token,
-		User:     user,
-		DeviceID: uuid.New().String(),
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	respondJSON(w, http.StatusOK, response)
 }
 
 // Login handles user authentication
@@ -154,13 +238,17 @@ func (h *Handlers) Login(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
+	if err := validateStruct(&req); err != nil {
+		respondWithValidationError(w, err)
+		return
+	}
 
 	// Find user
 	var user models.User
 	var avatarURL sql.NullString
 	err := h.db.QueryRow(`
 		SELECT id, username, email, password, avatar_url, created_at, updated_at
-		FROM users WHERE email = $1
+		FROM users WHERE LOWER(email) = LOWER($1)
 	`, req.Email).Scan(&user.ID, &user.Username, &user.Email, &user.Password, &avatarURL, &user.CreatedAt, &user.UpdatedAt)
 
 	if err == sql.ErrNoRows {
@@ -181,31 +269,20 @@ func (h *Handlers) Login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Verify password
-	if !verifyPassword(req.Password, user.Password) {
+	if !verifyPassword(req.Password, user.Password, user.ID) {
 		respondWithError(w, http.StatusUnauthorized, "Invalid email or password")
 		return
 	}
 
-	// Generate JWT token
-	token, err := h.generateToken(user.ID)
+	response := models.AuthResponse{User: user, DeviceID: uuid.New().String()}
+	response.Token, response.RefreshToken, err = h.issueTokens(r.Context(), user.ID, response.DeviceID)
 	if err != nil {
 		log.Printf("Login token generation error: %v", err)
 		respondWithError(w, http.StatusInternalServerError, "Failed to generate token")
 		return
 	}
 
-	response := models.AuthResponse{
-		Token:    token,
-		User:     user,
-		DeviceID: uuid.New().String(),
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("Login JSON encoding error: %v", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to encode response")
-		return
-	}
+	respondJSON(w, http.StatusOK, response)
 }
 
 // UpdateProfile handles updating the current user's profile
@@ -217,10 +294,18 @@ func (h *Handlers) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
+	if err := validateStruct(&req); err != nil {
+		respondWithValidationError(w, err)
+		return
+	}
+	if reason := h.invalidUsername(req.Username); reason != "" {
+		respondWithError(w, http.StatusBadRequest, reason)
+		return
+	}
 
 	// Check if the new username is already taken by another user
 	var existingUserID uuid.UUID
-	err := h.db.QueryRow("SELECT id FROM users WHERE username = $1 AND id != $2", req.Username, userID).Scan(&existingUserID)
+	err := h.db.QueryRow("SELECT id FROM users WHERE LOWER(username) = LOWER($1) AND id != $2", req.Username, userID).Scan(&existingUserID)
 	if err != nil && err != sql.ErrNoRows {
 		respondWithError(w, http.StatusInternalServerError, "Database error while checking username")
 		return
@@ -238,7 +323,7 @@ func (h *Handlers) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 		SET username = $1, updated_at = $2 
 		WHERE id = $3
 		RETURNING id, username, email, password, avatar_url, created_at, updated_at
-	`, req.Username, time.Now(), userID).Scan(
+	`, req.Username, time.Now().UTC(), userID).Scan(
 		&updatedUser.ID, &updatedUser.Username, &updatedUser.Email, &updatedUser.Password, &avatarURL, &updatedUser.CreatedAt, &updatedUser.UpdatedAt,
 	)
 
@@ -247,6 +332,10 @@ func (h *Handlers) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 			respondWithError(w, http.StatusNotFound, "User not found")
 			return
 		}
+		if database.IsUniqueViolation(err) {
+			respondWithError(w, http.StatusConflict, "This username is already taken")
+			return
+		}
 		respondWithError(w, http.StatusInternalServerError, "Failed to update user profile")
 		return
 	}
@@ -255,16 +344,20 @@ func (h *Handlers) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 		updatedUser.AvatarURL = avatarURL.String
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(updatedUser)
+	respondJSON(w, http.StatusOK, updatedUser)
 }
 
+// multipartOverhead is the room left for form fields and part headers on top
+// of an upload's size limit
+const multipartOverhead = 64 << 10
+
 // UploadAvatar handles uploading a new profile picture for the current user
 func (h *Handlers) UploadAvatar(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
 
-	// 1. Parse the multipart form data (max 10MB)
-	if err := r.ParseMultipartForm(10 << 20); err != nil {
+	// 1. Parse the multipart form data
+	r.Body = http.MaxBytesReader(w, r.Body, h.cfg.MaxAvatarSize+multipartOverhead)
+	if err := r.ParseMultipartForm(h.cfg.MaxAvatarSize); err != nil {
 		respondWithError(w, http.StatusBadRequest, "File too large")
 		return
 	}
@@ -278,9 +371,9 @@ func (h *Handlers) UploadAvatar(w http.ResponseWriter, r *http.Request) {
 	defer file.Close()
 
 	// 3. Create the uploads directory if it doesn't exist
-	uploadsDir := "./uploads"
+	uploadsDir := h.cfg.AvatarsDir
 	if _, err := os.Stat(uploadsDir); os.IsNotExist(err) {
-		os.Mkdir(uploadsDir, 0755)
+		os.MkdirAll(uploadsDir, 0755)
 	}
 
 	// 4. Create a unique filename and destination file
@@ -305,15 +398,14 @@ func (h *Handlers) UploadAvatar(w http.ResponseWriter, r *http.Request) {
 
 	// 6. Update the user's avatar_url in the database
 	avatarURL := fmt.Sprintf("/uploads/%s", fileName)
-	_, err = h.db.Exec("UPDATE users SET avatar_url = $1, updated_at = $2 WHERE id = $3", avatarURL, time.Now(), userID)
+	_, err = h.db.Exec("UPDATE users SET avatar_url = $1, updated_at = $2 WHERE id = $3", avatarURL, time.Now().UTC(), userID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to update user profile")
 		return
 	}
 
 	// 7. Respond with the new URL
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"avatar_url": avatarURL})
+	respondJSON(w, http.StatusOK, map[string]string{"avatar_url": avatarURL})
 }
 
 // ChangePassword handles updating the current user's password
@@ -325,26 +417,37 @@ func (h *Handlers) ChangePassword(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
+	if err := validateStruct(&req); err != nil {
+		respondWithValidationError(w, err)
+		return
+	}
 
 	// 1. Fetch current user to get their current hashed password
-	var currentUser models.User
-	err := h.db.QueryRow("SELECT password FROM users WHERE id = $1", userID).Scan(&currentUser.Password)
+	currentUser, err := middleware.CurrentUser(r)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve user data")
+		respondWithAppError(w, err)
 		return
 	}
 
 	// 2. Verify the old password
-	if !verifyPassword(req.OldPassword, currentUser.Password) {
+	if !verifyPassword(req.OldPassword, currentUser.Password, userID) {
 		respondWithError(w, http.StatusUnauthorized, "Incorrect current password")
 		return
 	}
+	if reason := h.invalidPassword(req.NewPassword, currentUser.Username); reason != "" {
+		respondWithError(w, http.StatusBadRequest, reason)
+		return
+	}
 
 	// 3. Hash the new password
-	newHashedPassword := hashPassword(req.NewPassword)
+	newHashedPassword, err := passwordhash.Hash(req.NewPassword)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to update password")
+		return
+	}
 
 	// 4. Update the password in the database
-	_, err = h.db.Exec("UPDATE users SET password = $1, updated_at = $2 WHERE id = $3", newHashedPassword, time.Now(), userID)
+	_, err = h.db.Exec("UPDATE users SET password = $1, updated_at = $2 WHERE id = $3", newHashedPassword, time.Now().UTC(), userID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to update password")
 		return
@@ -357,12 +460,9 @@ func (h *Handlers) ChangePassword(w http.ResponseWriter, r *http.Request) {
 func (h *Handlers) DeleteAccount(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
 
-	// The ON DELETE CASCADE constraint on the users table should handle
-	// deleting all related data (messages, keys, group memberships, etc.)
-	_, err := h.db.Exec("DELETE FROM users WHERE id = $1", userID)
-	if err != nil {
+	if err := h.svc.DeleteAccount(r.Context(), userID); err != nil {
 		log.Printf("Failed to delete user account %s: %v", userID, err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to delete account")
+		respondWithAppError(w, err)
 		return
 	}
 
@@ -402,14 +502,28 @@ func (h *Handlers) GetUsers(w http.ResponseWriter, r *http.Request) {
 		users = append(users, user)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(users)
+	respondJSON(w, http.StatusOK, users)
 }
 
-// GetChats returns a list of chats for the current user
+// deletedAccountName stands in for the name of a direct message partner
+// whose account no longer exists
+const deletedAccountName = "Deleted account"
+
+// GetChats returns a list of chats for the current user. Archived chats are
+// left out unless include_archived=true is passed. Deleted messages are
+// skipped when picking each chat's last message.
 func (h *Handlers) GetChats(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
 
+	includeArchived := false
+	if v := r.URL.Query().Get("include_archived"); v != "" {
+		var err error
+		if includeArchived, err = strconv.ParseBool(v); err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid include_archived value")
+			return
+		}
+	}
+
 	// This query is now much more complex. It combines Direct Messages and Group Chats.
 	query := `
 	WITH all_chats AS (
@@ -422,7 +536,7 @@ func (h *Handlers) GetChats(w http.ResponseWriter, r *http.Request) {
 			m.encrypted_content,
 			m.message_type
 		FROM messages m
-		WHERE m.group_id IS NULL AND (m.sender_id = $1 OR m.recipient_id = $1)
+		WHERE m.group_id IS NULL AND (m.sender_id = $1 OR m.recipient_id = $1) AND m.deleted_at IS NULL
 
 		UNION ALL
 
@@ -435,7 +549,7 @@ func (h *Handlers) GetChats(w http.ResponseWriter, r *http.Request) {
 			m.encrypted_content,
 			m.message_type
 		FROM group_members gm
-		LEFT JOIN messages m ON gm.group_id = m.group_id
+		LEFT JOIN messages m ON gm.group_id = m.group_id AND m.deleted_at IS NULL
 		WHERE gm.user_id = $1
 	),
 	latest_chats AS (
@@ -453,23 +567,33 @@ func (h *Handlers) GetChats(w http.ResponseWriter, r *http.Request) {
 	SELECT
 		lc.chat_type,
 		lc.chat_id,
-		COALESCE(lc.last_message_at, '1970-01-01T00:00:00Z') as last_message_at,
+		COALESCE(lc.last_message_at, 'epoch'::timestamptz) as last_message_at,
 		u.id AS participant_id,
 		u.username AS participant_username,
 		u.avatar_url AS participant_avatar_url,
 		g.id AS group_id,
 		g.name AS group_name,
+		g.description AS group_description,
+		g.encrypted_metadata AS group_encrypted_metadata,
 		(SELECT COUNT(*) FROM group_members WHERE group_id = g.id) as participant_count,
 		lc.message_id,
 		lc.encrypted_content,
-		lc.message_type
+		lc.message_type,
+		pc.pinned_at IS NOT NULL AS is_pinned,
+		ac.archived_at IS NOT NULL AS is_archived
 	FROM latest_chats lc
 	LEFT JOIN users u ON lc.chat_type = 'dm' AND lc.chat_id = u.id
 	LEFT JOIN groups g ON lc.chat_type = 'group' AND lc.chat_id = g.id
-	ORDER BY last_message_at DESC;
+	LEFT JOIN pinned_conversations pc ON pc.user_id = $1 AND pc.conversation_id = lc.chat_id
+	-- An archive only holds until the next message
+	LEFT JOIN archived_conversations ac ON ac.user_id = $1 AND ac.conversation_id = lc.chat_id
+		AND ac.archived_at >= COALESCE(lc.last_message_at, 'epoch'::timestamptz)
+	WHERE $2 OR ac.archived_at IS NULL
+	-- Pinned chats first in the user's order, then everything by recency
+	ORDER BY is_pinned DESC, pc.position, pc.pinned_at DESC, last_message_at DESC;
 	`
 
-	rows, err := h.db.Query(query, userID)
+	rows, err := h.db.QueryContext(database.WithLabel(r.Context(), "get_chats"), query, userID, includeArchived)
 	if err != nil {
 		log.Printf("Error fetching chats: %v", err)
 		respondWithError(w, http.StatusInternalServerError, "Failed to fetch chats")
@@ -484,14 +608,14 @@ func (h *Handlers) GetChats(w http.ResponseWriter, r *http.Request) {
 		var chatID uuid.UUID
 		var lastMessageAt time.Time
 		var participantID, groupID, messageID sql.NullString
-		var participantUsername, participantAvatarURL, groupName, encryptedContent, messageType sql.NullString
+		var participantUsername, participantAvatarURL, groupName, groupDescription, groupEncryptedMetadata, encryptedContent, messageType sql.NullString
 		var participantCount sql.NullInt64
 
 		err := rows.Scan(
 			&chatType, &chatID, &lastMessageAt,
 			&participantID, &participantUsername, &participantAvatarURL,
-			&groupID, &groupName, &participantCount,
-			&messageID, &encryptedContent, &messageType,
+			&groupID, &groupName, &groupDescription, &groupEncryptedMetadata, &participantCount,
+			&messageID, &encryptedContent, &messageType, &chat.IsPinned, &chat.IsArchived,
 		)
 		if err != nil {
 			log.Printf("Error scanning chat row: %v", err)
@@ -511,8 +635,14 @@ func (h *Handlers) GetChats(w http.ResponseWriter, r *http.Request) {
 				Username:  participantUsername.String,
 				AvatarURL: participantAvatarURL.String,
 			}
+		} else if chatType == "dm" {
+			// The other party's account is gone
+			chat.Name = deletedAccountName
+			chat.Participant = &models.User{ID: chatID}
 		} else if chatType == "group" && groupID.Valid {
 			chat.Name = groupName.String
+			chat.Description = groupDescription.String
+			chat.EncryptedMetadata = groupEncryptedMetadata.String
 			chat.ParticipantCount = int(participantCount.Int64)
 		}
 
@@ -534,8 +664,7 @@ func (h *Handlers) GetChats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(chats)
+	respondJSON(w, http.StatusOK, chats)
 }
 
 // UploadDeviceKey handles device key upload
@@ -553,15 +682,16 @@ func (h *Handlers) UploadDeviceKey(w http.ResponseWriter, r *http.Request) {
 		UserID:    userID,
 		DeviceID:  req.DeviceID,
 		PublicKey: req.PublicKey,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		CreatedAt: time.Now().UTC(),
+		UpdatedAt: time.Now().UTC(),
 	}
 
 	_, err := h.db.Exec(`
 		INSERT INTO device_keys (id, user_id, device_id, public_key, created_at, updated_at)
 		VALUES ($1, $2, $3, $4, $5, $6)
 		ON CONFLICT (user_id, device_id) 
-		DO UPDATE SET public_key = $4, updated_at = $6
+		DO UPDATE SET public_key = $4, updated_at = $6,
+			key_changed_at = CASE WHEN device_keys.public_key != $4 THEN $6 ELSE device_keys.key_changed_at END
 	`, deviceKey.ID, deviceKey.UserID, deviceKey.DeviceID, deviceKey.PublicKey, deviceKey.CreatedAt, deviceKey.UpdatedAt)
 
 	if err != nil {
@@ -569,8 +699,7 @@ func (h *Handlers) UploadDeviceKey(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(deviceKey)
+	respondJSON(w, http.StatusOK, deviceKey)
 }
 
 // UploadOneTimeKey handles one-time key upload
@@ -587,29 +716,43 @@ func (h *Handlers) UploadOneTimeKey(w http.ResponseWriter, r *http.Request) {
 		ID:        uuid.New(),
 		UserID:    userID,
 		KeyID:     req.KeyID,
+		DeviceID:  req.DeviceID,
 		PublicKey: req.PublicKey,
 		Used:      false,
-		CreatedAt: time.Now(),
+		CreatedAt: time.Now().UTC(),
 	}
 
-	_, err := h.db.Exec(`
-		INSERT INTO one_time_keys (id, user_id, key_id, public_key, used, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
+	// Re-uploading a key replaces it only while it is unused and on the same
+	// device, so nobody can take over another device's key or revive a used one
+	result, err := h.db.Exec(`
+		INSERT INTO one_time_keys (id, user_id, key_id, public_key, used, created_at, device_id)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))
 		ON CONFLICT (user_id, key_id) 
-		DO UPDATE SET public_key = $4, used = $5
-	`, oneTimeKey.ID, oneTimeKey.UserID, oneTimeKey.KeyID, oneTimeKey.PublicKey, oneTimeKey.Used, oneTimeKey.CreatedAt)
+		DO UPDATE SET public_key = $4
+		WHERE one_time_keys.used = false AND one_time_keys.device_id IS NOT DISTINCT FROM NULLIF($7, '')
+	`, oneTimeKey.ID, oneTimeKey.UserID, oneTimeKey.KeyID, oneTimeKey.PublicKey, oneTimeKey.Used, oneTimeKey.CreatedAt, oneTimeKey.DeviceID)
 
+	var stored int64
+	if err == nil {
+		stored, err = result.RowsAffected()
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to upload one-time key")
 		return
 	}
+	if stored == 0 {
+		respondWithError(w, http.StatusConflict, "key_id is already in use")
+		return
+	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(oneTimeKey)
+	respondJSON(w, http.StatusOK, oneTimeKey)
 }
 
-// GetBootstrapKeys returns device and one-time keys for a user
+// GetBootstrapKeys returns a user's device keys and claims one of their
+// one-time keys, if their privacy settings allow the current user to fetch them
 func (h *Handlers) GetBootstrapKeys(w http.ResponseWriter, r *http.Request) {
+	requesterID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
 	userIDStr := r.URL.Query().Get("user_id")
 	if userIDStr == "" {
 		respondWithError(w, http.StatusBadRequest, "user_id parameter required")
@@ -622,14 +765,54 @@ func (h *Handlers) GetBootstrapKeys(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := h.svc.AuthorizeKeyBootstrap(r.Context(), requesterID, userID); err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	response, err := h.loadBootstrapKeys(r.Context(), userID)
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+	if requesterID != userID && response.OneTimeKey != nil {
+		if err := h.svc.NotifySessionInitiated(r.Context(), requesterID, userID); err != nil {
+			log.Printf("Failed to notify %s of a session initiated by %s: %v", userID, requesterID, err)
+		}
+	}
+	if response.OneTimeKey != nil {
+		if err := h.svc.CheckPrekeySupply(r.Context(), userID); err != nil {
+			log.Printf("Failed to check the one-time key supply of %s: %v", userID, err)
+		}
+	}
+
+	respondJSON(w, http.StatusOK, response)
+}
+
+// loadBootstrapKeys fetches a user's device keys and claims their oldest
+// unused one-time key, marking it used so no other initiator gets it
+func (h *Handlers) loadBootstrapKeys(ctx context.Context, userID uuid.UUID) (*models.BootstrapKeysResponse, error) {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", userID).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to look up user: %w", err)
+	}
+	if !exists {
+		return nil, apperrors.ErrUserNotFound
+	}
+
 	// Get device keys
-	deviceRows, err := h.db.Query(`
+	deviceRows, err := tx.QueryContext(ctx, `
 		SELECT id, user_id, device_id, public_key, created_at, updated_at
 		FROM device_keys WHERE user_id = $1
 	`, userID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to fetch device keys")
-		return
+		return nil, fmt.Errorf("failed to fetch device keys: %w", err)
 	}
 	defer deviceRows.Close()
 
@@ -638,42 +821,41 @@ func (h *Handlers) GetBootstrapKeys(w http.ResponseWriter, r *http.Request) {
 		var key models.DeviceKey
 		err := deviceRows.Scan(&key.ID, &key.UserID, &key.DeviceID, &key.PublicKey, &key.CreatedAt, &key.UpdatedAt)
 		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "Failed to scan device key")
-			return
+			return nil, fmt.Errorf("failed to scan device key: %w", err)
 		}
 		deviceKeys = append(deviceKeys, key)
 	}
+	deviceRows.Close()
+	if err := deviceRows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch device keys: %w", err)
+	}
 
-	// Get unused one-time keys (limit to 10)
-	oneTimeRows, err := h.db.Query(`
-		SELECT id, user_id, key_id, public_key, used, created_at
+	// Concurrent bootstraps skip keys another one is claiming
+	var key models.OneTimeKey
+	var deviceID sql.NullString
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, user_id, key_id, device_id, public_key, created_at
 		FROM one_time_keys WHERE user_id = $1 AND used = false
-		ORDER BY created_at ASC LIMIT 10
-	`, userID)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to fetch one-time keys")
-		return
+		ORDER BY created_at ASC LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`, userID).Scan(&key.ID, &key.UserID, &key.KeyID, &deviceID, &key.PublicKey, &key.CreatedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to fetch one-time key: %w", err)
 	}
-	defer oneTimeRows.Close()
-
-	var oneTimeKeys []models.OneTimeKey
-	for oneTimeRows.Next() {
-		var key models.OneTimeKey
-		err := oneTimeRows.Scan(&key.ID, &key.UserID, &key.KeyID, &key.PublicKey, &key.Used, &key.CreatedAt)
-		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "Failed to scan one-time key")
-			return
+	response := &models.BootstrapKeysResponse{DeviceKeys: deviceKeys}
+	if err == nil {
+		if _, err := tx.ExecContext(ctx, "UPDATE one_time_keys SET used = true WHERE id = $1", key.ID); err != nil {
+			return nil, fmt.Errorf("failed to claim one-time key: %w", err)
 		}
-		oneTimeKeys = append(oneTimeKeys, key)
+		key.DeviceID = deviceID.String
+		key.Used = true
+		response.OneTimeKey = &key
 	}
 
-	response := models.BootstrapKeysResponse{
-		DeviceKeys:  deviceKeys,
-		OneTimeKeys: oneTimeKeys,
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	return response, nil
 }
 
 // SendMessage handles message sending
@@ -685,173 +867,198 @@ func (h *Handlers) SendMessage(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
-
-	// A message must have either a recipient or a group
-	if req.RecipientID == nil && req.GroupID == nil {
-		respondWithError(w, http.StatusBadRequest, "Message must have a recipient_id or a group_id")
+	if err := validateStruct(&req); err != nil {
+		respondWithValidationError(w, err)
 		return
 	}
 
-	message := models.Message{
-		ID:               uuid.New(),
+	input := service.SendMessageInput{
 		SenderID:         userID,
 		EncryptedContent: req.EncryptedContent,
 		MessageType:      req.MessageType,
-		CreatedAt:        time.Now(),
+		EncryptionScheme: req.EncryptionScheme,
+		Priority:         req.Priority,
+		WaitForDelivery:  req.WaitForDelivery,
+		ViewOnce:         req.ViewOnce,
 	}
 
 	if req.GroupID != nil {
-		// This is a group message
 		groupID, err := uuid.Parse(*req.GroupID)
 		if err != nil {
 			respondWithError(w, http.StatusBadRequest, "Invalid group_id format")
 			return
 		}
-		message.GroupID = &groupID
-
-		// Verify the sender is a member of the group
-		var memberCount int
-		err = h.db.QueryRow("SELECT COUNT(*) FROM group_members WHERE group_id = $1 AND user_id = $2", groupID, userID).Scan(&memberCount)
-		if err != nil || memberCount == 0 {
-			respondWithError(w, http.StatusForbidden, "You are not a member of this group")
+		input.GroupID = &groupID
+	}
+	if req.RecipientID != nil {
+		recipientID, err := uuid.Parse(*req.RecipientID)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid recipient_id format")
 			return
 		}
-
-		// Insert group message into DB
-		_, err = h.db.Exec(`
-			INSERT INTO messages (id, sender_id, group_id, encrypted_content, message_type, created_at)
-			VALUES ($1, $2, $3, $4, $5, $6)
-		`, message.ID, message.SenderID, message.GroupID, message.EncryptedContent, message.MessageType, message.CreatedAt)
+		input.RecipientID = &recipientID
+	}
+	for _, mentionStr := range req.Mentions {
+		mention, err := uuid.Parse(mentionStr)
 		if err != nil {
-			log.Printf("Database error on group message insert: %v", err)
-			respondWithError(w, http.StatusInternalServerError, "Failed to send group message")
+			respondWithError(w, http.StatusBadRequest, "Invalid mention format")
 			return
 		}
-	} else {
-		// This is a direct message
-		recipientID, err := uuid.Parse(*req.RecipientID)
-		message.RecipientID = &recipientID
+		input.Mentions = append(input.Mentions, mention)
+	}
+
+	message, err := h.svc.SendMessage(r.Context(), input)
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, message)
+}
+
+// RedeliverMessage re-sends a message's real-time event to its recipients
+func (h *Handlers) RedeliverMessage(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	messageID, err := uuid.Parse(chi.URLParam(r, "messageID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid messageID format")
+		return
+	}
+
+	if err := h.svc.RedeliverMessage(r.Context(), userID, messageID); err != nil {
+		respondWithAppError(w, err)
+		return
+	}
 
-		// Insert direct message into DB
-		_, err = h.db.Exec(`
-			INSERT INTO messages (id, sender_id, recipient_id, encrypted_content, message_type, created_at)
-			VALUES ($1, $2, $3, $4, $5, $6)
-		`, message.ID, message.SenderID, message.RecipientID, message.EncryptedContent, message.MessageType, message.CreatedAt)
+	w.WriteHeader(http.StatusAccepted)
+}
 
+// DeleteMessages deletes several of the caller's messages at once
+func (h *Handlers) DeleteMessages(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	var req models.DeleteMessagesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	messageIDs := make([]uuid.UUID, 0, len(req.MessageIDs))
+	for _, idStr := range req.MessageIDs {
+		messageID, err := uuid.Parse(idStr)
 		if err != nil {
-			log.Printf("Database error on message insert: %v", err)
-			respondWithError(w, http.StatusInternalServerError, "Failed to send message")
+			respondWithError(w, http.StatusBadRequest, "Invalid message_ids format")
 			return
 		}
+		messageIDs = append(messageIDs, messageID)
 	}
 
-	// Send real-time notification, but only if it's not a file message.
-	// File message notifications are sent by UploadAttachment after the upload is complete.
-	if message.MessageType != "file" {
-		h.notifyNewMessage(message)
+	deleted, err := h.svc.DeleteMessages(r.Context(), userID, messageIDs, req.SkipUnauthorized)
+	if err != nil {
+		respondWithAppError(w, err)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(message)
+	respondJSON(w, http.StatusOK, models.DeleteMessagesResponse{DeletedIDs: deleted})
 }
 
-// GetMessages handles message retrieval
-func (h *Handlers) GetMessages(w http.ResponseWriter, r *http.Request) {
+// DeleteMessage unsends one of the caller's messages
+func (h *Handlers) DeleteMessage(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
 
-	// Get query parameters
-	recipientIDStr := r.URL.Query().Get("recipient_id")
-	groupIDStr := r.URL.Query().Get("group_id")
-	limitStr := r.URL.Query().Get("limit")
+	messageID, err := uuid.Parse(chi.URLParam(r, "messageID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid messageID format")
+		return
+	}
 
-	// Set default limit
-	limit := 50 // default limit
+	if err := h.svc.DeleteMessage(r.Context(), userID, messageID); err != nil {
+		respondWithAppError(w, err)
+		return
+	}
 
-	if limitStr != "" {
-		if parsedLimit, err := strconv.Atoi(limitStr); err == nil && parsedLimit > 0 && parsedLimit <= 100 {
-			limit = parsedLimit
-		}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// EditMessage replaces the content of one of the caller's messages
+func (h *Handlers) EditMessage(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	messageID, err := uuid.Parse(chi.URLParam(r, "messageID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid messageID format")
+		return
+	}
+
+	var req models.EditMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := validateStruct(&req); err != nil {
+		respondWithValidationError(w, err)
+		return
 	}
 
-	var query string
-	var args []interface{}
+	message, err := h.svc.EditMessage(r.Context(), userID, messageID, req.EncryptedContent)
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
 
-	if groupIDStr != "" {
-		// Fetching messages for a group
+	respondJSON(w, http.StatusOK, message)
+}
+
+// GetMessages handles message retrieval; see Service.MessageHistory for
+// how "before", "after" and "cursor" page through a conversation. The
+// X-Next-Cursor header is kept for older clients.
+func (h *Handlers) GetMessages(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	// Use the configured default limit, clamping larger requests to the max.
+	// The cursor is the composite (created_at, id) of the oldest message of
+	// the previous page, so messages sharing a timestamp are neither skipped
+	// nor repeated.
+	page, err := h.cfg.MessagePages().Parse(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+	query := service.MessageHistoryQuery{
+		Before: r.URL.Query().Get("before"),
+		After:  r.URL.Query().Get("after"),
+		Page:   page,
+	}
+
+	if groupIDStr := r.URL.Query().Get("group_id"); groupIDStr != "" {
 		groupID, err := uuid.Parse(groupIDStr)
 		if err != nil {
 			respondWithError(w, http.StatusBadRequest, "Invalid group_id format")
 			return
 		}
-		// TODO: Verify user is a member of the group before fetching messages
-		query = `
-			SELECT sub.id, sub.sender_id, sub.group_id, sub.encrypted_content, sub.message_type, sub.created_at, u.id, u.username, u.avatar_url FROM (
-				SELECT id, sender_id, group_id, encrypted_content, message_type, created_at
-				FROM messages
-				WHERE group_id = $1
-				ORDER BY created_at DESC
-				LIMIT $2
-			) sub
-			JOIN users u ON sub.sender_id = u.id
-			ORDER BY sub.created_at ASC;
-		`
-		args = []interface{}{groupID, limit}
-
-	} else if recipientIDStr != "" {
-		// Fetching messages for a DM
+		query.GroupID = &groupID
+	} else if recipientIDStr := r.URL.Query().Get("recipient_id"); recipientIDStr != "" {
 		recipientID, err := uuid.Parse(recipientIDStr)
 		if err != nil {
 			respondWithError(w, http.StatusBadRequest, "Invalid recipient_id format")
 			return
 		}
-		query = `
-			SELECT id, sender_id, recipient_id, encrypted_content, message_type, created_at FROM (
-				SELECT id, sender_id, recipient_id, encrypted_content, message_type, created_at
-				FROM messages 
-				WHERE ((sender_id = $1 AND recipient_id = $2) OR (sender_id = $2 AND recipient_id = $1))
-				ORDER BY created_at DESC
-				LIMIT $3
-			) sub
-			ORDER BY created_at ASC;
-		`
-		args = []interface{}{userID, recipientID, limit}
-
+		query.RecipientID = &recipientID
 	} else {
 		respondWithError(w, http.StatusBadRequest, "Either recipient_id or group_id parameter is required")
 		return
 	}
 
-	rows, err := h.db.Query(query, args...)
+	messages, err := h.svc.MessageHistory(r.Context(), userID, query)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to fetch messages")
+		respondWithAppError(w, err)
 		return
 	}
-	defer rows.Close()
-
-	var messages []models.Message
-	for rows.Next() {
-		var message models.Message
-		if groupIDStr != "" {
-			var sender models.User
-			var avatarURL sql.NullString
-			err = rows.Scan(&message.ID, &message.SenderID, &message.GroupID, &message.EncryptedContent, &message.MessageType, &message.CreatedAt, &sender.ID, &sender.Username, &avatarURL)
-			if avatarURL.Valid {
-				sender.AvatarURL = avatarURL.String
-			}
-			message.Sender = &sender
-		} else {
-			err = rows.Scan(&message.ID, &message.SenderID, &message.RecipientID, &message.EncryptedContent, &message.MessageType, &message.CreatedAt)
-		}
-
-		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "Failed to scan message")
-			return
-		}
-		messages = append(messages, message)
+	if messages.NextCursor != "" {
+		w.Header().Set("X-Next-Cursor", messages.NextCursor)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(messages)
+	respondJSON(w, http.StatusOK, messages)
 }
 
 // SendReceipt handles message receipt sending
@@ -870,45 +1077,13 @@ func (h *Handlers) SendReceipt(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	receipt := models.Receipt{
-		ID:        uuid.New(),
-		MessageID: messageID,
-		UserID:    userID,
-		Type:      req.Type,
-		CreatedAt: time.Now(),
-	}
-
-	_, err = h.db.Exec(`
-		INSERT INTO receipts (id, message_id, user_id, type, created_at)
-		VALUES ($1, $2, $3, $4, $5)
-		ON CONFLICT (message_id, user_id, type) DO NOTHING
-	`, receipt.ID, receipt.MessageID, receipt.UserID, receipt.Type, receipt.CreatedAt)
-
+	receipt, err := h.svc.SendReceipt(r.Context(), userID, messageID, req.Type)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to send receipt")
+		respondWithAppError(w, err)
 		return
 	}
 
-	// Send real-time notification to sender
-	notification := map[string]interface{}{
-		"type": "message_receipt",
-		"payload": map[string]interface{}{
-			"message_id": messageID,
-			"user_id":    userID,
-			"type":       req.Type,
-			"created_at": receipt.CreatedAt,
-		},
-	}
-
-	// Get sender ID from message
-	var senderID uuid.UUID
-	err = h.db.QueryRow("SELECT sender_id FROM messages WHERE id = $1", messageID).Scan(&senderID)
-	if err == nil {
-		h.hub.SendToUser(senderID.String(), notification)
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(receipt)
+	respondJSON(w, http.StatusOK, receipt)
 }
 
 // CreateGroup handles the creation of a new group chat
@@ -920,83 +1095,43 @@ func (h *Handlers) CreateGroup(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusBadRequest, "Invalid request body")
 		return
 	}
-
-	// Start a database transaction
-	tx, err := h.db.Begin()
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to start database transaction")
-		return
-	}
-	// Defer a rollback in case of error, commit will override this if successful
-	defer tx.Rollback()
-
-	// 1. Create the group
-	group := models.Group{
-		ID:        uuid.New(),
-		Name:      req.Name,
-		CreatedBy: userID,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	}
-
-	_, err = tx.Exec(`
-		INSERT INTO groups (id, name, created_by, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5)
-	`, group.ID, group.Name, group.CreatedBy, group.CreatedAt, group.UpdatedAt)
-
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to create group")
-		return
-	}
-
-	// 2. Add the creator as an admin member
-	_, err = tx.Exec(`
-		INSERT INTO group_members (group_id, user_id, role)
-		VALUES ($1, $2, 'admin')
-	`, group.ID, userID)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to add creator to group")
+	if err := validateStruct(&req); err != nil {
+		respondWithValidationError(w, err)
 		return
 	}
 
-	// 3. Add the other members
-	stmt, err := tx.Prepare("INSERT INTO group_members (group_id, user_id, role) VALUES ($1, $2, 'member')")
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to prepare member insertion")
-		return
+	input := service.CreateGroupInput{
+		CreatorID:         userID,
+		Name:              req.Name,
+		Description:       req.Description,
+		EncryptedMetadata: req.EncryptedMetadata,
 	}
-	defer stmt.Close()
-
 	for _, memberIDStr := range req.MemberIDs {
 		memberID, err := uuid.Parse(memberIDStr)
 		if err != nil {
 			// Skip invalid UUIDs
 			continue
 		}
-		if _, err := stmt.Exec(group.ID, memberID); err != nil {
-			respondWithError(w, http.StatusInternalServerError, "Failed to add member to group")
-			return
-		}
+		input.MemberIDs = append(input.MemberIDs, memberID)
 	}
 
-	// If all went well, commit the transaction
-	if err := tx.Commit(); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to commit transaction")
+	group, err := h.svc.CreateGroup(r.Context(), input)
+	if err != nil {
+		respondWithAppError(w, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(group)
+	respondJSON(w, http.StatusCreated, group)
 }
 
 // UploadAttachment handles uploading a file attachment for a message
 func (h *Handlers) UploadAttachment(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
 
-	// 1. Parse the multipart form data (max 50MB for files)
-	if err := r.ParseMultipartForm(50 << 20); err != nil {
-		respondWithError(w, http.StatusBadRequest, "File too large (max 50MB)")
+	// 1. Parse the multipart form data
+	r.Body = http.MaxBytesReader(w, r.Body, h.cfg.MaxAttachmentSize+multipartOverhead)
+	if err := r.ParseMultipartForm(h.cfg.MaxAttachmentSize); err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("File too large (max %dMB)", h.cfg.MaxAttachmentSize>>20))
 		return
 	}
 
@@ -1008,6 +1143,12 @@ func (h *Handlers) UploadAttachment(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
+	// Refuse executables and scripts by their declared name and type
+	if reason := h.blockedAttachment(handler.Filename, handler.Header.Get("Content-Type")); reason != "" {
+		respondWithError(w, http.StatusBadRequest, reason)
+		return
+	}
+
 	// 3. Get other form fields
 	messageIDStr := r.FormValue("message_id")
 	encryptedKey := r.FormValue("encrypted_key")
@@ -1025,7 +1166,10 @@ func (h *Handlers) UploadAttachment(w http.ResponseWriter, r *http.Request) {
 	// Verify that the user has permission to attach a file to this message
 	// (e.g., they are the sender of the message).
 	var senderID uuid.UUID
-	err = h.db.QueryRow("SELECT sender_id FROM messages WHERE id = $1", messageID).Scan(&senderID)
+	err = h.db.QueryRow(`
+		SELECT sender_id FROM messages
+		WHERE id = $1 AND deleted_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+	`, messageID).Scan(&senderID)
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "Message not found")
 		return
@@ -1035,70 +1179,47 @@ func (h *Handlers) UploadAttachment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 4. Create a unique path and save the file
-	uploadsDir := "./uploads/attachments"
-	if _, err := os.Stat(uploadsDir); os.IsNotExist(err) {
-		os.MkdirAll(uploadsDir, 0755)
+	// 4. Save the file to a temporary location, hashing it on the way so
+	// identical uploads can share one stored copy
+	uploadsDir := filepath.Join(h.cfg.AttachmentsDir, "tmp")
+	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to save file")
+		return
 	}
-
-	// Use message ID for folder to keep attachments organized
-	attachmentDir := filepath.Join(uploadsDir, messageID.String())
-	os.MkdirAll(attachmentDir, 0755)
-	dstPath := filepath.Join(attachmentDir, handler.Filename)
-
-	dst, err := os.Create(dstPath)
+	dst, err := os.CreateTemp(uploadsDir, "upload-*")
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to save file")
 		return
 	}
-	defer dst.Close()
+	defer os.Remove(dst.Name())
 
-	if _, err := io.Copy(dst, file); err != nil {
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(dst, hash), file)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to save file content")
 		return
 	}
 
-	// 5. Create the attachment record in the database
-	_, err = h.db.Exec(`
-		INSERT INTO attachments (message_id, file_name, file_size, mime_type, storage_path, encrypted_key)
-		VALUES ($1, $2, $3, $4, $5, $6)
-	`, messageID, handler.Filename, handler.Size, handler.Header.Get("Content-Type"), dstPath, encryptedKey)
+	// 5. Create the attachment record in the database. The "new_message" event
+	// is queued in the same transaction now that the attachment is ready.
+	err = h.svc.AddAttachment(r.Context(), models.Attachment{
+		MessageID:    messageID,
+		FileName:     handler.Filename,
+		FileSize:     handler.Size,
+		MimeType:     handler.Header.Get("Content-Type"),
+		EncryptedKey: encryptedKey,
+		ContentHash:  hex.EncodeToString(hash.Sum(nil)),
+	}, dst.Name())
 	if err != nil {
 		log.Printf("Failed to create attachment record: %v", err)
 		respondWithError(w, http.StatusInternalServerError, "Failed to create attachment record")
 		return
 	}
 
-	// 6. Fetch the full message details and broadcast the "new_message" event now that the attachment is ready.
-	var message models.Message
-	var recipientID, groupID sql.NullString
-	err = h.db.QueryRow(`
-		SELECT id, sender_id, recipient_id, group_id, encrypted_content, message_type, created_at
-		FROM messages WHERE id = $1
-	`, messageID).Scan(
-		&message.ID, &message.SenderID, &recipientID, &groupID, &message.EncryptedContent, &message.MessageType, &message.CreatedAt,
-	)
-
-	if err != nil {
-		log.Printf("Failed to fetch message for attachment notification: %v", err)
-		// The upload was successful, so we still return a success status.
-		// The recipient will get the message on the next refresh.
-	} else {
-		// Re-construct the message object with the correct UUID types for the helper
-		if groupID.Valid {
-			gid, _ := uuid.Parse(groupID.String)
-			message.GroupID = &gid
-		}
-		if recipientID.Valid {
-			rid, _ := uuid.Parse(recipientID.String)
-			message.RecipientID = &rid
-		}
-		// Send the notification
-		h.notifyNewMessage(message)
-	}
-
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	respondJSON(w, http.StatusCreated, map[string]string{"status": "success"})
 }
 
 // DownloadAttachment serves a file for download
@@ -1126,7 +1247,7 @@ func (h *Handlers) DownloadAttachment(w http.ResponseWriter, r *http.Request) {
 		SELECT a.storage_path, a.mime_type, a.file_name, m.sender_id, m.recipient_id, m.group_id
 		FROM attachments a
 		JOIN messages m ON a.message_id = m.id
-		WHERE a.message_id = $1
+		WHERE a.message_id = $1 AND m.deleted_at IS NULL AND (m.expires_at IS NULL OR m.expires_at > NOW())
 	`, messageID).Scan(&storagePath, &mimeType, &fileName, &senderID, &recipientID, &groupID)
 
 	if err == sql.ErrNoRows {
@@ -1140,20 +1261,7 @@ func (h *Handlers) DownloadAttachment(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 2. Authorization Check: Verify the user is part of the conversation
-	isAuthorized := false
-	if groupID.Valid { // Group Message
-		var memberCount int
-		err = h.db.QueryRow("SELECT COUNT(*) FROM group_members WHERE group_id = $1 AND user_id = $2", groupID.String, userID).Scan(&memberCount)
-		if err == nil && memberCount > 0 {
-			isAuthorized = true
-		}
-	} else if senderID.Valid && recipientID.Valid { // Direct Message
-		if senderID.String == userID.String() || recipientID.String == userID.String() {
-			isAuthorized = true
-		}
-	}
-
-	if !isAuthorized {
+	if !h.canAccessMessage(r.Context(), userID, senderID, recipientID, groupID) {
 		respondWithError(w, http.StatusForbidden, "You are not authorized to download this attachment")
 		return
 	}
@@ -1188,31 +1296,114 @@ func (h *Handlers) DownloadAttachment(w http.ResponseWriter, r *http.Request) {
 // WebSocketHandler handles WebSocket connections
 func (h *Handlers) WebSocketHandler(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
-	websocket.ServeWS(h.hub, w, r, userID.String())
+
+	// Record where the connection comes from for the "active sessions" screen
+	session, err := h.svc.OpenSession(r.Context(), userID, sessionMetadata(r))
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	deviceID, _ := r.Context().Value(middleware.DeviceIDKey).(string)
+	h.serveSession(w, r, userID, session.ID, deviceID)
+}
+
+// ResumeWebSocket reconnects a client to the session its resumption token
+// was issued for, without a JWT. Clients whose token is rejected reconnect
+// to WebSocketHandler with full authentication.
+func (h *Handlers) ResumeWebSocket(w http.ResponseWriter, r *http.Request) {
+	state, ok := h.hub.Resume(r.URL.Query().Get("resume_token"))
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired resume token")
+		return
+	}
+	userID, err := uuid.Parse(state.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired resume token")
+		return
+	}
+	sessionID, err := uuid.Parse(state.SessionID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired resume token")
+		return
+	}
+
+	if err := h.svc.ReopenSession(r.Context(), userID, sessionID, sessionMetadata(r)); err != nil {
+		if errors.Is(err, apperrors.ErrNotFound) {
+			respondWithError(w, http.StatusUnauthorized, "Invalid or expired resume token")
+			return
+		}
+		respondWithAppError(w, err)
+		return
+	}
+
+	h.serveSession(w, r, userID, sessionID, state.DeviceID)
+}
+
+// sessionMetadata describes where a websocket connection comes from
+func sessionMetadata(r *http.Request) service.SessionMetadata {
+	return service.SessionMetadata{
+		RemoteAddr: clientIP(r),
+		UserAgent:  r.UserAgent(),
+		Origin:     r.Header.Get("Origin"),
+	}
+}
+
+// serveSession upgrades the request to a websocket for the session on
+// deviceID and closes the session once the connection is gone
+func (h *Handlers) serveSession(w http.ResponseWriter, r *http.Request, userID, sessionID uuid.UUID, deviceID string) {
+	session := websocket.ResumeState{UserID: userID.String(), SessionID: sessionID.String(), DeviceID: deviceID}
+	websocket.ServeWS(h.hub, w, r, session, func(lastActive time.Time) {
+		if err := h.svc.CloseSession(context.Background(), sessionID, lastActive); err != nil {
+			log.Printf("Failed to close session %s: %v", sessionID, err)
+		}
+	})
 }
 
 // Helper functions
 
-func (h *Handlers) generateToken(userID uuid.UUID) (string, error) {
+// issueTokens returns an access token and the first refresh token of a new
+// login on deviceID
+func (h *Handlers) issueTokens(ctx context.Context, userID uuid.UUID, deviceID string) (token, refreshToken string, err error) {
+	refreshToken, familyID, err := h.svc.IssueRefreshToken(ctx, userID, deviceID)
+	if err != nil {
+		return "", "", err
+	}
+	token, err = h.generateToken(userID, familyID, deviceID)
+	if err != nil {
+		return "", "", err
+	}
+	return token, refreshToken, nil
+}
+
+// generateToken signs an access token for the login on deviceID whose
+// refresh tokens are in familyID, so logging out or revoking the device can
+// revoke it too
+func (h *Handlers) generateToken(userID, familyID uuid.UUID, deviceID string) (string, error) {
 	claims := jwt.MapClaims{
-		"user_id": userID.String(),
-		"exp":     time.Now().Add(time.Hour * 24 * 7).Unix(), // 7 days
-		"iat":     time.Now().Unix(),
+		"user_id":   userID.String(),
+		"family_id": familyID.String(),
+		"device_id": deviceID,
+		"jti":       uuid.NewString(), // lets the token be revoked on its own
+		"iss":       h.cfg.JWTIssuer,
+		"aud":       h.cfg.JWTAudience,
+		"exp":       time.Now().Add(h.cfg.AccessTokenTTL).Unix(),
+		"iat":       time.Now().Unix(),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte(h.cfg.JWTSecret))
 }
 
-func hashPassword(password string) string {
-	// Using Argon2id for password hashing
-	salt := []byte("random-salt-change-in-production") // In production, use random salt per user
-	hash := argon2.IDKey([]byte(password), salt, 1, 64*1024, 4, 32)
-	return fmt.Sprintf("%x", hash)
-}
-
-func verifyPassword(password, hashedPassword string) bool {
-	// In production, implement proper Argon2id verification
-	// For now, using simple comparison (NOT SECURE - for demo only)
-	return hashPassword(password) == hashedPassword
+// verifyPassword reports whether password matches the user's stored hash.
+// Hashes in an unsupported format, such as the unsalted digests of early
+// versions, never match, so those accounts cannot log in until a new
+// password is set for them.
+func verifyPassword(password, hashedPassword string, userID uuid.UUID) bool {
+	ok, err := passwordhash.Verify(password, hashedPassword)
+	if err != nil {
+		log.Printf("Cannot verify password of user %s: %v", userID, err)
+		return false
+	}
+	return ok
 }
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"e2ee-messenger/server/internal/middleware"
+	"e2ee-messenger/server/internal/models"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// GetKeyStatus returns the current user's device count, key ages and unused one-time key counts
+func (h *Handlers) GetKeyStatus(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	status, err := h.svc.KeyStatus(r.Context(), userID)
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, status)
+}
+
+// RotateOneTimeKeys atomically replaces the unused one-time keys of one of the
+// current user's devices, for when they may have been exposed
+func (h *Handlers) RotateOneTimeKeys(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	var req models.RotateOneTimeKeysRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	keys, err := h.svc.RotateOneTimeKeys(r.Context(), userID, req.DeviceID, req.Keys)
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, keys)
+}
+
+// ListDevices returns the devices that uploaded keys under the current user's account
+func (h *Handlers) ListDevices(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	devices, err := h.svc.ListDevices(r.Context(), userID)
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, devices)
+}
+
+// RevokeDevice removes one of the current user's devices along with its keys
+func (h *Handlers) RevokeDevice(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	if err := h.svc.RevokeDevice(r.Context(), userID, chi.URLParam(r, "deviceID")); err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
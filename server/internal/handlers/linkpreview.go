@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/linkpreview"
+	"e2ee-messenger/server/internal/middleware"
+
+	"github.com/google/uuid"
+)
+
+// linkPreviewLimit is how many previews a user may request per minute
+const linkPreviewLimit = 60
+
+// GetLinkPreview fetches the OpenGraph metadata of a URL so the client can
+// show a preview without contacting the site itself. URLs come from
+// decrypted messages, so they are never logged.
+func (h *Handlers) GetLinkPreview(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	if !h.linkPreviewLimiter.Allow(userID.String()) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(h.linkPreviewLimiter.RetryAfter(userID.String()).Seconds()+1)))
+		respondWithAppError(w, apperrors.ErrRateLimited)
+		return
+	}
+
+	rawURL := r.URL.Query().Get("url")
+	if rawURL == "" {
+		respondWithError(w, http.StatusBadRequest, "url is required")
+		return
+	}
+
+	preview, err := h.linkPreviews.Fetch(r.Context(), rawURL)
+	switch {
+	case errors.Is(err, linkpreview.ErrInvalidURL):
+		respondWithError(w, http.StatusBadRequest, "Invalid url")
+		return
+	case errors.Is(err, linkpreview.ErrBlockedAddress):
+		respondWithError(w, http.StatusForbidden, "URL is not allowed")
+		return
+	case err != nil:
+		respondWithError(w, http.StatusBadGateway, "Failed to fetch link preview")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, preview)
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+// commonPasswords holds the lowercase passwords that are always rejected
+var commonPasswords = func() map[string]bool {
+	passwords := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(commonPasswordList))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			passwords[line] = true
+		}
+	}
+	return passwords
+}()
+
+// passwordClasses are the character classes a password policy can require,
+// with how violations describe them
+var passwordClasses = map[string]struct {
+	name    string
+	matches func(rune) bool
+}{
+	"lower":  {"lowercase", unicode.IsLower},
+	"upper":  {"uppercase", unicode.IsUpper},
+	"digit":  {"numeric", unicode.IsDigit},
+	"symbol": {"symbol", func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r) }},
+}
+
+// invalidPassword reports why password breaks the password policy, or "" if
+// it complies. The user's username is never accepted as their password.
+func (h *Handlers) invalidPassword(password, username string) string {
+	if len([]rune(password)) < h.cfg.PasswordMinLength {
+		return fmt.Sprintf("Password must be at least %d characters long", h.cfg.PasswordMinLength)
+	}
+	for _, class := range h.cfg.PasswordRequiredClasses {
+		if c, ok := passwordClasses[class]; ok && !strings.ContainsFunc(password, c.matches) {
+			return fmt.Sprintf("Password must contain at least one %s character", c.name)
+		}
+	}
+	lower := strings.ToLower(password)
+	if commonPasswords[lower] {
+		return "This password is too common"
+	}
+	if username != "" && lower == strings.ToLower(username) {
+		return "Password must not be the same as the username"
+	}
+	return ""
+}
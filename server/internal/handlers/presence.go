@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"e2ee-messenger/server/internal/middleware"
+
+	"github.com/google/uuid"
+)
+
+// GetPresence returns whether the users listed in the user_ids query
+// parameter (comma-separated) are online, and when they were last seen
+func (h *Handlers) GetPresence(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	var userIDs []uuid.UUID
+	for _, idStr := range strings.Split(r.URL.Query().Get("user_ids"), ",") {
+		if idStr = strings.TrimSpace(idStr); idStr == "" {
+			continue
+		}
+		id, err := uuid.Parse(idStr)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid user_ids format")
+			return
+		}
+		userIDs = append(userIDs, id)
+	}
+
+	presences, err := h.svc.GetPresence(r.Context(), userID, userIDs)
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, presences)
+}
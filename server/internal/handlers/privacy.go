@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"e2ee-messenger/server/internal/middleware"
+	"e2ee-messenger/server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// GetPrivacySettings returns the current user's privacy preferences
+func (h *Handlers) GetPrivacySettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	settings, err := h.svc.PrivacySettings(r.Context(), userID)
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, settings)
+}
+
+// UpdatePrivacySettings changes the current user's privacy preferences
+func (h *Handlers) UpdatePrivacySettings(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	var req models.UpdatePrivacySettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	settings, err := h.svc.UpdatePrivacySettings(r.Context(), userID, req)
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, settings)
+}
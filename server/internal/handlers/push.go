@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"e2ee-messenger/server/internal/middleware"
+	"e2ee-messenger/server/internal/models"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// RegisterPushToken stores or refreshes the push token of one of the current user's devices
+func (h *Handlers) RegisterPushToken(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	var req models.RegisterPushTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	token, err := h.svc.RegisterPushToken(r.Context(), userID, chi.URLParam(r, "deviceID"), req.Platform, req.Token)
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, token)
+}
+
+// RemovePushToken unregisters the push token of one of the current user's devices
+func (h *Handlers) RemovePushToken(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	if err := h.svc.RemovePushToken(r.Context(), userID, chi.URLParam(r, "deviceID")); err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
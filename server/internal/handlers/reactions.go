@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+
+	"e2ee-messenger/server/internal/middleware"
+	"e2ee-messenger/server/internal/models"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Reactions are not end-to-end encrypted: the server stores the emoji in
+// plaintext and relays it to the conversation's participants, so it can see
+// who reacted to which message with what.
+
+// AddReaction reacts to a message with an emoji
+func (h *Handlers) AddReaction(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	messageID, err := uuid.Parse(chi.URLParam(r, "messageID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid messageID format")
+		return
+	}
+
+	var req models.AddReactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := validateStruct(&req); err != nil {
+		respondWithValidationError(w, err)
+		return
+	}
+
+	if !h.requireMessageAccess(w, r, userID, messageID) {
+		return
+	}
+	reaction, err := h.svc.AddReaction(r.Context(), userID, messageID, req.Emoji)
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, reaction)
+}
+
+// RemoveReaction takes back the current user's reaction to a message
+func (h *Handlers) RemoveReaction(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	messageID, err := uuid.Parse(chi.URLParam(r, "messageID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid messageID format")
+		return
+	}
+	// Clients percent-encode the emoji in the path
+	emoji, err := url.PathUnescape(chi.URLParam(r, "emoji"))
+	if err != nil || emoji == "" {
+		respondWithError(w, http.StatusBadRequest, "Invalid emoji")
+		return
+	}
+
+	if !h.requireMessageAccess(w, r, userID, messageID) {
+		return
+	}
+	if err := h.svc.RemoveReaction(r.Context(), userID, messageID, emoji); err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requireMessageAccess checks, as DownloadAttachment does, that a message
+// exists and the user is part of its conversation. Otherwise it responds
+// with an error and returns false.
+func (h *Handlers) requireMessageAccess(w http.ResponseWriter, r *http.Request, userID, messageID uuid.UUID) bool {
+	var senderID, recipientID, groupID sql.NullString
+	err := h.db.QueryRowContext(r.Context(), `
+		SELECT sender_id, recipient_id, group_id FROM messages
+		WHERE id = $1 AND deleted_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+	`, messageID).Scan(&senderID, &recipientID, &groupID)
+	if err == sql.ErrNoRows {
+		respondWithError(w, http.StatusNotFound, "Message not found")
+		return false
+	}
+	if err != nil {
+		log.Printf("Error fetching message %s: %v", messageID, err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve message")
+		return false
+	}
+	if !h.canAccessMessage(r.Context(), userID, senderID, recipientID, groupID) {
+		respondWithError(w, http.StatusForbidden, "You are not part of this conversation")
+		return false
+	}
+	return true
+}
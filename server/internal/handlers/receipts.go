@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"e2ee-messenger/server/internal/middleware"
+	"e2ee-messenger/server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// SendBulkReceipts handles acknowledging a batch of messages at once
+func (h *Handlers) SendBulkReceipts(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	var req models.BulkReceiptRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	messageIDs := make([]uuid.UUID, 0, len(req.MessageIDs))
+	for _, messageIDStr := range req.MessageIDs {
+		messageID, err := uuid.Parse(messageIDStr)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid message_id format")
+			return
+		}
+		messageIDs = append(messageIDs, messageID)
+	}
+
+	receipts, err := h.svc.SendBulkReceipts(r.Context(), userID, messageIDs, req.Type)
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, receipts)
+}
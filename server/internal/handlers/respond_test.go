@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestRespondJSON(t *testing.T) {
+	w := httptest.NewRecorder()
+	respondJSON(w, http.StatusCreated, map[string]string{"status": "success"})
+
+	if w.Code != http.StatusCreated {
+		t.Errorf("Expected status %d, got %d", http.StatusCreated, w.Code)
+	}
+	if got := w.Header().Get("Content-Length"); got != strconv.Itoa(w.Body.Len()) {
+		t.Errorf("Expected Content-Length %d, got %s", w.Body.Len(), got)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body["status"] != "success" {
+		t.Errorf("Expected the payload as the body, got %q", w.Body.String())
+	}
+}
+
+func TestRespondJSONUnencodablePayloadIs500(t *testing.T) {
+	w := httptest.NewRecorder()
+	respondJSON(w, http.StatusOK, map[string]interface{}{"callback": func() {}})
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected status %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Expected a complete JSON error body, got %q", w.Body.String())
+	}
+	if body["message"] == "" {
+		t.Errorf("Expected an error message, got %v", body)
+	}
+}
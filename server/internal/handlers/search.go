@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"net/http"
+
+	"e2ee-messenger/server/internal/middleware"
+
+	"github.com/google/uuid"
+)
+
+// Search finds users and the current user's groups by name, most recently
+// talked to first
+func (h *Handlers) Search(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	results, err := h.svc.Search(r.Context(), userID, r.URL.Query().Get("q"))
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, results)
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"e2ee-messenger/server/internal/middleware"
+	"e2ee-messenger/server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// GetSessions returns the current user's recent websocket sessions
+func (h *Handlers) GetSessions(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	sessions, err := h.svc.ListSessions(r.Context(), userID)
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, sessions)
+}
+
+// Logout revokes the token the request was made with and the refresh tokens
+// of its login. Other devices stay logged in.
+func (h *Handlers) Logout(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+	tokenID, ok := r.Context().Value(middleware.TokenIDKey).(string)
+	expiresAt, hasExpiry := r.Context().Value(middleware.TokenExpiryKey).(time.Time)
+	if !ok || !hasExpiry {
+		respondWithError(w, http.StatusBadRequest, "This token cannot be revoked on its own, log out of all devices instead")
+		return
+	}
+
+	// Revoke the refresh tokens first: it is safe to repeat if revoking the
+	// access token fails
+	if familyID, ok := r.Context().Value(middleware.TokenFamilyKey).(uuid.UUID); ok {
+		if err := h.svc.RevokeRefreshTokenFamily(r.Context(), userID, familyID); err != nil {
+			respondWithAppError(w, err)
+			return
+		}
+	}
+	if err := h.svc.RevokeToken(r.Context(), userID, tokenID, expiresAt); err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Refresh exchanges a refresh token for a new access token. The refresh token
+// is rotated: the response carries its replacement and it can't be used again.
+func (h *Handlers) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req models.RefreshTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if err := validateStruct(&req); err != nil {
+		respondWithValidationError(w, err)
+		return
+	}
+
+	refreshed, err := h.svc.RotateRefreshToken(r.Context(), req.RefreshToken)
+	if err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+	token, err := h.generateToken(refreshed.UserID, refreshed.FamilyID, refreshed.DeviceID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, models.RefreshTokenResponse{
+		Token:        token,
+		RefreshToken: refreshed.RefreshToken,
+		DeviceID:     refreshed.DeviceID,
+	})
+}
+
+// LogoutAll revokes all of the current user's tokens and closes their
+// websocket connections, logging them out on every device
+func (h *Handlers) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(middleware.UserIDKey).(uuid.UUID)
+
+	if err := h.svc.LogoutAll(r.Context(), userID); err != nil {
+		respondWithAppError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
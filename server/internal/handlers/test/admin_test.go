@@ -0,0 +1,66 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"e2ee-messenger/server/internal/config"
+	"e2ee-messenger/server/internal/handlers"
+	"e2ee-messenger/server/internal/middleware"
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/testutil"
+	"e2ee-messenger/server/internal/websocket"
+
+	"github.com/google/uuid"
+)
+
+func TestSetMaintenanceMakesTheServerReadOnly(t *testing.T) {
+	// Without a service the switch is only kept in memory, so no database is
+	// needed
+	hub := testutil.NewHub(t)
+	h := handlers.New(nil, hub, config.Load(), nil)
+	client := testutil.ConnectClient(t, hub, uuid.New())
+
+	setMaintenance := func(body string) models.MaintenanceStatus {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.SetMaintenance(rr, httptest.NewRequest(http.MethodPut, "/v1/admin/maintenance", strings.NewReader(body)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		var status models.MaintenanceStatus
+		if err := json.NewDecoder(rr.Body).Decode(&status); err != nil {
+			t.Fatalf("Failed to decode status: %v", err)
+		}
+		return status
+	}
+
+	status := setMaintenance(`{"enabled": true, "message": "Upgrading the database"}`)
+	if !status.Enabled || status.Message != "Upgrading the database" {
+		t.Errorf("Unexpected maintenance status: %+v", status)
+	}
+	event := testutil.ExpectEvent(t, client, websocket.EventMaintenance)
+	if payload := event.Payload.(map[string]interface{}); payload["enabled"] != true || payload["message"] != "Upgrading the database" {
+		t.Errorf("Unexpected maintenance event: %v", payload)
+	}
+
+	routes := middleware.ReadOnlyDuringMaintenance(h.Maintenance())(http.HandlerFunc(h.GetCapabilities))
+	rr := httptest.NewRecorder()
+	routes.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/messages", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected a POST to be rejected with %d, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	routes.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/capabilities", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected a GET to succeed with %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	if status := setMaintenance(`{"enabled": false}`); status.Enabled {
+		t.Error("Expected maintenance mode to be off")
+	}
+	testutil.ExpectEvent(t, client, websocket.EventMaintenance)
+}
@@ -0,0 +1,160 @@
+package test
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"e2ee-messenger/server/internal/config"
+	"e2ee-messenger/server/internal/handlers"
+	"e2ee-messenger/server/internal/testutil"
+
+	"github.com/google/uuid"
+)
+
+// attachmentUpload builds a multipart attachment upload request
+func attachmentUpload(t *testing.T, fileName, contentType string, content []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="attachment"; filename="`+fileName+`"`)
+	header.Set("Content-Type", contentType)
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		t.Fatalf("Failed to create form file: %v", err)
+	}
+	part.Write(content)
+	writer.WriteField("message_id", uuid.NewString())
+	writer.WriteField("encrypted_key", "encrypted-key")
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages/attachment", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return withUser(req, uuid.New())
+}
+
+func TestUploadAttachmentRejectsBlockedFiles(t *testing.T) {
+	// The blocklist is checked before the database is touched
+	cfg := config.Load()
+	h := handlers.New(nil, nil, cfg, nil)
+
+	tests := []struct {
+		name        string
+		fileName    string
+		contentType string
+		content     []byte
+		reason      string
+	}{
+		{"blocked extension with a benign MIME type", "holiday.exe", "image/png", []byte("ciphertext"), ".exe"},
+		{"extension is case-insensitive", "INSTALL.BAT", "text/plain", []byte("ciphertext"), ".bat"},
+		{"trailing dots are ignored", "run.sh.", "text/plain", []byte("ciphertext"), ".sh"},
+		{"blocked MIME type", "notes", "application/x-msdownload", []byte("ciphertext"), "application/x-msdownload"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			h.UploadAttachment(rr, attachmentUpload(t, tt.fileName, tt.contentType, tt.content))
+
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, rr.Code)
+			}
+			if !strings.Contains(rr.Body.String(), tt.reason) {
+				t.Errorf("Expected the error to mention %q, got %s", tt.reason, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestDownloadAttachmentArchive(t *testing.T) {
+	h, db := setupTestHandlers(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	mallory := testutil.CreateUser(t, db, "mallory")
+
+	var messageID uuid.UUID
+	err := db.QueryRow(`
+		INSERT INTO messages (sender_id, recipient_id, encrypted_content, message_type)
+		VALUES ($1, $2, 'ciphertext', 'file') RETURNING id
+	`, alice.ID, bob.ID).Scan(&messageID)
+	if err != nil {
+		t.Fatalf("Failed to seed message: %v", err)
+	}
+
+	// Duplicate and path-like names must still give one flat entry per file
+	dir := t.TempDir()
+	files := []struct{ name, content string }{
+		{"photo.jpg", "encrypted photo"},
+		{"photo.jpg", "another encrypted photo"},
+		{"../notes.txt", "encrypted notes"},
+	}
+	for i, f := range files {
+		path := filepath.Join(dir, uuid.NewString())
+		if err := os.WriteFile(path, []byte(f.content), 0644); err != nil {
+			t.Fatalf("Failed to write attachment: %v", err)
+		}
+		_, err := db.Exec(`
+			INSERT INTO attachments (message_id, file_name, file_size, mime_type, storage_path, encrypted_key, created_at)
+			VALUES ($1, $2, $3, 'application/octet-stream', $4, 'encrypted-key', NOW() + $5 * INTERVAL '1 second')
+		`, messageID, f.name, len(f.content), path, i)
+		if err != nil {
+			t.Fatalf("Failed to seed attachment: %v", err)
+		}
+	}
+
+	download := func(userID uuid.UUID) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/v1/messages/"+messageID.String()+"/attachments/archive", nil)
+		rr := httptest.NewRecorder()
+		h.DownloadAttachmentArchive(rr, withURLParam(withUser(req, userID), "messageID", messageID.String()))
+		return rr
+	}
+
+	if rr := download(mallory.ID); rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected status %d for an outsider, got %d", http.StatusForbidden, rr.Code)
+	}
+
+	rr := download(bob.ID)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/zip" {
+		t.Errorf("Expected Content-Type application/zip, got %q", ct)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatalf("Failed to read archive: %v", err)
+	}
+	want := []struct{ name, content string }{
+		{"photo.jpg", "encrypted photo"},
+		{"photo (2).jpg", "another encrypted photo"},
+		{"notes.txt", "encrypted notes"},
+	}
+	if len(archive.File) != len(want) {
+		t.Fatalf("Expected %d files in the archive, got %d", len(want), len(archive.File))
+	}
+	for i, f := range archive.File {
+		if f.Name != want[i].name {
+			t.Errorf("Expected file %d to be named %q, got %q", i, want[i].name, f.Name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", f.Name, err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		if string(content) != want[i].content {
+			t.Errorf("Expected %s to contain %q, got %q", f.Name, want[i].content, content)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"e2ee-messenger/server/internal/config"
+	"e2ee-messenger/server/internal/handlers"
+	"e2ee-messenger/server/internal/models"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestGetCapabilitiesReflectsConfig(t *testing.T) {
+	cfg := config.Load()
+	cfg.MaxAttachmentSize = 5 << 20
+	cfg.MaxGroupMembers = 12
+	cfg.MaxMessageLimit = 40
+	cfg.DeleteOnRead = true
+	h := handlers.New(nil, nil, cfg, nil)
+
+	// Only features whose routes are mounted are advertised
+	r := chi.NewRouter()
+	r.Route("/v1", func(r chi.Router) {
+		r.Post("/receipts", h.SendReceipt)
+	})
+	if err := h.RegisterRoutes(r); err != nil {
+		t.Fatalf("RegisterRoutes failed: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/capabilities", nil)
+	rr := httptest.NewRecorder()
+	h.GetCapabilities(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	var capabilities models.Capabilities
+	if err := json.NewDecoder(rr.Body).Decode(&capabilities); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if capabilities.MaxAttachmentSize != 5<<20 {
+		t.Errorf("Expected max attachment size %d, got %d", 5<<20, capabilities.MaxAttachmentSize)
+	}
+	if capabilities.MaxGroupMembers != 12 {
+		t.Errorf("Expected max group members 12, got %d", capabilities.MaxGroupMembers)
+	}
+	if capabilities.MaxMessageLimit != 40 {
+		t.Errorf("Expected max message limit 40, got %d", capabilities.MaxMessageLimit)
+	}
+	if capabilities.ProtocolVersion < 1 {
+		t.Errorf("Expected a protocol version, got %d", capabilities.ProtocolVersion)
+	}
+	for _, feature := range []string{"read_receipts", "delete_on_read", "mentions"} {
+		if !slices.Contains(capabilities.Features, feature) {
+			t.Errorf("Expected %s among the features, got %v", feature, capabilities.Features)
+		}
+	}
+	if slices.Contains(capabilities.Features, "search") {
+		t.Errorf("Expected search to be left out without its route, got %v", capabilities.Features)
+	}
+	if !slices.Contains(capabilities.Features, "wait_for_delivery") {
+		t.Errorf("Expected wait_for_delivery on a single instance, got %v", capabilities.Features)
+	}
+
+	cfg.RedisURL = "redis://localhost:6379/0"
+	rr = httptest.NewRecorder()
+	h.GetCapabilities(rr, req)
+	capabilities = models.Capabilities{}
+	if err := json.NewDecoder(rr.Body).Decode(&capabilities); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if slices.Contains(capabilities.Features, "wait_for_delivery") {
+		t.Errorf("Expected wait_for_delivery to be left out with Redis, got %v", capabilities.Features)
+	}
+	if !slices.Contains(capabilities.MessageTypes, "file") {
+		t.Errorf("Expected file among the message types, got %v", capabilities.MessageTypes)
+	}
+}
@@ -0,0 +1,274 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"e2ee-messenger/server/internal/config"
+	"e2ee-messenger/server/internal/handlers"
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/service"
+	"e2ee-messenger/server/internal/testutil"
+
+	"github.com/google/uuid"
+)
+
+func TestGetChatsSortsPinnedConversationsFirst(t *testing.T) {
+	h, db := setupTestHandlers(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	carol := testutil.CreateUser(t, db, "carol")
+
+	// bob's conversation is older than carol's
+	for i, recipient := range []uuid.UUID{bob.ID, carol.ID} {
+		_, err := db.Exec(`
+			INSERT INTO messages (sender_id, recipient_id, encrypted_content, message_type, created_at)
+			VALUES ($1, $2, 'ciphertext', 'text', $3)
+		`, alice.ID, recipient, time.Now().Add(time.Duration(i-2)*time.Hour))
+		if err != nil {
+			t.Fatalf("Failed to seed message: %v", err)
+		}
+	}
+
+	getChats := func() []models.Chat {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.GetChats(rr, withUser(httptest.NewRequest(http.MethodGet, "/v1/chats", nil), alice.ID))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		var chats []models.Chat
+		if err := json.NewDecoder(rr.Body).Decode(&chats); err != nil {
+			t.Fatalf("Failed to decode chats: %v", err)
+		}
+		if len(chats) != 2 {
+			t.Fatalf("Expected 2 chats, got %d", len(chats))
+		}
+		return chats
+	}
+
+	if chats := getChats(); chats[0].ID != carol.ID.String() || chats[0].IsPinned {
+		t.Fatalf("Expected carol's unpinned chat first before pinning, got %+v", chats[0])
+	}
+
+	cfg := config.Load()
+	svc := service.New(db, testutil.NewHub(t), cfg)
+	if err := svc.PinConversation(context.Background(), alice.ID, bob.ID); err != nil {
+		t.Fatalf("PinConversation failed: %v", err)
+	}
+
+	chats := getChats()
+	if chats[0].ID != bob.ID.String() || !chats[0].IsPinned {
+		t.Errorf("Expected bob's pinned chat first, got %+v", chats[0])
+	}
+	if chats[1].IsPinned {
+		t.Errorf("Expected carol's chat not to be pinned")
+	}
+}
+
+func TestGetChatsFollowsPinOrder(t *testing.T) {
+	h, db := setupTestHandlers(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	carol := testutil.CreateUser(t, db, "carol")
+	dave := testutil.CreateUser(t, db, "dave")
+
+	svc := service.New(db, testutil.NewHub(t), config.Load())
+	ctx := context.Background()
+	for _, peer := range []uuid.UUID{bob.ID, carol.ID, dave.ID} {
+		if _, err := db.Exec(`
+			INSERT INTO messages (sender_id, recipient_id, encrypted_content, message_type)
+			VALUES ($1, $2, 'ciphertext', 'text')
+		`, alice.ID, peer); err != nil {
+			t.Fatalf("Failed to seed message: %v", err)
+		}
+		if err := svc.PinConversation(ctx, alice.ID, peer); err != nil {
+			t.Fatalf("PinConversation failed: %v", err)
+		}
+	}
+
+	order := func() []string {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.GetChats(rr, withUser(httptest.NewRequest(http.MethodGet, "/v1/chats", nil), alice.ID))
+		var chats []models.Chat
+		if err := json.NewDecoder(rr.Body).Decode(&chats); err != nil {
+			t.Fatalf("Failed to decode chats: %v", err)
+		}
+		var ids []string
+		for _, chat := range chats {
+			ids = append(ids, chat.ID)
+		}
+		return ids
+	}
+	// The latest pin is on top
+	if got := order(); got[0] != dave.ID.String() {
+		t.Fatalf("Expected dave's chat on top, got %v", got)
+	}
+
+	// carol first, bob second; dave is left out and goes below them
+	body := `{"conversation_ids": ["` + carol.ID.String() + `", "` + bob.ID.String() + `"]}`
+	rr := httptest.NewRecorder()
+	h.ReorderPinnedConversations(rr, withUser(httptest.NewRequest(http.MethodPut, "/v1/conversations/pins", strings.NewReader(body)), alice.ID))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, rr.Code, rr.Body.String())
+	}
+	want := []string{carol.ID.String(), bob.ID.String(), dave.ID.String()}
+	if got := order(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected order %v, got %v", want, got)
+	}
+}
+
+func TestGetChatsHidesArchivedConversations(t *testing.T) {
+	h, db := setupTestHandlers(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	carol := testutil.CreateUser(t, db, "carol")
+
+	send := func(sender, recipient uuid.UUID) {
+		t.Helper()
+		_, err := db.Exec(`
+			INSERT INTO messages (sender_id, recipient_id, encrypted_content, message_type)
+			VALUES ($1, $2, 'ciphertext', 'text')
+		`, sender, recipient)
+		if err != nil {
+			t.Fatalf("Failed to seed message: %v", err)
+		}
+	}
+	send(alice.ID, bob.ID)
+	send(alice.ID, carol.ID)
+
+	getChats := func(query string) map[string]models.Chat {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.GetChats(rr, withUser(httptest.NewRequest(http.MethodGet, "/v1/chats"+query, nil), alice.ID))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		var chats []models.Chat
+		if err := json.NewDecoder(rr.Body).Decode(&chats); err != nil {
+			t.Fatalf("Failed to decode chats: %v", err)
+		}
+		byID := make(map[string]models.Chat)
+		for _, chat := range chats {
+			byID[chat.ID] = chat
+		}
+		return byID
+	}
+
+	svc := service.New(db, testutil.NewHub(t), config.Load())
+	if err := svc.ArchiveConversation(context.Background(), alice.ID, bob.ID); err != nil {
+		t.Fatalf("ArchiveConversation failed: %v", err)
+	}
+
+	chats := getChats("")
+	if _, ok := chats[bob.ID.String()]; ok {
+		t.Error("Expected bob's archived chat to be hidden")
+	}
+	if _, ok := chats[carol.ID.String()]; !ok {
+		t.Error("Expected carol's chat to be listed")
+	}
+
+	chats = getChats("?include_archived=true")
+	if chat, ok := chats[bob.ID.String()]; !ok || !chat.IsArchived {
+		t.Errorf("Expected bob's chat to be listed as archived, got %+v", chat)
+	}
+
+	// A new message brings the conversation back
+	send(bob.ID, alice.ID)
+	chats = getChats("")
+	if chat, ok := chats[bob.ID.String()]; !ok || chat.IsArchived {
+		t.Errorf("Expected bob's chat to be unarchived by new activity, got %+v", chat)
+	}
+}
+
+func TestGetChatsRejectsInvalidIncludeArchived(t *testing.T) {
+	h := handlers.New(nil, nil, config.Load(), nil)
+
+	rr := httptest.NewRecorder()
+	h.GetChats(rr, withUser(httptest.NewRequest(http.MethodGet, "/v1/chats?include_archived=maybe", nil), uuid.New()))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestGetChatsReturnsEncryptedGroupMetadata(t *testing.T) {
+	h, db := setupTestHandlers(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+
+	svc := service.New(db, testutil.NewHub(t), config.Load())
+	group, err := svc.CreateGroup(context.Background(), service.CreateGroupInput{
+		CreatorID:         alice.ID,
+		MemberIDs:         []uuid.UUID{bob.ID},
+		EncryptedMetadata: "sealed",
+	})
+	if err != nil {
+		t.Fatalf("CreateGroup failed: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	h.GetChats(rr, withUser(httptest.NewRequest(http.MethodGet, "/v1/chats", nil), bob.ID))
+	var chats []models.Chat
+	if err := json.NewDecoder(rr.Body).Decode(&chats); err != nil {
+		t.Fatalf("Failed to decode chats: %v", err)
+	}
+	if len(chats) != 1 || chats[0].ID != group.ID.String() {
+		t.Fatalf("Expected the group chat, got %+v", chats)
+	}
+	if chats[0].EncryptedMetadata != "sealed" || chats[0].Name != "" {
+		t.Errorf("Expected only the encrypted metadata, got %+v", chats[0])
+	}
+}
+
+func TestGetChatsSkipsDeletedLastMessage(t *testing.T) {
+	h, db := setupTestHandlers(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+
+	var ids []uuid.UUID
+	for i := 0; i < 2; i++ {
+		var id uuid.UUID
+		err := db.QueryRow(`
+			INSERT INTO messages (sender_id, recipient_id, encrypted_content, message_type, created_at)
+			VALUES ($1, $2, 'ciphertext', 'text', $3) RETURNING id
+		`, alice.ID, bob.ID, time.Now().Add(time.Duration(i-2)*time.Hour)).Scan(&id)
+		if err != nil {
+			t.Fatalf("Failed to seed message: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	svc := service.New(db, testutil.NewHub(t), config.Load())
+	if _, err := svc.DeleteMessages(context.Background(), alice.ID, ids[1:], false); err != nil {
+		t.Fatalf("DeleteMessages failed: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	h.GetChats(rr, withUser(httptest.NewRequest(http.MethodGet, "/v1/chats", nil), bob.ID))
+	var chats []models.Chat
+	if err := json.NewDecoder(rr.Body).Decode(&chats); err != nil {
+		t.Fatalf("Failed to decode chats: %v", err)
+	}
+	if len(chats) != 1 || chats[0].LastMessage == nil {
+		t.Fatalf("Expected one chat with a last message, got %+v", chats)
+	}
+	if chats[0].LastMessage.ID != ids[0] {
+		t.Errorf("Expected the last message before the deleted one, got %s", chats[0].LastMessage.ID)
+	}
+
+	rr = httptest.NewRecorder()
+	h.GetMessages(rr, withUser(httptest.NewRequest(http.MethodGet, "/v1/messages?recipient_id="+alice.ID.String(), nil), bob.ID))
+	var page models.MessagePage
+	if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+		t.Fatalf("Failed to decode messages: %v", err)
+	}
+	messages := page.Messages
+	if len(messages) != 1 || messages[0].ID != ids[0] {
+		t.Errorf("Expected only the remaining message, got %+v", messages)
+	}
+}
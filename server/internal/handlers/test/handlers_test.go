@@ -7,31 +7,55 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"e2ee-messenger/server/internal/config"
 	"e2ee-messenger/server/internal/database"
 	"e2ee-messenger/server/internal/handlers"
+	"e2ee-messenger/server/internal/middleware"
 	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/service"
+	"e2ee-messenger/server/internal/testutil"
 	"e2ee-messenger/server/internal/websocket"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 )
 
-func setupTestHandlers() *handlers.Handlers {
-	// Create in-memory database for testing
-	db, _ := database.New(":memory:")
-	database.Migrate(db)
+func setupTestHandlers(t *testing.T) (*handlers.Handlers, *database.DB) {
+	t.Helper()
 
-	hub := websocket.NewHub()
-	cfg := &config.Config{
-		JWTSecret: "test-secret",
-	}
+	db := testutil.NewDB(t)
+	hub := testutil.NewHub(t)
+	cfg := config.Load()
+	cfg.JWTSecret = "test-secret"
+
+	svc := service.New(db, hub, cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go svc.RunOutboxRelay(ctx)
+
+	return handlers.New(db, hub, cfg, svc), db
+}
+
+// withUser attaches an authenticated user ID to the request, as the auth middleware would
+func withUser(req *http.Request, userID uuid.UUID) *http.Request {
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, userID)
+	return req.WithContext(ctx)
+}
 
-	return handlers.New(db, hub, cfg)
+// withURLParam sets a chi route parameter on the request, as the router would
+func withURLParam(req *http.Request, key, value string) *http.Request {
+	rctx := chi.RouteContext(req.Context())
+	if rctx == nil {
+		rctx = chi.NewRouteContext()
+	}
+	rctx.URLParams.Add(key, value)
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
 }
 
 func TestSignup(t *testing.T) {
-	h := setupTestHandlers()
+	h, _ := setupTestHandlers(t)
 
 	tests := []struct {
 		name           string
@@ -44,7 +68,7 @@ func TestSignup(t *testing.T) {
 			request: models.SignupRequest{
 				Username: "testuser",
 				Email:    "test@example.com",
-				Password: "password123",
+				Password: "correct-horse-battery",
 			},
 			expectedStatus: http.StatusOK,
 			expectError:    false,
@@ -53,7 +77,27 @@ func TestSignup(t *testing.T) {
 			name: "missing username",
 			request: models.SignupRequest{
 				Email:    "test@example.com",
-				Password: "password123",
+				Password: "correct-horse-battery",
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectError:    true,
+		},
+		{
+			name: "username with invalid characters",
+			request: models.SignupRequest{
+				Username: "test user/1",
+				Email:    "test@example.com",
+				Password: "correct-horse-battery",
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectError:    true,
+		},
+		{
+			name: "reserved username",
+			request: models.SignupRequest{
+				Username: "Admin",
+				Email:    "test@example.com",
+				Password: "correct-horse-battery",
 			},
 			expectedStatus: http.StatusBadRequest,
 			expectError:    true,
@@ -63,7 +107,7 @@ func TestSignup(t *testing.T) {
 			request: models.SignupRequest{
 				Username: "testuser",
 				Email:    "invalid-email",
-				Password: "password123",
+				Password: "correct-horse-battery",
 			},
 			expectedStatus: http.StatusBadRequest,
 			expectError:    true,
@@ -111,14 +155,35 @@ func TestSignup(t *testing.T) {
 	}
 }
 
+func TestSignupUsernamesAreCaseInsensitive(t *testing.T) {
+	h, _ := setupTestHandlers(t)
+
+	signup := func(username, email string) int {
+		body, _ := json.Marshal(models.SignupRequest{Username: username, Email: email, Password: "correct-horse-battery"})
+		w := httptest.NewRecorder()
+		h.Signup(w, httptest.NewRequest("POST", "/v1/auth/signup", bytes.NewBuffer(body)))
+		return w.Code
+	}
+
+	if code := signup("Alice", "alice@example.com"); code != http.StatusOK {
+		t.Fatalf("Expected the first signup to succeed, got %d", code)
+	}
+	if code := signup("alice", "other@example.com"); code != http.StatusConflict {
+		t.Errorf("Expected a username differing only in case to conflict, got %d", code)
+	}
+	if code := signup("bob", "ALICE@example.com"); code != http.StatusConflict {
+		t.Errorf("Expected an email differing only in case to conflict, got %d", code)
+	}
+}
+
 func TestLogin(t *testing.T) {
-	h := setupTestHandlers()
+	h, _ := setupTestHandlers(t)
 
 	// First create a user
 	signupReq := models.SignupRequest{
 		Username: "testuser",
 		Email:    "test@example.com",
-		Password: "password123",
+		Password: "correct-horse-battery",
 	}
 
 	body, _ := json.Marshal(signupReq)
@@ -137,7 +202,16 @@ func TestLogin(t *testing.T) {
 			name: "valid login",
 			request: models.LoginRequest{
 				Email:    "test@example.com",
-				Password: "password123",
+				Password: "correct-horse-battery",
+			},
+			expectedStatus: http.StatusOK,
+			expectError:    false,
+		},
+		{
+			name: "email in a different case",
+			request: models.LoginRequest{
+				Email:    "Test@Example.COM",
+				Password: "correct-horse-battery",
 			},
 			expectedStatus: http.StatusOK,
 			expectError:    false,
@@ -146,7 +220,7 @@ func TestLogin(t *testing.T) {
 			name: "invalid email",
 			request: models.LoginRequest{
 				Email:    "wrong@example.com",
-				Password: "password123",
+				Password: "correct-horse-battery",
 			},
 			expectedStatus: http.StatusUnauthorized,
 			expectError:    true,
@@ -190,19 +264,8 @@ func TestLogin(t *testing.T) {
 }
 
 func TestUploadDeviceKey(t *testing.T) {
-	h := setupTestHandlers()
-
-	// Create a user and get token
-	userID := uuid.New()
-	token := "test-token" // In real test, generate proper JWT
-
-	req := httptest.NewRequest("POST", "/v1/keys/device", nil)
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", "application/json")
-
-	// Add user to context (simulate middleware)
-	ctx := context.WithValue(req.Context(), "user_id", userID)
-	req = req.WithContext(ctx)
+	h, db := setupTestHandlers(t)
+	user := testutil.CreateUser(t, db, "testuser")
 
 	deviceKeyReq := models.DeviceKeyRequest{
 		DeviceID:  "test-device",
@@ -210,12 +273,9 @@ func TestUploadDeviceKey(t *testing.T) {
 	}
 
 	body, _ := json.Marshal(deviceKeyReq)
-	req.Body = http.NoBody // Reset body
-	req = httptest.NewRequest("POST", "/v1/keys/device", bytes.NewBuffer(body))
-	req.Header.Set("Authorization", "Bearer "+token)
+	req := httptest.NewRequest("POST", "/v1/keys/device", bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
-	ctx = context.WithValue(req.Context(), "user_id", userID)
-	req = req.WithContext(ctx)
+	req = withUser(req, user.ID)
 
 	w := httptest.NewRecorder()
 	h.UploadDeviceKey(w, req)
@@ -234,53 +294,543 @@ func TestUploadDeviceKey(t *testing.T) {
 	}
 }
 
-func TestSendMessage(t *testing.T) {
-	h := setupTestHandlers()
-
-	// Create two users
-	senderID := uuid.New()
-	recipientID := uuid.New()
+func TestSendMessageInvalidRecipient(t *testing.T) {
+	h, db := setupTestHandlers(t)
+	sender := testutil.CreateUser(t, db, "sender")
 
-	req := httptest.NewRequest("POST", "/v1/messages", nil)
-	req.Header.Set("Authorization", "Bearer test-token")
+	recipientID := "not-a-uuid"
+	body, _ := json.Marshal(models.SendMessageRequest{
+		RecipientID:      &recipientID,
+		EncryptedContent: "encrypted-message-content",
+		MessageType:      "text",
+	})
+	req := httptest.NewRequest("POST", "/v1/messages", bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
+	req = withUser(req, sender.ID)
 
-	// Add sender to context
-	ctx := context.WithValue(req.Context(), "user_id", senderID)
-	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+	h.SendMessage(w, req)
 
-	recipientIDStr := recipientID.String()
-	messageReq := models.SendMessageRequest{
-		RecipientID:      &recipientIDStr,
-		EncryptedContent: "encrypted-message-content",
-		MessageType:      "text",
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
 	}
+}
+
+func TestSendMessageNonexistentRecipient(t *testing.T) {
+	h, db := setupTestHandlers(t)
+	sender := testutil.CreateUser(t, db, "sender")
 
-	body, _ := json.Marshal(messageReq)
-	req.Body = http.NoBody
-	req = httptest.NewRequest("POST", "/v1/messages", bytes.NewBuffer(body))
-	req.Header.Set("Authorization", "Bearer test-token")
+	recipientID := uuid.New().String()
+	body, _ := json.Marshal(models.SendMessageRequest{
+		RecipientID:      &recipientID,
+		EncryptedContent: "encrypted-message-content",
+		MessageType:      "text",
+	})
+	req := httptest.NewRequest("POST", "/v1/messages", bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
-	ctx = context.WithValue(req.Context(), "user_id", senderID)
-	req = req.WithContext(ctx)
+	req = withUser(req, sender.ID)
 
 	w := httptest.NewRecorder()
 	h.SendMessage(w, req)
 
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+	var response map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if response["code"] != "recipient_not_found" {
+		t.Errorf("Expected code recipient_not_found, got %q", response["code"])
+	}
+}
+
+func TestRotateOneTimeKeysReplacesUnusedKeys(t *testing.T) {
+	h, db := setupTestHandlers(t)
+	user := testutil.CreateUser(t, db, "testuser")
+
+	_, err := db.Exec(`
+		INSERT INTO one_time_keys (user_id, key_id, public_key, device_id)
+		VALUES ($1, 'old-1', 'stale', 'phone'), ($1, 'old-2', 'stale', 'phone')
+	`, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to seed keys: %v", err)
+	}
+
+	body, _ := json.Marshal(models.RotateOneTimeKeysRequest{
+		DeviceID: "phone",
+		Keys: []models.OneTimeKeyRequest{
+			{KeyID: "new-1", PublicKey: "fresh"},
+			{KeyID: "new-2", PublicKey: "fresh"},
+		},
+	})
+	req := withUser(httptest.NewRequest("POST", "/v1/keys/one-time/rotate", bytes.NewBuffer(body)), user.ID)
+	w := httptest.NewRecorder()
+	h.RotateOneTimeKeys(w, req)
 	if w.Code != http.StatusOK {
-		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
 	}
 
-	var response models.Message
-	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
-		t.Errorf("Failed to unmarshal response: %v", err)
+	rows, err := db.Query("SELECT key_id, public_key FROM one_time_keys WHERE user_id = $1 AND used = false", user.ID)
+	if err != nil {
+		t.Fatalf("Failed to fetch keys: %v", err)
+	}
+	defer rows.Close()
+	var unused int
+	for rows.Next() {
+		var keyID, publicKey string
+		if err := rows.Scan(&keyID, &publicKey); err != nil {
+			t.Fatalf("Failed to scan key: %v", err)
+		}
+		if publicKey != "fresh" {
+			t.Errorf("Expected only rotated keys, got %s", keyID)
+		}
+		unused++
+	}
+	if unused != 2 {
+		t.Fatalf("Expected 2 unused one-time keys, got %d", unused)
+	}
+}
+
+func TestBootstrapClaimsOneTimeKeysOnce(t *testing.T) {
+	h, db := setupTestHandlers(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	if _, err := db.Exec(`
+		INSERT INTO one_time_keys (user_id, key_id, public_key) VALUES ($1, 'k1', 'otk-1'), ($1, 'k2', 'otk-2')
+	`, bob.ID); err != nil {
+		t.Fatalf("Failed to seed keys: %v", err)
+	}
+
+	bootstrap := func() models.BootstrapKeysResponse {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.GetBootstrapKeys(w, withUser(httptest.NewRequest("GET", "/v1/keys/bootstrap?user_id="+bob.ID.String(), nil), alice.ID))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		var response models.BootstrapKeysResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		return response
+	}
+
+	first, second := bootstrap(), bootstrap()
+	if first.OneTimeKey == nil || second.OneTimeKey == nil {
+		t.Fatalf("Expected a one-time key from each call, got %+v and %+v", first.OneTimeKey, second.OneTimeKey)
+	}
+	if first.OneTimeKey.KeyID == second.OneTimeKey.KeyID {
+		t.Errorf("Expected different one-time keys, got %s twice", first.OneTimeKey.KeyID)
+	}
+	if third := bootstrap(); third.OneTimeKey != nil {
+		t.Errorf("Expected no one-time key once they are used up, got %s", third.OneTimeKey.KeyID)
+	}
+}
+
+func TestRotateOneTimeKeysRejectsEmptyBatch(t *testing.T) {
+	h, db := setupTestHandlers(t)
+	user := testutil.CreateUser(t, db, "testuser")
+
+	body, _ := json.Marshal(models.RotateOneTimeKeysRequest{DeviceID: "phone"})
+	req := withUser(httptest.NewRequest("POST", "/v1/keys/one-time/rotate", bytes.NewBuffer(body)), user.ID)
+	w := httptest.NewRecorder()
+	h.RotateOneTimeKeys(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestBootstrapNotifiesTargetOfSessionInitiated(t *testing.T) {
+	db := testutil.NewDB(t)
+	hub := testutil.NewHub(t)
+	cfg := config.Load()
+	h := handlers.New(db, hub, cfg, service.New(db, hub, cfg))
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	bobClient := testutil.ConnectClient(t, hub, bob.ID)
+
+	if _, err := db.Exec("INSERT INTO one_time_keys (user_id, key_id, public_key) VALUES ($1, 'k1', 'otk')", bob.ID); err != nil {
+		t.Fatalf("Failed to seed keys: %v", err)
+	}
+
+	req := withUser(httptest.NewRequest("GET", "/v1/keys/bootstrap?user_id="+bob.ID.String(), nil), alice.ID)
+	w := httptest.NewRecorder()
+	h.GetBootstrapKeys(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	event := testutil.ExpectEvent(t, bobClient, websocket.EventSessionInitiated)
+	payload := event.Payload.(map[string]interface{})
+	if payload["user_id"] != alice.ID.String() || payload["username"] != "alice" {
+		t.Errorf("Expected alice as the initiator, got %v", payload)
+	}
+	if _, ok := payload["email"]; ok {
+		t.Error("Expected the initiator's email to stay private")
 	}
+}
+
+func TestBootstrapWarnsTargetWhenOneTimeKeysRunLow(t *testing.T) {
+	db := testutil.NewDB(t)
+	hub := testutil.NewHub(t)
+	cfg := config.Load()
+	cfg.PrekeyLowThreshold = 2
+	h := handlers.New(db, hub, cfg, service.New(db, hub, cfg))
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	bobClient := testutil.ConnectClient(t, hub, bob.ID)
+
+	if _, err := db.Exec(`
+		INSERT INTO one_time_keys (user_id, key_id, public_key) VALUES ($1, 'k1', 'otk'), ($1, 'k2', 'otk')
+	`, bob.ID); err != nil {
+		t.Fatalf("Failed to seed keys: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	h.GetBootstrapKeys(w, withUser(httptest.NewRequest("GET", "/v1/keys/bootstrap?user_id="+bob.ID.String(), nil), alice.ID))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	testutil.ExpectEvent(t, bobClient, websocket.EventSessionInitiated)
+	event := testutil.ExpectEvent(t, bobClient, websocket.EventPrekeyLow)
+	if remaining := event.Payload.(map[string]interface{})["remaining"]; remaining != float64(1) {
+		t.Errorf("Expected 1 remaining key, got %v", remaining)
+	}
+}
+
+func TestReadingViewOnceMessageLeavesTombstone(t *testing.T) {
+	db := testutil.NewDB(t)
+	hub := testutil.NewHub(t)
+	cfg := config.Load()
+	svc := service.New(db, hub, cfg)
+	h := handlers.New(db, hub, cfg, svc)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	aliceClient := testutil.ConnectClient(t, hub, alice.ID)
+
+	message, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         alice.ID,
+		RecipientID:      &bob.ID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "text",
+		ViewOnce:         true,
+	})
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if _, err := svc.SendReceipt(context.Background(), bob.ID, message.ID, "read"); err != nil {
+		t.Fatalf("SendReceipt failed: %v", err)
+	}
+
+	event := testutil.ExpectEvent(t, aliceClient, websocket.EventMessageConsumed)
+	payload := event.Payload.(map[string]interface{})
+	if payload["message_id"] != message.ID.String() || payload["consumed_by"] != bob.ID.String() {
+		t.Errorf("Expected bob to have consumed the message, got %v", payload)
+	}
+
+	req := withUser(httptest.NewRequest("GET", "/v1/messages?recipient_id="+alice.ID.String(), nil), bob.ID)
+	w := httptest.NewRecorder()
+	h.GetMessages(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var page models.MessagePage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Failed to decode messages: %v", err)
+	}
+	messages := page.Messages
+	if len(messages) != 1 {
+		t.Fatalf("Expected the tombstone to be returned, got %d messages", len(messages))
+	}
+	if messages[0].EncryptedContent != "" || messages[0].ConsumedAt == nil || !messages[0].ViewOnce {
+		t.Errorf("Expected a consumed tombstone, got %+v", messages[0])
+	}
+}
+
+func TestDeleteMessageLeavesTombstone(t *testing.T) {
+	db := testutil.NewDB(t)
+	hub := testutil.NewHub(t)
+	cfg := config.Load()
+	svc := service.New(db, hub, cfg)
+	h := handlers.New(db, hub, cfg, svc)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	bobClient := testutil.ConnectClient(t, hub, bob.ID)
+
+	message, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         alice.ID,
+		RecipientID:      &bob.ID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "text",
+	})
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	testutil.ExpectEvent(t, bobClient, websocket.EventNewMessage)
+
+	// Only the sender may unsend it
+	for _, tt := range []struct {
+		name           string
+		userID         uuid.UUID
+		expectedStatus int
+	}{
+		{"recipient", bob.ID, http.StatusForbidden},
+		{"sender", alice.ID, http.StatusNoContent},
+		{"already deleted", alice.ID, http.StatusNotFound},
+	} {
+		req := withURLParam(withUser(httptest.NewRequest("DELETE", "/v1/messages/"+message.ID.String(), nil), tt.userID), "messageID", message.ID.String())
+		w := httptest.NewRecorder()
+		h.DeleteMessage(w, req)
+		if w.Code != tt.expectedStatus {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.name, tt.expectedStatus, w.Code, w.Body.String())
+		}
+	}
+
+	event := testutil.ExpectEvent(t, bobClient, websocket.EventMessageDeleted)
+	if id := event.Payload.(map[string]interface{})["message_id"]; id != message.ID.String() {
+		t.Errorf("Expected the event to carry the message ID, got %v", id)
+	}
+
+	req := withUser(httptest.NewRequest("GET", "/v1/messages?recipient_id="+alice.ID.String(), nil), bob.ID)
+	w := httptest.NewRecorder()
+	h.GetMessages(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var page models.MessagePage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Failed to decode messages: %v", err)
+	}
+	messages := page.Messages
+	if len(messages) != 1 || !messages[0].Deleted || messages[0].EncryptedContent != "" {
+		t.Errorf("Expected a deleted tombstone without content, got %+v", messages)
+	}
+}
+
+func TestGetMessagesPagesThroughTimestampCollisions(t *testing.T) {
+	h, db := setupTestHandlers(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+
+	// Five messages sharing one timestamp, as a bulk insert would leave them
+	sent := make(map[uuid.UUID]bool)
+	for i := 0; i < 5; i++ {
+		var id uuid.UUID
+		err := db.QueryRow(`
+			INSERT INTO messages (sender_id, recipient_id, encrypted_content, message_type, created_at)
+			VALUES ($1, $2, 'ciphertext', 'text', '2024-01-01T00:00:00Z') RETURNING id
+		`, alice.ID, bob.ID).Scan(&id)
+		if err != nil {
+			t.Fatalf("Failed to insert message: %v", err)
+		}
+		sent[id] = true
+	}
+
+	seen := make(map[uuid.UUID]bool)
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("Expected paging to end")
+		}
+		url := "/v1/messages?limit=2&recipient_id=" + bob.ID.String()
+		if cursor != "" {
+			url += "&before=" + cursor
+		}
+		w := httptest.NewRecorder()
+		h.GetMessages(w, withUser(httptest.NewRequest("GET", url, nil), alice.ID))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		var page models.MessagePage
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatalf("Failed to decode messages: %v", err)
+		}
+		for _, message := range page.Messages {
+			if seen[message.ID] {
+				t.Errorf("Message %s was returned twice", message.ID)
+			}
+			seen[message.ID] = true
+		}
+		if cursor = page.NextCursor; cursor == "" {
+			break
+		}
+	}
+
+	if len(seen) != len(sent) {
+		t.Errorf("Expected all %d messages across the pages, got %d", len(sent), len(seen))
+	}
+}
+
+func TestGetMessagesRejectsMalformedCursor(t *testing.T) {
+	h := handlers.New(nil, nil, config.Load(), nil)
+
+	req := withUser(httptest.NewRequest("GET", "/v1/messages?recipient_id="+uuid.New().String()+"&cursor=bogus", nil), uuid.New())
+	w := httptest.NewRecorder()
+	h.GetMessages(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestGetMessagesPagesForwardAndBackward(t *testing.T) {
+	h, db := setupTestHandlers(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+
+	var ids []uuid.UUID
+	for i := 0; i < 5; i++ {
+		var id uuid.UUID
+		err := db.QueryRow(`
+			INSERT INTO messages (sender_id, recipient_id, encrypted_content, message_type, created_at)
+			VALUES ($1, $2, 'ciphertext', 'text', $3) RETURNING id
+		`, alice.ID, bob.ID, time.Date(2024, 1, 1, 0, i, 0, 0, time.UTC)).Scan(&id)
+		if err != nil {
+			t.Fatalf("Failed to insert message: %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	get := func(query string) models.MessagePage {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.GetMessages(w, withUser(httptest.NewRequest("GET", "/v1/messages?recipient_id="+bob.ID.String()+"&"+query, nil), alice.ID))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		var page models.MessagePage
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatalf("Failed to decode messages: %v", err)
+		}
+		return page
+	}
+	expectIDs := func(page models.MessagePage, want ...uuid.UUID) {
+		t.Helper()
+		if len(page.Messages) != len(want) {
+			t.Fatalf("Expected %d messages, got %d", len(want), len(page.Messages))
+		}
+		for i, message := range page.Messages {
+			if message.ID != want[i] {
+				t.Errorf("Expected message %d to be %s, got %s", i, want[i], message.ID)
+			}
+		}
+	}
+
+	// Backwards from a message ID, each page oldest first
+	page := get("limit=2&before=" + ids[4].String())
+	expectIDs(page, ids[2], ids[3])
+	if page.NextCursor == "" {
+		t.Fatal("Expected a next cursor")
+	}
+	page = get("limit=2&before=" + page.NextCursor)
+	expectIDs(page, ids[0], ids[1])
+
+	// Forwards from a timestamp
+	page = get("limit=2&after=2024-01-01T00:01:00Z")
+	expectIDs(page, ids[2], ids[3])
+	page = get("limit=2&after=" + page.NextCursor)
+	expectIDs(page, ids[4])
+	if page.NextCursor != "" {
+		t.Errorf("Expected no next cursor on the last page, got %q", page.NextCursor)
+	}
+
+	// Between two messages
+	expectIDs(get("before="+ids[4].String()+"&after="+ids[1].String()), ids[2], ids[3])
+}
+
+func TestGetMessagesRejectsUnknownBound(t *testing.T) {
+	h, db := setupTestHandlers(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+
+	for _, query := range []string{"before=" + uuid.New().String(), "after=yesterday"} {
+		w := httptest.NewRecorder()
+		h.GetMessages(w, withUser(httptest.NewRequest("GET", "/v1/messages?recipient_id="+bob.ID.String()+"&"+query, nil), alice.ID))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %s, got %d", http.StatusBadRequest, query, w.Code)
+		}
+	}
+}
 
-	if response.SenderID != senderID {
-		t.Errorf("Expected sender ID %s, got %s", senderID, response.SenderID)
+func TestGetMessagesRequiresGroupMembership(t *testing.T) {
+	h, db := setupTestHandlers(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	mallory := testutil.CreateUser(t, db, "mallory")
+
+	svc := service.New(db, testutil.NewHub(t), config.Load())
+	group, err := svc.CreateGroup(context.Background(), service.CreateGroupInput{
+		CreatorID: alice.ID,
+		Name:      "Private",
+		MemberIDs: []uuid.UUID{bob.ID},
+	})
+	if err != nil {
+		t.Fatalf("CreateGroup failed: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name           string
+		userID         uuid.UUID
+		expectedStatus int
+	}{
+		{"member", bob.ID, http.StatusOK},
+		{"outsider", mallory.ID, http.StatusForbidden},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := withUser(httptest.NewRequest("GET", "/v1/messages?group_id="+group.ID.String(), nil), tt.userID)
+			w := httptest.NewRecorder()
+			h.GetMessages(w, req)
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestDeletedAccountIsGoneFromReadPaths(t *testing.T) {
+	h, db := setupTestHandlers(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	if _, err := db.Exec(`
+		INSERT INTO messages (sender_id, recipient_id, encrypted_content, message_type) VALUES ($1, $2, 'ciphertext', 'text')
+	`, bob.ID, alice.ID); err != nil {
+		t.Fatalf("Failed to seed message: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	h.DeleteAccount(w, withUser(httptest.NewRequest("DELETE", "/v1/profile", nil), bob.ID))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.GetUsers(w, withUser(httptest.NewRequest("GET", "/v1/users", nil), alice.ID))
+	var users []models.User
+	if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil {
+		t.Fatalf("Failed to decode users: %v", err)
+	}
+	for _, user := range users {
+		if user.ID == bob.ID {
+			t.Error("Expected the deleted account to be left out of the directory")
+		}
+	}
+
+	w = httptest.NewRecorder()
+	h.GetChats(w, withUser(httptest.NewRequest("GET", "/v1/chats", nil), alice.ID))
+	var chats []models.Chat
+	if err := json.Unmarshal(w.Body.Bytes(), &chats); err != nil {
+		t.Fatalf("Failed to decode chats: %v", err)
+	}
+	for _, chat := range chats {
+		if chat.ID == bob.ID.String() && chat.Name != "Deleted account" {
+			t.Errorf("Expected the deleted account's chat to be gone or a placeholder, got %+v", chat)
+		}
 	}
 
-	if response.RecipientID == nil || *response.RecipientID != recipientID {
-		t.Errorf("Expected recipient ID %s, got %s", recipientID, response.RecipientID.String())
+	w = httptest.NewRecorder()
+	h.GetBootstrapKeys(w, withUser(httptest.NewRequest("GET", "/v1/keys/bootstrap?user_id="+bob.ID.String(), nil), alice.ID))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for the deleted account's keys, got %d", http.StatusNotFound, w.Code)
 	}
 }
@@ -0,0 +1,74 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"e2ee-messenger/server/internal/config"
+	"e2ee-messenger/server/internal/handlers"
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/testutil"
+)
+
+func TestInviteOnlySignupRequiresCode(t *testing.T) {
+	// The code is required before the database is touched
+	cfg := config.Load()
+	cfg.InviteOnly = true
+	h := handlers.New(nil, nil, cfg, nil)
+
+	if rr := signup(h, "correct-horse-battery-9"); rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusForbidden, rr.Code, rr.Body.String())
+	}
+}
+
+func TestInviteOnlySignupConsumesCode(t *testing.T) {
+	t.Setenv("INVITE_ONLY", "true")
+	h, db := setupTestHandlers(t)
+	admin := testutil.CreateUser(t, db, "admin")
+
+	req := withUser(httptest.NewRequest(http.MethodPost, "/v1/admin/invite-codes", bytes.NewBufferString(`{"max_uses": 1}`)), admin.ID)
+	rr := httptest.NewRecorder()
+	h.CreateInviteCode(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	var invite models.InviteCode
+	if err := json.Unmarshal(rr.Body.Bytes(), &invite); err != nil {
+		t.Fatalf("Failed to decode invite code: %v", err)
+	}
+
+	signupWith := func(username, code string) int {
+		t.Helper()
+		body, _ := json.Marshal(models.SignupRequest{
+			Username:   username,
+			Email:      username + "@example.com",
+			Password:   "correct-horse-battery-9",
+			InviteCode: code,
+		})
+		rr := httptest.NewRecorder()
+		h.Signup(rr, httptest.NewRequest(http.MethodPost, "/v1/auth/signup", bytes.NewBuffer(body)))
+		return rr.Code
+	}
+	if code := signupWith("invitee", "NOT-A-CODE"); code != http.StatusForbidden {
+		t.Errorf("Expected an unknown code to be rejected with %d, got %d", http.StatusForbidden, code)
+	}
+	if code := signupWith("invitee", invite.Code); code != http.StatusOK {
+		t.Fatalf("Expected signup with the invite code to succeed, got %d", code)
+	}
+	if code := signupWith("latecomer", invite.Code); code != http.StatusForbidden {
+		t.Errorf("Expected a used up code to be rejected with %d, got %d", http.StatusForbidden, code)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ListInviteCodes(rr, withUser(httptest.NewRequest(http.MethodGet, "/v1/admin/invite-codes", nil), admin.ID))
+	var invites []models.InviteCode
+	if err := json.Unmarshal(rr.Body.Bytes(), &invites); err != nil {
+		t.Fatalf("Failed to decode invite codes: %v", err)
+	}
+	if len(invites) != 1 || invites[0].UseCount != 1 {
+		t.Errorf("Expected one invite code used once, got %+v", invites)
+	}
+}
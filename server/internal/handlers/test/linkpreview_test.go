@@ -0,0 +1,42 @@
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"e2ee-messenger/server/internal/config"
+	"e2ee-messenger/server/internal/handlers"
+
+	"github.com/google/uuid"
+)
+
+func TestGetLinkPreviewRejectsUnsafeURLs(t *testing.T) {
+	h := handlers.New(nil, nil, config.Load(), nil)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected the private server not to be contacted")
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		url    string
+		status int
+	}{
+		{"missing", "", http.StatusBadRequest},
+		{"unsupported scheme", "file:///etc/passwd", http.StatusBadRequest},
+		{"loopback", server.URL, http.StatusForbidden},
+		{"cloud metadata", "http://169.254.169.254/latest/meta-data/", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/v1/link-preview?url="+url.QueryEscape(tt.url), nil)
+			h.GetLinkPreview(rr, withUser(req, uuid.New()))
+			if rr.Code != tt.status {
+				t.Errorf("Expected status %d, got %d: %s", tt.status, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
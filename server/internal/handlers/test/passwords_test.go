@@ -0,0 +1,61 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"e2ee-messenger/server/internal/config"
+	"e2ee-messenger/server/internal/handlers"
+	"e2ee-messenger/server/internal/models"
+)
+
+// signup posts a signup request for testuser with the given password
+func signup(h *handlers.Handlers, password string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(models.SignupRequest{Username: "testuser", Email: "test@example.com", Password: password})
+	rr := httptest.NewRecorder()
+	h.Signup(rr, httptest.NewRequest(http.MethodPost, "/v1/auth/signup", bytes.NewBuffer(body)))
+	return rr
+}
+
+func TestSignupRejectsPasswordsBreakingThePolicy(t *testing.T) {
+	// The policy is checked before the database is touched
+	cfg := config.Load()
+	h := handlers.New(nil, nil, cfg, nil)
+
+	tests := []struct {
+		name     string
+		classes  []string
+		password string
+		reason   string
+	}{
+		{"too short", nil, "s3cret", "at least 8 characters"},
+		{"common password", nil, "password123", "too common"},
+		{"common password in another case", nil, "QWERTY123", "too common"},
+		{"same as the username", nil, "TestUser", "username"},
+		{"missing a required class", []string{"lower", "digit"}, "correct-horse-battery", "numeric"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.PasswordRequiredClasses = tt.classes
+			rr := signup(h, tt.password)
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, rr.Code)
+			}
+			if !strings.Contains(rr.Body.String(), tt.reason) {
+				t.Errorf("Expected the error to mention %q, got %s", tt.reason, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestSignupAcceptsPolicyCompliantPassword(t *testing.T) {
+	h, _ := setupTestHandlers(t)
+
+	if rr := signup(h, "correct-horse-battery-9"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,47 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"e2ee-messenger/server/internal/config"
+	"e2ee-messenger/server/internal/service"
+	"e2ee-messenger/server/internal/testutil"
+
+	"github.com/google/uuid"
+)
+
+func TestAddReactionRequiresParticipant(t *testing.T) {
+	h, db := setupTestHandlers(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	mallory := testutil.CreateUser(t, db, "mallory")
+
+	svc := service.New(db, testutil.NewHub(t), config.Load())
+	message, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         alice.ID,
+		RecipientID:      &bob.ID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "text",
+	})
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	react := func(userID uuid.UUID) int {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages/"+message.ID.String()+"/reactions", strings.NewReader(`{"emoji":"🎉"}`))
+		req = withURLParam(req, "messageID", message.ID.String())
+		w := httptest.NewRecorder()
+		h.AddReaction(w, withUser(req, userID))
+		return w.Code
+	}
+	if code := react(mallory.ID); code != http.StatusForbidden {
+		t.Errorf("Expected status %d for an outsider, got %d", http.StatusForbidden, code)
+	}
+	if code := react(bob.ID); code != http.StatusOK {
+		t.Errorf("Expected status %d for the recipient, got %d", http.StatusOK, code)
+	}
+}
@@ -0,0 +1,201 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"e2ee-messenger/server/internal/config"
+	"e2ee-messenger/server/internal/middleware"
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/service"
+	"e2ee-messenger/server/internal/testutil"
+	ws "e2ee-messenger/server/internal/websocket"
+
+	"nhooyr.io/websocket"
+)
+
+func TestWebSocketCreatesSession(t *testing.T) {
+	h, db := setupTestHandlers(t)
+	user := testutil.CreateUser(t, db, "alice")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.WebSocketHandler(w, withUser(r, user.ID))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	header := http.Header{}
+	header.Set("User-Agent", "e2ee-test-client/1.0")
+	header.Set("Origin", "http://localhost:3000")
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), &websocket.DialOptions{HTTPHeader: header})
+	if err != nil {
+		t.Fatalf("Failed to dial websocket: %v", err)
+	}
+
+	var userAgent, origin, remoteAddr string
+	err = db.QueryRow("SELECT user_agent, origin, remote_addr FROM sessions WHERE user_id = $1", user.ID).Scan(&userAgent, &origin, &remoteAddr)
+	if err != nil {
+		t.Fatalf("Expected a session record: %v", err)
+	}
+	if userAgent != "e2ee-test-client/1.0" {
+		t.Errorf("Expected user agent to be recorded, got %q", userAgent)
+	}
+	if origin != "http://localhost:3000" {
+		t.Errorf("Expected origin to be recorded, got %q", origin)
+	}
+	if remoteAddr != "127.0.0.1" {
+		t.Errorf("Expected remote address 127.0.0.1, got %q", remoteAddr)
+	}
+
+	conn.Close(websocket.StatusNormalClosure, "")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var closed bool
+		if err := db.QueryRow("SELECT closed_at IS NOT NULL FROM sessions WHERE user_id = $1", user.ID).Scan(&closed); err != nil {
+			t.Fatalf("Failed to fetch session: %v", err)
+		}
+		if closed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the session to be closed after disconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLogoutAllRevokesTokensAndClosesSockets(t *testing.T) {
+	h, db := setupTestHandlers(t)
+	cfg := config.Load()
+
+	rr := signup(h, "correct-horse-battery-9")
+	var auth models.AuthResponse
+	if err := json.NewDecoder(rr.Body).Decode(&auth); err != nil {
+		t.Fatalf("Failed to decode signup response: %v", err)
+	}
+	userID := auth.User.ID
+
+	authenticate := middleware.Auth(middleware.AuthConfig{
+		Secret:     "test-secret",
+		Issuer:     cfg.JWTIssuer,
+		Audience:   cfg.JWTAudience,
+		ValidAfter: service.New(db, nil, cfg).TokensValidAfter,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	authorized := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/v1/sessions", nil)
+		req.Header.Set("Authorization", "Bearer "+auth.Token)
+		rr := httptest.NewRecorder()
+		authenticate.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	if code := authorized(); code != http.StatusNoContent {
+		t.Fatalf("Expected the fresh token to be accepted, got %d", code)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.WebSocketHandler(w, withUser(r, userID))
+	}))
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Failed to dial websocket: %v", err)
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+	// The first event is only written once the hub has registered the connection
+	if _, _, err := conn.Read(ctx); err != nil {
+		t.Fatalf("Failed to read the resume token: %v", err)
+	}
+
+	rr = httptest.NewRecorder()
+	h.LogoutAll(rr, withUser(httptest.NewRequest(http.MethodPost, "/v1/auth/logout-all", nil), userID))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, rr.Code, rr.Body.String())
+	}
+
+	if code := authorized(); code != http.StatusUnauthorized {
+		t.Errorf("Expected the revoked token to be rejected, got %d", code)
+	}
+	for {
+		if _, _, err := conn.Read(ctx); err != nil {
+			if status := websocket.CloseStatus(err); status != ws.StatusSessionRevoked {
+				t.Fatalf("Expected close status %d, got %d (%v)", ws.StatusSessionRevoked, status, err)
+			}
+			break
+		}
+	}
+}
+
+func TestLogoutRevokesOnlyTheCurrentToken(t *testing.T) {
+	h, db := setupTestHandlers(t)
+	cfg := config.Load()
+
+	rr := signup(h, "correct-horse-battery-9")
+	var first models.AuthResponse
+	if err := json.NewDecoder(rr.Body).Decode(&first); err != nil {
+		t.Fatalf("Failed to decode signup response: %v", err)
+	}
+	body, _ := json.Marshal(models.LoginRequest{Email: "test@example.com", Password: "correct-horse-battery-9"})
+	rr = httptest.NewRecorder()
+	h.Login(rr, httptest.NewRequest(http.MethodPost, "/v1/auth/login", bytes.NewBuffer(body)))
+	var second models.AuthResponse
+	if err := json.NewDecoder(rr.Body).Decode(&second); err != nil {
+		t.Fatalf("Failed to decode login response: %v", err)
+	}
+
+	svc := service.New(db, nil, cfg)
+	logout := middleware.Auth(middleware.AuthConfig{
+		Secret:     "test-secret",
+		Issuer:     cfg.JWTIssuer,
+		Audience:   cfg.JWTAudience,
+		ValidAfter: svc.TokensValidAfter,
+		Revoked:    svc.IsTokenRevoked,
+	})(middleware.UserContext(http.HandlerFunc(h.Logout)))
+	post := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/auth/logout", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		logout.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := post(first.Token); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusNoContent, rr.Code, rr.Body.String())
+	}
+	if rr := post(first.Token); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the revoked token to be rejected, got %d", rr.Code)
+	}
+
+	// The login's refresh token is revoked with it
+	refresh := func(refreshToken string) int {
+		body, _ := json.Marshal(models.RefreshTokenRequest{RefreshToken: refreshToken})
+		rr := httptest.NewRecorder()
+		h.Refresh(rr, httptest.NewRequest(http.MethodPost, "/v1/auth/refresh", bytes.NewBuffer(body)))
+		return rr.Code
+	}
+	if code := refresh(first.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("Expected the logged out refresh token to be rejected, got %d", code)
+	}
+	if code := refresh(second.RefreshToken); code != http.StatusOK {
+		t.Errorf("Expected the other login to still refresh, got %d", code)
+	}
+	if rr := post(second.Token); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected the other token to still be accepted, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if n, err := svc.PurgeRevokedTokens(context.Background()); err != nil || n != 0 {
+		t.Errorf("Expected unexpired revocations to be kept, purged %d (%v)", n, err)
+	}
+}
@@ -0,0 +1,70 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"e2ee-messenger/server/internal/config"
+	"e2ee-messenger/server/internal/handlers"
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/testutil"
+)
+
+// checkUsername asks whether username is available
+func checkUsername(t *testing.T, h *handlers.Handlers, username string) (int, models.UsernameAvailabilityResponse) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	h.UsernameAvailable(rr, httptest.NewRequest(http.MethodGet, "/v1/auth/username-available?username="+username, nil))
+	var response models.UsernameAvailabilityResponse
+	if rr.Code == http.StatusOK {
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+	}
+	return rr.Code, response
+}
+
+func TestUsernameAvailable(t *testing.T) {
+	h, db := setupTestHandlers(t)
+	testutil.CreateUser(t, db, "alice")
+
+	tests := []struct {
+		username  string
+		available bool
+	}{
+		{"bob", true},
+		{"alice", false},
+		{"ALICE", false},
+		{"Support", false},
+		{"bob smith", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.username, func(t *testing.T) {
+			code, response := checkUsername(t, h, tt.username)
+			if code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+			}
+			if response.Available != tt.available {
+				t.Errorf("Expected available=%v, got %+v", tt.available, response)
+			}
+			if !response.Available && response.Reason == "" {
+				t.Error("Expected a reason for an unavailable username")
+			}
+		})
+	}
+}
+
+func TestUsernameAvailableIsRateLimited(t *testing.T) {
+	// Reserved names are refused before the database is touched
+	h := handlers.New(nil, nil, config.Load(), nil)
+
+	var code int
+	for i := 0; i < 100 && code != http.StatusTooManyRequests; i++ {
+		code, _ = checkUsername(t, h, "admin")
+	}
+	if code != http.StatusTooManyRequests {
+		t.Errorf("Expected lookups to be rate-limited, got %d", code)
+	}
+}
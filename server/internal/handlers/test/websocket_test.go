@@ -0,0 +1,22 @@
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"e2ee-messenger/server/internal/config"
+	"e2ee-messenger/server/internal/handlers"
+	"e2ee-messenger/server/internal/testutil"
+)
+
+func TestResumeWebSocketRejectsUnknownToken(t *testing.T) {
+	// A rejected token is answered before the database is touched
+	h := handlers.New(nil, testutil.NewHub(t), config.Load(), nil)
+
+	rr := httptest.NewRecorder()
+	h.ResumeWebSocket(rr, httptest.NewRequest(http.MethodGet, "/v1/ws/resume?resume_token=stale", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d so the client falls back to full auth, got %d", http.StatusUnauthorized, rr.Code)
+	}
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/models"
+)
+
+// usernameCheckLimit is how many availability checks a client address may
+// make per minute, enough for typing feedback but not for enumeration
+const usernameCheckLimit = 30
+
+// invalidUsername reports why a username is not allowed, or "" if it is
+func (h *Handlers) invalidUsername(username string) string {
+	if !h.cfg.UsernamePattern.MatchString(username) {
+		return "Username contains characters that are not allowed"
+	}
+	if slices.Contains(h.cfg.ReservedUsernames, strings.ToLower(username)) {
+		return "This username is reserved"
+	}
+	return ""
+}
+
+// UsernameAvailable tells a prospective user whether a username can be
+// registered. It is public, so lookups are rate-limited per client address.
+func (h *Handlers) UsernameAvailable(w http.ResponseWriter, r *http.Request) {
+	ip := clientIP(r)
+	if !h.usernameLimiter.Allow(ip) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(h.usernameLimiter.RetryAfter(ip).Seconds()+1)))
+		respondWithAppError(w, apperrors.ErrRateLimited)
+		return
+	}
+
+	username := r.URL.Query().Get("username")
+	if username == "" {
+		respondWithError(w, http.StatusBadRequest, "username parameter is required")
+		return
+	}
+
+	response := models.UsernameAvailabilityResponse{Username: username}
+	if reason := h.invalidUsername(username); reason != "" {
+		response.Reason = reason
+	} else {
+		var taken bool
+		err := h.db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE LOWER(username) = LOWER($1))", username).Scan(&taken)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Database error while checking username")
+			return
+		}
+		response.Available = !taken
+		if taken {
+			response.Reason = "This username is already taken"
+		}
+	}
+
+	respondJSON(w, http.StatusOK, response)
+}
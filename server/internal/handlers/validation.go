@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/models"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// validate checks request DTOs against their validate struct tags. It caches
+// struct metadata, so it is shared by every request.
+var validate = func() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	// Report fields by the names clients send them under
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return v
+}()
+
+// validateStruct runs the validate tags of the struct v points to
+func validateStruct(v interface{}) error {
+	return validate.Struct(v)
+}
+
+// respondWithValidationError reports every field that failed validation as
+// a 400. Errors other than failed fields are treated as invalid input too.
+func respondWithValidationError(w http.ResponseWriter, err error) {
+	response := models.ValidationErrorResponse{
+		Code:    apperrors.Code(apperrors.ErrInvalidInput),
+		Message: "Invalid request",
+	}
+	var failures validator.ValidationErrors
+	if errors.As(err, &failures) {
+		for _, failure := range failures {
+			response.Errors = append(response.Errors, models.FieldError{
+				Field:  fieldPath(failure),
+				Reason: reason(failure),
+			})
+		}
+	}
+	respondJSON(w, http.StatusBadRequest, response)
+}
+
+// fieldPath names the field as it appears in the request body, e.g.
+// "keys[0].public_key"
+func fieldPath(failure validator.FieldError) string {
+	// The namespace starts with the Go name of the top-level struct
+	_, path, found := strings.Cut(failure.Namespace(), ".")
+	if !found {
+		return failure.Field()
+	}
+	return path
+}
+
+// reason describes why a field failed a validation tag
+func reason(failure validator.FieldError) string {
+	param := failure.Param()
+	kind := failure.Kind()
+	switch failure.Tag() {
+	case "required":
+		return "is required"
+	case "required_without":
+		return fmt.Sprintf("is required unless %s is set", snakeCase(param))
+	case "email":
+		return "must be a valid email address"
+	case "uuid":
+		return "must be a UUID"
+	case "oneof":
+		return "must be one of: " + strings.Join(strings.Fields(param), ", ")
+	case "min":
+		switch kind {
+		case reflect.String:
+			return fmt.Sprintf("must be at least %s characters long", param)
+		case reflect.Slice, reflect.Map, reflect.Array:
+			return fmt.Sprintf("must have at least %s items", param)
+		}
+		return "must be at least " + param
+	case "max":
+		switch kind {
+		case reflect.String:
+			return fmt.Sprintf("must be at most %s characters long", param)
+		case reflect.Slice, reflect.Map, reflect.Array:
+			return fmt.Sprintf("must have at most %s items", param)
+		}
+		return "must be at most " + param
+	}
+	return "is invalid (" + failure.Tag() + ")"
+}
+
+// snakeCase turns the Go field names some tags take as parameters into the
+// JSON names clients know them by, e.g. EncryptedMetadata to encrypted_metadata
+func snakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
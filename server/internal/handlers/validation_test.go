@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"e2ee-messenger/server/internal/config"
+	"e2ee-messenger/server/internal/models"
+)
+
+func TestValidationErrorsListEachField(t *testing.T) {
+	// Validation runs before the database is touched
+	h := New(nil, nil, config.Load(), nil)
+
+	body, _ := json.Marshal(models.SignupRequest{Username: "al", Email: "not-an-email", Password: "short"})
+	w := httptest.NewRecorder()
+	h.Signup(w, httptest.NewRequest(http.MethodPost, "/v1/auth/signup", bytes.NewBuffer(body)))
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	var response models.ValidationErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	want := map[string]string{
+		"username": "must be at least 3 characters long",
+		"email":    "must be a valid email address",
+		"password": "must be at least 8 characters long",
+	}
+	if len(response.Errors) != len(want) {
+		t.Fatalf("Expected %d field errors, got %+v", len(want), response.Errors)
+	}
+	for _, failure := range response.Errors {
+		if want[failure.Field] != failure.Reason {
+			t.Errorf("Expected %s to fail with %q, got %q", failure.Field, want[failure.Field], failure.Reason)
+		}
+	}
+}
+
+func TestValidationUsesJSONFieldNames(t *testing.T) {
+	err := validateStruct(&models.CreateGroupRequest{Description: "no name"})
+	w := httptest.NewRecorder()
+	respondWithValidationError(w, err)
+
+	var response models.ValidationErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	got := make(map[string]string)
+	for _, failure := range response.Errors {
+		got[failure.Field] = failure.Reason
+	}
+	if got["name"] != "is required unless encrypted_metadata is set" {
+		t.Errorf("Unexpected reason for name: %q", got["name"])
+	}
+	if got["member_ids"] != "is required" {
+		t.Errorf("Unexpected reason for member_ids: %q", got["member_ids"])
+	}
+}
@@ -0,0 +1,317 @@
+// Package linkpreview fetches web pages on behalf of clients and extracts
+// their OpenGraph metadata, so clients can show rich link previews without
+// revealing their IP address to every site linked in a conversation.
+//
+// The server fetches arbitrary URLs, so every connection is checked against
+// the address it actually dials: loopback, private, link-local and other
+// non-public ranges are refused, including when reached through a redirect
+// or a DNS name resolving to them.
+package linkpreview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrInvalidURL is returned for URLs that are not absolute http(s) URLs
+	ErrInvalidURL = errors.New("invalid URL")
+	// ErrBlockedAddress is returned when a URL leads to a non-public address
+	ErrBlockedAddress = errors.New("address not allowed")
+)
+
+// Preview is the metadata extracted from a page. Fields the page does not
+// provide are empty.
+type Preview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+}
+
+// Config limits what the fetcher does for a single preview
+type Config struct {
+	// Limit on the whole fetch, redirects included
+	Timeout time.Duration
+	// Only the first MaxBytes of a page are read
+	MaxBytes     int64
+	MaxRedirects int
+	// How long previews, and failures, are cached; zero disables caching
+	CacheTTL time.Duration
+}
+
+// maxCacheEntries bounds the cache; expired entries are dropped first
+const maxCacheEntries = 10000
+
+type cacheEntry struct {
+	preview *Preview
+	err     error
+	expires time.Time
+}
+
+// Fetcher fetches and caches link previews
+type Fetcher struct {
+	cfg    Config
+	client *http.Client
+
+	// Reports whether connections to an address are refused
+	blocked func(netip.Addr) bool
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+// New creates a fetcher
+func New(cfg Config) *Fetcher {
+	f := &Fetcher{cfg: cfg, blocked: isBlocked, cache: make(map[string]cacheEntry)}
+
+	dialer := &net.Dialer{Timeout: cfg.Timeout, Control: f.checkDial}
+	f.client = &http.Client{
+		Timeout: cfg.Timeout,
+		Transport: &http.Transport{
+			// A proxy would dial on our behalf and bypass the address check
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   cfg.Timeout,
+			ResponseHeaderTimeout: cfg.Timeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > cfg.MaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", cfg.MaxRedirects)
+			}
+			return checkURL(req.URL)
+		},
+	}
+	return f
+}
+
+// checkDial runs after DNS resolution, right before connecting, so it sees
+// the address that is actually used
+func (f *Fetcher) checkDial(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("unexpected address %q: %w", address, ErrBlockedAddress)
+	}
+	if f.blocked(addrPort.Addr().Unmap()) {
+		return fmt.Errorf("%s: %w", addrPort.Addr(), ErrBlockedAddress)
+	}
+	return nil
+}
+
+// blockedPrefixes are non-public ranges not covered by the netip.Addr predicates
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"), // NAT64, can reach private IPv4
+	netip.MustParsePrefix("2002::/16"),    // 6to4, likewise
+}
+
+// isBlocked reports whether addr is outside the public internet
+func isBlocked(addr netip.Addr) bool {
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return true
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkURL accepts absolute http and https URLs without credentials
+func checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("only http and https URLs are supported: %w", ErrInvalidURL)
+	}
+	if u.Hostname() == "" || u.User != nil {
+		return fmt.Errorf("URL must have a host and no credentials: %w", ErrInvalidURL)
+	}
+	return nil
+}
+
+// Fetch returns the preview for rawURL, from the cache when possible
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*Preview, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", err, ErrInvalidURL)
+	}
+	if err := checkURL(u); err != nil {
+		return nil, err
+	}
+	u.Fragment = ""
+	key := u.String()
+
+	if entry, ok := f.cached(key); ok {
+		return entry.preview, entry.err
+	}
+	preview, err := f.fetch(ctx, u)
+	// Errors caused by the caller going away say nothing about the URL
+	if ctx.Err() == nil {
+		f.store(key, preview, err)
+	}
+	return preview, err
+}
+
+func (f *Fetcher) cached(key string) (cacheEntry, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entry, ok := f.cache[key]
+	if !ok || time.Now().After(entry.expires) {
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+func (f *Fetcher) store(key string, preview *Preview, err error) {
+	if f.cfg.CacheTTL <= 0 {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	if len(f.cache) >= maxCacheEntries {
+		for k, entry := range f.cache {
+			if now.After(entry.expires) {
+				delete(f.cache, k)
+			}
+		}
+	}
+	if len(f.cache) >= maxCacheEntries {
+		// Still full of live entries: make room by dropping an arbitrary one
+		for k := range f.cache {
+			delete(f.cache, k)
+			break
+		}
+	}
+	f.cache[key] = cacheEntry{preview: preview, err: err, expires: now.Add(f.cfg.CacheTTL)}
+}
+
+// fetch downloads the page and parses its metadata. Pages that are not HTML
+// get a preview with just their URL.
+func (f *Fetcher) fetch(ctx context.Context, u *url.URL) (*Preview, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", err, ErrInvalidURL)
+	}
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", "e2ee-messenger-link-preview/1.0")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", u.Redacted(), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("failed to fetch %s: responded %s", u.Redacted(), resp.Status)
+	}
+
+	// The final URL, after redirects
+	preview := &Preview{URL: resp.Request.URL.String()}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return preview, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.cfg.MaxBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", u.Redacted(), err)
+	}
+	parse(preview, resp.Request.URL, string(body))
+	return preview, nil
+}
+
+var (
+	metaTagPattern   = regexp.MustCompile(`(?is)<meta\s([^>]*)>`)
+	attributePattern = regexp.MustCompile(`(?s)([a-zA-Z_:-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	titlePattern     = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+)
+
+// maxFieldLength bounds each text field of a preview, in bytes
+const maxFieldLength = 1024
+
+// parse fills the preview from the page's OpenGraph tags, falling back to
+// the <title> element and the description meta tag. Relative image URLs are
+// resolved against base.
+func parse(preview *Preview, base *url.URL, page string) {
+	// Metadata lives in <head>; ignore the body, which may be cut short
+	if end := strings.Index(strings.ToLower(page), "</head>"); end >= 0 {
+		page = page[:end]
+	}
+
+	meta := make(map[string]string)
+	for _, tag := range metaTagPattern.FindAllStringSubmatch(page, -1) {
+		attrs := make(map[string]string)
+		for _, attr := range attributePattern.FindAllStringSubmatch(tag[1], -1) {
+			attrs[strings.ToLower(attr[1])] = attr[2] + attr[3] + attr[4]
+		}
+		// OpenGraph uses property=, plain HTML uses name=
+		key := attrs["property"]
+		if key == "" {
+			key = attrs["name"]
+		}
+		key = strings.ToLower(key)
+		if _, seen := meta[key]; key != "" && !seen {
+			meta[key] = clean(attrs["content"])
+		}
+	}
+
+	preview.Title = first(meta["og:title"], meta["twitter:title"])
+	if preview.Title == "" {
+		if m := titlePattern.FindStringSubmatch(page); m != nil {
+			preview.Title = clean(m[1])
+		}
+	}
+	preview.Description = first(meta["og:description"], meta["twitter:description"], meta["description"])
+	preview.SiteName = meta["og:site_name"]
+
+	if image := first(meta["og:image"], meta["og:image:url"], meta["twitter:image"]); image != "" {
+		if ref, err := url.Parse(image); err == nil {
+			if resolved := base.ResolveReference(ref); checkURL(resolved) == nil {
+				preview.Image = resolved.String()
+			}
+		}
+	}
+}
+
+// clean unescapes HTML entities, collapses whitespace and truncates s
+func clean(s string) string {
+	s = strings.Join(strings.Fields(html.UnescapeString(s)), " ")
+	if len(s) > maxFieldLength {
+		s = strings.ToValidUTF8(s[:maxFieldLength], "")
+	}
+	return s
+}
+
+// first returns the first non-empty value
+func first(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+// RequireAdmin only lets through users listed in adminIDs, answering
+// everyone else with 403. It must run after Auth.
+func RequireAdmin(adminIDs []uuid.UUID) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, ok := r.Context().Value(UserIDKey).(uuid.UUID)
+			if !ok {
+				http.Error(w, "User not authenticated", http.StatusUnauthorized)
+				return
+			}
+			if !slices.Contains(adminIDs, userID) {
+				http.Error(w, "Admin access required", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
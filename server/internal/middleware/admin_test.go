@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestRequireAdmin(t *testing.T) {
+	admin := uuid.New()
+	handler := RequireAdmin([]uuid.UUID{admin})(noContent)
+
+	for _, tt := range []struct {
+		name   string
+		userID uuid.UUID
+		want   int
+	}{
+		{"admin", admin, http.StatusNoContent},
+		{"regular user", uuid.New(), http.StatusForbidden},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/v1/admin/hub", nil)
+			req = req.WithContext(context.WithValue(req.Context(), UserIDKey, tt.userID))
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, rr.Code)
+			}
+		})
+	}
+}
@@ -2,8 +2,12 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strings"
+	"time"
+
+	"e2ee-messenger/server/internal/apperrors"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
@@ -13,8 +17,78 @@ type contextKey string
 
 const UserIDKey contextKey = "user_id"
 
-// Auth middleware validates JWT tokens
-func Auth(jwtSecret string) func(http.Handler) http.Handler {
+// TokenIDKey and TokenExpiryKey hold the authenticating token's jti claim
+// (a string) and expiry (a time.Time). Tokens issued before jti claims were
+// added have no TokenIDKey. TokenFamilyKey holds the uuid.UUID of the refresh
+// token family the token was issued with and DeviceIDKey the device it was
+// issued to (a string), which tokens issued before refresh tokens lack.
+const (
+	TokenIDKey     contextKey = "token_id"
+	TokenExpiryKey contextKey = "token_expiry"
+	TokenFamilyKey contextKey = "token_family"
+	DeviceIDKey    contextKey = "device_id"
+)
+
+// AuthConfig describes which JWTs the Auth middleware accepts
+type AuthConfig struct {
+	// Secret signs new tokens and is always accepted
+	Secret string
+	// PreviousSecrets are accepted until PreviousSecretsUntil (forever if zero),
+	// so rotating Secret does not log everybody out at once
+	PreviousSecrets      []string
+	PreviousSecretsUntil time.Time
+
+	Issuer   string
+	Audience string
+
+	// ValidAfter, if set, returns the time before which the user's tokens
+	// were revoked. Tokens issued at or before it are rejected. It returns
+	// the zero time when nothing was revoked.
+	ValidAfter func(ctx context.Context, userID uuid.UUID) (time.Time, error)
+
+	// Revoked, if set, reports whether the token with the given jti claim
+	// was revoked on its own by logging out
+	Revoked func(ctx context.Context, tokenID string) (bool, error)
+
+	// FamilyRevoked, if set, reports whether the refresh token family the
+	// token was issued with was revoked, by logging out or revoking its device
+	FamilyRevoked func(ctx context.Context, familyID uuid.UUID) (bool, error)
+}
+
+// verificationSecrets returns the secrets a token may currently be signed with
+func (c AuthConfig) verificationSecrets(now time.Time) []string {
+	secrets := []string{c.Secret}
+	if c.PreviousSecretsUntil.IsZero() || now.Before(c.PreviousSecretsUntil) {
+		secrets = append(secrets, c.PreviousSecrets...)
+	}
+	return secrets
+}
+
+// parseToken verifies the token against each accepted secret in turn
+func (c AuthConfig) parseToken(tokenString string) (*jwt.Token, error) {
+	var lastErr error
+	for _, secret := range c.verificationSecrets(time.Now()) {
+		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+			// Validate signing method
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, jwt.ErrSignatureInvalid
+			}
+			return []byte(secret), nil
+		}, jwt.WithIssuer(c.Issuer), jwt.WithAudience(c.Audience))
+		if err == nil {
+			return token, nil
+		}
+		lastErr = err
+		// Only a signature mismatch can be fixed by trying another secret
+		if !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+			return nil, err
+		}
+	}
+	return nil, lastErr
+}
+
+// Auth middleware validates JWT tokens, including their issuer and audience
+func Auth(cfg AuthConfig) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			var tokenString string
@@ -39,13 +113,7 @@ func Auth(jwtSecret string) func(http.Handler) http.Handler {
 			}
 
 			// Parse and validate token
-			token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-				// Validate signing method
-				if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-					return nil, jwt.ErrSignatureInvalid
-				}
-				return []byte(jwtSecret), nil
-			})
+			token, err := cfg.parseToken(tokenString)
 
 			if err != nil {
 				http.Error(w, "Invalid token", http.StatusUnauthorized)
@@ -76,8 +144,63 @@ func Auth(jwtSecret string) func(http.Handler) http.Handler {
 				return
 			}
 
+			if cfg.ValidAfter != nil {
+				validAfter, err := cfg.ValidAfter(r.Context(), userID)
+				if errors.Is(err, apperrors.ErrUserNotFound) {
+					http.Error(w, "Invalid token", http.StatusUnauthorized)
+					return
+				}
+				if err != nil {
+					http.Error(w, apperrors.Message(err), apperrors.HTTPStatus(err))
+					return
+				}
+				issuedAt, err := token.Claims.GetIssuedAt()
+				if !validAfter.IsZero() && (err != nil || issuedAt == nil || !issuedAt.After(validAfter)) {
+					http.Error(w, "Token has been revoked", http.StatusUnauthorized)
+					return
+				}
+			}
+
+			ctx := r.Context()
+			if tokenID, _ := claims["jti"].(string); tokenID != "" {
+				if cfg.Revoked != nil {
+					revoked, err := cfg.Revoked(r.Context(), tokenID)
+					if err != nil {
+						http.Error(w, apperrors.Message(err), apperrors.HTTPStatus(err))
+						return
+					}
+					if revoked {
+						http.Error(w, "Token has been revoked", http.StatusUnauthorized)
+						return
+					}
+				}
+				ctx = context.WithValue(ctx, TokenIDKey, tokenID)
+			}
+			if expiresAt, err := token.Claims.GetExpirationTime(); err == nil && expiresAt != nil {
+				ctx = context.WithValue(ctx, TokenExpiryKey, expiresAt.Time)
+			}
+			if familyIDStr, ok := claims["family_id"].(string); ok {
+				if familyID, err := uuid.Parse(familyIDStr); err == nil {
+					if cfg.FamilyRevoked != nil {
+						revoked, err := cfg.FamilyRevoked(r.Context(), familyID)
+						if err != nil {
+							http.Error(w, apperrors.Message(err), apperrors.HTTPStatus(err))
+							return
+						}
+						if revoked {
+							http.Error(w, "Token has been revoked", http.StatusUnauthorized)
+							return
+						}
+					}
+					ctx = context.WithValue(ctx, TokenFamilyKey, familyID)
+				}
+			}
+			if deviceID, _ := claims["device_id"].(string); deviceID != "" {
+				ctx = context.WithValue(ctx, DeviceIDKey, deviceID)
+			}
+
 			// Add user ID to context
-			ctx := context.WithValue(r.Context(), UserIDKey, userID)
+			ctx = context.WithValue(ctx, UserIDKey, userID)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
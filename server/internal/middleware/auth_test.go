@@ -0,0 +1,267 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func signToken(t *testing.T, secret string, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return token
+}
+
+var noContent = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+})
+
+func TestAuthValidatesIssuerAndAudience(t *testing.T) {
+	handler := Auth(AuthConfig{Secret: "secret", Issuer: "e2ee-messenger", Audience: "e2ee-messenger-api"})(noContent)
+
+	claims := func(iss, aud string) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"user_id": uuid.NewString(),
+			"exp":     time.Now().Add(time.Hour).Unix(),
+			"iat":     time.Now().Unix(),
+		}
+		if iss != "" {
+			c["iss"] = iss
+		}
+		if aud != "" {
+			c["aud"] = aud
+		}
+		return c
+	}
+
+	tests := []struct {
+		name           string
+		claims         jwt.MapClaims
+		expectedStatus int
+	}{
+		{"matching issuer and audience", claims("e2ee-messenger", "e2ee-messenger-api"), http.StatusNoContent},
+		{"wrong audience", claims("e2ee-messenger", "another-service"), http.StatusUnauthorized},
+		{"wrong issuer", claims("someone-else", "e2ee-messenger-api"), http.StatusUnauthorized},
+		{"missing audience", claims("e2ee-messenger", ""), http.StatusUnauthorized},
+		{"missing issuer", claims("", "e2ee-messenger-api"), http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+signToken(t, "secret", tt.claims))
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
+
+func TestAuthAcceptsPreviousSecretsDuringGracePeriod(t *testing.T) {
+	claims := jwt.MapClaims{
+		"user_id": uuid.NewString(),
+		"exp":     time.Now().Add(time.Hour).Unix(),
+		"iat":     time.Now().Unix(),
+		"iss":     "e2ee-messenger",
+		"aud":     "e2ee-messenger-api",
+	}
+	cfg := AuthConfig{
+		Secret:          "new-secret",
+		PreviousSecrets: []string{"old-secret"},
+		Issuer:          "e2ee-messenger",
+		Audience:        "e2ee-messenger-api",
+	}
+
+	tests := []struct {
+		name           string
+		secret         string
+		until          time.Time
+		expectedStatus int
+	}{
+		{"current secret", "new-secret", time.Time{}, http.StatusNoContent},
+		{"previous secret without deadline", "old-secret", time.Time{}, http.StatusNoContent},
+		{"previous secret before deadline", "old-secret", time.Now().Add(time.Hour), http.StatusNoContent},
+		{"previous secret after deadline", "old-secret", time.Now().Add(-time.Minute), http.StatusUnauthorized},
+		{"current secret after deadline", "new-secret", time.Now().Add(-time.Minute), http.StatusNoContent},
+		{"unknown secret", "other-secret", time.Time{}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := cfg
+			cfg.PreviousSecretsUntil = tt.until
+			handler := Auth(cfg)(noContent)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+signToken(t, tt.secret, claims))
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
+
+func TestAuthRejectsTokensIssuedBeforeRevocation(t *testing.T) {
+	userID := uuid.New()
+	revokedAt := time.Now().Truncate(time.Second)
+	handler := Auth(AuthConfig{
+		Secret:   "secret",
+		Issuer:   "e2ee-messenger",
+		Audience: "e2ee-messenger-api",
+		ValidAfter: func(ctx context.Context, id uuid.UUID) (time.Time, error) {
+			if id != userID {
+				return time.Time{}, nil
+			}
+			return revokedAt, nil
+		},
+	})(noContent)
+
+	token := func(id uuid.UUID, issuedAt time.Time) string {
+		return signToken(t, "secret", jwt.MapClaims{
+			"user_id": id.String(),
+			"exp":     time.Now().Add(time.Hour).Unix(),
+			"iat":     issuedAt.Unix(),
+			"iss":     "e2ee-messenger",
+			"aud":     "e2ee-messenger-api",
+		})
+	}
+
+	tests := []struct {
+		name           string
+		token          string
+		expectedStatus int
+	}{
+		{"issued before revocation", token(userID, revokedAt.Add(-time.Minute)), http.StatusUnauthorized},
+		{"issued in the second of revocation", token(userID, revokedAt), http.StatusUnauthorized},
+		{"issued after revocation", token(userID, revokedAt.Add(time.Second)), http.StatusNoContent},
+		{"another user's token", token(uuid.New(), revokedAt.Add(-time.Minute)), http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
+
+func TestAuthRejectsRevokedTokenIDs(t *testing.T) {
+	handler := Auth(AuthConfig{
+		Secret:   "secret",
+		Issuer:   "e2ee-messenger",
+		Audience: "e2ee-messenger-api",
+		Revoked: func(ctx context.Context, tokenID string) (bool, error) {
+			return tokenID == "revoked", nil
+		},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(TokenExpiryKey).(time.Time); !ok {
+			t.Error("Expected the token expiry in the request context")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	token := func(tokenID string) string {
+		claims := jwt.MapClaims{
+			"user_id": uuid.NewString(),
+			"exp":     time.Now().Add(time.Hour).Unix(),
+			"iat":     time.Now().Unix(),
+			"iss":     "e2ee-messenger",
+			"aud":     "e2ee-messenger-api",
+		}
+		if tokenID != "" {
+			claims["jti"] = tokenID
+		}
+		return signToken(t, "secret", claims)
+	}
+
+	tests := []struct {
+		name           string
+		token          string
+		expectedStatus int
+	}{
+		{"revoked token", token("revoked"), http.StatusUnauthorized},
+		{"other token", token("active"), http.StatusNoContent},
+		{"token without jti", token(""), http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
+
+func TestAuthRejectsTokensOfRevokedFamilies(t *testing.T) {
+	revokedFamily := uuid.New()
+	handler := Auth(AuthConfig{
+		Secret:   "secret",
+		Issuer:   "e2ee-messenger",
+		Audience: "e2ee-messenger-api",
+		FamilyRevoked: func(ctx context.Context, familyID uuid.UUID) (bool, error) {
+			return familyID == revokedFamily, nil
+		},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if deviceID, _ := r.Context().Value(DeviceIDKey).(string); deviceID != "phone" {
+			t.Errorf("Expected device ID phone in the request context, got %q", deviceID)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	token := func(familyID uuid.UUID) string {
+		return signToken(t, "secret", jwt.MapClaims{
+			"user_id":   uuid.NewString(),
+			"family_id": familyID.String(),
+			"device_id": "phone",
+			"exp":       time.Now().Add(time.Hour).Unix(),
+			"iat":       time.Now().Unix(),
+			"iss":       "e2ee-messenger",
+			"aud":       "e2ee-messenger-api",
+		})
+	}
+
+	tests := []struct {
+		name           string
+		token          string
+		expectedStatus int
+	}{
+		{"revoked family", token(revokedFamily), http.StatusUnauthorized},
+		{"other family", token(uuid.New()), http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
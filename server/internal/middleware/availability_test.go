@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequireDatabase(t *testing.T) {
+	down := false
+	handler := RequireDatabase(func() (time.Duration, bool) { return 4500 * time.Millisecond, down })(noContent)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/chats", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d while the database is up, got %d", http.StatusNoContent, rr.Code)
+	}
+
+	down = true
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/chats", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status %d while the database is down, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "5" {
+		t.Errorf("Expected Retry-After 5, got %q", got)
+	}
+}
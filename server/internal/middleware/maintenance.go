@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// DefaultMaintenanceMessage is shown when maintenance mode is enabled without a message
+const DefaultMaintenanceMessage = "The server is under maintenance and is read-only, try again later"
+
+// Maintenance is this instance's copy of the maintenance-mode switch, which is
+// stored in the database. While it is enabled the server is read-only; it is
+// safe for concurrent use.
+type Maintenance struct {
+	mu      sync.RWMutex
+	enabled bool
+	message string
+}
+
+// NewMaintenance creates the switch in the given state
+func NewMaintenance(enabled bool, message string) *Maintenance {
+	m := &Maintenance{}
+	m.Set(enabled, message)
+	return m
+}
+
+// Set turns maintenance mode on or off. An empty message uses
+// DefaultMaintenanceMessage. It reports whether enabled changed.
+func (m *Maintenance) Set(enabled bool, message string) bool {
+	if message == "" {
+		message = DefaultMaintenanceMessage
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	changed := m.enabled != enabled
+	m.enabled = enabled
+	m.message = message
+	return changed
+}
+
+// Status reports whether maintenance mode is enabled, and its message
+func (m *Maintenance) Status() (bool, string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.enabled, m.message
+}
+
+// ReadOnlyDuringMaintenance rejects mutating requests with 503 and the
+// maintenance message, in the same JSON body as the handlers' errors, while
+// maintenance mode is enabled. GET, HEAD and OPTIONS requests, websocket
+// upgrades included, still go through.
+func ReadOnlyDuringMaintenance(m *Maintenance) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+			default:
+				if enabled, message := m.Status(); enabled {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusServiceUnavailable)
+					json.NewEncoder(w).Encode(map[string]string{"message": message})
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadOnlyDuringMaintenance(t *testing.T) {
+	maintenance := NewMaintenance(false, "")
+	handler := ReadOnlyDuringMaintenance(maintenance)(noContent)
+
+	serve := func(method string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(method, "/v1/messages", nil))
+		return rr
+	}
+
+	if rr := serve(http.MethodPost); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected a POST to pass outside maintenance, got %d", rr.Code)
+	}
+
+	if !maintenance.Set(true, "Upgrading the database") {
+		t.Error("Expected enabling maintenance to report a change")
+	}
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodDelete} {
+		rr := serve(method)
+		if rr.Code != http.StatusServiceUnavailable {
+			t.Errorf("Expected %s to be rejected with %d, got %d", method, http.StatusServiceUnavailable, rr.Code)
+		}
+		var body map[string]string
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil || body["message"] != "Upgrading the database" {
+			t.Errorf("Expected the maintenance message as JSON, got %v (%v)", body, err)
+		}
+		if contentType := rr.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("Expected a JSON response, got %q", contentType)
+		}
+	}
+	if rr := serve(http.MethodGet); rr.Code != http.StatusNoContent {
+		t.Errorf("Expected a GET to pass during maintenance, got %d", rr.Code)
+	}
+
+	if maintenance.Set(true, "") {
+		t.Error("Expected updating the message alone not to report a change")
+	}
+	if _, message := maintenance.Status(); message != DefaultMaintenanceMessage {
+		t.Errorf("Expected the default message, got %q", message)
+	}
+}
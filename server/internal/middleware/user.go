@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"e2ee-messenger/server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+const currentUserKey contextKey = "current_user"
+
+// UserLoader fetches a user by ID
+type UserLoader func(ctx context.Context, userID uuid.UUID) (*models.User, error)
+
+// userCache holds the current user for the lifetime of one request
+type userCache struct {
+	once sync.Once
+	load func() (*models.User, error)
+	user *models.User
+	err  error
+}
+
+// LoadUser makes the authenticated user available through CurrentUser. The
+// user is only fetched when a handler first asks for it, and at most once
+// per request. It must run after Auth.
+func LoadUser(load UserLoader) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, ok := r.Context().Value(UserIDKey).(uuid.UUID)
+			if !ok {
+				http.Error(w, "User not authenticated", http.StatusUnauthorized)
+				return
+			}
+
+			cache := &userCache{load: func() (*models.User, error) {
+				return load(r.Context(), userID)
+			}}
+			ctx := context.WithValue(r.Context(), currentUserKey, cache)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// CurrentUser returns the authenticated user, loading it on first use.
+// Errors from the loader are returned as is.
+func CurrentUser(r *http.Request) (*models.User, error) {
+	cache, ok := r.Context().Value(currentUserKey).(*userCache)
+	if !ok {
+		return nil, errors.New("current user requested without the LoadUser middleware")
+	}
+	cache.once.Do(func() {
+		cache.user, cache.err = cache.load()
+	})
+	return cache.user, cache.err
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"e2ee-messenger/server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+func TestCurrentUserIsLoadedOncePerRequest(t *testing.T) {
+	userID := uuid.New()
+	loads := 0
+	loader := func(ctx context.Context, id uuid.UUID) (*models.User, error) {
+		loads++
+		return &models.User{ID: id, Username: "alice"}, nil
+	}
+
+	handler := LoadUser(loader)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 3; i++ {
+			user, err := CurrentUser(r)
+			if err != nil {
+				t.Fatalf("CurrentUser failed: %v", err)
+			}
+			if user.ID != userID || user.Username != "alice" {
+				t.Errorf("Expected alice (%s), got %s (%s)", userID, user.Username, user.ID)
+			}
+		}
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req = req.WithContext(context.WithValue(req.Context(), UserIDKey, userID))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if loads != 1 {
+		t.Errorf("Expected the user to be loaded once, got %d loads", loads)
+	}
+}
+
+func TestCurrentUserIsLoadedLazily(t *testing.T) {
+	loader := func(ctx context.Context, id uuid.UUID) (*models.User, error) {
+		t.Error("Expected no load when the handler does not ask for the user")
+		return nil, errors.New("unexpected load")
+	}
+
+	handler := LoadUser(loader)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req = req.WithContext(context.WithValue(req.Context(), UserIDKey, uuid.New()))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, rr.Code)
+	}
+}
+
+func TestLoadUserRequiresAuthentication(t *testing.T) {
+	handler := LoadUser(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected the handler not to run")
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+}
@@ -1,6 +1,9 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -27,6 +30,20 @@ type Chat struct {
 	UnreadCount      int       `json:"unread_count"`
 	UpdatedAt        time.Time `json:"updated_at"`
 	ParticipantCount int       `json:"participant_count,omitempty"`
+	Description      string    `json:"description,omitempty"`
+	IsPinned         bool      `json:"is_pinned"`
+	IsArchived       bool      `json:"is_archived"`
+	// Set for groups with client-encrypted metadata, whose Name is empty
+	EncryptedMetadata string `json:"encrypted_metadata,omitempty"`
+}
+
+// SearchResult is a user or group whose name matched a search, with the
+// latest message of the caller's conversation with it, if any
+type SearchResult struct {
+	Type        string   `json:"type"` // "dm", "group"
+	User        *User    `json:"user,omitempty"`
+	Group       *Group   `json:"group,omitempty"`
+	LastMessage *Message `json:"last_message,omitempty"`
 }
 
 // DeviceKey represents a device's identity key
@@ -39,11 +56,27 @@ type DeviceKey struct {
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
 }
 
+// Presence tells whether a user is connected. LastSeen is when their last
+// connection went away, unset if they never connected.
+type Presence struct {
+	UserID   uuid.UUID  `json:"user_id"`
+	Status   string     `json:"status"` // "online", "offline"
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+}
+
+// Device is a device that uploaded keys under the user's account
+type Device struct {
+	DeviceID  string    `json:"device_id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
 // OneTimeKey represents a one-time prekey
 type OneTimeKey struct {
 	ID        uuid.UUID `json:"id" db:"id"`
 	UserID    uuid.UUID `json:"user_id" db:"user_id"`
 	KeyID     string    `json:"key_id" db:"key_id"`
+	DeviceID  string    `json:"device_id,omitempty" db:"device_id"`
 	PublicKey string    `json:"public_key" db:"public_key"`
 	Used      bool      `json:"used" db:"used"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
@@ -56,10 +89,64 @@ type Message struct {
 	RecipientID *uuid.UUID `json:"recipient_id,omitempty" db:"recipient_id"`
 	GroupID     *uuid.UUID `json:"group_id,omitempty" db:"group_id"`
 	// Note: We never store plaintext content
-	EncryptedContent string    `json:"encrypted_content" db:"encrypted_content"`
-	MessageType      string    `json:"message_type" db:"message_type"` // "text", "file", "system"
-	Sender           *User     `json:"sender,omitempty"`               // Included in API responses, not a DB column
-	CreatedAt        time.Time `json:"created_at" db:"created_at"`
+	EncryptedContent string      `json:"encrypted_content" db:"encrypted_content"`
+	MessageType      string      `json:"message_type" db:"message_type"` // "text", "file", "system"
+	Mentions         []uuid.UUID `json:"mentions,omitempty" db:"mentioned_user_ids"`
+	Status           string      `json:"status,omitempty"` // "sent", "delivered", "read"; direct messages may also be "delivery_pending", "delivery_failed"
+	Sender           *User       `json:"sender,omitempty"` // Included in API responses, not a DB column
+	CreatedAt        time.Time   `json:"created_at" db:"created_at"`
+	// Set on "system" messages only: server-authored plaintext describing a
+	// group event. User content is never stored here.
+	System *SystemEvent `json:"system,omitempty" db:"system_payload"`
+	// "normal" or "high"; high-priority messages notify recipients who
+	// muted the conversation
+	Priority string `json:"priority,omitempty" db:"priority"`
+	// View-once direct messages are wiped as soon as the recipient reads
+	// them; ConsumedAt is set from then on and the content is empty
+	ViewOnce   bool       `json:"view_once,omitempty" db:"view_once"`
+	ConsumedAt *time.Time `json:"consumed_at,omitempty" db:"consumed_at"`
+	// Set once the sender edits the message, to the time of the latest edit
+	EditedAt *time.Time `json:"edited_at,omitempty" db:"edited_at"`
+	// Set when the conversation had a disappearing message timer when the
+	// message was sent; the message is deleted for everyone from then on
+	ExpiresAt *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	// Deleted messages are tombstones with empty content, kept so receipts
+	// and ordering stay intact
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// SystemEvent is the content of a server-generated "system" message
+type SystemEvent struct {
+	Action    string      `json:"action"` // "member_added", "member_joined", "member_removed", "member_left", "group_renamed"
+	ActorID   uuid.UUID   `json:"actor_id"`
+	TargetIDs []uuid.UUID `json:"target_ids,omitempty"`
+	Name      string      `json:"name,omitempty"` // New name, for "group_renamed"
+}
+
+// Value stores a system event as JSON
+func (e SystemEvent) Value() (driver.Value, error) {
+	return json.Marshal(e)
+}
+
+// Scan reads a system event stored as JSON
+func (e *SystemEvent) Scan(src interface{}) error {
+	switch data := src.(type) {
+	case []byte:
+		return json.Unmarshal(data, e)
+	case string:
+		return json.Unmarshal([]byte(data), e)
+	default:
+		return fmt.Errorf("cannot scan %T into a system event", src)
+	}
+}
+
+// Reaction is an emoji a user reacted to a message with. The emoji is
+// plaintext metadata, not end-to-end encrypted.
+type Reaction struct {
+	MessageID uuid.UUID `json:"message_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Emoji     string    `json:"emoji"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 // Receipt represents a message receipt (delivered, read)
@@ -79,15 +166,38 @@ type Group struct {
 	CreatedBy   uuid.UUID `json:"created_by" db:"created_by"`
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+	// Name and description encrypted by the members with the group's sender
+	// key. Groups that use it have an empty plaintext name and description.
+	EncryptedMetadata string `json:"encrypted_metadata,omitempty" db:"encrypted_metadata"`
+}
+
+// GroupSummary is one of the current user's groups, with their role in it
+type GroupSummary struct {
+	Group
+	Role        string `json:"role"` // "admin", "member"
+	MemberCount int    `json:"member_count"`
+	// Time of the group's latest message, or of joining when there is none
+	LastActivityAt time.Time `json:"last_activity_at"`
+	// Messages from others since joining that the user has not read
+	UnreadCount int `json:"unread_count"`
 }
 
 // GroupMember represents a group membership (Phase 2 placeholder)
 type GroupMember struct {
-	ID       uuid.UUID `json:"id" db:"id"`
-	GroupID  uuid.UUID `json:"group_id" db:"group_id"`
-	UserID   uuid.UUID `json:"user_id" db:"user_id"`
-	Role     string    `json:"role" db:"role"` // "admin", "member"
-	JoinedAt time.Time `json:"joined_at" db:"joined_at"`
+	ID                uuid.UUID `json:"id" db:"id"`
+	GroupID           uuid.UUID `json:"group_id" db:"group_id"`
+	UserID            uuid.UUID `json:"user_id" db:"user_id"`
+	Role              string    `json:"role" db:"role"`                             // "admin", "member"
+	NotificationLevel string    `json:"notification_level" db:"notification_level"` // "all", "mentions", "none"
+	JoinedAt          time.Time `json:"joined_at" db:"joined_at"`
+}
+
+// GroupMemberProfile is a group member as listed to the other members. Only
+// the user's id, username and avatar_url are set.
+type GroupMemberProfile struct {
+	User     User      `json:"user"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
 }
 
 // Attachment represents an encrypted file attachment (Phase 2 placeholder)
@@ -100,15 +210,62 @@ type Attachment struct {
 	StoragePath  string    `json:"storage_path" db:"storage_path"`
 	EncryptedKey string    `json:"encrypted_key" db:"encrypted_key"` // AES key encrypted with recipient's key
 	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	// Hex SHA-256 of the stored file; identical uploads share one stored blob
+	ContentHash string `json:"content_hash,omitempty" db:"content_hash"`
+}
+
+// MediaItem is a message in a conversation's media gallery with its image
+// and video attachments
+type MediaItem struct {
+	Message     Message      `json:"message"`
+	Attachments []Attachment `json:"attachments"`
+}
+
+// MediaPage is one page of a media gallery, newest first. NextCursor is empty
+// on the last page.
+type MediaPage struct {
+	Items      []MediaItem `json:"items"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// MessagePage is one page of a conversation's messages, oldest first.
+// NextCursor is empty on the last page.
+type MessagePage struct {
+	Messages   []Message `json:"messages"`
+	NextCursor string    `json:"next_cursor,omitempty"`
 }
 
 // Request/Response DTOs
 
+// ValidationErrorResponse is the 400 body for a request whose fields break
+// the rules in their validate tags
+type ValidationErrorResponse struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Errors  []FieldError `json:"errors"`
+}
+
+// FieldError is one field that failed validation and why
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
 // SignupRequest represents a user signup request
 type SignupRequest struct {
 	Username string `json:"username" validate:"required,min=3,max=50"`
 	Email    string `json:"email" validate:"required,email"`
 	Password string `json:"password" validate:"required,min=8"`
+	// Required when the server is invite-only
+	InviteCode string `json:"invite_code,omitempty"`
+}
+
+// UsernameAvailabilityResponse tells whether a username can be registered,
+// and why not when it can't
+type UsernameAvailabilityResponse struct {
+	Username  string `json:"username"`
+	Available bool   `json:"available"`
+	Reason    string `json:"reason,omitempty"`
 }
 
 // LoginRequest represents a user login request
@@ -130,15 +287,87 @@ type ChangePasswordRequest struct {
 
 // CreateGroupRequest represents a request to create a new group
 type CreateGroupRequest struct {
-	Name      string   `json:"name" validate:"required,min=1,max=255"`
-	MemberIDs []string `json:"member_ids" validate:"required,min=1"`
+	Name        string   `json:"name" validate:"required_without=EncryptedMetadata,max=255"`
+	Description string   `json:"description,omitempty" validate:"max=1024"`
+	MemberIDs   []string `json:"member_ids" validate:"required,min=1"`
+	// Client-encrypted name and description, sent instead of Name and Description
+	EncryptedMetadata string `json:"encrypted_metadata,omitempty"`
+}
+
+// PromoteConversationRequest turns a direct conversation into a group by adding people
+type PromoteConversationRequest struct {
+	Name        string   `json:"name" validate:"required_without=EncryptedMetadata,max=255"`
+	Description string   `json:"description,omitempty" validate:"max=1024"`
+	MemberIDs   []string `json:"member_ids" validate:"required,min=1"`
+	// Client-encrypted name and description, sent instead of Name and Description
+	EncryptedMetadata string `json:"encrypted_metadata,omitempty"`
+}
+
+// AddGroupMembersRequest adds users to an existing group
+type AddGroupMembersRequest struct {
+	UserIDs []string `json:"user_ids" validate:"required,min=1"`
+}
+
+// AddGroupMembersResponse lists the users that were not yet members and got added
+type AddGroupMembersResponse struct {
+	AddedUserIDs []uuid.UUID `json:"added_user_ids"`
+}
+
+// InviteToGroupRequest invites users to join an existing group
+type InviteToGroupRequest struct {
+	UserIDs []string `json:"user_ids" validate:"required,min=1"`
+}
+
+// InviteToGroupResponse lists the users that were newly invited
+type InviteToGroupResponse struct {
+	InvitedUserIDs []uuid.UUID `json:"invited_user_ids"`
+}
+
+// GroupInvitation is a pending invitation for the current user to join a group
+type GroupInvitation struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	Group     Group     `json:"group"`
+	InvitedBy uuid.UUID `json:"invited_by" db:"invited_by"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// UpdateGroupRequest represents a change to a group's metadata. Omitted fields are left unchanged.
+type UpdateGroupRequest struct {
+	Name        *string `json:"name,omitempty" validate:"omitempty,min=1,max=255"`
+	Description *string `json:"description,omitempty" validate:"omitempty,max=1024"`
+	// Replaces the client-encrypted metadata. Setting it on a plaintext group
+	// opts the group in and clears its name and description.
+	EncryptedMetadata *string `json:"encrypted_metadata,omitempty"`
+}
+
+// TransferOwnershipRequest hands a group over to another member
+type TransferOwnershipRequest struct {
+	NewOwnerID string `json:"new_owner_id" validate:"required,uuid"`
+	// Make the previous owner a plain member instead of keeping them admin
+	DemotePreviousOwner bool `json:"demote_previous_owner"`
 }
 
 // AuthResponse represents an authentication response
 type AuthResponse struct {
-	Token    string `json:"token"`
-	User     User   `json:"user"`
-	DeviceID string `json:"device_id"`
+	// Short-lived access token (JWT)
+	Token string `json:"token"`
+	// Renews the access token at POST /v1/auth/refresh
+	RefreshToken string `json:"refresh_token"`
+	User         User   `json:"user"`
+	DeviceID     string `json:"device_id"`
+}
+
+// RefreshTokenRequest exchanges a refresh token for new tokens
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// RefreshTokenResponse carries a new access token and the refresh token that
+// replaces the one presented
+type RefreshTokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	DeviceID     string `json:"device_id"`
 }
 
 // DeviceKeyRequest represents a device key upload request
@@ -150,13 +379,38 @@ type DeviceKeyRequest struct {
 // OneTimeKeyRequest represents a one-time key upload request
 type OneTimeKeyRequest struct {
 	KeyID     string `json:"key_id" validate:"required"`
+	DeviceID  string `json:"device_id,omitempty"`
 	PublicKey string `json:"public_key" validate:"required"`
 }
 
+// RotateOneTimeKeysRequest replaces all of a device's unused one-time keys
+// with a fresh batch. The device ID of the individual keys is ignored.
+type RotateOneTimeKeysRequest struct {
+	DeviceID string              `json:"device_id" validate:"required"`
+	Keys     []OneTimeKeyRequest `json:"keys" validate:"required,min=1"`
+}
+
+// KeyStatusResponse summarizes the current user's key material
+type KeyStatusResponse struct {
+	DeviceCount       int               `json:"device_count"`
+	UnusedOneTimeKeys int               `json:"unused_one_time_keys"`
+	Devices           []DeviceKeyStatus `json:"devices"`
+}
+
+// DeviceKeyStatus describes the key state of one registered device
+type DeviceKeyStatus struct {
+	DeviceID          string    `json:"device_id"`
+	KeyUpdatedAt      time.Time `json:"key_updated_at"`
+	KeyAgeSeconds     int64     `json:"key_age_seconds"`
+	UnusedOneTimeKeys int       `json:"unused_one_time_keys"`
+}
+
 // BootstrapKeysResponse represents the response for bootstrap keys
 type BootstrapKeysResponse struct {
-	DeviceKeys  []DeviceKey  `json:"device_keys"`
-	OneTimeKeys []OneTimeKey `json:"one_time_keys"`
+	DeviceKeys []DeviceKey `json:"device_keys"`
+	// Claimed for the requester and never handed out again; null once the
+	// user has run out, in which case the session starts without one
+	OneTimeKey *OneTimeKey `json:"one_time_key"`
 }
 
 // SendMessageRequest represents a message send request
@@ -165,12 +419,29 @@ type SendMessageRequest struct {
 	GroupID          *string `json:"group_id,omitempty"`
 	EncryptedContent string  `json:"encrypted_content" validate:"required"`
 	MessageType      string  `json:"message_type" validate:"required,oneof=text file system"`
+	// Group members mentioned in the message. This is plaintext routing metadata,
+	// used to notify members whose notification level is "mentions".
+	Mentions []string `json:"mentions,omitempty" validate:"max=256"`
+	// Encryption scheme the content was produced with. The first tagged
+	// message settles the conversation's scheme; later ones must match it.
+	EncryptionScheme string `json:"encryption_scheme,omitempty" validate:"max=64"`
+	// "normal" (the default) or "high". Only group admins, or in a direct
+	// conversation someone the recipient has written to, may send "high".
+	Priority string `json:"priority,omitempty" validate:"omitempty,oneof=normal high"`
+	// Hold the response until an online recipient confirms delivery of a
+	// direct message, or a short timeout passes. The response's status is
+	// then "delivered" or "queued". Servers running several instances do not
+	// advertise the wait_for_delivery feature and ignore it.
+	WaitForDelivery bool `json:"wait_for_delivery,omitempty"`
+	// Wipe the message once the recipient reads it (direct messages only)
+	ViewOnce bool `json:"view_once,omitempty"`
 }
 
 // GetMessagesRequest represents a get messages request
 type GetMessagesRequest struct {
 	RecipientID string `json:"recipient_id" validate:"required"`
-	Since       string `json:"since,omitempty"` // ISO timestamp
+	Before      string `json:"before,omitempty"` // RFC3339 timestamp, message ID or cursor
+	After       string `json:"after,omitempty"`  // RFC3339 timestamp, message ID or cursor
 	Limit       int    `json:"limit,omitempty"`
 }
 
@@ -179,3 +450,186 @@ type SendReceiptRequest struct {
 	MessageID string `json:"message_id" validate:"required"`
 	Type      string `json:"type" validate:"required,oneof=delivered read"`
 }
+
+// BulkReceiptRequest represents a request to acknowledge several messages at once
+type BulkReceiptRequest struct {
+	MessageIDs []string `json:"message_ids" validate:"required,min=1,max=500"`
+	Type       string   `json:"type" validate:"required,oneof=delivered read"`
+}
+
+// DeleteMessagesRequest represents a request to delete several of the caller's messages at once
+type DeleteMessagesRequest struct {
+	MessageIDs []string `json:"message_ids" validate:"required,min=1,max=500"`
+	// Skip messages the caller did not send instead of rejecting the request
+	SkipUnauthorized bool `json:"skip_unauthorized"`
+}
+
+// DeleteMessagesResponse lists the messages that were deleted
+type DeleteMessagesResponse struct {
+	DeletedIDs []uuid.UUID `json:"deleted_ids"`
+}
+
+// AddReactionRequest reacts to a message with an emoji
+type AddReactionRequest struct {
+	Emoji string `json:"emoji" validate:"required,max=64"`
+}
+
+// EditMessageRequest represents a request to replace the content of one of the caller's messages
+type EditMessageRequest struct {
+	EncryptedContent string `json:"encrypted_content" validate:"required"`
+}
+
+// Session represents a websocket connection, used to show users where they are logged in
+type Session struct {
+	ID           uuid.UUID  `json:"id" db:"id"`
+	UserID       uuid.UUID  `json:"user_id" db:"user_id"`
+	RemoteAddr   string     `json:"remote_addr" db:"remote_addr"`
+	UserAgent    string     `json:"user_agent" db:"user_agent"`
+	Origin       string     `json:"origin" db:"origin"`
+	ConnectedAt  time.Time  `json:"connected_at" db:"connected_at"`
+	LastActiveAt time.Time  `json:"last_active_at" db:"last_active_at"`
+	ClosedAt     *time.Time `json:"closed_at,omitempty" db:"closed_at"`
+	Active       bool       `json:"active"`
+}
+
+// NotificationLevelRequest represents a request to change a group's notification level
+type NotificationLevelRequest struct {
+	Level string `json:"level" validate:"required,oneof=all mentions none"`
+}
+
+// ConversationSettings is the caller's effective settings for one conversation.
+// ID is the group ID for group chats and the other participant's user ID for DMs.
+type ConversationSettings struct {
+	ConversationID    uuid.UUID  `json:"conversation_id"`
+	Type              string     `json:"type"`               // "dm", "group"
+	NotificationLevel string     `json:"notification_level"` // "all", "mentions", "none"
+	Muted             bool       `json:"muted"`
+	MutedUntil        *time.Time `json:"muted_until,omitempty"`
+	MessageTTLSeconds int        `json:"message_ttl_seconds"` // Shared by all participants; 0 when messages do not disappear
+	IsPinned          bool       `json:"is_pinned"`
+	// Encryption scheme the participants agreed on; empty until negotiated
+	EncryptionScheme string `json:"encryption_scheme,omitempty"`
+}
+
+// SetEncryptionSchemeRequest sets the encryption scheme of a conversation
+type SetEncryptionSchemeRequest struct {
+	Scheme string `json:"scheme" validate:"required,max=64"`
+}
+
+// SessionStatus describes the key material the server holds for the other
+// participants of a conversation, to help diagnose messages that fail to
+// decrypt. It never includes keys themselves.
+type SessionStatus struct {
+	ConversationID uuid.UUID                  `json:"conversation_id"`
+	Type           string                     `json:"type"` // "dm", "group"
+	Participants   []ParticipantSessionStatus `json:"participants"`
+}
+
+// ParticipantSessionStatus is the key state of one other participant
+type ParticipantSessionStatus struct {
+	UserID        uuid.UUID             `json:"user_id"`
+	HasDeviceKeys bool                  `json:"has_device_keys"`
+	Devices       []DeviceSessionStatus `json:"devices"`
+	// Whether a bootstrap would currently hand out a one-time key
+	OneTimeKeysAvailable bool `json:"one_time_keys_available"`
+	// Whether any device replaced its public key recently
+	IdentityKeyChanged bool `json:"identity_key_changed"`
+}
+
+// DeviceSessionStatus is the key state of one of a participant's devices.
+// Devices upload a single signed key, so its age is the signed prekey age.
+type DeviceSessionStatus struct {
+	DeviceID      string     `json:"device_id"`
+	KeyAgeSeconds int64      `json:"key_age_seconds"`
+	KeyChangedAt  *time.Time `json:"key_changed_at,omitempty"`
+}
+
+// ReorderPinnedConversationsRequest lists pinned conversations top to bottom.
+// Pinned conversations left out keep their order below the listed ones.
+type ReorderPinnedConversationsRequest struct {
+	ConversationIDs []uuid.UUID `json:"conversation_ids"`
+}
+
+// UpdateConversationSettingsRequest changes the caller's settings for a conversation.
+// Omitted fields are left unchanged; a past muted_until unmutes and a zero TTL disables expiry.
+// The TTL is the conversation's disappearing message timer, shared by all its participants.
+type UpdateConversationSettingsRequest struct {
+	MutedUntil        *time.Time `json:"muted_until,omitempty"`
+	MessageTTLSeconds *int       `json:"message_ttl_seconds,omitempty" validate:"omitempty,min=0"`
+}
+
+// PrivacySettings holds a user's privacy preferences
+type PrivacySettings struct {
+	// When false, the user's "read" receipts are neither stored nor sent
+	SendReadReceipts bool `json:"send_read_receipts"`
+	// When false, only contacts (users the user has exchanged direct messages
+	// or shares a group with) may fetch the user's keys to start a session
+	AllowKeyBootstrap bool `json:"allow_key_bootstrap"`
+}
+
+// UpdatePrivacySettingsRequest changes a user's privacy preferences. Omitted fields are left unchanged.
+type UpdatePrivacySettingsRequest struct {
+	SendReadReceipts  *bool `json:"send_read_receipts,omitempty"`
+	AllowKeyBootstrap *bool `json:"allow_key_bootstrap,omitempty"`
+}
+
+// Capabilities describes what this server deployment supports, so clients
+// can adapt to it instead of hardcoding limits
+type Capabilities struct {
+	ProtocolVersion        int      `json:"protocol_version"`
+	MaxAvatarSize          int64    `json:"max_avatar_size"`
+	MaxAttachmentSize      int64    `json:"max_attachment_size"`
+	MaxGroupMembers        int      `json:"max_group_members"`
+	MaxMessageLimit        int      `json:"max_message_limit"`
+	MaxPinnedConversations int      `json:"max_pinned_conversations"`
+	MessageTypes           []string `json:"message_types"`
+	Features               []string `json:"features"`
+	// Signup requires an invite code
+	InviteOnly bool `json:"invite_only"`
+}
+
+// InviteCode lets up to MaxUses people sign up to an invite-only server
+type InviteCode struct {
+	Code      string     `json:"code"`
+	CreatedBy *uuid.UUID `json:"created_by,omitempty"`
+	MaxUses   int        `json:"max_uses"`
+	UseCount  int        `json:"use_count"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// CreateInviteCodeRequest describes a new invite code. MaxUses defaults to
+// one; codes without ExpiresAt never expire.
+type CreateInviteCodeRequest struct {
+	MaxUses   int        `json:"max_uses,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// PushToken is a device's registration with a push provider
+type PushToken struct {
+	UserID    uuid.UUID `json:"user_id"`
+	DeviceID  string    `json:"device_id"`
+	Platform  string    `json:"platform"` // "apns", "fcm"
+	Token     string    `json:"token"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RegisterPushTokenRequest registers a device's push token
+type RegisterPushTokenRequest struct {
+	Platform string `json:"platform" validate:"required,oneof=apns fcm"`
+	Token    string `json:"token" validate:"required"`
+}
+
+// MaintenanceStatus describes the server's maintenance mode
+type MaintenanceStatus struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message"`
+}
+
+// SetMaintenanceRequest turns maintenance mode on or off; an empty message
+// uses the default one
+type SetMaintenanceRequest struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message,omitempty" validate:"max=500"`
+}
@@ -0,0 +1,77 @@
+// Package passwordhash hashes passwords with Argon2id and a random salt per
+// password. Hashes are stored as PHC strings, which carry the parameters they
+// were made with, so the parameters can be raised later without breaking
+// existing hashes:
+//
+//	$argon2id$v=19$m=65536,t=1,p=4$<salt>$<hash>
+//
+// Salt and hash are unpadded standard base64.
+package passwordhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// ErrUnsupportedHash is returned for stored hashes that are not Argon2id PHC
+// strings, such as the unsalted hex digests written by early versions
+var ErrUnsupportedHash = errors.New("unsupported password hash format")
+
+// Parameters for new hashes
+const (
+	memory     = 64 * 1024 // KiB
+	iterations = 1
+	threads    = 4
+	saltLen    = 16
+	keyLen     = 32
+)
+
+var encoding = base64.RawStdEncoding
+
+// Hash returns the PHC string for password, salted with fresh random bytes
+func Hash(password string) (string, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+	key := argon2.IDKey([]byte(password), salt, iterations, memory, threads, keyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, memory, iterations, threads, encoding.EncodeToString(salt), encoding.EncodeToString(key)), nil
+}
+
+// Verify reports whether password matches the PHC string encoded. The
+// comparison takes constant time. Hashes it cannot parse return
+// ErrUnsupportedHash rather than a mismatch.
+func Verify(password, encoded string) (bool, error) {
+	parts := strings.Split(encoded, "$")
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, hash
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return false, ErrUnsupportedHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, ErrUnsupportedHash
+	}
+	var m, t uint32
+	var p uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &m, &t, &p); err != nil || m == 0 || t == 0 || p == 0 {
+		return false, ErrUnsupportedHash
+	}
+	salt, err := encoding.DecodeString(parts[4])
+	if err != nil || len(salt) == 0 {
+		return false, ErrUnsupportedHash
+	}
+	key, err := encoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return false, ErrUnsupportedHash
+	}
+
+	computed := argon2.IDKey([]byte(password), salt, t, m, p, uint32(len(key)))
+	return subtle.ConstantTimeCompare(computed, key) == 1, nil
+}
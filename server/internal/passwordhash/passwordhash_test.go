@@ -0,0 +1,47 @@
+package passwordhash
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestHashIsSaltedAndVerifies(t *testing.T) {
+	first, err := Hash("correct-horse-battery-9")
+	if err != nil {
+		t.Fatalf("Failed to hash: %v", err)
+	}
+	if !strings.HasPrefix(first, "$argon2id$v=19$m=65536,t=1,p=4$") {
+		t.Errorf("Expected a PHC string, got %s", first)
+	}
+	second, _ := Hash("correct-horse-battery-9")
+	if first == second {
+		t.Error("Expected hashes of the same password to differ")
+	}
+
+	for _, encoded := range []string{first, second} {
+		if ok, err := Verify("correct-horse-battery-9", encoded); err != nil || !ok {
+			t.Errorf("Expected the password to verify, got %v, %v", ok, err)
+		}
+		if ok, err := Verify("wrong-horse-battery-9", encoded); err != nil || ok {
+			t.Errorf("Expected a wrong password to be rejected, got %v, %v", ok, err)
+		}
+	}
+}
+
+func TestVerifyRejectsUnsupportedHashes(t *testing.T) {
+	for _, encoded := range []string{
+		"",
+		// The unsalted hex digest of early versions
+		"5f4dcc3b5aa765d61d8327deb882cf995f4dcc3b5aa765d61d8327deb882cf99",
+		"$argon2i$v=19$m=65536,t=1,p=4$c2FsdA$aGFzaA",
+		"$argon2id$v=16$m=65536,t=1,p=4$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=0,t=1,p=4$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=65536,t=1,p=4$not base64!$aGFzaA",
+		"$argon2id$v=19$m=65536,t=1,p=4$c2FsdA$",
+	} {
+		if ok, err := Verify("password123", encoded); ok || !errors.Is(err, ErrUnsupportedHash) {
+			t.Errorf("Expected %q to be unsupported, got %v, %v", encoded, ok, err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Presence records which instances users are connected to in Redis. Each
+// user has a sorted set of instance IDs scored by when the instance last
+// recorded them; entries older than the TTL no longer count.
+type Presence struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+// Presence shares presence through the same Redis server, under keys
+// prefixed with the channel name. Entries that are not refreshed for ttl
+// expire.
+func (r *Redis) Presence(ttl time.Duration) *Presence {
+	return &Presence{client: r.client, prefix: r.channel + ":presence:", ttl: ttl}
+}
+
+// key is the sorted set of the instances the user is connected to
+func (p *Presence) key(userID string) string {
+	return p.prefix + userID
+}
+
+// expired is the score range of entries older than the TTL
+func (p *Presence) expired(now time.Time) string {
+	return "(" + strconv.FormatInt(now.Add(-p.ttl).UnixMilli(), 10)
+}
+
+// Join records that the user has clients connected to the instance and
+// reports whether they had none on any instance before
+func (p *Presence) Join(ctx context.Context, instanceID, userID string) (bool, error) {
+	now := time.Now()
+	key := p.key(userID)
+	pipe := p.client.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", p.expired(now))
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.UnixMilli()), Member: instanceID})
+	count := pipe.ZCard(ctx, key)
+	pipe.Expire(ctx, key, p.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, fmt.Errorf("failed to record presence: %w", err)
+	}
+	return count.Val() == 1, nil
+}
+
+// Leave records that the user has no clients left on the instance and
+// reports whether they have none on any instance now
+func (p *Presence) Leave(ctx context.Context, instanceID, userID string) (bool, error) {
+	key := p.key(userID)
+	pipe := p.client.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", p.expired(time.Now()))
+	pipe.ZRem(ctx, key, instanceID)
+	count := pipe.ZCard(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, fmt.Errorf("failed to record presence: %w", err)
+	}
+	return count.Val() == 0, nil
+}
+
+// Online reports whether the user has clients connected to any instance
+func (p *Presence) Online(ctx context.Context, userID string) (bool, error) {
+	min := strconv.FormatInt(time.Now().Add(-p.ttl).UnixMilli(), 10)
+	count, err := p.client.ZCount(ctx, p.key(userID), min, "+inf").Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to get presence: %w", err)
+	}
+	return count > 0, nil
+}
+
+// Refresh keeps the instance's entries for the users from expiring
+func (p *Presence) Refresh(ctx context.Context, instanceID string, userIDs []string) error {
+	now := time.Now()
+	pipe := p.client.Pipeline()
+	for _, userID := range userIDs {
+		key := p.key(userID)
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.UnixMilli()), Member: instanceID})
+		pipe.Expire(ctx, key, p.ttl)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to refresh presence: %w", err)
+	}
+	return nil
+}
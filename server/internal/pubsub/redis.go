@@ -0,0 +1,65 @@
+// Package pubsub provides the Redis backend hubs use to share events when
+// several server instances run behind a load balancer.
+package pubsub
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis publishes and subscribes to a single Redis channel
+type Redis struct {
+	client  *redis.Client
+	channel string
+}
+
+// NewRedis connects to the Redis server at url, a redis:// or rediss:// URL,
+// and uses channel for hub events
+func NewRedis(ctx context.Context, url, channel string) (*Redis, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	return &Redis{client: client, channel: channel}, nil
+}
+
+// Publish sends data to every subscriber of the channel
+func (r *Redis) Publish(ctx context.Context, data []byte) error {
+	return r.client.Publish(ctx, r.channel, data).Err()
+}
+
+// Subscribe calls handler with every payload published to the channel until
+// ctx is done or the subscription fails
+func (r *Redis) Subscribe(ctx context.Context, handler func(data []byte)) error {
+	sub := r.client.Subscribe(ctx, r.channel)
+	defer sub.Close()
+	// Wait for the subscription to be confirmed so failures are reported
+	if _, err := sub.Receive(ctx); err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", r.channel, err)
+	}
+
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-messages:
+			if !ok {
+				return fmt.Errorf("subscription to %s closed", r.channel)
+			}
+			handler([]byte(msg.Payload))
+		}
+	}
+}
+
+// Close closes the connection to Redis
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
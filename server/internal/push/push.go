@@ -0,0 +1,74 @@
+// Package push builds push notification payloads and hands them to a push
+// provider such as APNs or FCM.
+//
+// Messages are end-to-end encrypted, so a payload only carries routing
+// metadata: which conversation has a new message, from whom, and when. The
+// client fetches and decrypts the message itself once woken up.
+package push
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"e2ee-messenger/server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// Payload is the notification-safe summary of a message
+type Payload struct {
+	MessageID        uuid.UUID `json:"message_id"`
+	ConversationID   uuid.UUID `json:"conversation_id"`
+	ConversationType string    `json:"conversation_type"` // "dm", "group"
+	SenderID         uuid.UUID `json:"sender_id"`
+	SenderUsername   string    `json:"sender_username,omitempty"`
+	MessageType      string    `json:"message_type"`
+	Priority         string    `json:"priority,omitempty"` // "high" lets the client alert despite do-not-disturb
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// NotificationPayload builds the push payload for a message as seen by its
+// recipient. The conversation of a direct message is the sender, the way the
+// recipient's chat list identifies it. The encrypted content is never included.
+func NotificationPayload(msg models.Message) Payload {
+	payload := Payload{
+		MessageID:        msg.ID,
+		ConversationID:   msg.SenderID,
+		ConversationType: "dm",
+		SenderID:         msg.SenderID,
+		MessageType:      msg.MessageType,
+		Priority:         msg.Priority,
+		CreatedAt:        msg.CreatedAt.UTC(),
+	}
+	if msg.GroupID != nil {
+		payload.ConversationID = *msg.GroupID
+		payload.ConversationType = "group"
+	}
+	if msg.Sender != nil {
+		payload.SenderUsername = msg.Sender.Username
+	}
+	return payload
+}
+
+// ErrInvalidToken is returned, possibly wrapped, by a Sender when the
+// provider reports that a token is no longer registered. The token is then
+// forgotten until the device registers a new one.
+var ErrInvalidToken = errors.New("push token no longer valid")
+
+// Sender delivers a payload to one device through its push provider
+type Sender interface {
+	Send(ctx context.Context, token models.PushToken, payload Payload) error
+}
+
+// LogSender only logs what it would have sent. It is the default until a
+// real provider is configured.
+type LogSender struct{}
+
+// Send logs the notification
+func (LogSender) Send(ctx context.Context, token models.PushToken, payload Payload) error {
+	log.Printf("Push (%s) to user %s device %s: %s message %s in %s %s",
+		token.Platform, token.UserID, token.DeviceID, payload.MessageType, payload.MessageID, payload.ConversationType, payload.ConversationID)
+	return nil
+}
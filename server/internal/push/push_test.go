@@ -0,0 +1,64 @@
+package push
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"e2ee-messenger/server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+func TestNotificationPayloadForDirectMessage(t *testing.T) {
+	recipientID := uuid.New()
+	msg := models.Message{
+		ID:               uuid.New(),
+		SenderID:         uuid.New(),
+		RecipientID:      &recipientID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "text",
+		CreatedAt:        time.Date(2026, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600)),
+	}
+	msg.Sender = &models.User{ID: msg.SenderID, Username: "alice"}
+
+	payload := NotificationPayload(msg)
+	if payload.ConversationType != "dm" || payload.ConversationID != msg.SenderID {
+		t.Errorf("Expected the DM to be identified by its sender, got %s %s", payload.ConversationType, payload.ConversationID)
+	}
+	if payload.SenderUsername != "alice" {
+		t.Errorf("Expected sender username alice, got %q", payload.SenderUsername)
+	}
+	if payload.CreatedAt.Location() != time.UTC {
+		t.Errorf("Expected a UTC timestamp, got %s", payload.CreatedAt.Location())
+	}
+}
+
+func TestNotificationPayloadForGroupMessage(t *testing.T) {
+	groupID := uuid.New()
+	msg := models.Message{
+		ID:               uuid.New(),
+		SenderID:         uuid.New(),
+		GroupID:          &groupID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "file",
+		CreatedAt:        time.Now(),
+	}
+
+	payload := NotificationPayload(msg)
+	if payload.ConversationType != "group" || payload.ConversationID != groupID {
+		t.Errorf("Expected group conversation %s, got %s %s", groupID, payload.ConversationType, payload.ConversationID)
+	}
+	if payload.MessageType != "file" {
+		t.Errorf("Expected message type file, got %s", payload.MessageType)
+	}
+
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("Failed to encode payload: %v", err)
+	}
+	if strings.Contains(string(encoded), "ciphertext") {
+		t.Errorf("Expected the payload to leave out the message content, got %s", encoded)
+	}
+}
@@ -0,0 +1,88 @@
+// Package ratelimit provides an in-memory, per-key fixed-window rate limiter.
+//
+// Limits are kept per process, so with several server instances each one
+// enforces its own budget.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter allows up to limit events per key in each window
+type Limiter struct {
+	limit  int
+	window time.Duration
+
+	mu        sync.Mutex
+	windows   map[string]*bucket
+	lastSweep time.Time
+
+	// now is swapped out in tests
+	now func() time.Time
+}
+
+// bucket counts the events of one key in its current window
+type bucket struct {
+	start time.Time
+	count int
+}
+
+// New creates a limiter allowing limit events per key every window
+func New(limit int, window time.Duration) *Limiter {
+	return &Limiter{
+		limit:   limit,
+		window:  window,
+		windows: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow records an event for key and reports whether it is within the limit.
+// Denied events do not count against the budget.
+func (l *Limiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		w = &bucket{start: now}
+		l.windows[key] = w
+	}
+	if w.count >= l.limit {
+		return false
+	}
+	w.count++
+	return true
+}
+
+// RetryAfter returns how long until key gets a fresh budget, or zero if it has one now
+func (l *Limiter) RetryAfter(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w, ok := l.windows[key]
+	if !ok || w.count < l.limit {
+		return 0
+	}
+	if remaining := l.window - l.now().Sub(w.start); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// sweep drops expired windows so idle keys do not pile up. The caller must hold mu.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.window {
+		return
+	}
+	l.lastSweep = now
+	for key, w := range l.windows {
+		if now.Sub(w.start) >= l.window {
+			delete(l.windows, key)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterAllowsUpToLimitPerWindow(t *testing.T) {
+	now := time.Now()
+	l := New(2, time.Minute)
+	l.now = func() time.Time { return now }
+
+	if !l.Allow("alice") || !l.Allow("alice") {
+		t.Fatal("Expected the first two events to be allowed")
+	}
+	if l.Allow("alice") {
+		t.Error("Expected the third event in the window to be denied")
+	}
+	if !l.Allow("bob") {
+		t.Error("Expected other keys to have their own budget")
+	}
+	if got := l.RetryAfter("alice"); got != time.Minute {
+		t.Errorf("Expected to retry after a minute, got %s", got)
+	}
+
+	now = now.Add(time.Minute)
+	if !l.Allow("alice") {
+		t.Error("Expected a fresh budget in the next window")
+	}
+}
+
+func TestLimiterSweepsExpiredWindows(t *testing.T) {
+	now := time.Now()
+	l := New(1, time.Minute)
+	l.now = func() time.Time { return now }
+
+	l.Allow("alice")
+	now = now.Add(2 * time.Minute)
+	l.Allow("bob")
+
+	if _, ok := l.windows["alice"]; ok {
+		t.Error("Expected alice's expired window to be swept")
+	}
+}
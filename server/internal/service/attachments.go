@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+)
+
+// Attachment files are stored once per distinct content, as
+// <AttachmentsDir>/blobs/<first two hash characters>/<hash>, and
+// attachment_blobs counts the attachments referencing each blob. Files of
+// released blobs are only removed once the deletion has committed, so a
+// rolled back deletion never loses them. Removal then holds a placeholder
+// row for the blob, so a blob is never removed while an upload is about to
+// share it.
+
+// blobPath returns where the blob with the given content hash is stored
+func (s *Service) blobPath(contentHash string) string {
+	return filepath.Join(s.cfg.AttachmentsDir, "blobs", contentHash[:2], contentHash)
+}
+
+// retainBlobTx takes a reference on the blob for contentHash within tx and
+// returns its storage path. The uploaded file becomes the blob when none is
+// stored yet; otherwise the caller discards it.
+func (s *Service) retainBlobTx(ctx context.Context, tx *sql.Tx, contentHash, uploadPath string) (string, error) {
+	var storagePath string
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO attachment_blobs (content_hash, storage_path, ref_count)
+		VALUES ($1, $2, 1)
+		ON CONFLICT (content_hash) DO UPDATE SET ref_count = attachment_blobs.ref_count + 1
+		RETURNING storage_path
+	`, contentHash, s.blobPath(contentHash)).Scan(&storagePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to reference attachment blob: %w", err)
+	}
+
+	// A missing file is restored from the upload, which has the same content
+	if _, err := os.Stat(storagePath); err == nil {
+		return storagePath, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(storagePath), 0755); err != nil {
+		return "", fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	if err := os.Rename(uploadPath, storagePath); err != nil {
+		return "", fmt.Errorf("failed to store attachment blob: %w", err)
+	}
+	return storagePath, nil
+}
+
+// releasedFiles lists the attachment files whose rows a transaction deleted,
+// to be removed with removeReleasedFiles once it commits
+type releasedFiles struct {
+	blobs  map[string]string // Storage path by content hash
+	legacy []string
+}
+
+// merge adds the files released by another deletion in the same transaction
+func (f *releasedFiles) merge(other releasedFiles) {
+	for contentHash, storagePath := range other.blobs {
+		if f.blobs == nil {
+			f.blobs = make(map[string]string)
+		}
+		f.blobs[contentHash] = storagePath
+	}
+	f.legacy = append(f.legacy, other.legacy...)
+}
+
+// deleteAttachmentsTx deletes the attachments of the given messages within tx
+// and releases their blobs. It returns the files of blobs no attachment
+// references anymore, which the caller removes once tx has committed.
+func deleteAttachmentsTx(ctx context.Context, tx *sql.Tx, messageIDs []uuid.UUID) (releasedFiles, error) {
+	var files releasedFiles
+	rows, err := tx.QueryContext(ctx, `
+		DELETE FROM attachments WHERE message_id = ANY($1::uuid[])
+		RETURNING storage_path, content_hash
+	`, uuidArray(messageIDs))
+	if err != nil {
+		return files, fmt.Errorf("failed to delete attachments: %w", err)
+	}
+	released := make(map[string]int)
+	for rows.Next() {
+		var storagePath string
+		var contentHash sql.NullString
+		if err := rows.Scan(&storagePath, &contentHash); err != nil {
+			rows.Close()
+			return files, fmt.Errorf("failed to scan attachment: %w", err)
+		}
+		if contentHash.Valid {
+			released[contentHash.String]++
+		} else {
+			// Uploaded before blobs were shared, into a directory per message
+			files.legacy = append(files.legacy, storagePath)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return files, fmt.Errorf("failed to delete attachments: %w", err)
+	}
+
+	for contentHash, count := range released {
+		var storagePath string
+		err := tx.QueryRowContext(ctx, `
+			DELETE FROM attachment_blobs WHERE content_hash = $1 AND ref_count <= $2
+			RETURNING storage_path
+		`, contentHash, count).Scan(&storagePath)
+		if err == nil {
+			files.merge(releasedFiles{blobs: map[string]string{contentHash: storagePath}})
+			continue
+		}
+		if err != sql.ErrNoRows {
+			return files, fmt.Errorf("failed to release attachment blob: %w", err)
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE attachment_blobs SET ref_count = ref_count - $2 WHERE content_hash = $1", contentHash, count)
+		if err != nil {
+			return files, fmt.Errorf("failed to release attachment blob: %w", err)
+		}
+	}
+	return files, nil
+}
+
+// removeReleasedFiles removes the files of a committed deletion. A blob
+// another upload retained in the meantime is kept. The rows are gone, so a
+// file that fails to go only wastes disk space and is just logged.
+func (s *Service) removeReleasedFiles(ctx context.Context, files releasedFiles) {
+	for contentHash, storagePath := range files.blobs {
+		if err := s.removeBlobFile(ctx, contentHash, storagePath); err != nil {
+			log.Printf("Failed to remove attachment file %s: %v", storagePath, err)
+		}
+	}
+	for _, storagePath := range files.legacy {
+		if err := os.Remove(storagePath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove attachment file %s: %v", storagePath, err)
+		}
+		// Fails, harmlessly, while the message directory still holds files
+		os.Remove(filepath.Dir(storagePath))
+	}
+}
+
+// removeBlobFile removes a released blob's file unless an upload retained
+// the blob again. A placeholder row without references stands in for the
+// blob meanwhile: an upload sharing it waits for the placeholder to go, and
+// then stores its own file.
+func (s *Service) removeBlobFile(ctx context.Context, contentHash, storagePath string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO attachment_blobs (content_hash, storage_path, ref_count) VALUES ($1, $2, 0)
+		ON CONFLICT (content_hash) DO NOTHING
+	`, contentHash, storagePath)
+	if err != nil {
+		return fmt.Errorf("failed to hold attachment blob: %w", err)
+	}
+	if held, _ := result.RowsAffected(); held == 0 {
+		return nil
+	}
+	if err := os.Remove(storagePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM attachment_blobs WHERE content_hash = $1", contentHash); err != nil {
+		return fmt.Errorf("failed to release attachment blob: %w", err)
+	}
+	return tx.Commit()
+}
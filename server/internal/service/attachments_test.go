@@ -0,0 +1,257 @@
+package service_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/service"
+	"e2ee-messenger/server/internal/testutil"
+
+	"github.com/google/uuid"
+)
+
+func TestIdenticalAttachmentsShareOneBlob(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("ATTACHMENTS_DIR", dir)
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+
+	content := []byte("encrypted-file-content")
+	sum := sha256.Sum256(content)
+	contentHash := hex.EncodeToString(sum[:])
+
+	var messageIDs []uuid.UUID
+	for i := 0; i < 2; i++ {
+		message, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+			SenderID:         alice.ID,
+			RecipientID:      &bob.ID,
+			EncryptedContent: "ciphertext",
+			MessageType:      "file",
+		})
+		if err != nil {
+			t.Fatalf("SendMessage failed: %v", err)
+		}
+		upload := filepath.Join(t.TempDir(), "upload")
+		if err := os.WriteFile(upload, content, 0644); err != nil {
+			t.Fatalf("Failed to write upload: %v", err)
+		}
+		err = svc.AddAttachment(context.Background(), models.Attachment{
+			MessageID:    message.ID,
+			FileName:     "photo.jpg",
+			FileSize:     int64(len(content)),
+			MimeType:     "image/jpeg",
+			EncryptedKey: "encrypted-key",
+			ContentHash:  contentHash,
+		}, upload)
+		if err != nil {
+			t.Fatalf("AddAttachment failed: %v", err)
+		}
+		if _, err := os.Stat(upload); !os.IsNotExist(err) {
+			t.Errorf("Expected the upload to be moved or discarded, got %v", err)
+		}
+		messageIDs = append(messageIDs, message.ID)
+	}
+
+	var paths int
+	var storagePath string
+	if err := db.QueryRow("SELECT COUNT(DISTINCT storage_path), MIN(storage_path) FROM attachments").Scan(&paths, &storagePath); err != nil {
+		t.Fatalf("Failed to fetch attachments: %v", err)
+	}
+	if paths != 1 {
+		t.Fatalf("Expected both attachments to share one blob, got %d paths", paths)
+	}
+	blobs, _ := filepath.Glob(filepath.Join(dir, "blobs", "*", "*"))
+	if len(blobs) != 1 {
+		t.Fatalf("Expected one stored blob, got %v", blobs)
+	}
+
+	// The blob outlives the first deletion and goes with the last
+	if _, err := svc.DeleteMessages(context.Background(), alice.ID, messageIDs[:1], false); err != nil {
+		t.Fatalf("DeleteMessages failed: %v", err)
+	}
+	if _, err := os.Stat(storagePath); err != nil {
+		t.Fatalf("Expected the shared blob to be kept, got %v", err)
+	}
+	if _, err := svc.DeleteMessages(context.Background(), alice.ID, messageIDs[1:], false); err != nil {
+		t.Fatalf("DeleteMessages failed: %v", err)
+	}
+	if _, err := os.Stat(storagePath); !os.IsNotExist(err) {
+		t.Errorf("Expected the unreferenced blob to be removed, got %v", err)
+	}
+	var remaining int
+	if err := db.QueryRow("SELECT COUNT(*) FROM attachment_blobs").Scan(&remaining); err != nil {
+		t.Fatalf("Failed to count blobs: %v", err)
+	}
+	if remaining != 0 {
+		t.Errorf("Expected no blob rows left, got %d", remaining)
+	}
+}
+
+func TestDeleteAccountRemovesFiles(t *testing.T) {
+	dir := t.TempDir()
+	avatars := t.TempDir()
+	t.Setenv("ATTACHMENTS_DIR", dir)
+	t.Setenv("AVATARS_DIR", avatars)
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+
+	message, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         alice.ID,
+		RecipientID:      &bob.ID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "file",
+	})
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	content := []byte("encrypted-file-content")
+	sum := sha256.Sum256(content)
+	upload := filepath.Join(t.TempDir(), "upload")
+	if err := os.WriteFile(upload, content, 0644); err != nil {
+		t.Fatalf("Failed to write upload: %v", err)
+	}
+	err = svc.AddAttachment(context.Background(), models.Attachment{
+		MessageID:    message.ID,
+		FileName:     "photo.jpg",
+		FileSize:     int64(len(content)),
+		MimeType:     "image/jpeg",
+		EncryptedKey: "encrypted-key",
+		ContentHash:  hex.EncodeToString(sum[:]),
+	}, upload)
+	if err != nil {
+		t.Fatalf("AddAttachment failed: %v", err)
+	}
+
+	avatar := filepath.Join(avatars, alice.ID.String()+".jpg")
+	if err := os.WriteFile(avatar, []byte("avatar"), 0644); err != nil {
+		t.Fatalf("Failed to write avatar: %v", err)
+	}
+	if _, err := db.Exec("UPDATE users SET avatar_url = $1 WHERE id = $2", "/uploads/"+alice.ID.String()+".jpg", alice.ID); err != nil {
+		t.Fatalf("Failed to set avatar: %v", err)
+	}
+
+	if err := svc.DeleteAccount(context.Background(), alice.ID); err != nil {
+		t.Fatalf("DeleteAccount failed: %v", err)
+	}
+
+	if blobs, _ := filepath.Glob(filepath.Join(dir, "blobs", "*", "*")); len(blobs) != 0 {
+		t.Errorf("Expected the attachment blob to be removed, got %v", blobs)
+	}
+	var remaining int
+	if err := db.QueryRow("SELECT COUNT(*) FROM attachment_blobs").Scan(&remaining); err != nil {
+		t.Fatalf("Failed to count blobs: %v", err)
+	}
+	if remaining != 0 {
+		t.Errorf("Expected no blob rows left, got %d", remaining)
+	}
+	if _, err := os.Stat(avatar); !os.IsNotExist(err) {
+		t.Errorf("Expected the avatar to be removed, got %v", err)
+	}
+}
+
+func TestMoveLegacyAvatars(t *testing.T) {
+	legacy := t.TempDir()
+	avatars := filepath.Join(legacy, "avatars")
+	t.Setenv("AVATARS_DIR", avatars)
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+
+	name := alice.ID.String() + ".jpg"
+	for path, content := range map[string]string{
+		filepath.Join(legacy, name):        "avatar",
+		filepath.Join(legacy, "stray.txt"): "not an avatar",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+	if _, err := db.Exec("UPDATE users SET avatar_url = $1 WHERE id = $2", "/uploads/"+name, alice.ID); err != nil {
+		t.Fatalf("Failed to set avatar: %v", err)
+	}
+
+	moved, err := svc.MoveLegacyAvatars(context.Background(), legacy)
+	if err != nil || moved != 1 {
+		t.Fatalf("Expected 1 avatar to be moved, got %d (%v)", moved, err)
+	}
+	if content, err := os.ReadFile(filepath.Join(avatars, name)); err != nil || string(content) != "avatar" {
+		t.Errorf("Expected the avatar in AVATARS_DIR, got %q (%v)", content, err)
+	}
+	if _, err := os.Stat(filepath.Join(legacy, "stray.txt")); err != nil {
+		t.Errorf("Expected files no avatar_url names to stay, got %v", err)
+	}
+
+	// Running it again on the next boot moves nothing
+	if moved, err := svc.MoveLegacyAvatars(context.Background(), legacy); err != nil || moved != 0 {
+		t.Errorf("Expected nothing left to move, got %d (%v)", moved, err)
+	}
+}
+
+func TestFailedDeleteAccountKeepsFiles(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("ATTACHMENTS_DIR", dir)
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+
+	message, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         alice.ID,
+		RecipientID:      &bob.ID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "file",
+	})
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	content := []byte("encrypted-file-content")
+	sum := sha256.Sum256(content)
+	upload := filepath.Join(t.TempDir(), "upload")
+	if err := os.WriteFile(upload, content, 0644); err != nil {
+		t.Fatalf("Failed to write upload: %v", err)
+	}
+	err = svc.AddAttachment(context.Background(), models.Attachment{
+		MessageID:    message.ID,
+		FileName:     "photo.jpg",
+		FileSize:     int64(len(content)),
+		MimeType:     "image/jpeg",
+		EncryptedKey: "encrypted-key",
+		ContentHash:  hex.EncodeToString(sum[:]),
+	}, upload)
+	if err != nil {
+		t.Fatalf("AddAttachment failed: %v", err)
+	}
+
+	// Make the user's deletion fail after their attachments were released
+	_, err = db.Exec(`
+		CREATE OR REPLACE FUNCTION test_refuse_user_delete() RETURNS trigger AS $$
+		BEGIN RAISE EXCEPTION 'refused'; END
+		$$ LANGUAGE plpgsql;
+		CREATE TRIGGER test_refuse_user_delete BEFORE DELETE ON users
+			FOR EACH ROW EXECUTE FUNCTION test_refuse_user_delete();
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create trigger: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Exec("DROP TRIGGER IF EXISTS test_refuse_user_delete ON users")
+		db.Exec("DROP FUNCTION IF EXISTS test_refuse_user_delete()")
+	})
+
+	if err := svc.DeleteAccount(context.Background(), alice.ID); err == nil {
+		t.Fatal("Expected DeleteAccount to fail")
+	}
+
+	var storagePath string
+	if err := db.QueryRow("SELECT storage_path FROM attachments WHERE message_id = $1", message.ID).Scan(&storagePath); err != nil {
+		t.Fatalf("Expected the attachment to survive the rollback: %v", err)
+	}
+	if _, err := os.Stat(storagePath); err != nil {
+		t.Errorf("Expected the attachment file to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Group audit log actions
+const (
+	auditOwnershipTransferred = "ownership_transferred"
+)
+
+// recordGroupAudit appends an entry to the group's audit log within tx, so the
+// entry exists if and only if the change does
+func recordGroupAudit(ctx context.Context, tx *sql.Tx, groupID, actorID uuid.UUID, action string, targetID uuid.UUID) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO group_audit_log (group_id, actor_id, action, target_id, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, groupID, actorID, action, targetID, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record audit log entry: %w", err)
+	}
+	return nil
+}
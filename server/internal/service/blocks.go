@@ -0,0 +1,44 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"e2ee-messenger/server/internal/apperrors"
+
+	"github.com/google/uuid"
+)
+
+// BlockUser stops real-time deliveries from blockedID to blockerID. Blocking
+// the same user twice is a no-op.
+func (s *Service) BlockUser(ctx context.Context, blockerID, blockedID uuid.UUID) error {
+	if blockerID == blockedID {
+		return fmt.Errorf("you cannot block yourself: %w", apperrors.ErrInvalidInput)
+	}
+
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", blockedID).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to look up user: %w", err)
+	}
+	if !exists {
+		return apperrors.ErrUserNotFound
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO blocks (blocker_id, blocked_id) VALUES ($1, $2)
+		ON CONFLICT (blocker_id, blocked_id) DO NOTHING
+	`, blockerID, blockedID)
+	if err != nil {
+		return fmt.Errorf("failed to block user: %w", err)
+	}
+	return nil
+}
+
+// UnblockUser lifts a block. Unblocking a user who is not blocked is a no-op.
+func (s *Service) UnblockUser(ctx context.Context, blockerID, blockedID uuid.UUID) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM blocks WHERE blocker_id = $1 AND blocked_id = $2", blockerID, blockedID)
+	if err != nil {
+		return fmt.Errorf("failed to unblock user: %w", err)
+	}
+	return nil
+}
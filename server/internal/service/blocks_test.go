@@ -0,0 +1,57 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"e2ee-messenger/server/internal/service"
+	"e2ee-messenger/server/internal/testutil"
+)
+
+func TestGroupFanOutSkipsMembersWhoBlockedSender(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	carol := testutil.CreateUser(t, db, "carol")
+	group := createGroup(t, svc, alice, bob, carol)
+
+	if err := svc.BlockUser(context.Background(), bob.ID, alice.ID); err != nil {
+		t.Fatalf("BlockUser failed: %v", err)
+	}
+	bobClient := testutil.ConnectClient(t, hub, bob.ID)
+	carolClient := testutil.ConnectClient(t, hub, carol.ID)
+
+	message, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         alice.ID,
+		GroupID:          &group.ID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "text",
+	})
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	testutil.ExpectEvent(t, carolClient, "new_message")
+	testutil.ExpectNoEvent(t, bobClient)
+
+	var stored bool
+	if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM messages WHERE id = $1)", message.ID).Scan(&stored); err != nil {
+		t.Fatalf("Failed to look up message: %v", err)
+	}
+	if !stored {
+		t.Error("Expected the message to be stored despite the block")
+	}
+
+	if err := svc.UnblockUser(context.Background(), bob.ID, alice.ID); err != nil {
+		t.Fatalf("UnblockUser failed: %v", err)
+	}
+	if _, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         alice.ID,
+		GroupID:          &group.ID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "text",
+	}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	testutil.ExpectEvent(t, bobClient, "new_message")
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/database"
+	"e2ee-messenger/server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// Encryption schemes are opaque to the server: it only stores the name the
+// participants agreed on and holds every tagged message to it, so both sides
+// of a conversation always decrypt with the scheme it was encrypted with.
+
+// validScheme matches encryption scheme names such as "olm-v1" or "mls:1.0"
+var validScheme = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// cryptoKey identifies a conversation in conversation_crypto and
+// conversation_ttls: its group, or the two users of a direct conversation in
+// ascending order
+type cryptoKey struct {
+	groupID      *uuid.UUID
+	userA, userB uuid.UUID
+}
+
+// conversationKey returns the key of the conversation the user sees as
+// conversationID, which is a group or, for a "dm", the other user
+func conversationKey(userID, conversationID uuid.UUID, convType string) cryptoKey {
+	if convType == "group" {
+		return cryptoKey{groupID: &conversationID}
+	}
+	if userID.String() < conversationID.String() {
+		return cryptoKey{userA: userID, userB: conversationID}
+	}
+	return cryptoKey{userA: conversationID, userB: userID}
+}
+
+// messageKey returns the key of the conversation a message is sent to
+func messageKey(message models.Message) cryptoKey {
+	if message.GroupID != nil {
+		return conversationKey(message.SenderID, *message.GroupID, "group")
+	}
+	return conversationKey(message.SenderID, *message.RecipientID, "dm")
+}
+
+// conversationScheme returns the conversation's encryption scheme, or "" when
+// none has been negotiated
+func (s *Service) conversationScheme(ctx context.Context, key cryptoKey) (string, error) {
+	var scheme string
+	var err error
+	if key.groupID != nil {
+		err = s.db.QueryRowContext(ctx, "SELECT scheme FROM conversation_crypto WHERE group_id = $1", *key.groupID).Scan(&scheme)
+	} else {
+		err = s.db.QueryRowContext(ctx, `
+			SELECT scheme FROM conversation_crypto WHERE group_id IS NULL AND user_a = $1 AND user_b = $2
+		`, key.userA, key.userB).Scan(&scheme)
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("failed to fetch encryption scheme: %w", err)
+	}
+	return scheme, nil
+}
+
+// agreeSchemeTx settles the conversation on scheme if it has none yet, and
+// otherwise rejects a scheme other than the one it uses with
+// apperrors.ErrConflict
+func (s *Service) agreeSchemeTx(ctx context.Context, tx *sql.Tx, key cryptoKey, scheme string, userID uuid.UUID) error {
+	// A concurrent first message makes the insert wait for it and do nothing;
+	// the separate select then sees the scheme it settled on
+	var current string
+	var err error
+	if key.groupID != nil {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO conversation_crypto (group_id, scheme, negotiated_by) VALUES ($1, $2, $3)
+			ON CONFLICT (group_id) WHERE group_id IS NOT NULL DO NOTHING
+		`, *key.groupID, scheme, userID)
+		if err == nil {
+			err = tx.QueryRowContext(ctx, "SELECT scheme FROM conversation_crypto WHERE group_id = $1", *key.groupID).Scan(&current)
+		}
+	} else {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO conversation_crypto (user_a, user_b, scheme, negotiated_by) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_a, user_b) WHERE group_id IS NULL DO NOTHING
+		`, key.userA, key.userB, scheme, userID)
+		if err == nil {
+			err = tx.QueryRowContext(ctx, `
+				SELECT scheme FROM conversation_crypto WHERE group_id IS NULL AND user_a = $1 AND user_b = $2
+			`, key.userA, key.userB).Scan(&current)
+		}
+	}
+	if err != nil {
+		// The first message to someone who does not exist settles nothing
+		switch constraint, _ := database.ForeignKeyViolation(err); constraint {
+		case "conversation_crypto_user_a_fkey", "conversation_crypto_user_b_fkey":
+			return apperrors.ErrRecipientNotFound
+		case "conversation_crypto_group_id_fkey":
+			return apperrors.ErrGroupNotFound
+		}
+		return fmt.Errorf("failed to negotiate encryption scheme: %w", err)
+	}
+	if current != scheme {
+		return fmt.Errorf("this conversation uses the %q encryption scheme: %w", current, apperrors.ErrConflict)
+	}
+	return nil
+}
+
+// SetEncryptionScheme sets the scheme of a conversation the user is part of,
+// replacing any scheme negotiated before. Messages tagged with another scheme
+// are rejected from then on.
+func (s *Service) SetEncryptionScheme(ctx context.Context, userID, conversationID uuid.UUID, scheme string) (*models.ConversationSettings, error) {
+	if !validScheme.MatchString(scheme) {
+		return nil, fmt.Errorf("scheme must be 1 to 64 letters, digits or . _ : -: %w", apperrors.ErrInvalidInput)
+	}
+	convType, err := s.resolveConversation(ctx, userID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	key := conversationKey(userID, conversationID, convType)
+	if key.groupID != nil {
+		_, err = s.db.ExecContext(ctx, `
+			INSERT INTO conversation_crypto (group_id, scheme, negotiated_by) VALUES ($1, $2, $3)
+			ON CONFLICT (group_id) WHERE group_id IS NOT NULL
+			DO UPDATE SET scheme = EXCLUDED.scheme, negotiated_by = EXCLUDED.negotiated_by, negotiated_at = NOW()
+		`, *key.groupID, scheme, userID)
+	} else {
+		_, err = s.db.ExecContext(ctx, `
+			INSERT INTO conversation_crypto (user_a, user_b, scheme, negotiated_by) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_a, user_b) WHERE group_id IS NULL
+			DO UPDATE SET scheme = EXCLUDED.scheme, negotiated_by = EXCLUDED.negotiated_by, negotiated_at = NOW()
+		`, key.userA, key.userB, scheme, userID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to set encryption scheme: %w", err)
+	}
+
+	return s.ConversationSettings(ctx, userID, conversationID)
+}
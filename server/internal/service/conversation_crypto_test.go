@@ -0,0 +1,118 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/service"
+	"e2ee-messenger/server/internal/testutil"
+
+	"github.com/google/uuid"
+)
+
+func TestFirstTaggedMessageSettlesTheScheme(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+
+	send := func(sender, recipient models.User, scheme string) error {
+		t.Helper()
+		_, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+			SenderID:         sender.ID,
+			RecipientID:      &recipient.ID,
+			EncryptedContent: "ciphertext",
+			MessageType:      "text",
+			EncryptionScheme: scheme,
+		})
+		return err
+	}
+
+	if err := send(alice, bob, "ratchet-v2"); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	// Both sides see the same scheme, whichever of them asks
+	for _, viewer := range []struct{ user, other models.User }{{alice, bob}, {bob, alice}} {
+		settings, err := svc.ConversationSettings(context.Background(), viewer.user.ID, viewer.other.ID)
+		if err != nil {
+			t.Fatalf("ConversationSettings failed: %v", err)
+		}
+		if settings.EncryptionScheme != "ratchet-v2" {
+			t.Errorf("Expected scheme ratchet-v2 for %s, got %q", viewer.user.Username, settings.EncryptionScheme)
+		}
+	}
+
+	if err := send(bob, alice, "ratchet-v2"); err != nil {
+		t.Errorf("Expected a reply with the same scheme to be accepted, got %v", err)
+	}
+	if err := send(bob, alice, "legacy"); !errors.Is(err, apperrors.ErrConflict) {
+		t.Errorf("Expected a mismatched scheme to be rejected with ErrConflict, got %v", err)
+	}
+	if err := send(bob, alice, ""); err != nil {
+		t.Errorf("Expected an untagged message to be accepted, got %v", err)
+	}
+	if err := send(alice, bob, "not a scheme!"); !errors.Is(err, apperrors.ErrInvalidInput) {
+		t.Errorf("Expected an invalid scheme name to be rejected, got %v", err)
+	}
+}
+
+func TestSetEncryptionSchemeSwitchesTheConversation(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	carol := testutil.CreateUser(t, db, "carol")
+	group := createGroup(t, svc, alice, bob)
+
+	sendToGroup := func(sender models.User, scheme string) error {
+		t.Helper()
+		_, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+			SenderID:         sender.ID,
+			GroupID:          &group.ID,
+			EncryptedContent: "ciphertext",
+			MessageType:      "text",
+			EncryptionScheme: scheme,
+		})
+		return err
+	}
+
+	if err := sendToGroup(alice, "legacy"); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	settings, err := svc.SetEncryptionScheme(context.Background(), bob.ID, group.ID, "mls-1")
+	if err != nil {
+		t.Fatalf("SetEncryptionScheme failed: %v", err)
+	}
+	if settings.EncryptionScheme != "mls-1" {
+		t.Errorf("Expected scheme mls-1, got %q", settings.EncryptionScheme)
+	}
+	if err := sendToGroup(alice, "legacy"); !errors.Is(err, apperrors.ErrConflict) {
+		t.Errorf("Expected the old scheme to be rejected after the switch, got %v", err)
+	}
+	if err := sendToGroup(alice, "mls-1"); err != nil {
+		t.Errorf("Expected the negotiated scheme to be accepted, got %v", err)
+	}
+
+	if _, err := svc.SetEncryptionScheme(context.Background(), carol.ID, group.ID, "legacy"); !errors.Is(err, apperrors.ErrNotGroupMember) {
+		t.Errorf("Expected a non-member to be rejected with ErrNotGroupMember, got %v", err)
+	}
+}
+
+func TestTaggedMessageToMissingRecipient(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	missing := uuid.New()
+
+	_, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         alice.ID,
+		RecipientID:      &missing,
+		EncryptedContent: "ciphertext",
+		MessageType:      "text",
+		EncryptionScheme: "ratchet-v2",
+	})
+	if !errors.Is(err, apperrors.ErrRecipientNotFound) {
+		t.Errorf("Expected ErrRecipientNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,318 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// resolveConversation works out whether conversationID is a group the user
+// belongs to or another user they can message directly, returning "group" or "dm"
+func (s *Service) resolveConversation(ctx context.Context, userID, conversationID uuid.UUID) (string, error) {
+	var isGroup bool
+	if err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM groups WHERE id = $1)", conversationID).Scan(&isGroup); err != nil {
+		return "", fmt.Errorf("failed to look up group: %w", err)
+	}
+	if isGroup {
+		if err := s.RequireGroupMember(ctx, conversationID, userID); err != nil {
+			return "", err
+		}
+		return "group", nil
+	}
+
+	if conversationID == userID {
+		return "", fmt.Errorf("conversation not found: %w", apperrors.ErrNotFound)
+	}
+	var isUser bool
+	if err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", conversationID).Scan(&isUser); err != nil {
+		return "", fmt.Errorf("failed to look up user: %w", err)
+	}
+	if !isUser {
+		return "", fmt.Errorf("conversation not found: %w", apperrors.ErrNotFound)
+	}
+	return "dm", nil
+}
+
+// ConversationSettings gathers the user's effective settings for a conversation
+// from the group membership and conversation settings tables
+func (s *Service) ConversationSettings(ctx context.Context, userID, conversationID uuid.UUID) (*models.ConversationSettings, error) {
+	convType, err := s.resolveConversation(ctx, userID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := models.ConversationSettings{
+		ConversationID:    conversationID,
+		Type:              convType,
+		NotificationLevel: "all",
+	}
+
+	if convType == "group" {
+		err := s.db.QueryRowContext(ctx, `
+			SELECT notification_level FROM group_members WHERE group_id = $1 AND user_id = $2
+		`, conversationID, userID).Scan(&settings.NotificationLevel)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch notification level: %w", err)
+		}
+	}
+
+	var mutedUntil sql.NullTime
+	err = s.db.QueryRowContext(ctx, `
+		SELECT muted_until FROM conversation_settings
+		WHERE user_id = $1 AND conversation_id = $2
+	`, userID, conversationID).Scan(&mutedUntil)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to fetch conversation settings: %w", err)
+	}
+
+	key := conversationKey(userID, conversationID, convType)
+	ttl, err := conversationTTL(ctx, s.db, key)
+	if err != nil {
+		return nil, err
+	}
+	settings.MessageTTLSeconds = int(ttl / time.Second)
+
+	err = s.db.QueryRowContext(ctx, `
+		SELECT EXISTS(SELECT 1 FROM pinned_conversations WHERE user_id = $1 AND conversation_id = $2)
+	`, userID, conversationID).Scan(&settings.IsPinned)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch pin: %w", err)
+	}
+
+	settings.EncryptionScheme, err = s.conversationScheme(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if mutedUntil.Valid && mutedUntil.Time.After(time.Now()) {
+		settings.MutedUntil = &mutedUntil.Time
+		settings.Muted = true
+	}
+	if settings.NotificationLevel == "none" {
+		settings.Muted = true
+	}
+
+	return &settings, nil
+}
+
+// UpdateConversationSettings changes the user's mute setting and the
+// conversation's disappearing message timer. The mute setting is the
+// user's own, while the timer applies to every participant's messages sent
+// from now on, and changing it sends them all a "message_ttl_changed"
+// event. Nil fields are left unchanged.
+func (s *Service) UpdateConversationSettings(ctx context.Context, userID, conversationID uuid.UUID, req models.UpdateConversationSettingsRequest) (*models.ConversationSettings, error) {
+	if req.MessageTTLSeconds != nil && *req.MessageTTLSeconds < 0 {
+		return nil, fmt.Errorf("message_ttl_seconds must not be negative: %w", apperrors.ErrInvalidInput)
+	}
+	if req.MessageTTLSeconds != nil && time.Duration(*req.MessageTTLSeconds)*time.Second > maxMessageTTL {
+		return nil, fmt.Errorf("message_ttl_seconds must be at most %d: %w", int(maxMessageTTL/time.Second), apperrors.ErrInvalidInput)
+	}
+	convType, err := s.resolveConversation(ctx, userID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if req.MutedUntil != nil {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO conversation_settings (user_id, conversation_id, muted_until, updated_at)
+			VALUES ($1, $2, $3, NOW())
+			ON CONFLICT (user_id, conversation_id) DO UPDATE SET
+				muted_until = EXCLUDED.muted_until,
+				updated_at = NOW()
+		`, userID, conversationID, *req.MutedUntil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update conversation settings: %w", err)
+		}
+	}
+	ttlChanged := false
+	if req.MessageTTLSeconds != nil {
+		key := conversationKey(userID, conversationID, convType)
+		ttl := time.Duration(*req.MessageTTLSeconds) * time.Second
+		previous, err := conversationTTL(ctx, tx, key)
+		if err != nil {
+			return nil, err
+		}
+		if err := setConversationTTL(ctx, tx, key, ttl, userID); err != nil {
+			return nil, err
+		}
+		ttlChanged = ttl != previous
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit conversation settings: %w", err)
+	}
+	if ttlChanged {
+		s.notifyMessageTTLChanged(ctx, userID, conversationID, convType, time.Duration(*req.MessageTTLSeconds)*time.Second)
+	}
+	return s.ConversationSettings(ctx, userID, conversationID)
+}
+
+// PinConversation keeps a conversation at the top of the user's chat list,
+// above the conversations pinned before it. Pinning an already pinned
+// conversation keeps its place. Users can pin up to MaxPinnedConversations.
+func (s *Service) PinConversation(ctx context.Context, userID, conversationID uuid.UUID) error {
+	convType, err := s.resolveConversation(ctx, userID, conversationID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Serialize the user's pins so concurrent requests cannot exceed the limit
+	if _, err := tx.ExecContext(ctx, "SELECT 1 FROM users WHERE id = $1 FOR UPDATE", userID); err != nil {
+		return fmt.Errorf("failed to lock user: %w", err)
+	}
+	var alreadyPinned bool
+	var count, top int
+	err = tx.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(MIN(position), 0), COALESCE(BOOL_OR(conversation_id = $2), false)
+		FROM pinned_conversations WHERE user_id = $1
+	`, userID, conversationID).Scan(&count, &top, &alreadyPinned)
+	if err != nil {
+		return fmt.Errorf("failed to count pinned conversations: %w", err)
+	}
+	if alreadyPinned {
+		return nil
+	}
+	if count >= s.cfg.MaxPinnedConversations {
+		return apperrors.ErrPinLimitReached
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO pinned_conversations (user_id, conversation_type, conversation_id, pinned_at, position)
+		VALUES ($1, $2, $3, NOW(), $4)
+	`, userID, convType, conversationID, top-1)
+	if err != nil {
+		return fmt.Errorf("failed to pin conversation: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit pin: %w", err)
+	}
+	return nil
+}
+
+// ReorderPinnedConversations puts the user's pinned conversations in the
+// order of conversationIDs, top first. Pinned conversations that are not
+// listed keep their relative order below the listed ones. Every listed
+// conversation must be pinned.
+func (s *Service) ReorderPinnedConversations(ctx context.Context, userID uuid.UUID, conversationIDs []uuid.UUID) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT conversation_id FROM pinned_conversations WHERE user_id = $1
+		ORDER BY position, pinned_at DESC
+		FOR UPDATE
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch pinned conversations: %w", err)
+	}
+	var current []uuid.UUID
+	for rows.Next() {
+		var conversationID uuid.UUID
+		if err := rows.Scan(&conversationID); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan pinned conversation: %w", err)
+		}
+		current = append(current, conversationID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to fetch pinned conversations: %w", err)
+	}
+
+	pinned := make(map[uuid.UUID]bool, len(current))
+	for _, conversationID := range current {
+		pinned[conversationID] = true
+	}
+	listed := make(map[uuid.UUID]bool, len(conversationIDs))
+	for _, conversationID := range conversationIDs {
+		if !pinned[conversationID] {
+			return fmt.Errorf("conversation %s is not pinned: %w", conversationID, apperrors.ErrInvalidInput)
+		}
+		if listed[conversationID] {
+			return fmt.Errorf("conversation %s is listed twice: %w", conversationID, apperrors.ErrInvalidInput)
+		}
+		listed[conversationID] = true
+	}
+	order := append([]uuid.UUID{}, conversationIDs...)
+	for _, conversationID := range current {
+		if !listed[conversationID] {
+			order = append(order, conversationID)
+		}
+	}
+
+	for position, conversationID := range order {
+		_, err := tx.ExecContext(ctx, `
+			UPDATE pinned_conversations SET position = $1 WHERE user_id = $2 AND conversation_id = $3
+		`, position, userID, conversationID)
+		if err != nil {
+			return fmt.Errorf("failed to reorder pinned conversations: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit pin order: %w", err)
+	}
+	return nil
+}
+
+// ArchiveConversation hides a conversation from the user's chat list without
+// deleting its history. The conversation comes back as soon as a newer
+// message arrives. Archiving again moves the archive time forward.
+func (s *Service) ArchiveConversation(ctx context.Context, userID, conversationID uuid.UUID) error {
+	convType, err := s.resolveConversation(ctx, userID, conversationID)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO archived_conversations (user_id, conversation_type, conversation_id, archived_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (user_id, conversation_id) DO UPDATE SET archived_at = EXCLUDED.archived_at
+	`, userID, convType, conversationID)
+	if err != nil {
+		return fmt.Errorf("failed to archive conversation: %w", err)
+	}
+	return nil
+}
+
+// UnarchiveConversation returns a conversation to the user's chat list.
+// Unarchiving a conversation that is not archived is a no-op.
+func (s *Service) UnarchiveConversation(ctx context.Context, userID, conversationID uuid.UUID) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM archived_conversations WHERE user_id = $1 AND conversation_id = $2", userID, conversationID)
+	if err != nil {
+		return fmt.Errorf("failed to unarchive conversation: %w", err)
+	}
+	return nil
+}
+
+// UnpinConversation returns a conversation to its place by recency.
+// Unpinning a conversation that is not pinned is a no-op.
+func (s *Service) UnpinConversation(ctx context.Context, userID, conversationID uuid.UUID) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM pinned_conversations WHERE user_id = $1 AND conversation_id = $2", userID, conversationID)
+	if err != nil {
+		return fmt.Errorf("failed to unpin conversation: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/testutil"
+
+	"github.com/google/uuid"
+)
+
+func TestConversationSettingsAggregatesMuteAndTTL(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	group := createGroup(t, svc, alice, bob)
+
+	// Defaults before anything is configured
+	settings, err := svc.ConversationSettings(context.Background(), alice.ID, bob.ID)
+	if err != nil {
+		t.Fatalf("ConversationSettings failed: %v", err)
+	}
+	if settings.Type != "dm" || settings.Muted || settings.MessageTTLSeconds != 0 || settings.NotificationLevel != "all" {
+		t.Errorf("Unexpected default DM settings: %+v", settings)
+	}
+
+	mutedUntil := time.Now().Add(time.Hour)
+	ttl := 3600
+	if _, err := svc.UpdateConversationSettings(context.Background(), alice.ID, bob.ID, models.UpdateConversationSettingsRequest{
+		MutedUntil:        &mutedUntil,
+		MessageTTLSeconds: &ttl,
+	}); err != nil {
+		t.Fatalf("UpdateConversationSettings failed: %v", err)
+	}
+
+	settings, err = svc.ConversationSettings(context.Background(), alice.ID, bob.ID)
+	if err != nil {
+		t.Fatalf("ConversationSettings failed: %v", err)
+	}
+	if !settings.Muted || settings.MutedUntil == nil || !settings.MutedUntil.Equal(mutedUntil.Truncate(time.Microsecond)) {
+		t.Errorf("Expected the DM to be muted until %v, got %+v", mutedUntil, settings)
+	}
+	if settings.MessageTTLSeconds != ttl {
+		t.Errorf("Expected TTL %d, got %d", ttl, settings.MessageTTLSeconds)
+	}
+
+	// Updating the TTL alone leaves the mute in place
+	ttl = 60
+	settings, err = svc.UpdateConversationSettings(context.Background(), alice.ID, bob.ID, models.UpdateConversationSettingsRequest{MessageTTLSeconds: &ttl})
+	if err != nil {
+		t.Fatalf("UpdateConversationSettings failed: %v", err)
+	}
+	if !settings.Muted || settings.MessageTTLSeconds != 60 {
+		t.Errorf("Expected muted DM with a 60s TTL, got %+v", settings)
+	}
+
+	// A group silenced through its notification level counts as muted
+	if err := svc.SetNotificationLevel(context.Background(), group.ID, bob.ID, "none"); err != nil {
+		t.Fatalf("SetNotificationLevel failed: %v", err)
+	}
+	settings, err = svc.ConversationSettings(context.Background(), bob.ID, group.ID)
+	if err != nil {
+		t.Fatalf("ConversationSettings failed: %v", err)
+	}
+	if settings.Type != "group" || !settings.Muted || settings.NotificationLevel != "none" {
+		t.Errorf("Expected a muted group, got %+v", settings)
+	}
+}
+
+func TestConversationSettingsRequireParticipation(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	mallory := testutil.CreateUser(t, db, "mallory")
+	group := createGroup(t, svc, alice)
+
+	_, err := svc.ConversationSettings(context.Background(), mallory.ID, group.ID)
+	if !errors.Is(err, apperrors.ErrNotGroupMember) {
+		t.Errorf("Expected ErrNotGroupMember, got %v", err)
+	}
+}
+
+func TestPinConversationEnforcesLimit(t *testing.T) {
+	t.Setenv("PINNED_CONVERSATIONS_MAX", "2")
+	svc, db, _ := setupService(t)
+	ctx := context.Background()
+	alice := testutil.CreateUser(t, db, "alice")
+
+	var peers []uuid.UUID
+	for _, name := range []string{"bob", "carol", "dave"} {
+		peers = append(peers, testutil.CreateUser(t, db, name).ID)
+	}
+	for _, peer := range peers[:2] {
+		if err := svc.PinConversation(ctx, alice.ID, peer); err != nil {
+			t.Fatalf("PinConversation failed: %v", err)
+		}
+	}
+
+	if err := svc.PinConversation(ctx, alice.ID, peers[2]); !errors.Is(err, apperrors.ErrPinLimitReached) {
+		t.Fatalf("Expected ErrPinLimitReached, got %v", err)
+	}
+	// Pinning an already pinned conversation is not a new pin
+	if err := svc.PinConversation(ctx, alice.ID, peers[0]); err != nil {
+		t.Errorf("Expected re-pinning to succeed at the limit, got %v", err)
+	}
+	if err := svc.UnpinConversation(ctx, alice.ID, peers[0]); err != nil {
+		t.Fatalf("UnpinConversation failed: %v", err)
+	}
+	if err := svc.PinConversation(ctx, alice.ID, peers[2]); err != nil {
+		t.Errorf("Expected a pin to succeed after unpinning, got %v", err)
+	}
+}
+
+func TestReorderPinnedConversationsRejectsUnpinned(t *testing.T) {
+	svc, db, _ := setupService(t)
+	ctx := context.Background()
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+
+	err := svc.ReorderPinnedConversations(ctx, alice.ID, []uuid.UUID{bob.ID})
+	if !errors.Is(err, apperrors.ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput, got %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"e2ee-messenger/server/internal/websocket"
+
+	"github.com/google/uuid"
+)
+
+// recordDeadLetterOnHub is called by the hub for every event it gives up on
+func (s *Service) recordDeadLetterOnHub(letter websocket.DeadLetter) {
+	if err := s.RecordDeadLetter(context.Background(), letter); err != nil {
+		log.Printf("Failed to record dead letter %s for user %s: %v", letter.EventID, letter.UserID, err)
+	}
+}
+
+// RecordDeadLetter stores an undeliverable event for diagnostics. A group
+// message it carried is marked as not pushed to the user, so the offline
+// replay sends it again when they next connect; direct messages stay
+// unacknowledged and are fetched by the client's resync.
+func (s *Service) RecordDeadLetter(ctx context.Context, letter websocket.DeadLetter) error {
+	userID, err := uuid.Parse(letter.UserID)
+	if err != nil {
+		return fmt.Errorf("invalid user ID %q: %w", letter.UserID, err)
+	}
+	var messageID uuid.NullUUID
+	if id, err := uuid.Parse(letter.MessageID); err == nil {
+		messageID = uuid.NullUUID{UUID: id, Valid: true}
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO dead_letters (user_id, event_id, event_type, message_id, reason)
+		VALUES ($1, $2, $3, $4, $5)
+	`, userID, letter.EventID, letter.EventType, messageID, letter.Reason)
+	if err != nil {
+		return fmt.Errorf("failed to record dead letter: %w", err)
+	}
+
+	if messageID.Valid {
+		_, err := s.db.ExecContext(ctx, `
+			UPDATE message_deliveries SET pushed_at = NULL WHERE message_id = $1 AND user_id = $2
+		`, messageID, userID)
+		if err != nil {
+			return fmt.Errorf("failed to queue message for replay: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"e2ee-messenger/server/internal/service"
+	"e2ee-messenger/server/internal/testutil"
+	"e2ee-messenger/server/internal/websocket"
+)
+
+func TestDeadLetteredGroupMessageIsReplayed(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	group := createGroup(t, svc, alice, bob)
+	bobClient := testutil.ConnectClient(t, hub, bob.ID)
+
+	message, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         alice.ID,
+		GroupID:          &group.ID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "text",
+	})
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	event := testutil.ExpectEvent(t, bobClient, "new_message")
+
+	// The hub gives up on the event, say because bob never acked it
+	err = svc.RecordDeadLetter(context.Background(), websocket.DeadLetter{
+		UserID:    bob.ID.String(),
+		EventID:   event.ID,
+		EventType: event.Type,
+		MessageID: message.ID.String(),
+		Reason:    websocket.DeadLetterAckTimeout,
+	})
+	if err != nil {
+		t.Fatalf("RecordDeadLetter failed: %v", err)
+	}
+
+	var reason string
+	if err := db.QueryRow("SELECT reason FROM dead_letters WHERE user_id = $1 AND message_id = $2", bob.ID, message.ID).Scan(&reason); err != nil {
+		t.Fatalf("Expected a dead-letter record: %v", err)
+	}
+	if reason != websocket.DeadLetterAckTimeout {
+		t.Errorf("Expected reason %s, got %s", websocket.DeadLetterAckTimeout, reason)
+	}
+
+	// The message goes out again through the offline replay
+	if err := svc.ReplayMissedMessages(context.Background(), bob.ID); err != nil {
+		t.Fatalf("ReplayMissedMessages failed: %v", err)
+	}
+	testutil.ExpectEvent(t, bobClient, "new_message")
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/websocket"
+
+	"github.com/google/uuid"
+)
+
+// maxBulkDeletes caps how many messages can be deleted in one call
+const maxBulkDeletes = 500
+
+// DeleteMessages soft-deletes messages the user sent. Messages sent by
+// someone else are rejected with apperrors.ErrForbidden unless
+// skipUnauthorized is set, in which case they are left alone; messages that
+// do not exist or were already deleted are skipped either way. Every
+// participant of the affected conversations gets a single "messages_deleted"
+// event listing the messages they could see. It returns the IDs of the
+// deleted messages.
+func (s *Service) DeleteMessages(ctx context.Context, userID uuid.UUID, messageIDs []uuid.UUID, skipUnauthorized bool) ([]uuid.UUID, error) {
+	if len(messageIDs) == 0 || len(messageIDs) > maxBulkDeletes {
+		return nil, fmt.Errorf("between 1 and %d message_ids are required: %w", maxBulkDeletes, apperrors.ErrInvalidInput)
+	}
+
+	deleted, err := s.softDeleteMessages(ctx, userID, messageIDs, skipUnauthorized)
+	if err != nil || len(deleted) == 0 {
+		return deleted, err
+	}
+
+	audiences, err := s.messageAudiences(ctx, deleted)
+	if err != nil {
+		log.Printf("Failed to get audience for deleted messages: %v", err)
+		return deleted, nil
+	}
+	for audienceID, visible := range audiences {
+		s.hub.SendToUser(audienceID.String(), websocket.MessagesDeletedEvent(userID, visible))
+	}
+	return deleted, nil
+}
+
+// DeleteMessage unsends one of the user's messages, leaving a tombstone in
+// its place. Everyone who could see it gets a "message_deleted" event.
+func (s *Service) DeleteMessage(ctx context.Context, userID, messageID uuid.UUID) error {
+	deleted, err := s.softDeleteMessages(ctx, userID, []uuid.UUID{messageID}, false)
+	if err != nil {
+		return err
+	}
+	if len(deleted) == 0 {
+		return apperrors.ErrMessageNotFound
+	}
+
+	audiences, err := s.messageAudiences(ctx, deleted)
+	if err != nil {
+		log.Printf("Failed to get audience for deleted message %s: %v", messageID, err)
+		return nil
+	}
+	for audienceID := range audiences {
+		s.hub.SendToUser(audienceID.String(), websocket.MessageDeletedEvent(messageID))
+	}
+	return nil
+}
+
+// softDeleteMessages turns messages the user sent into tombstones: their
+// content, edit history and reactions are wiped, deleted_at is set and their
+// attachments are removed, all in one transaction. The row is kept so
+// receipts and ordering stay intact. Messages sent by someone else fail the
+// whole call with apperrors.ErrForbidden unless skipUnauthorized is set.
+func (s *Service) softDeleteMessages(ctx context.Context, userID uuid.UUID, messageIDs []uuid.UUID, skipUnauthorized bool) ([]uuid.UUID, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, sender_id FROM messages
+		WHERE id = ANY($1::uuid[]) AND deleted_at IS NULL AND message_type != 'system'
+		FOR UPDATE
+	`, uuidArray(messageIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch messages: %w", err)
+	}
+	deleted := []uuid.UUID{}
+	for rows.Next() {
+		var messageID, senderID uuid.UUID
+		if err := rows.Scan(&messageID, &senderID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		if senderID != userID {
+			if skipUnauthorized {
+				continue
+			}
+			rows.Close()
+			return nil, fmt.Errorf("message %s was sent by someone else: %w", messageID, apperrors.ErrForbidden)
+		}
+		deleted = append(deleted, messageID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch messages: %w", err)
+	}
+	if len(deleted) == 0 {
+		return deleted, nil
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE messages SET encrypted_content = '', deleted_at = NOW()
+		WHERE id = ANY($1::uuid[])
+	`, uuidArray(deleted))
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete messages: %w", err)
+	}
+
+	// Earlier versions of the content and reactions to it go with it
+	_, err = tx.ExecContext(ctx, "DELETE FROM message_edits WHERE message_id = ANY($1::uuid[])", uuidArray(deleted))
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete message edits: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM reactions WHERE message_id = ANY($1::uuid[])", uuidArray(deleted))
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete reactions: %w", err)
+	}
+
+	files, err := deleteAttachmentsTx(ctx, tx, deleted)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit deletion: %w", err)
+	}
+	s.removeReleasedFiles(ctx, files)
+	return deleted, nil
+}
+
+// messageAudiences maps each participant of the messages' conversations,
+// the sender included, to the messages among messageIDs they can see
+func (s *Service) messageAudiences(ctx context.Context, messageIDs []uuid.UUID) (map[uuid.UUID][]uuid.UUID, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT m.id, audience.user_id
+		FROM messages m
+		CROSS JOIN LATERAL (
+			SELECT m.sender_id AS user_id
+			UNION SELECT m.recipient_id WHERE m.recipient_id IS NOT NULL
+			UNION SELECT gm.user_id FROM group_members gm WHERE gm.group_id = m.group_id
+		) audience
+		WHERE m.id = ANY($1::uuid[])
+	`, uuidArray(messageIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byUser := make(map[uuid.UUID][]uuid.UUID)
+	for rows.Next() {
+		var messageID, audienceID uuid.UUID
+		if err := rows.Scan(&messageID, &audienceID); err != nil {
+			return nil, err
+		}
+		byUser[audienceID] = append(byUser[audienceID], messageID)
+	}
+	return byUser, rows.Err()
+}
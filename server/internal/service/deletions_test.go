@@ -0,0 +1,99 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/service"
+	"e2ee-messenger/server/internal/testutil"
+
+	"github.com/google/uuid"
+)
+
+// sendText sends a text direct message from sender to recipient
+func sendText(t *testing.T, svc *service.Service, sender, recipient models.User) *models.Message {
+	t.Helper()
+	message, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         sender.ID,
+		RecipientID:      &recipient.ID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "text",
+	})
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	return message
+}
+
+func TestDeleteMessagesSoftDeletesAndNotifiesOnce(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	bobClient := testutil.ConnectClient(t, hub, bob.ID)
+
+	first := sendText(t, svc, alice, bob)
+	second := sendText(t, svc, alice, bob)
+	testutil.ExpectEvent(t, bobClient, "new_message")
+	testutil.ExpectEvent(t, bobClient, "new_message")
+
+	deleted, err := svc.DeleteMessages(context.Background(), alice.ID, []uuid.UUID{first.ID, second.ID}, false)
+	if err != nil {
+		t.Fatalf("DeleteMessages failed: %v", err)
+	}
+	if len(deleted) != 2 {
+		t.Fatalf("Expected 2 deleted messages, got %v", deleted)
+	}
+
+	event := testutil.ExpectEvent(t, bobClient, "messages_deleted")
+	if ids := event.Payload.(map[string]interface{})["message_ids"].([]interface{}); len(ids) != 2 {
+		t.Errorf("Expected one event listing both messages, got %v", ids)
+	}
+	testutil.ExpectNoEvent(t, bobClient)
+
+	var content string
+	var softDeleted bool
+	err = db.QueryRow("SELECT encrypted_content, deleted_at IS NOT NULL FROM messages WHERE id = $1", first.ID).Scan(&content, &softDeleted)
+	if err != nil {
+		t.Fatalf("Failed to fetch message: %v", err)
+	}
+	if content != "" || !softDeleted {
+		t.Errorf("Expected the row to be kept as an empty tombstone, got content %q deleted %v", content, softDeleted)
+	}
+
+	// Deleting again is a no-op
+	if deleted, err := svc.DeleteMessages(context.Background(), alice.ID, []uuid.UUID{first.ID}, false); err != nil || len(deleted) != 0 {
+		t.Errorf("Expected nothing left to delete, got %v, %v", deleted, err)
+	}
+}
+
+func TestDeleteMessagesOnlyDeletesOwnMessages(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+
+	own := sendText(t, svc, alice, bob)
+	theirs := sendText(t, svc, bob, alice)
+	ids := []uuid.UUID{own.ID, theirs.ID}
+
+	if _, err := svc.DeleteMessages(context.Background(), alice.ID, ids, false); !errors.Is(err, apperrors.ErrForbidden) {
+		t.Fatalf("Expected ErrForbidden, got %v", err)
+	}
+	var remaining int
+	if err := db.QueryRow("SELECT COUNT(*) FROM messages WHERE deleted_at IS NULL").Scan(&remaining); err != nil {
+		t.Fatalf("Failed to count messages: %v", err)
+	}
+	if remaining != 2 {
+		t.Errorf("Expected a rejected request to delete nothing, %d messages remain", remaining)
+	}
+
+	deleted, err := svc.DeleteMessages(context.Background(), alice.ID, ids, true)
+	if err != nil {
+		t.Fatalf("DeleteMessages failed: %v", err)
+	}
+	if len(deleted) != 1 || deleted[0] != own.ID {
+		t.Errorf("Expected only alice's own message to be deleted, got %v", deleted)
+	}
+}
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"e2ee-messenger/server/internal/websocket"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Delivery statuses of direct messages that were never received
+const (
+	DeliveryPending = "delivery_pending"
+	DeliveryFailed  = "delivery_failed"
+)
+
+// DeliveryQueued is the status a send waiting for delivery reports when the
+// recipient was offline or did not confirm delivery in time
+const DeliveryQueued = "queued"
+
+// deliveryWaiters tracks sends waiting for a recipient's receipt
+type deliveryWaiters struct {
+	mu        sync.Mutex
+	byMessage map[uuid.UUID]deliveryWaiter
+}
+
+type deliveryWaiter struct {
+	recipientID uuid.UUID
+	delivered   chan struct{}
+}
+
+// expectDelivery starts listening for the recipient's receipt of a message.
+// The returned channel is closed when it arrives; stop stops listening.
+func (s *Service) expectDelivery(messageID, recipientID uuid.UUID) (<-chan struct{}, func()) {
+	w := &s.deliveryWaiters
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.byMessage == nil {
+		w.byMessage = make(map[uuid.UUID]deliveryWaiter)
+	}
+	delivered := make(chan struct{})
+	w.byMessage[messageID] = deliveryWaiter{recipientID: recipientID, delivered: delivered}
+	return delivered, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.byMessage, messageID)
+	}
+}
+
+// confirmDelivery wakes the sends waiting for userID to receive messageIDs
+func (s *Service) confirmDelivery(userID uuid.UUID, messageIDs []uuid.UUID) {
+	w := &s.deliveryWaiters
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, messageID := range messageIDs {
+		if waiter, ok := w.byMessage[messageID]; ok && waiter.recipientID == userID {
+			close(waiter.delivered)
+			delete(w.byMessage, messageID)
+		}
+	}
+}
+
+// awaitDelivery waits up to DeliveryWaitTimeout for delivered to be closed
+// and returns the resulting status. A nil channel means there is nobody
+// online to wait for.
+func (s *Service) awaitDelivery(ctx context.Context, delivered <-chan struct{}) string {
+	if delivered == nil {
+		return DeliveryQueued
+	}
+	timer := time.NewTimer(s.cfg.DeliveryWaitTimeout)
+	defer timer.Stop()
+	select {
+	case <-delivered:
+		return "delivered"
+	case <-timer.C:
+		return DeliveryQueued
+	case <-ctx.Done():
+		return DeliveryQueued
+	}
+}
+
+// RunDeliveryMonitor reports undelivered direct messages to their senders
+// until ctx is cancelled
+func (s *Service) RunDeliveryMonitor(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.DeliveryCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if s.databaseDown() {
+				continue
+			}
+			if _, err := s.CheckDeliveries(ctx); err != nil {
+				log.Printf("Delivery monitor error: %v", err)
+			}
+		}
+	}
+}
+
+// CheckDeliveries moves direct messages the recipient never acknowledged to
+// "delivery_pending" and then "delivery_failed" as they age, and tells each
+// sender with one "message_status" event per status. It returns the number of
+// messages whose status changed.
+func (s *Service) CheckDeliveries(ctx context.Context) (int, error) {
+	now := time.Now()
+	failed, err := s.markUndelivered(ctx, DeliveryFailed, []string{"sent", DeliveryPending}, now.Add(-s.cfg.DeliveryFailedAfter))
+	if err != nil {
+		return 0, err
+	}
+	pending, err := s.markUndelivered(ctx, DeliveryPending, []string{"sent"}, now.Add(-s.cfg.DeliveryPendingAfter))
+	if err != nil {
+		return failed, err
+	}
+	return failed + pending, nil
+}
+
+// markUndelivered moves unacknowledged direct messages sent before cutoff from
+// one of the given statuses to status, and notifies their senders
+func (s *Service) markUndelivered(ctx context.Context, status string, from []string, cutoff time.Time) (int, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE messages m SET delivery_status = $1
+		WHERE m.recipient_id IS NOT NULL
+		  AND m.delivery_status = ANY($2::varchar[])
+		  AND m.created_at < $3
+		  AND NOT EXISTS (SELECT 1 FROM receipts r WHERE r.message_id = m.id AND r.user_id = m.recipient_id)
+		RETURNING m.id, m.sender_id
+	`, status, pq.StringArray(from), cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark messages %s: %w", status, err)
+	}
+	defer rows.Close()
+
+	bySender := make(map[uuid.UUID][]uuid.UUID)
+	count := 0
+	for rows.Next() {
+		var messageID, senderID uuid.UUID
+		if err := rows.Scan(&messageID, &senderID); err != nil {
+			return count, fmt.Errorf("failed to scan message: %w", err)
+		}
+		bySender[senderID] = append(bySender[senderID], messageID)
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, fmt.Errorf("failed to read messages: %w", err)
+	}
+
+	for senderID, messageIDs := range bySender {
+		s.hub.SendToUser(senderID.String(), websocket.MessageStatusEvent(status, messageIDs))
+	}
+	return count, nil
+}
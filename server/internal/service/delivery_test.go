@@ -0,0 +1,177 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/service"
+	"e2ee-messenger/server/internal/testutil"
+	"e2ee-messenger/server/internal/websocket"
+
+	"github.com/google/uuid"
+)
+
+func TestUndeliveredMessageReportsPendingThenFailed(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	aliceClient := testutil.ConnectClient(t, hub, alice.ID)
+
+	input := service.SendMessageInput{SenderID: alice.ID, RecipientID: &bob.ID, EncryptedContent: "ciphertext", MessageType: "text"}
+	undelivered, err := svc.SendMessage(context.Background(), input)
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	delivered, err := svc.SendMessage(context.Background(), input)
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if _, err := svc.SendReceipt(context.Background(), bob.ID, delivered.ID, "delivered"); err != nil {
+		t.Fatalf("SendReceipt failed: %v", err)
+	}
+	testutil.ExpectEvent(t, aliceClient, "message_receipt")
+	testutil.ExpectEvent(t, aliceClient, "message_status")
+
+	// Nothing changes inside the window
+	if changed, err := svc.CheckDeliveries(context.Background()); err != nil || changed != 0 {
+		t.Fatalf("Expected no status change yet, got %d (%v)", changed, err)
+	}
+
+	age := func(d time.Duration) {
+		t.Helper()
+		if _, err := db.Exec("UPDATE messages SET created_at = $1 WHERE sender_id = $2", time.Now().Add(-d), alice.ID); err != nil {
+			t.Fatalf("Failed to age messages: %v", err)
+		}
+	}
+	status := func() string {
+		t.Helper()
+		var status string
+		if err := db.QueryRow("SELECT delivery_status FROM messages WHERE id = $1", undelivered.ID).Scan(&status); err != nil {
+			t.Fatalf("Failed to fetch delivery status: %v", err)
+		}
+		return status
+	}
+
+	age(2 * time.Hour)
+	if changed, err := svc.CheckDeliveries(context.Background()); err != nil || changed != 1 {
+		t.Fatalf("Expected one message to become pending, got %d (%v)", changed, err)
+	}
+	event := testutil.ExpectEvent(t, aliceClient, "message_status")
+	payload := event.Payload.(map[string]interface{})
+	ids := payload["message_ids"].([]interface{})
+	if payload["status"] != service.DeliveryPending || len(ids) != 1 || ids[0] != undelivered.ID.String() {
+		t.Errorf("Expected a pending status for %s, got %v", undelivered.ID, payload)
+	}
+	if got := status(); got != service.DeliveryPending {
+		t.Errorf("Expected status %s, got %s", service.DeliveryPending, got)
+	}
+
+	age(8 * 24 * time.Hour)
+	if changed, err := svc.CheckDeliveries(context.Background()); err != nil || changed != 1 {
+		t.Fatalf("Expected one message to fail, got %d (%v)", changed, err)
+	}
+	testutil.ExpectEvent(t, aliceClient, "message_status")
+	if got := status(); got != service.DeliveryFailed {
+		t.Errorf("Expected status %s, got %s", service.DeliveryFailed, got)
+	}
+}
+
+func TestSendMessageWaitsForDelivery(t *testing.T) {
+	t.Setenv("DELIVERY_WAIT_TIMEOUT", "5s")
+	svc, db, hub := setupService(t)
+	ctx := context.Background()
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	bobClient := testutil.ConnectClient(t, hub, bob.ID)
+
+	type result struct {
+		message *models.Message
+		err     error
+	}
+	sent := make(chan result, 1)
+	go func() {
+		message, err := svc.SendMessage(ctx, service.SendMessageInput{
+			SenderID:         alice.ID,
+			RecipientID:      &bob.ID,
+			EncryptedContent: "ciphertext",
+			MessageType:      "text",
+			WaitForDelivery:  true,
+		})
+		sent <- result{message, err}
+	}()
+
+	// bob's device confirms delivery as soon as the message arrives
+	event := testutil.ExpectEvent(t, bobClient, websocket.EventNewMessage)
+	messageID := uuid.MustParse(event.Payload.(map[string]interface{})["id"].(string))
+	if _, err := svc.SendReceipt(ctx, bob.ID, messageID, "delivered"); err != nil {
+		t.Fatalf("SendReceipt failed: %v", err)
+	}
+
+	r := <-sent
+	if r.err != nil {
+		t.Fatalf("SendMessage failed: %v", r.err)
+	}
+	if r.message.Status != "delivered" {
+		t.Errorf("Expected status delivered, got %q", r.message.Status)
+	}
+}
+
+func TestSendMessageDeliveryAckModes(t *testing.T) {
+	t.Setenv("DELIVERY_WAIT_TIMEOUT", "50ms")
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	carol := testutil.CreateUser(t, db, "carol")
+	testutil.ConnectClient(t, hub, carol.ID)
+
+	send := func(recipient uuid.UUID, wait bool) string {
+		t.Helper()
+		message, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+			SenderID:         alice.ID,
+			RecipientID:      &recipient,
+			EncryptedContent: "ciphertext",
+			MessageType:      "text",
+			WaitForDelivery:  wait,
+		})
+		if err != nil {
+			t.Fatalf("SendMessage failed: %v", err)
+		}
+		return message.Status
+	}
+
+	if status := send(bob.ID, false); status != "sent" {
+		t.Errorf("Expected a fast ack with status sent, got %q", status)
+	}
+	if status := send(bob.ID, true); status != service.DeliveryQueued {
+		t.Errorf("Expected an offline recipient to leave the message queued, got %q", status)
+	}
+	// carol is online but her device never confirms
+	if status := send(carol.ID, true); status != service.DeliveryQueued {
+		t.Errorf("Expected a timed out wait to leave the message queued, got %q", status)
+	}
+}
+
+func TestSendMessageDoesNotWaitForDeliveryWithRedis(t *testing.T) {
+	// carol's receipt could arrive on another instance, so nobody waits for it
+	t.Setenv("REDIS_URL", "redis://localhost:6379/0")
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	carol := testutil.CreateUser(t, db, "carol")
+	testutil.ConnectClient(t, hub, carol.ID)
+
+	message, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         alice.ID,
+		RecipientID:      &carol.ID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "text",
+		WaitForDelivery:  true,
+	})
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if message.Status != "sent" {
+		t.Errorf("Expected status sent without waiting, got %q", message.Status)
+	}
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/websocket"
+
+	"github.com/google/uuid"
+)
+
+// EditMessage replaces the content of a message the user sent, keeping the
+// ciphertext it replaces in message_edits. Only the sender may edit a
+// message, and only within the configured edit window; system and view-once
+// messages cannot be edited. The recipient of a direct message, or every
+// member of a group, is sent a "message_edited" event with the updated
+// message, as are the sender's other devices.
+func (s *Service) EditMessage(ctx context.Context, userID, messageID uuid.UUID, encryptedContent string) (*models.Message, error) {
+	if encryptedContent == "" {
+		return nil, fmt.Errorf("encrypted_content is required: %w", apperrors.ErrInvalidInput)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var senderID uuid.UUID
+	var previousContent, messageType string
+	var viewOnce bool
+	var createdAt time.Time
+	err = tx.QueryRowContext(ctx, `
+		SELECT sender_id, encrypted_content, message_type, view_once, created_at
+		FROM messages WHERE id = $1 AND deleted_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+		FOR UPDATE
+	`, messageID).Scan(&senderID, &previousContent, &messageType, &viewOnce, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, apperrors.ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch message: %w", err)
+	}
+	if senderID != userID {
+		return nil, fmt.Errorf("only the sender can edit a message: %w", apperrors.ErrForbidden)
+	}
+	if messageType == "system" || viewOnce {
+		return nil, fmt.Errorf("%s messages cannot be edited: %w", messageType, apperrors.ErrForbidden)
+	}
+	if time.Since(createdAt) > s.cfg.MessageEditWindow {
+		return nil, fmt.Errorf("message %s is past its edit window: %w", messageID, apperrors.ErrForbidden)
+	}
+
+	editedAt := time.Now().UTC()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO message_edits (message_id, previous_content, edited_at) VALUES ($1, $2, $3)
+	`, messageID, previousContent, editedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record message edit: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE messages SET encrypted_content = $1, edited_at = $2 WHERE id = $3
+	`, encryptedContent, editedAt, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to edit message: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit message edit: %w", err)
+	}
+
+	message, err := s.loadMessage(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	s.notifyMessageEdited(ctx, *message)
+	return message, nil
+}
+
+// notifyMessageEdited sends the edited message to everyone who can see it
+func (s *Service) notifyMessageEdited(ctx context.Context, message models.Message) {
+	if message.GroupID == nil {
+		event := websocket.MessageEditedEvent(message)
+		s.hub.SendToUser(message.RecipientID.String(), event)
+		s.hub.SendToUser(message.SenderID.String(), event)
+		return
+	}
+
+	message = s.withSender(ctx, message)
+	// Edits are not new activity, so members' notification levels do not apply
+	if _, err := s.SendToGroup(ctx, GroupFanout{GroupID: *message.GroupID}, websocket.MessageEditedEvent(message)); err != nil {
+		log.Printf("Failed to notify group %s of edited message %s: %v", *message.GroupID, message.ID, err)
+	}
+}
@@ -0,0 +1,59 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/testutil"
+)
+
+func TestEditMessageKeepsPreviousContent(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	bobClient := testutil.ConnectClient(t, hub, bob.ID)
+
+	message := sendText(t, svc, alice, bob)
+	testutil.ExpectEvent(t, bobClient, "new_message")
+
+	edited, err := svc.EditMessage(context.Background(), alice.ID, message.ID, "new-ciphertext")
+	if err != nil {
+		t.Fatalf("EditMessage failed: %v", err)
+	}
+	if edited.EncryptedContent != "new-ciphertext" || edited.EditedAt == nil {
+		t.Errorf("Expected the edited message with edited_at set, got %+v", edited)
+	}
+
+	event := testutil.ExpectEvent(t, bobClient, "message_edited")
+	if content := event.Payload.(map[string]interface{})["encrypted_content"]; content != "new-ciphertext" {
+		t.Errorf("Expected the event to carry the new content, got %v", content)
+	}
+
+	var previous string
+	if err := db.QueryRow("SELECT previous_content FROM message_edits WHERE message_id = $1", message.ID).Scan(&previous); err != nil {
+		t.Fatalf("Failed to fetch edit history: %v", err)
+	}
+	if previous != "ciphertext" {
+		t.Errorf("Expected the original content in the edit history, got %q", previous)
+	}
+}
+
+func TestEditMessageRejectsOthersAndOldMessages(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+
+	message := sendText(t, svc, alice, bob)
+	if _, err := svc.EditMessage(context.Background(), bob.ID, message.ID, "forged"); !errors.Is(err, apperrors.ErrForbidden) {
+		t.Errorf("Expected ErrForbidden for someone else's message, got %v", err)
+	}
+
+	if _, err := db.Exec("UPDATE messages SET created_at = NOW() - INTERVAL '49 hours' WHERE id = $1", message.ID); err != nil {
+		t.Fatalf("Failed to age message: %v", err)
+	}
+	if _, err := svc.EditMessage(context.Background(), alice.ID, message.ID, "late"); !errors.Is(err, apperrors.ErrForbidden) {
+		t.Errorf("Expected ErrForbidden past the edit window, got %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// emojiBases are the code points emoji are built from. It approximates Unicode's
+// Extended_Pictographic property, leaving out the regional indicators and
+// skin tone modifiers in its range, which only appear in sequences.
+var emojiBases = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x00a9, Hi: 0x00a9, Stride: 1},
+		{Lo: 0x00ae, Hi: 0x00ae, Stride: 1},
+		{Lo: 0x203c, Hi: 0x203c, Stride: 1},
+		{Lo: 0x2049, Hi: 0x2049, Stride: 1},
+		{Lo: 0x2122, Hi: 0x2122, Stride: 1},
+		{Lo: 0x2139, Hi: 0x2139, Stride: 1},
+		{Lo: 0x2194, Hi: 0x2199, Stride: 1},
+		{Lo: 0x21a9, Hi: 0x21aa, Stride: 1},
+		{Lo: 0x231a, Hi: 0x231b, Stride: 1},
+		{Lo: 0x2328, Hi: 0x2328, Stride: 1},
+		{Lo: 0x23cf, Hi: 0x23cf, Stride: 1},
+		{Lo: 0x23e9, Hi: 0x23f3, Stride: 1},
+		{Lo: 0x23f8, Hi: 0x23fa, Stride: 1},
+		{Lo: 0x24c2, Hi: 0x24c2, Stride: 1},
+		{Lo: 0x25aa, Hi: 0x25ab, Stride: 1},
+		{Lo: 0x25b6, Hi: 0x25b6, Stride: 1},
+		{Lo: 0x25c0, Hi: 0x25c0, Stride: 1},
+		{Lo: 0x25fb, Hi: 0x25fe, Stride: 1},
+		{Lo: 0x2600, Hi: 0x27bf, Stride: 1},
+		{Lo: 0x2934, Hi: 0x2935, Stride: 1},
+		{Lo: 0x2b05, Hi: 0x2b07, Stride: 1},
+		{Lo: 0x2b1b, Hi: 0x2b1c, Stride: 1},
+		{Lo: 0x2b50, Hi: 0x2b50, Stride: 1},
+		{Lo: 0x2b55, Hi: 0x2b55, Stride: 1},
+		{Lo: 0x3030, Hi: 0x3030, Stride: 1},
+		{Lo: 0x303d, Hi: 0x303d, Stride: 1},
+		{Lo: 0x3297, Hi: 0x3297, Stride: 1},
+		{Lo: 0x3299, Hi: 0x3299, Stride: 1},
+	},
+	R32: []unicode.Range32{
+		{Lo: 0x1f000, Hi: 0x1f1e5, Stride: 1},
+		{Lo: 0x1f200, Hi: 0x1f3fa, Stride: 1},
+		{Lo: 0x1f400, Hi: 0x1faff, Stride: 1},
+		{Lo: 0x1fc00, Hi: 0x1fffd, Stride: 1},
+	},
+}
+
+// Code points with a special role in emoji sequences
+const (
+	variationSelector  = '\ufe0f'
+	zeroWidthJoiner    = '\u200d'
+	combiningKeycap    = '\u20e3'
+	blackFlag          = '\U0001f3f4'
+	firstTag           = '\U000e0020'
+	cancelTag          = '\U000e007f'
+	firstRegional      = '\U0001f1e6'
+	lastRegional       = '\U0001f1ff'
+	firstSkinTone      = '\U0001f3fb'
+	lastSkinTone       = '\U0001f3ff'
+	keycapBases        = "0123456789#*"
+	maxEmojiComponents = 10
+)
+
+// isEmoji reports whether s is a single emoji: a flag, a keycap, a tag
+// sequence such as a subdivision flag, or pictographs with optional skin
+// tones joined by zero width joiners, up to maxEmojiComponents of them
+func isEmoji(s string) bool {
+	runes := []rune(s)
+	if len(runes) == 0 || !utf8.ValidString(s) {
+		return false
+	}
+
+	// Flags are a pair of regional indicators
+	if len(runes) == 2 && isRegional(runes[0]) && isRegional(runes[1]) {
+		return true
+	}
+	// Keycaps are a digit, # or *, usually a variation selector, and the
+	// combining keycap
+	if len(runes) >= 2 && runes[len(runes)-1] == combiningKeycap {
+		rest := runes[:len(runes)-1]
+		if len(rest) == 2 && rest[1] == variationSelector {
+			rest = rest[:1]
+		}
+		return len(rest) == 1 && strings.ContainsRune(keycapBases, rest[0])
+	}
+	// Tag sequences are a black flag, tag characters and a cancel tag
+	if runes[0] == blackFlag && len(runes) > 2 && runes[len(runes)-1] == cancelTag {
+		for _, r := range runes[1 : len(runes)-1] {
+			if r < firstTag || r >= cancelTag {
+				return false
+			}
+		}
+		return true
+	}
+
+	components := 0
+	for i := 0; i < len(runes); {
+		if components > 0 {
+			if runes[i] != zeroWidthJoiner {
+				return false
+			}
+			i++
+		}
+		if i == len(runes) || !unicode.Is(emojiBases, runes[i]) {
+			return false
+		}
+		i++
+		if i < len(runes) && runes[i] >= firstSkinTone && runes[i] <= lastSkinTone {
+			i++
+		}
+		if i < len(runes) && runes[i] == variationSelector {
+			i++
+		}
+		components++
+		if components > maxEmojiComponents {
+			return false
+		}
+	}
+	return true
+}
+
+// isRegional reports whether r is a regional indicator, half of a flag
+func isRegional(r rune) bool {
+	return r >= firstRegional && r <= lastRegional
+}
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"e2ee-messenger/server/internal/websocket"
+
+	"github.com/google/uuid"
+)
+
+// Disappearing messages: a conversation's timer is shared by its
+// participants, and any of them may change it, which they all hear about
+// through a "message_ttl_changed" event. Messages take expires_at from
+// the timer in force when they are sent, so changing it leaves earlier
+// messages alone. Expired messages are hidden straight away and deleted for
+// good, attachments included, by the expiry sweeper.
+
+// maxMessageTTL is the longest disappearing message timer
+const maxMessageTTL = 365 * 24 * time.Hour
+
+// rowQuerier runs a single-row query on the database or within a transaction
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// conversationTTL returns the conversation's disappearing message timer, or
+// zero when its messages do not disappear
+func conversationTTL(ctx context.Context, q rowQuerier, key cryptoKey) (time.Duration, error) {
+	var seconds int
+	var err error
+	if key.groupID != nil {
+		err = q.QueryRowContext(ctx, "SELECT ttl_seconds FROM conversation_ttls WHERE group_id = $1", *key.groupID).Scan(&seconds)
+	} else {
+		err = q.QueryRowContext(ctx, `
+			SELECT ttl_seconds FROM conversation_ttls WHERE group_id IS NULL AND user_a = $1 AND user_b = $2
+		`, key.userA, key.userB).Scan(&seconds)
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("failed to fetch message TTL: %w", err)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// setConversationTTL sets the conversation's disappearing message timer on
+// behalf of userID; a zero ttl turns it off
+func setConversationTTL(ctx context.Context, tx *sql.Tx, key cryptoKey, ttl time.Duration, userID uuid.UUID) error {
+	var err error
+	switch {
+	case ttl <= 0 && key.groupID != nil:
+		_, err = tx.ExecContext(ctx, "DELETE FROM conversation_ttls WHERE group_id = $1", *key.groupID)
+	case ttl <= 0:
+		_, err = tx.ExecContext(ctx, `
+			DELETE FROM conversation_ttls WHERE group_id IS NULL AND user_a = $1 AND user_b = $2
+		`, key.userA, key.userB)
+	case key.groupID != nil:
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO conversation_ttls (group_id, ttl_seconds, set_by) VALUES ($1, $2, $3)
+			ON CONFLICT (group_id) WHERE group_id IS NOT NULL DO UPDATE SET
+				ttl_seconds = EXCLUDED.ttl_seconds, set_by = EXCLUDED.set_by, updated_at = NOW()
+		`, *key.groupID, int(ttl/time.Second), userID)
+	default:
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO conversation_ttls (user_a, user_b, ttl_seconds, set_by) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_a, user_b) WHERE group_id IS NULL DO UPDATE SET
+				ttl_seconds = EXCLUDED.ttl_seconds, set_by = EXCLUDED.set_by, updated_at = NOW()
+		`, key.userA, key.userB, int(ttl/time.Second), userID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set message TTL: %w", err)
+	}
+	return nil
+}
+
+// notifyMessageTTLChanged sends everyone in the conversation userID sees as
+// conversationID a "message_ttl_changed" event, with the conversation as
+// each of them sees it
+func (s *Service) notifyMessageTTLChanged(ctx context.Context, userID, conversationID uuid.UUID, convType string, ttl time.Duration) {
+	if convType == "group" {
+		event := websocket.MessageTTLChangedEvent(conversationID, ttl, userID)
+		if _, err := s.SendToGroup(ctx, GroupFanout{GroupID: conversationID}, event); err != nil {
+			log.Printf("Failed to notify group %s of a %s event: %v", conversationID, event.Type, err)
+		}
+		return
+	}
+	s.hub.SendToUser(userID.String(), websocket.MessageTTLChangedEvent(conversationID, ttl, userID))
+	s.hub.SendToUser(conversationID.String(), websocket.MessageTTLChangedEvent(userID, ttl, userID))
+}
+
+// RunExpirySweeper deletes messages past their expires_at every
+// ExpirySweepInterval until ctx is cancelled
+func (s *Service) RunExpirySweeper(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.ExpirySweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if s.databaseDown() {
+				continue
+			}
+			if _, err := s.SweepExpiredMessages(ctx); err != nil {
+				log.Printf("Expiry sweeper error: %v", err)
+			}
+		}
+	}
+}
+
+// pastExpiryQuery selects messages whose disappearing message timer ran out
+const pastExpiryQuery = `
+	SELECT id FROM messages
+	WHERE expires_at <= NOW()
+	ORDER BY expires_at
+	LIMIT $1
+	FOR UPDATE SKIP LOCKED
+`
+
+// SweepExpiredMessages deletes expired messages with their attachments, in
+// batches, and sends everyone who could see some of a batch one
+// "messages_deleted" event listing them. It returns the number of messages
+// deleted.
+func (s *Service) SweepExpiredMessages(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		n, err := s.sweepBatch(ctx)
+		total += n
+		if err != nil || n < retentionBatchSize {
+			return total, err
+		}
+	}
+}
+
+// sweepBatch deletes up to retentionBatchSize expired messages in one
+// transaction, notifying their audiences once it commits
+func (s *Service) sweepBatch(ctx context.Context) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	expired, err := selectMessageIDsTx(ctx, tx, pastExpiryQuery, []interface{}{retentionBatchSize})
+	if err != nil || len(expired) == 0 {
+		return 0, err
+	}
+
+	// Looked up before the delete commits, while the messages still exist
+	audiences, err := s.messageAudiences(ctx, expired)
+	if err != nil {
+		log.Printf("Failed to get audience for expired messages: %v", err)
+	}
+
+	files, err := deleteAttachmentsTx(ctx, tx, expired)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM messages WHERE id = ANY($1::uuid[])", uuidArray(expired)); err != nil {
+		return 0, fmt.Errorf("failed to delete expired messages: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit expiry: %w", err)
+	}
+	s.removeReleasedFiles(ctx, files)
+
+	for audienceID, visible := range audiences {
+		s.hub.SendToUser(audienceID.String(), websocket.MessagesExpiredEvent(visible))
+	}
+	return len(expired), nil
+}
@@ -0,0 +1,108 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/testutil"
+	"e2ee-messenger/server/internal/websocket"
+)
+
+func TestConversationTTLSetsExpiresAtForBothSides(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+
+	before := sendText(t, svc, alice, bob)
+	if before.ExpiresAt != nil {
+		t.Errorf("Expected no expiry without a timer, got %v", before.ExpiresAt)
+	}
+
+	ttl := 60
+	if _, err := svc.UpdateConversationSettings(context.Background(), alice.ID, bob.ID, models.UpdateConversationSettingsRequest{MessageTTLSeconds: &ttl}); err != nil {
+		t.Fatalf("UpdateConversationSettings failed: %v", err)
+	}
+
+	// The timer is shared, so it applies to bob's messages too
+	settings, err := svc.ConversationSettings(context.Background(), bob.ID, alice.ID)
+	if err != nil {
+		t.Fatalf("ConversationSettings failed: %v", err)
+	}
+	if settings.MessageTTLSeconds != ttl {
+		t.Errorf("Expected bob to see the %ds timer, got %d", ttl, settings.MessageTTLSeconds)
+	}
+	reply := sendText(t, svc, bob, alice)
+	if reply.ExpiresAt == nil || !reply.ExpiresAt.Equal(reply.CreatedAt.Add(time.Minute)) {
+		t.Errorf("Expected the reply to expire a minute after it was sent, got %v", reply.ExpiresAt)
+	}
+
+	var stored *time.Time
+	if err := db.QueryRow("SELECT expires_at FROM messages WHERE id = $1", before.ID).Scan(&stored); err != nil {
+		t.Fatalf("Failed to fetch message: %v", err)
+	}
+	if stored != nil {
+		t.Errorf("Expected the earlier message to keep no expiry, got %v", stored)
+	}
+}
+
+func TestSweepExpiredMessagesDeletesAndNotifies(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	aliceClient := testutil.ConnectClient(t, hub, alice.ID)
+	bobClient := testutil.ConnectClient(t, hub, bob.ID)
+
+	ttl := 60
+	if _, err := svc.UpdateConversationSettings(context.Background(), alice.ID, bob.ID, models.UpdateConversationSettingsRequest{MessageTTLSeconds: &ttl}); err != nil {
+		t.Fatalf("UpdateConversationSettings failed: %v", err)
+	}
+	// Each side hears about the timer, under the conversation as they see it
+	for client, conversationID := range map[*websocket.Client]string{aliceClient: bob.ID.String(), bobClient: alice.ID.String()} {
+		payload := testutil.ExpectEvent(t, client, websocket.EventMessageTTLChanged).Payload.(map[string]interface{})
+		if payload["conversation_id"] != conversationID || payload["message_ttl_seconds"] != float64(ttl) || payload["changed_by"] != alice.ID.String() {
+			t.Errorf("Unexpected message_ttl_changed payload: %v", payload)
+		}
+	}
+	// Setting the same timer again changes nothing
+	if _, err := svc.UpdateConversationSettings(context.Background(), bob.ID, alice.ID, models.UpdateConversationSettingsRequest{MessageTTLSeconds: &ttl}); err != nil {
+		t.Fatalf("UpdateConversationSettings failed: %v", err)
+	}
+	testutil.ExpectNoEvent(t, aliceClient)
+
+	expiring := sendText(t, svc, alice, bob)
+	testutil.ExpectEvent(t, bobClient, "new_message")
+
+	// Nothing has expired yet
+	if n, err := svc.SweepExpiredMessages(context.Background()); err != nil || n != 0 {
+		t.Fatalf("Expected nothing to sweep, got %d (%v)", n, err)
+	}
+
+	if _, err := db.Exec("UPDATE messages SET expires_at = NOW() - INTERVAL '1 second' WHERE id = $1", expiring.ID); err != nil {
+		t.Fatalf("Failed to expire message: %v", err)
+	}
+	n, err := svc.SweepExpiredMessages(context.Background())
+	if err != nil {
+		t.Fatalf("SweepExpiredMessages failed: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 message swept, got %d", n)
+	}
+
+	for _, client := range []*websocket.Client{aliceClient, bobClient} {
+		payload := testutil.ExpectEvent(t, client, websocket.EventMessagesDeleted).Payload.(map[string]interface{})
+		ids, _ := payload["message_ids"].([]interface{})
+		if len(ids) != 1 || ids[0] != expiring.ID.String() || payload["expired"] != true {
+			t.Errorf("Expected messages_deleted for the expired %s, got %v", expiring.ID, payload)
+		}
+	}
+
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM messages WHERE id = $1)", expiring.ID).Scan(&exists); err != nil {
+		t.Fatalf("Failed to check message: %v", err)
+	}
+	if exists {
+		t.Error("Expected the expired message to be deleted")
+	}
+}
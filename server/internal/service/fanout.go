@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"e2ee-messenger/server/internal/websocket"
+
+	"github.com/google/uuid"
+)
+
+// GroupFanout selects which members of a group an event is sent to
+type GroupFanout struct {
+	GroupID uuid.UUID
+	// Member the event is not sent to, usually whoever caused it; uuid.Nil
+	// sends it to everyone
+	ActorID uuid.UUID
+	// Leave out members who blocked the actor or whose notification level
+	// keeps the event from them: "none" hears nothing and "mentions" only
+	// hears about events mentioning them, unless the event is Urgent
+	RespectMutes bool
+	Mentions     []uuid.UUID
+	Urgent       bool
+	// Send with SendEphemeral, for events nobody needs once they are missed
+	Ephemeral bool
+}
+
+// GroupDelivery records whether a group event reached a member. Delivered
+// is false when the member has no connected client.
+type GroupDelivery struct {
+	UserID    uuid.UUID
+	Delivered bool
+}
+
+// SendToGroup sends an event to the current members of a group chosen by
+// fanout. Message, reaction, edit, deletion and membership events all reach
+// group members through it, so they agree on who hears about what.
+func (s *Service) SendToGroup(ctx context.Context, fanout GroupFanout, event websocket.Message) ([]GroupDelivery, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT gm.user_id FROM group_members gm
+		WHERE gm.group_id = $1 AND gm.user_id != $2
+		  AND (NOT $3 OR (
+			(gm.notification_level = 'all' OR (gm.notification_level = 'mentions' AND gm.user_id = ANY($4::uuid[])) OR $5)
+			AND NOT EXISTS (SELECT 1 FROM blocks b WHERE b.blocker_id = gm.user_id AND b.blocked_id = $2)
+		  ))
+		ORDER BY gm.joined_at, gm.user_id
+	`, fanout.GroupID, fanout.ActorID, fanout.RespectMutes, uuidArray(fanout.Mentions), fanout.Urgent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get group members for %s event: %w", event.Type, err)
+	}
+	var memberIDs []uuid.UUID
+	for rows.Next() {
+		var memberID uuid.UUID
+		if err := rows.Scan(&memberID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan group member: %w", err)
+		}
+		memberIDs = append(memberIDs, memberID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get group members for %s event: %w", event.Type, err)
+	}
+
+	deliveries := make([]GroupDelivery, len(memberIDs))
+	send := s.hub.SendToUser
+	if fanout.Ephemeral {
+		send = s.hub.SendEphemeral
+	}
+	for i, memberID := range memberIDs {
+		deliveries[i] = GroupDelivery{UserID: memberID, Delivered: send(memberID.String(), event) > 0}
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,77 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/service"
+	"e2ee-messenger/server/internal/testutil"
+	"e2ee-messenger/server/internal/websocket"
+
+	"github.com/google/uuid"
+)
+
+// reached lists who a fan-out was sent to
+func reached(deliveries []service.GroupDelivery) map[uuid.UUID]bool {
+	users := make(map[uuid.UUID]bool, len(deliveries))
+	for _, delivery := range deliveries {
+		users[delivery.UserID] = true
+	}
+	return users
+}
+
+func TestSendToGroupSkipsActorAndRespectsMutes(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	carol := testutil.CreateUser(t, db, "carol")
+	dave := testutil.CreateUser(t, db, "dave")
+	group := createGroup(t, svc, alice, bob, carol, dave)
+	ctx := context.Background()
+
+	if err := svc.SetNotificationLevel(ctx, group.ID, carol.ID, "none"); err != nil {
+		t.Fatalf("SetNotificationLevel failed: %v", err)
+	}
+	if err := svc.BlockUser(ctx, dave.ID, alice.ID); err != nil {
+		t.Fatalf("BlockUser failed: %v", err)
+	}
+	bobClient := testutil.ConnectClient(t, hub, bob.ID)
+
+	event := websocket.GroupUpdatedEvent(*group)
+	deliveries, err := svc.SendToGroup(ctx, service.GroupFanout{GroupID: group.ID, ActorID: alice.ID, RespectMutes: true}, event)
+	if err != nil {
+		t.Fatalf("SendToGroup failed: %v", err)
+	}
+	if got := reached(deliveries); len(got) != 1 || !got[bob.ID] {
+		t.Errorf("Expected only bob to be reached, got %v", got)
+	}
+	if !deliveries[0].Delivered {
+		t.Error("Expected the event to be delivered to bob's connected client")
+	}
+	testutil.ExpectEvent(t, bobClient, websocket.EventGroupUpdated)
+
+	// Without RespectMutes everyone but the actor hears about it
+	deliveries, err = svc.SendToGroup(ctx, service.GroupFanout{GroupID: group.ID, ActorID: alice.ID}, event)
+	if err != nil {
+		t.Fatalf("SendToGroup failed: %v", err)
+	}
+	got := reached(deliveries)
+	for _, member := range []models.User{bob, carol, dave} {
+		if !got[member.ID] {
+			t.Errorf("Expected %s to be reached", member.Username)
+		}
+	}
+	if got[alice.ID] {
+		t.Error("Expected the actor to be left out")
+	}
+
+	// Urgent events reach muted members, but still not those who blocked the actor
+	deliveries, err = svc.SendToGroup(ctx, service.GroupFanout{GroupID: group.ID, ActorID: alice.ID, RespectMutes: true, Urgent: true}, event)
+	if err != nil {
+		t.Fatalf("SendToGroup failed: %v", err)
+	}
+	if got := reached(deliveries); !got[carol.ID] || got[dave.ID] {
+		t.Errorf("Expected carol but not dave to be reached, got %v", got)
+	}
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/websocket"
+
+	"github.com/google/uuid"
+)
+
+// InviteToGroup invites users to join a group, leaving it to them to accept.
+// Only group admins may invite. Members, users with a pending invitation and
+// users who blocked the actor are skipped. Each invitee is sent a "group_invitation" event, and the
+// users that were newly invited are returned.
+func (s *Service) InviteToGroup(ctx context.Context, groupID, actorID uuid.UUID, userIDs []uuid.UUID) ([]uuid.UUID, error) {
+	if len(userIDs) == 0 {
+		return nil, fmt.Errorf("at least one user_id is required: %w", apperrors.ErrInvalidInput)
+	}
+	if err := s.requireGroupAdmin(ctx, groupID, actorID); err != nil {
+		return nil, err
+	}
+
+	unique := make(map[uuid.UUID]bool, len(userIDs))
+	for _, userID := range userIDs {
+		unique[userID] = true
+	}
+	var known int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE id = ANY($1::uuid[])", uuidArray(userIDs)).Scan(&known); err != nil {
+		return nil, fmt.Errorf("failed to look up users: %w", err)
+	}
+	if known != len(unique) {
+		return nil, apperrors.ErrUserNotFound
+	}
+
+	group, err := s.loadGroup(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		INSERT INTO group_invitations (group_id, invitee_id, invited_by)
+		SELECT $1, invitee_id, $2 FROM unnest($3::uuid[]) AS invitee_id
+		WHERE NOT EXISTS (SELECT 1 FROM group_members gm WHERE gm.group_id = $1 AND gm.user_id = invitee_id)
+		  AND NOT EXISTS (SELECT 1 FROM blocks b WHERE b.blocker_id = invitee_id AND b.blocked_id = $2)
+		ON CONFLICT (group_id, invitee_id) DO NOTHING
+		RETURNING id, invitee_id, created_at
+	`, groupID, actorID, uuidArray(userIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to invite users: %w", err)
+	}
+	defer rows.Close()
+
+	invited := []uuid.UUID{}
+	var events []websocket.Message
+	for rows.Next() {
+		invitation := models.GroupInvitation{Group: *group, InvitedBy: actorID}
+		var inviteeID uuid.UUID
+		if err := rows.Scan(&invitation.ID, &inviteeID, &invitation.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan invitation: %w", err)
+		}
+		invited = append(invited, inviteeID)
+		events = append(events, websocket.GroupInvitationEvent(invitation))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to invite users: %w", err)
+	}
+
+	for i, inviteeID := range invited {
+		s.hub.SendToUser(inviteeID.String(), events[i])
+	}
+	return invited, nil
+}
+
+// ListGroupInvitations returns the user's pending group invitations, newest first
+func (s *Service) ListGroupInvitations(ctx context.Context, userID uuid.UUID) ([]models.GroupInvitation, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT gi.id, gi.invited_by, gi.created_at,
+		       g.id, g.name, COALESCE(g.description, ''), g.created_by, g.created_at, g.updated_at, COALESCE(g.encrypted_metadata, '')
+		FROM group_invitations gi
+		JOIN groups g ON g.id = gi.group_id
+		WHERE gi.invitee_id = $1
+		ORDER BY gi.created_at DESC, gi.id
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list group invitations: %w", err)
+	}
+	defer rows.Close()
+
+	invitations := []models.GroupInvitation{}
+	for rows.Next() {
+		var inv models.GroupInvitation
+		err := rows.Scan(&inv.ID, &inv.InvitedBy, &inv.CreatedAt,
+			&inv.Group.ID, &inv.Group.Name, &inv.Group.Description, &inv.Group.CreatedBy, &inv.Group.CreatedAt, &inv.Group.UpdatedAt, &inv.Group.EncryptedMetadata)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan group invitation: %w", err)
+		}
+		invitations = append(invitations, inv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list group invitations: %w", err)
+	}
+	return invitations, nil
+}
+
+// AcceptGroupInvitation makes the user a member of the group they were
+// invited to and returns the group. The invitation is kept if the group is
+// full. Members are told about the new member as for an admin adding them.
+func (s *Service) AcceptGroupInvitation(ctx context.Context, userID, invitationID uuid.UUID) (*models.Group, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var groupID uuid.UUID
+	err = tx.QueryRowContext(ctx, `
+		DELETE FROM group_invitations WHERE id = $1 AND invitee_id = $2 RETURNING group_id
+	`, invitationID, userID).Scan(&groupID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("invitation not found: %w", apperrors.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim invitation: %w", err)
+	}
+
+	// Serialize additions so concurrent requests cannot overshoot the size limit
+	if _, err := tx.ExecContext(ctx, "SELECT id FROM groups WHERE id = $1 FOR UPDATE", groupID); err != nil {
+		return nil, fmt.Errorf("failed to lock group: %w", err)
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO group_members (group_id, user_id, role) VALUES ($1, $2, 'member')
+		ON CONFLICT (group_id, user_id) DO NOTHING
+	`, groupID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add group member: %w", err)
+	}
+	joined, _ := result.RowsAffected()
+
+	var memberCount int
+	if joined > 0 {
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM group_members WHERE group_id = $1", groupID).Scan(&memberCount); err != nil {
+			return nil, fmt.Errorf("failed to count group members: %w", err)
+		}
+		if memberCount > s.cfg.MaxGroupMembers {
+			return nil, fmt.Errorf("a group can have at most %d members: %w", s.cfg.MaxGroupMembers, apperrors.ErrInvalidInput)
+		}
+
+		err = insertSystemMessageTx(ctx, tx, groupID, models.SystemEvent{
+			Action:  systemMemberJoined,
+			ActorID: userID,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if joined > 0 {
+		s.wakeOutboxRelay()
+		s.notifyMembershipChanged(ctx, websocket.GroupMembershipPayload{
+			GroupID:     groupID,
+			UserID:      userID,
+			Action:      "joined",
+			ChangedBy:   userID,
+			MemberCount: memberCount,
+		})
+	}
+	return s.loadGroup(ctx, groupID)
+}
+
+// DeclineGroupInvitation discards one of the user's pending invitations
+func (s *Service) DeclineGroupInvitation(ctx context.Context, userID, invitationID uuid.UUID) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM group_invitations WHERE id = $1 AND invitee_id = $2", invitationID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to decline invitation: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("invitation not found: %w", apperrors.ErrNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/service"
+	"e2ee-messenger/server/internal/testutil"
+	"e2ee-messenger/server/internal/websocket"
+
+	"github.com/google/uuid"
+)
+
+// invite has owner invite the user to the group and returns the invitation
+// as the user lists it
+func invite(t *testing.T, svc *service.Service, group *models.Group, owner, user models.User) models.GroupInvitation {
+	t.Helper()
+	invited, err := svc.InviteToGroup(context.Background(), group.ID, owner.ID, []uuid.UUID{user.ID})
+	if err != nil {
+		t.Fatalf("InviteToGroup failed: %v", err)
+	}
+	if len(invited) != 1 || invited[0] != user.ID {
+		t.Fatalf("Expected %s to be invited, got %v", user.Username, invited)
+	}
+	invitations, err := svc.ListGroupInvitations(context.Background(), user.ID)
+	if err != nil {
+		t.Fatalf("ListGroupInvitations failed: %v", err)
+	}
+	if len(invitations) != 1 || invitations[0].Group.ID != group.ID || invitations[0].InvitedBy != owner.ID {
+		t.Fatalf("Expected one invitation to the group from %s, got %+v", owner.Username, invitations)
+	}
+	return invitations[0]
+}
+
+func TestInviteToGroupNotifiesInviteeAndSkipsMembers(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	carol := testutil.CreateUser(t, db, "carol")
+	group := createGroup(t, svc, alice, bob)
+	carolClient := testutil.ConnectClient(t, hub, carol.ID)
+
+	invitation := invite(t, svc, group, alice, carol)
+	event := testutil.ExpectEvent(t, carolClient, websocket.EventGroupInvitation)
+	if payload := event.Payload.(map[string]interface{}); payload["id"] != invitation.ID.String() {
+		t.Errorf("Expected the event to carry the invitation, got %v", payload)
+	}
+
+	// Members and users already invited are not invited again
+	invited, err := svc.InviteToGroup(context.Background(), group.ID, alice.ID, []uuid.UUID{bob.ID, carol.ID})
+	if err != nil {
+		t.Fatalf("InviteToGroup failed: %v", err)
+	}
+	if len(invited) != 0 {
+		t.Errorf("Expected nobody new to be invited, got %v", invited)
+	}
+
+	if _, err := svc.InviteToGroup(context.Background(), group.ID, bob.ID, []uuid.UUID{carol.ID}); !errors.Is(err, apperrors.ErrForbidden) {
+		t.Errorf("Expected ErrForbidden for a non-admin, got %v", err)
+	}
+}
+
+func TestInviteToGroupSkipsUsersWhoBlockedTheInviter(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	dave := testutil.CreateUser(t, db, "dave")
+	erin := testutil.CreateUser(t, db, "erin")
+	group := createGroup(t, svc, alice)
+	ctx := context.Background()
+	if err := svc.BlockUser(ctx, dave.ID, alice.ID); err != nil {
+		t.Fatalf("BlockUser failed: %v", err)
+	}
+	daveClient := testutil.ConnectClient(t, hub, dave.ID)
+
+	invited, err := svc.InviteToGroup(ctx, group.ID, alice.ID, []uuid.UUID{dave.ID, erin.ID})
+	if err != nil {
+		t.Fatalf("InviteToGroup failed: %v", err)
+	}
+	if len(invited) != 1 || invited[0] != erin.ID {
+		t.Errorf("Expected only erin to be invited, got %v", invited)
+	}
+	testutil.ExpectNoEvent(t, daveClient)
+	if invitations, _ := svc.ListGroupInvitations(ctx, dave.ID); len(invitations) != 0 {
+		t.Errorf("Expected dave to have no invitations, got %+v", invitations)
+	}
+}
+
+func TestAcceptGroupInvitationJoinsGroup(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	group := createGroup(t, svc, alice)
+	invitation := invite(t, svc, group, alice, bob)
+	aliceClient := testutil.ConnectClient(t, hub, alice.ID)
+
+	joined, err := svc.AcceptGroupInvitation(context.Background(), bob.ID, invitation.ID)
+	if err != nil {
+		t.Fatalf("AcceptGroupInvitation failed: %v", err)
+	}
+	if joined.ID != group.ID {
+		t.Errorf("Expected to join %s, got %s", group.ID, joined.ID)
+	}
+	if err := svc.RequireGroupMember(context.Background(), group.ID, bob.ID); err != nil {
+		t.Errorf("Expected bob to be a member, got %v", err)
+	}
+
+	event := testutil.ExpectEvent(t, aliceClient, websocket.EventGroupMembershipChanged)
+	if payload := event.Payload.(map[string]interface{}); payload["action"] != "joined" || payload["user_id"] != bob.ID.String() {
+		t.Errorf("Expected bob to have joined, got %v", payload)
+	}
+
+	if _, err := svc.AcceptGroupInvitation(context.Background(), bob.ID, invitation.ID); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Errorf("Expected the invitation to be used up, got %v", err)
+	}
+}
+
+func TestDeclineGroupInvitation(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	carol := testutil.CreateUser(t, db, "carol")
+	group := createGroup(t, svc, alice)
+	invitation := invite(t, svc, group, alice, bob)
+
+	// Only the invitee can act on an invitation
+	if err := svc.DeclineGroupInvitation(context.Background(), carol.ID, invitation.ID); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for someone else's invitation, got %v", err)
+	}
+	if err := svc.DeclineGroupInvitation(context.Background(), bob.ID, invitation.ID); err != nil {
+		t.Fatalf("DeclineGroupInvitation failed: %v", err)
+	}
+
+	invitations, err := svc.ListGroupInvitations(context.Background(), bob.ID)
+	if err != nil {
+		t.Fatalf("ListGroupInvitations failed: %v", err)
+	}
+	if len(invitations) != 0 {
+		t.Errorf("Expected no pending invitations, got %d", len(invitations))
+	}
+	if err := svc.RequireGroupMember(context.Background(), group.ID, bob.ID); !errors.Is(err, apperrors.ErrNotGroupMember) {
+		t.Errorf("Expected bob not to have joined, got %v", err)
+	}
+}
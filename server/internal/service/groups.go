@@ -0,0 +1,962 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/database"
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/websocket"
+
+	"github.com/google/uuid"
+)
+
+// maxGroupDescriptionLength is the longest group description, in characters
+const maxGroupDescriptionLength = 1024
+
+// maxEncryptedMetadataSize is the largest encrypted group metadata blob, in bytes
+const maxEncryptedMetadataSize = 4096
+
+// CreateGroupInput describes a new group chat
+type CreateGroupInput struct {
+	CreatorID   uuid.UUID
+	Name        string
+	Description string
+	MemberIDs   []uuid.UUID
+	// Replaces Name and Description for groups that keep them from the server
+	EncryptedMetadata string
+}
+
+// UpdateGroupInput describes changes to a group's metadata. Nil fields are left unchanged.
+type UpdateGroupInput struct {
+	GroupID           uuid.UUID
+	UserID            uuid.UUID
+	Name              *string
+	Description       *string
+	EncryptedMetadata *string
+}
+
+// checkGroupMetadata validates a new group's name and description. Groups
+// either show them to the server in plaintext or opt into client-encrypted
+// metadata, which members encrypt with the group's sender key and the server
+// stores as an opaque blob; they cannot mix the two.
+func checkGroupMetadata(name, description, encryptedMetadata string) error {
+	if encryptedMetadata == "" {
+		if name == "" {
+			return fmt.Errorf("group name is required: %w", apperrors.ErrInvalidInput)
+		}
+		return nil
+	}
+	if name != "" || description != "" {
+		return fmt.Errorf("groups with encrypted metadata cannot have a plaintext name or description: %w", apperrors.ErrInvalidInput)
+	}
+	return checkEncryptedMetadata(encryptedMetadata)
+}
+
+// checkEncryptedMetadata enforces the size limit on an encrypted metadata blob
+func checkEncryptedMetadata(encryptedMetadata string) error {
+	if encryptedMetadata == "" {
+		return fmt.Errorf("encrypted_metadata must not be empty: %w", apperrors.ErrInvalidInput)
+	}
+	if len(encryptedMetadata) > maxEncryptedMetadataSize {
+		return fmt.Errorf("encrypted_metadata must be at most %d bytes: %w", maxEncryptedMetadataSize, apperrors.ErrInvalidInput)
+	}
+	return nil
+}
+
+// sanitizeDescription strips control characters, keeping the newlines and
+// tabs markdown relies on, and enforces the length limit. Descriptions are
+// not end-to-end encrypted, so they are stored and served as plain text.
+func sanitizeDescription(description string) (string, error) {
+	description = strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return r
+		}
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return -1
+		}
+		return r
+	}, description)
+	description = strings.TrimSpace(description)
+
+	if utf8.RuneCountInString(description) > maxGroupDescriptionLength {
+		return "", fmt.Errorf("description must be at most %d characters: %w", maxGroupDescriptionLength, apperrors.ErrInvalidInput)
+	}
+	return description, nil
+}
+
+// CreateGroup creates a group with the creator as admin and the given members
+func (s *Service) CreateGroup(ctx context.Context, in CreateGroupInput) (*models.Group, error) {
+	if err := checkGroupMetadata(in.Name, in.Description, in.EncryptedMetadata); err != nil {
+		return nil, err
+	}
+	description, err := sanitizeDescription(in.Description)
+	if err != nil {
+		return nil, err
+	}
+	members := map[uuid.UUID]bool{in.CreatorID: true}
+	for _, memberID := range in.MemberIDs {
+		members[memberID] = true
+	}
+	if len(members) > s.cfg.MaxGroupMembers {
+		return nil, fmt.Errorf("a group can have at most %d members: %w", s.cfg.MaxGroupMembers, apperrors.ErrInvalidInput)
+	}
+
+	// Start a database transaction
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	// Defer a rollback in case of error, commit will override this if successful
+	defer tx.Rollback()
+
+	in.Description = description
+	group, err := createGroupTx(ctx, tx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	// If all went well, commit the transaction
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	s.emitGroupCreated(group, len(members))
+
+	return group, nil
+}
+
+// createGroupTx inserts a group and its memberships within tx. The input
+// must already be validated and its description sanitized.
+func createGroupTx(ctx context.Context, tx *sql.Tx, in CreateGroupInput) (*models.Group, error) {
+	// 1. Create the group
+	group := models.Group{
+		ID:                uuid.New(),
+		Name:              in.Name,
+		Description:       in.Description,
+		CreatedBy:         in.CreatorID,
+		CreatedAt:         time.Now().UTC(),
+		UpdatedAt:         time.Now().UTC(),
+		EncryptedMetadata: in.EncryptedMetadata,
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO groups (id, name, description, created_by, created_at, updated_at, encrypted_metadata)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))
+	`, group.ID, group.Name, group.Description, group.CreatedBy, group.CreatedAt, group.UpdatedAt, group.EncryptedMetadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create group: %w", err)
+	}
+
+	// 2. Add the creator as an admin member
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO group_members (group_id, user_id, role)
+		VALUES ($1, $2, 'admin')
+	`, group.ID, in.CreatorID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add creator to group: %w", err)
+	}
+
+	// 3. Add the other members
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO group_members (group_id, user_id, role) VALUES ($1, $2, 'member') ON CONFLICT (group_id, user_id) DO NOTHING")
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare member insertion: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, memberID := range in.MemberIDs {
+		if _, err := stmt.ExecContext(ctx, group.ID, memberID); err != nil {
+			return nil, fmt.Errorf("failed to add member to group: %w", err)
+		}
+	}
+
+	return &group, nil
+}
+
+// PromoteConversationInput describes turning a direct conversation into a
+// group by adding people to it
+type PromoteConversationInput struct {
+	UserID            uuid.UUID
+	PeerID            uuid.UUID
+	Name              string
+	Description       string
+	MemberIDs         []uuid.UUID
+	EncryptedMetadata string
+}
+
+// PromoteConversationToGroup creates a group seeded with both participants of
+// a direct conversation plus the added members, with the user as its admin.
+// Direct and group messages are encrypted for different sessions, so the
+// history stays in the direct conversation and the group starts fresh with a
+// "conversation_promoted" system message naming everyone who was added.
+func (s *Service) PromoteConversationToGroup(ctx context.Context, in PromoteConversationInput) (*models.Group, error) {
+	if err := checkGroupMetadata(in.Name, in.Description, in.EncryptedMetadata); err != nil {
+		return nil, err
+	}
+	description, err := sanitizeDescription(in.Description)
+	if err != nil {
+		return nil, err
+	}
+
+	kind, err := s.resolveConversation(ctx, in.UserID, in.PeerID)
+	if err != nil {
+		return nil, err
+	}
+	if kind != "dm" {
+		return nil, fmt.Errorf("conversation is already a group: %w", apperrors.ErrInvalidInput)
+	}
+
+	var exists, blocked bool
+	err = s.db.QueryRowContext(ctx, `
+		SELECT
+			EXISTS (
+				SELECT 1 FROM messages
+				WHERE (sender_id = $1 AND recipient_id = $2) OR (sender_id = $2 AND recipient_id = $1)
+			),
+			EXISTS (
+				SELECT 1 FROM blocks
+				WHERE (blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1)
+			)
+	`, in.UserID, in.PeerID).Scan(&exists, &blocked)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up conversation: %w", err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("conversation not found: %w", apperrors.ErrNotFound)
+	}
+	if blocked {
+		return nil, fmt.Errorf("cannot add people to a conversation with a blocked user: %w", apperrors.ErrForbidden)
+	}
+
+	// Everyone but the user ends up a member, the peer first
+	added := []uuid.UUID{in.PeerID}
+	seen := map[uuid.UUID]bool{in.UserID: true, in.PeerID: true}
+	for _, memberID := range in.MemberIDs {
+		if !seen[memberID] {
+			seen[memberID] = true
+			added = append(added, memberID)
+		}
+	}
+	if len(added) < 2 {
+		return nil, fmt.Errorf("add at least one person besides the conversation's participants: %w", apperrors.ErrInvalidInput)
+	}
+
+	if len(added)+1 > s.cfg.MaxGroupMembers {
+		return nil, fmt.Errorf("a group can have at most %d members: %w", s.cfg.MaxGroupMembers, apperrors.ErrInvalidInput)
+	}
+	var known int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE id = ANY($1::uuid[])", uuidArray(added)).Scan(&known); err != nil {
+		return nil, fmt.Errorf("failed to look up users: %w", err)
+	}
+	if known != len(added) {
+		return nil, apperrors.ErrUserNotFound
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	group, err := createGroupTx(ctx, tx, CreateGroupInput{
+		CreatorID:         in.UserID,
+		Name:              in.Name,
+		Description:       description,
+		MemberIDs:         added,
+		EncryptedMetadata: in.EncryptedMetadata,
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = insertSystemMessageTx(ctx, tx, group.ID, models.SystemEvent{
+		Action:    systemConversationPromoted,
+		ActorID:   in.UserID,
+		TargetIDs: added,
+		Name:      group.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	s.wakeOutboxRelay()
+	s.emitGroupCreated(group, len(added)+1)
+
+	return group, nil
+}
+
+// GetGroup returns a group's details to one of its members
+func (s *Service) GetGroup(ctx context.Context, groupID, userID uuid.UUID) (*models.Group, error) {
+	if err := s.RequireGroupMember(ctx, groupID, userID); err != nil {
+		return nil, err
+	}
+	return s.loadGroup(ctx, groupID)
+}
+
+// ListGroupMembers returns a group's members, admins first and then by when
+// they joined, to one of its members. It returns apperrors.ErrGroupNotFound
+// for a group that does not exist.
+func (s *Service) ListGroupMembers(ctx context.Context, groupID, userID uuid.UUID) ([]models.GroupMemberProfile, error) {
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM groups WHERE id = $1)", groupID).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to look up group: %w", err)
+	}
+	if !exists {
+		return nil, apperrors.ErrGroupNotFound
+	}
+	if err := s.RequireGroupMember(ctx, groupID, userID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT u.id, u.username, COALESCE(u.avatar_url, ''), gm.role, gm.joined_at
+		FROM group_members gm
+		JOIN users u ON u.id = gm.user_id
+		WHERE gm.group_id = $1
+		ORDER BY gm.role = 'admin' DESC, gm.joined_at, gm.user_id
+	`, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list group members: %w", err)
+	}
+	defer rows.Close()
+
+	members := []models.GroupMemberProfile{}
+	for rows.Next() {
+		var member models.GroupMemberProfile
+		if err := rows.Scan(&member.User.ID, &member.User.Username, &member.User.AvatarURL, &member.Role, &member.JoinedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan group member: %w", err)
+		}
+		members = append(members, member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list group members: %w", err)
+	}
+	return members, nil
+}
+
+// ListGroups returns the groups the user belongs to, most recently active first
+func (s *Service) ListGroups(ctx context.Context, userID uuid.UUID) ([]models.GroupSummary, error) {
+	rows, err := s.db.QueryContext(database.WithLabel(ctx, "list_groups"), `
+		SELECT g.id, g.name, COALESCE(g.description, ''), g.created_by, g.created_at, g.updated_at,
+			COALESCE(g.encrypted_metadata, ''), gm.role,
+			(SELECT COUNT(*) FROM group_members c WHERE c.group_id = g.id),
+			COALESCE(
+				(SELECT MAX(m.created_at) FROM messages m WHERE m.group_id = g.id AND m.deleted_at IS NULL),
+				gm.joined_at
+			) AS last_activity_at,
+			(SELECT COUNT(*) FROM messages m
+			 WHERE m.group_id = g.id AND m.sender_id != $1 AND m.message_type != 'system'
+			   AND m.created_at >= gm.joined_at AND m.deleted_at IS NULL
+			   AND NOT EXISTS (SELECT 1 FROM receipts r WHERE r.message_id = m.id AND r.user_id = $1 AND r.type = 'read'))
+		FROM group_members gm
+		JOIN groups g ON g.id = gm.group_id
+		WHERE gm.user_id = $1
+		ORDER BY last_activity_at DESC, g.name
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list groups: %w", err)
+	}
+	defer rows.Close()
+
+	groups := []models.GroupSummary{}
+	for rows.Next() {
+		var group models.GroupSummary
+		err := rows.Scan(
+			&group.ID, &group.Name, &group.Description, &group.CreatedBy, &group.CreatedAt, &group.UpdatedAt,
+			&group.EncryptedMetadata, &group.Role,
+			&group.MemberCount, &group.LastActivityAt, &group.UnreadCount,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan group: %w", err)
+		}
+		groups = append(groups, group)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list groups: %w", err)
+	}
+	return groups, nil
+}
+
+// UpdateGroup changes a group's name and/or description, or its encrypted
+// metadata. Only group admins may do this. Members are sent a
+// "group_updated" event.
+func (s *Service) UpdateGroup(ctx context.Context, in UpdateGroupInput) (*models.Group, error) {
+	var name, description, encryptedMetadata sql.NullString
+	if in.EncryptedMetadata != nil {
+		if in.Name != nil || in.Description != nil {
+			return nil, fmt.Errorf("groups with encrypted metadata cannot have a plaintext name or description: %w", apperrors.ErrInvalidInput)
+		}
+		if err := checkEncryptedMetadata(*in.EncryptedMetadata); err != nil {
+			return nil, err
+		}
+		encryptedMetadata = sql.NullString{String: *in.EncryptedMetadata, Valid: true}
+	}
+	if in.Name != nil {
+		trimmed := strings.TrimSpace(*in.Name)
+		if trimmed == "" || utf8.RuneCountInString(trimmed) > 255 {
+			return nil, fmt.Errorf("group name must be between 1 and 255 characters: %w", apperrors.ErrInvalidInput)
+		}
+		name = sql.NullString{String: trimmed, Valid: true}
+	}
+	if in.Description != nil {
+		sanitized, err := sanitizeDescription(*in.Description)
+		if err != nil {
+			return nil, err
+		}
+		description = sql.NullString{String: sanitized, Valid: true}
+	}
+
+	if err := s.requireGroupAdmin(ctx, in.GroupID, in.UserID); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var previousName string
+	var encrypted bool
+	err = tx.QueryRowContext(ctx, "SELECT name, encrypted_metadata IS NOT NULL FROM groups WHERE id = $1 FOR UPDATE", in.GroupID).Scan(&previousName, &encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load group: %w", err)
+	}
+	if encrypted && (name.Valid || description.Valid) {
+		return nil, fmt.Errorf("group metadata is encrypted, update encrypted_metadata instead: %w", apperrors.ErrInvalidInput)
+	}
+
+	// Opting into encrypted metadata drops the plaintext name and description
+	_, err = tx.ExecContext(ctx, `
+		UPDATE groups
+		SET name = CASE WHEN $3::text IS NULL THEN COALESCE($1, name) ELSE '' END,
+			description = CASE WHEN $3::text IS NULL THEN COALESCE($2, description) ELSE '' END,
+			encrypted_metadata = COALESCE($3, encrypted_metadata),
+			updated_at = NOW()
+		WHERE id = $4
+	`, name, description, encryptedMetadata, in.GroupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update group: %w", err)
+	}
+
+	renamed := name.Valid && name.String != previousName
+	if renamed {
+		err := insertSystemMessageTx(ctx, tx, in.GroupID, models.SystemEvent{
+			Action:  systemGroupRenamed,
+			ActorID: in.UserID,
+			Name:    name.String,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	if renamed {
+		s.wakeOutboxRelay()
+	}
+
+	group, err := s.loadGroup(ctx, in.GroupID)
+	if err != nil {
+		return nil, err
+	}
+	s.notifyGroupMembers(ctx, in.GroupID, websocket.GroupUpdatedEvent(*group))
+	return group, nil
+}
+
+// loadGroup fetches a group by ID
+func (s *Service) loadGroup(ctx context.Context, groupID uuid.UUID) (*models.Group, error) {
+	var group models.Group
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, name, COALESCE(description, ''), created_by, created_at, updated_at, COALESCE(encrypted_metadata, '')
+		FROM groups WHERE id = $1
+	`, groupID).Scan(&group.ID, &group.Name, &group.Description, &group.CreatedBy, &group.CreatedAt, &group.UpdatedAt, &group.EncryptedMetadata)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperrors.ErrGroupNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load group: %w", err)
+	}
+	return &group, nil
+}
+
+// requireGroupAdmin returns apperrors.ErrNotGroupMember unless the user belongs
+// to the group, and apperrors.ErrForbidden unless they are one of its admins
+func (s *Service) requireGroupAdmin(ctx context.Context, groupID, userID uuid.UUID) error {
+	var role string
+	err := s.db.QueryRowContext(ctx, "SELECT role FROM group_members WHERE group_id = $1 AND user_id = $2", groupID, userID).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return apperrors.ErrNotGroupMember
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check group role: %w", err)
+	}
+	if role != "admin" {
+		return fmt.Errorf("only group admins can do this: %w", apperrors.ErrForbidden)
+	}
+	return nil
+}
+
+// RequireGroupMember returns apperrors.ErrNotGroupMember unless the user belongs to the group
+func (s *Service) RequireGroupMember(ctx context.Context, groupID, userID uuid.UUID) error {
+	var memberCount int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM group_members WHERE group_id = $1 AND user_id = $2", groupID, userID).Scan(&memberCount)
+	if err != nil {
+		return fmt.Errorf("failed to check group membership: %w", err)
+	}
+	if memberCount == 0 {
+		return apperrors.ErrNotGroupMember
+	}
+	return nil
+}
+
+// SetNotificationLevel changes how the user is notified about a group's messages
+func (s *Service) SetNotificationLevel(ctx context.Context, groupID, userID uuid.UUID, level string) error {
+	if level != "all" && level != "mentions" && level != "none" {
+		return fmt.Errorf("level must be all, mentions or none: %w", apperrors.ErrInvalidInput)
+	}
+
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE group_members SET notification_level = $1 WHERE group_id = $2 AND user_id = $3
+	`, level, groupID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update notification level: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return apperrors.ErrNotGroupMember
+	}
+	return nil
+}
+
+// AddGroupMembers adds users to a group. Only group admins may do this. Users
+// who already belong to the group are skipped, so adding is idempotent. It
+// returns the users that were actually added.
+func (s *Service) AddGroupMembers(ctx context.Context, groupID, actorID uuid.UUID, userIDs []uuid.UUID) ([]uuid.UUID, error) {
+	if len(userIDs) == 0 {
+		return nil, fmt.Errorf("at least one user_id is required: %w", apperrors.ErrInvalidInput)
+	}
+	if err := s.requireGroupAdmin(ctx, groupID, actorID); err != nil {
+		return nil, err
+	}
+
+	unique := make(map[uuid.UUID]bool, len(userIDs))
+	for _, userID := range userIDs {
+		unique[userID] = true
+	}
+	var known int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE id = ANY($1::uuid[])", uuidArray(userIDs)).Scan(&known); err != nil {
+		return nil, fmt.Errorf("failed to look up users: %w", err)
+	}
+	if known != len(unique) {
+		return nil, apperrors.ErrUserNotFound
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Serialize additions so concurrent requests cannot overshoot the size limit
+	if _, err := tx.ExecContext(ctx, "SELECT id FROM groups WHERE id = $1 FOR UPDATE", groupID); err != nil {
+		return nil, fmt.Errorf("failed to lock group: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		INSERT INTO group_members (group_id, user_id, role)
+		SELECT $1, user_id, 'member' FROM unnest($2::uuid[]) AS user_id
+		ON CONFLICT (group_id, user_id) DO NOTHING
+		RETURNING user_id
+	`, groupID, uuidArray(userIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to add group members: %w", err)
+	}
+	added := []uuid.UUID{}
+	for rows.Next() {
+		var userID uuid.UUID
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan added member: %w", err)
+		}
+		added = append(added, userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to add group members: %w", err)
+	}
+	if len(added) == 0 {
+		return added, nil
+	}
+	// Invitations for the new members are moot
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM group_invitations WHERE group_id = $1 AND invitee_id = ANY($2::uuid[])
+	`, groupID, uuidArray(added)); err != nil {
+		return nil, fmt.Errorf("failed to clear group invitations: %w", err)
+	}
+
+	var memberCount int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM group_members WHERE group_id = $1", groupID).Scan(&memberCount); err != nil {
+		return nil, fmt.Errorf("failed to count group members: %w", err)
+	}
+	if memberCount > s.cfg.MaxGroupMembers {
+		return nil, fmt.Errorf("a group can have at most %d members: %w", s.cfg.MaxGroupMembers, apperrors.ErrInvalidInput)
+	}
+
+	err = insertSystemMessageTx(ctx, tx, groupID, models.SystemEvent{
+		Action:    systemMemberAdded,
+		ActorID:   actorID,
+		TargetIDs: added,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	s.wakeOutboxRelay()
+
+	for _, userID := range added {
+		s.notifyMembershipChanged(ctx, websocket.GroupMembershipPayload{
+			GroupID:     groupID,
+			UserID:      userID,
+			Action:      "added",
+			ChangedBy:   actorID,
+			MemberCount: memberCount,
+		})
+	}
+	return added, nil
+}
+
+// RemoveGroupMember removes a member from a group. Only group admins may remove
+// other members. Removing the owner hands the group to the longest-standing
+// admin. Remaining members and the removed user are told the group's new
+// member count.
+func (s *Service) RemoveGroupMember(ctx context.Context, groupID, actorID, memberID uuid.UUID) error {
+	if actorID == memberID {
+		return fmt.Errorf("you cannot remove yourself from a group: %w", apperrors.ErrInvalidInput)
+	}
+	if err := s.requireGroupAdmin(ctx, groupID, actorID); err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Serialize with departures, which also reassign ownership
+	if _, err := tx.ExecContext(ctx, "SELECT id FROM groups WHERE id = $1 FOR UPDATE", groupID); err != nil {
+		return fmt.Errorf("failed to lock group: %w", err)
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM group_members WHERE group_id = $1 AND user_id = $2", groupID, memberID)
+	if err != nil {
+		return fmt.Errorf("failed to remove group member: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("user is not a member of this group: %w", apperrors.ErrNotFound)
+	}
+	if err := reassignOwnerTx(ctx, tx, groupID, memberID); err != nil {
+		return err
+	}
+
+	var memberCount int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM group_members WHERE group_id = $1", groupID).Scan(&memberCount); err != nil {
+		return fmt.Errorf("failed to count group members: %w", err)
+	}
+
+	err = insertSystemMessageTx(ctx, tx, groupID, models.SystemEvent{
+		Action:    systemMemberRemoved,
+		ActorID:   actorID,
+		TargetIDs: []uuid.UUID{memberID},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	s.wakeOutboxRelay()
+
+	s.notifyMembershipChanged(ctx, websocket.GroupMembershipPayload{
+		GroupID:     groupID,
+		UserID:      memberID,
+		Action:      "removed",
+		ChangedBy:   actorID,
+		MemberCount: memberCount,
+	})
+	return nil
+}
+
+// TransferOwnershipInput hands a group over to another member
+type TransferOwnershipInput struct {
+	GroupID    uuid.UUID
+	UserID     uuid.UUID
+	NewOwnerID uuid.UUID
+	// Make the previous owner a plain member instead of keeping them admin
+	DemotePreviousOwner bool
+}
+
+// TransferGroupOwnership makes another member the group's owner and an admin.
+// Only the current owner may do this. Members are sent a "group_updated" event.
+func (s *Service) TransferGroupOwnership(ctx context.Context, in TransferOwnershipInput) (*models.Group, error) {
+	if in.NewOwnerID == in.UserID {
+		return nil, fmt.Errorf("you already own this group: %w", apperrors.ErrInvalidInput)
+	}
+	if err := s.RequireGroupMember(ctx, in.GroupID, in.UserID); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var ownerID uuid.UUID
+	err = tx.QueryRowContext(ctx, "SELECT created_by FROM groups WHERE id = $1 FOR UPDATE", in.GroupID).Scan(&ownerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperrors.ErrGroupNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load group: %w", err)
+	}
+	if ownerID != in.UserID {
+		return nil, fmt.Errorf("only the group owner can transfer ownership: %w", apperrors.ErrForbidden)
+	}
+
+	result, err := tx.ExecContext(ctx, "UPDATE group_members SET role = 'admin' WHERE group_id = $1 AND user_id = $2", in.GroupID, in.NewOwnerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to promote new owner: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return nil, fmt.Errorf("the new owner must be a member of the group: %w", apperrors.ErrInvalidInput)
+	}
+	if in.DemotePreviousOwner {
+		if _, err := tx.ExecContext(ctx, "UPDATE group_members SET role = 'member' WHERE group_id = $1 AND user_id = $2", in.GroupID, in.UserID); err != nil {
+			return nil, fmt.Errorf("failed to demote previous owner: %w", err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE groups SET created_by = $1, updated_at = $2 WHERE id = $3", in.NewOwnerID, time.Now().UTC(), in.GroupID); err != nil {
+		return nil, fmt.Errorf("failed to transfer group ownership: %w", err)
+	}
+	if err := recordGroupAudit(ctx, tx, in.GroupID, in.UserID, auditOwnershipTransferred, in.NewOwnerID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	group, err := s.loadGroup(ctx, in.GroupID)
+	if err != nil {
+		return nil, err
+	}
+	s.notifyGroupMembers(ctx, in.GroupID, websocket.GroupUpdatedEvent(*group))
+	return group, nil
+}
+
+// notifyGroupMembers sends an event to every member of the group
+func (s *Service) notifyGroupMembers(ctx context.Context, groupID uuid.UUID, event websocket.Message) {
+	if _, err := s.SendToGroup(ctx, GroupFanout{GroupID: groupID}, event); err != nil {
+		log.Printf("Failed to notify group %s: %v", groupID, err)
+	}
+}
+
+// groupDeparture records the outcome of a member leaving a group
+type groupDeparture struct {
+	groupID     uuid.UUID
+	promotedID  uuid.UUID // uuid.Nil when no admin had to be promoted
+	memberCount int
+	// Attachment files of a deleted group, removed once the departure commits
+	files releasedFiles
+}
+
+// departGroupTx removes userID from the group within tx. If they were the last
+// admin, the longest-standing remaining member is promoted. Ownership moves
+// to an admin so the group is not cascade-deleted along with its creator, and
+// a group left without members is deleted along with its messages, whose
+// attachment files the caller removes after committing.
+func departGroupTx(ctx context.Context, tx *sql.Tx, groupID, userID uuid.UUID) (groupDeparture, error) {
+	departure := groupDeparture{groupID: groupID}
+
+	// Serialize departures so two admins leaving at once cannot both skip the promotion
+	if _, err := tx.ExecContext(ctx, "SELECT id FROM groups WHERE id = $1 FOR UPDATE", groupID); err != nil {
+		return departure, fmt.Errorf("failed to lock group: %w", err)
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM group_members WHERE group_id = $1 AND user_id = $2", groupID, userID)
+	if err != nil {
+		return departure, fmt.Errorf("failed to remove group member: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return departure, apperrors.ErrNotGroupMember
+	}
+
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM group_members WHERE group_id = $1", groupID).Scan(&departure.memberCount); err != nil {
+		return departure, fmt.Errorf("failed to count group members: %w", err)
+	}
+	if departure.memberCount == 0 {
+		// The messages cascade, but their attachments' blobs must be released
+		var messageIDs []uuid.UUID
+		rows, err := tx.QueryContext(ctx, `
+			SELECT DISTINCT m.id FROM messages m
+			JOIN attachments a ON a.message_id = m.id
+			WHERE m.group_id = $1
+		`, groupID)
+		if err != nil {
+			return departure, fmt.Errorf("failed to get group attachments: %w", err)
+		}
+		for rows.Next() {
+			var messageID uuid.UUID
+			if err := rows.Scan(&messageID); err != nil {
+				rows.Close()
+				return departure, fmt.Errorf("failed to scan message: %w", err)
+			}
+			messageIDs = append(messageIDs, messageID)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return departure, fmt.Errorf("failed to get group attachments: %w", err)
+		}
+		departure.files, err = deleteAttachmentsTx(ctx, tx, messageIDs)
+		if err != nil {
+			return departure, err
+		}
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM groups WHERE id = $1", groupID); err != nil {
+			return departure, fmt.Errorf("failed to delete empty group: %w", err)
+		}
+		return departure, nil
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE group_members SET role = 'admin'
+		WHERE id = (
+			SELECT id FROM group_members WHERE group_id = $1 ORDER BY joined_at, user_id LIMIT 1
+		)
+		AND NOT EXISTS (SELECT 1 FROM group_members WHERE group_id = $1 AND role = 'admin')
+		RETURNING user_id
+	`, groupID).Scan(&departure.promotedID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return departure, fmt.Errorf("failed to promote group admin: %w", err)
+	}
+
+	if err := reassignOwnerTx(ctx, tx, groupID, userID); err != nil {
+		return departure, err
+	}
+	return departure, nil
+}
+
+// reassignOwnerTx hands the group over to its longest-standing admin, or
+// member if it has no admin, when userID owns it. Groups stay owned by a
+// member, so created_by's ON DELETE CASCADE never takes a group along with
+// the account of someone who is no longer in it.
+func reassignOwnerTx(ctx context.Context, tx *sql.Tx, groupID, userID uuid.UUID) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE groups SET
+			created_by = (
+				SELECT user_id FROM group_members WHERE group_id = $1
+				ORDER BY role = 'admin' DESC, joined_at, user_id LIMIT 1
+			),
+			updated_at = $3
+		WHERE id = $1 AND created_by = $2
+		  AND EXISTS (SELECT 1 FROM group_members WHERE group_id = $1)
+	`, groupID, userID, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to transfer group ownership: %w", err)
+	}
+	return nil
+}
+
+// LeaveGroup removes the user from a group. If they were its last admin, the
+// earliest-joined remaining member is promoted; if they were its last
+// member, the group is deleted along with its messages. Otherwise a
+// "member_left" system message is posted and the remaining members are sent
+// "group_membership_changed" events.
+func (s *Service) LeaveGroup(ctx context.Context, groupID, userID uuid.UUID) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	departure, err := departGroupTx(ctx, tx, groupID, userID)
+	if err != nil {
+		return err
+	}
+	if departure.memberCount > 0 {
+		err = insertSystemMessageTx(ctx, tx, groupID, models.SystemEvent{
+			Action:  systemMemberLeft,
+			ActorID: userID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	s.wakeOutboxRelay()
+	s.removeReleasedFiles(ctx, departure.files)
+
+	s.notifyDeparture(ctx, userID, departure)
+	return nil
+}
+
+// notifyDeparture tells a group's remaining members that userID left and,
+// when it happened, which member was promoted to admin in their place
+func (s *Service) notifyDeparture(ctx context.Context, userID uuid.UUID, departure groupDeparture) {
+	if departure.memberCount == 0 {
+		return
+	}
+	s.notifyMembershipChanged(ctx, websocket.GroupMembershipPayload{
+		GroupID:     departure.groupID,
+		UserID:      userID,
+		Action:      "left",
+		ChangedBy:   userID,
+		MemberCount: departure.memberCount,
+	})
+	if departure.promotedID != uuid.Nil {
+		s.notifyMembershipChanged(ctx, websocket.GroupMembershipPayload{
+			GroupID:     departure.groupID,
+			UserID:      departure.promotedID,
+			Action:      "promoted",
+			ChangedBy:   userID,
+			MemberCount: departure.memberCount,
+		})
+	}
+}
+
+// notifyMembershipChanged sends a "group_membership_changed" event to the
+// group's current members and to the user whose membership changed
+func (s *Service) notifyMembershipChanged(ctx context.Context, change websocket.GroupMembershipPayload) {
+	event := websocket.GroupMembershipChangedEvent(change)
+
+	deliveries, err := s.SendToGroup(ctx, GroupFanout{GroupID: change.GroupID}, event)
+	if err != nil {
+		log.Printf("Failed to notify group %s of a membership change: %v", change.GroupID, err)
+		return
+	}
+	for _, delivery := range deliveries {
+		if delivery.UserID == change.UserID {
+			return
+		}
+	}
+	s.hub.SendToUser(change.UserID.String(), event)
+}
@@ -0,0 +1,827 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/service"
+	"e2ee-messenger/server/internal/testutil"
+	"e2ee-messenger/server/internal/websocket"
+
+	"github.com/google/uuid"
+)
+
+// createGroup creates a group owned by owner with the given members
+func createGroup(t *testing.T, svc *service.Service, owner models.User, members ...models.User) *models.Group {
+	t.Helper()
+	input := service.CreateGroupInput{CreatorID: owner.ID, Name: "Friends"}
+	for _, member := range members {
+		input.MemberIDs = append(input.MemberIDs, member.ID)
+	}
+	group, err := svc.CreateGroup(context.Background(), input)
+	if err != nil {
+		t.Fatalf("CreateGroup failed: %v", err)
+	}
+	return group
+}
+
+func TestMentionsOnlyMemberNotifiedWhenMentioned(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	carol := testutil.CreateUser(t, db, "carol")
+	group := createGroup(t, svc, alice, bob, carol)
+
+	if err := svc.SetNotificationLevel(context.Background(), group.ID, bob.ID, "mentions"); err != nil {
+		t.Fatalf("SetNotificationLevel failed: %v", err)
+	}
+	bobClient := testutil.ConnectClient(t, hub, bob.ID)
+	carolClient := testutil.ConnectClient(t, hub, carol.ID)
+
+	send := func(mentions ...uuid.UUID) {
+		t.Helper()
+		_, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+			SenderID:         alice.ID,
+			GroupID:          &group.ID,
+			EncryptedContent: "ciphertext",
+			MessageType:      "text",
+			Mentions:         mentions,
+		})
+		if err != nil {
+			t.Fatalf("SendMessage failed: %v", err)
+		}
+	}
+
+	send()
+	testutil.ExpectEvent(t, carolClient, "new_message")
+	testutil.ExpectNoEvent(t, bobClient)
+
+	send(bob.ID)
+	testutil.ExpectEvent(t, carolClient, "new_message")
+	msg := testutil.ExpectEvent(t, bobClient, "new_message")
+	payload := msg.Payload.(map[string]interface{})
+	if mentions, _ := payload["mentions"].([]interface{}); len(mentions) != 1 || mentions[0] != bob.ID.String() {
+		t.Errorf("Expected the event to carry bob's mention, got %v", payload["mentions"])
+	}
+}
+
+func TestNoneLevelSilencesGroup(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	group := createGroup(t, svc, alice, bob)
+
+	if err := svc.SetNotificationLevel(context.Background(), group.ID, bob.ID, "none"); err != nil {
+		t.Fatalf("SetNotificationLevel failed: %v", err)
+	}
+	bobClient := testutil.ConnectClient(t, hub, bob.ID)
+
+	_, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         alice.ID,
+		GroupID:          &group.ID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "text",
+		Mentions:         []uuid.UUID{bob.ID},
+	})
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	testutil.ExpectNoEvent(t, bobClient)
+}
+
+func TestSetNotificationLevelRequiresMembership(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	mallory := testutil.CreateUser(t, db, "mallory")
+	group := createGroup(t, svc, alice)
+
+	err := svc.SetNotificationLevel(context.Background(), group.ID, mallory.ID, "none")
+	if !errors.Is(err, apperrors.ErrNotGroupMember) {
+		t.Errorf("Expected ErrNotGroupMember, got %v", err)
+	}
+
+	err = svc.SetNotificationLevel(context.Background(), group.ID, alice.ID, "loud")
+	if !errors.Is(err, apperrors.ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput, got %v", err)
+	}
+}
+
+func TestUpdateGroupDescriptionStripsControlCharacters(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	group := createGroup(t, svc, alice)
+
+	description := "  **Weekend** plans\r\n- hiking\x00\x1b[31m\tand\x07 food  "
+	updated, err := svc.UpdateGroup(context.Background(), service.UpdateGroupInput{
+		GroupID:     group.ID,
+		UserID:      alice.ID,
+		Description: &description,
+	})
+	if err != nil {
+		t.Fatalf("UpdateGroup failed: %v", err)
+	}
+
+	expected := "**Weekend** plans\n- hiking[31m\tand food"
+	if updated.Description != expected {
+		t.Errorf("Expected description %q, got %q", expected, updated.Description)
+	}
+	if updated.Name != group.Name {
+		t.Errorf("Expected name to stay %q, got %q", group.Name, updated.Name)
+	}
+}
+
+func TestUpdateGroupNotifiesMembers(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	group := createGroup(t, svc, alice, bob)
+	bobClient := testutil.ConnectClient(t, hub, bob.ID)
+
+	name, description := "Hikers", "Weekend trips"
+	_, err := svc.UpdateGroup(context.Background(), service.UpdateGroupInput{
+		GroupID:     group.ID,
+		UserID:      alice.ID,
+		Name:        &name,
+		Description: &description,
+	})
+	if err != nil {
+		t.Fatalf("UpdateGroup failed: %v", err)
+	}
+
+	// The rename's system message is relayed separately
+	events := testutil.ExpectEvents(t, bobClient, websocket.EventGroupUpdated, websocket.EventNewMessage)
+	payload := events[websocket.EventGroupUpdated][0].Payload.(map[string]interface{})
+	if payload["name"] != name || payload["description"] != description {
+		t.Errorf("Expected the new name and description, got %v", payload)
+	}
+}
+
+func TestUpdateGroupDescriptionLengthLimit(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	group := createGroup(t, svc, alice)
+
+	// Multi-byte characters count once each
+	atLimit := strings.Repeat("é", 1024)
+	if _, err := svc.UpdateGroup(context.Background(), service.UpdateGroupInput{GroupID: group.ID, UserID: alice.ID, Description: &atLimit}); err != nil {
+		t.Fatalf("Expected a 1024 character description to be accepted: %v", err)
+	}
+
+	tooLong := atLimit + "!"
+	_, err := svc.UpdateGroup(context.Background(), service.UpdateGroupInput{GroupID: group.ID, UserID: alice.ID, Description: &tooLong})
+	if !errors.Is(err, apperrors.ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput, got %v", err)
+	}
+}
+
+func TestUpdateGroupRequiresAdmin(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	mallory := testutil.CreateUser(t, db, "mallory")
+	group := createGroup(t, svc, alice, bob)
+
+	description := "Bob was here"
+	_, err := svc.UpdateGroup(context.Background(), service.UpdateGroupInput{GroupID: group.ID, UserID: bob.ID, Description: &description})
+	if !errors.Is(err, apperrors.ErrForbidden) {
+		t.Errorf("Expected ErrForbidden for a non-admin member, got %v", err)
+	}
+
+	_, err = svc.UpdateGroup(context.Background(), service.UpdateGroupInput{GroupID: group.ID, UserID: mallory.ID, Description: &description})
+	if !errors.Is(err, apperrors.ErrNotGroupMember) {
+		t.Errorf("Expected ErrNotGroupMember for an outsider, got %v", err)
+	}
+}
+
+func TestListGroupMembers(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	carol := testutil.CreateUser(t, db, "carol")
+	mallory := testutil.CreateUser(t, db, "mallory")
+	group := createGroup(t, svc, bob, carol)
+	if _, err := svc.AddGroupMembers(context.Background(), group.ID, bob.ID, []uuid.UUID{alice.ID}); err != nil {
+		t.Fatalf("AddGroupMembers failed: %v", err)
+	}
+
+	members, err := svc.ListGroupMembers(context.Background(), group.ID, alice.ID)
+	if err != nil {
+		t.Fatalf("ListGroupMembers failed: %v", err)
+	}
+	if len(members) != 3 {
+		t.Fatalf("Expected 3 members, got %+v", members)
+	}
+	// The admin first, then the others in the order they joined
+	for i, want := range []struct {
+		user models.User
+		role string
+	}{{bob, "admin"}, {carol, "member"}, {alice, "member"}} {
+		if members[i].User.ID != want.user.ID || members[i].User.Username != want.user.Username || members[i].Role != want.role {
+			t.Errorf("Expected member %d to be %s (%s), got %+v", i, want.user.Username, want.role, members[i])
+		}
+	}
+
+	if _, err := svc.ListGroupMembers(context.Background(), group.ID, mallory.ID); !errors.Is(err, apperrors.ErrNotGroupMember) {
+		t.Errorf("Expected ErrNotGroupMember for an outsider, got %v", err)
+	}
+	if _, err := svc.ListGroupMembers(context.Background(), uuid.New(), alice.ID); !errors.Is(err, apperrors.ErrGroupNotFound) {
+		t.Errorf("Expected ErrGroupNotFound for a missing group, got %v", err)
+	}
+}
+
+func TestRemoveGroupMemberBroadcastsMemberCount(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	carol := testutil.CreateUser(t, db, "carol")
+	group := createGroup(t, svc, alice, bob, carol)
+
+	bobClient := testutil.ConnectClient(t, hub, bob.ID)
+	carolClient := testutil.ConnectClient(t, hub, carol.ID)
+
+	if err := svc.RemoveGroupMember(context.Background(), group.ID, alice.ID, bob.ID); err != nil {
+		t.Fatalf("RemoveGroupMember failed: %v", err)
+	}
+
+	for _, client := range []*websocket.Client{carolClient, bobClient} {
+		msg := testutil.ExpectEvent(t, client, "group_membership_changed")
+		payload := msg.Payload.(map[string]interface{})
+		if payload["member_count"] != float64(2) {
+			t.Errorf("Expected member_count 2, got %v", payload["member_count"])
+		}
+		if payload["action"] != "removed" || payload["user_id"] != bob.ID.String() {
+			t.Errorf("Expected bob's removal, got %v", payload)
+		}
+	}
+
+	if err := svc.RequireGroupMember(context.Background(), group.ID, bob.ID); !errors.Is(err, apperrors.ErrNotGroupMember) {
+		t.Errorf("Expected bob to be removed, got %v", err)
+	}
+}
+
+func TestRemoveGroupMemberRequiresAdmin(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	carol := testutil.CreateUser(t, db, "carol")
+	group := createGroup(t, svc, alice, bob, carol)
+
+	err := svc.RemoveGroupMember(context.Background(), group.ID, bob.ID, carol.ID)
+	if !errors.Is(err, apperrors.ErrForbidden) {
+		t.Errorf("Expected ErrForbidden, got %v", err)
+	}
+}
+
+func TestDeleteAccountPromotesNewAdmin(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	carol := testutil.CreateUser(t, db, "carol")
+	group := createGroup(t, svc, alice, bob, carol)
+	bobClient := testutil.ConnectClient(t, hub, bob.ID)
+
+	if err := svc.DeleteAccount(context.Background(), alice.ID); err != nil {
+		t.Fatalf("DeleteAccount failed: %v", err)
+	}
+
+	var admins int
+	if err := db.QueryRow("SELECT COUNT(*) FROM group_members WHERE group_id = $1 AND role = 'admin'", group.ID).Scan(&admins); err != nil {
+		t.Fatalf("Failed to count admins: %v", err)
+	}
+	if admins != 1 {
+		t.Errorf("Expected one promoted admin, got %d", admins)
+	}
+
+	// The group outlives its creator
+	if _, err := svc.GetGroup(context.Background(), group.ID, bob.ID); err != nil {
+		t.Errorf("Expected the group to survive its creator's deletion: %v", err)
+	}
+
+	left := testutil.ExpectEvent(t, bobClient, websocket.EventGroupMembershipChanged)
+	if payload := left.Payload.(map[string]interface{}); payload["action"] != "left" || payload["member_count"] != float64(2) {
+		t.Errorf("Expected alice to have left a group of 2, got %v", payload)
+	}
+	promoted := testutil.ExpectEvent(t, bobClient, websocket.EventGroupMembershipChanged)
+	if payload := promoted.Payload.(map[string]interface{}); payload["action"] != "promoted" {
+		t.Errorf("Expected a promotion, got %v", payload)
+	}
+}
+
+func TestLeaveGroupAsMember(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	carol := testutil.CreateUser(t, db, "carol")
+	group := createGroup(t, svc, alice, bob, carol)
+	carolClient := testutil.ConnectClient(t, hub, carol.ID)
+
+	if err := svc.LeaveGroup(context.Background(), group.ID, bob.ID); err != nil {
+		t.Fatalf("LeaveGroup failed: %v", err)
+	}
+
+	events := testutil.ExpectEvents(t, carolClient, websocket.EventGroupMembershipChanged, websocket.EventNewMessage)
+	if payload := events[websocket.EventGroupMembershipChanged][0].Payload.(map[string]interface{}); payload["action"] != "left" || payload["user_id"] != bob.ID.String() || payload["member_count"] != float64(2) {
+		t.Errorf("Expected bob to have left a group of 2, got %v", payload)
+	}
+	system, _ := events[websocket.EventNewMessage][0].Payload.(map[string]interface{})["system"].(map[string]interface{})
+	if system["action"] != "member_left" || system["actor_id"] != bob.ID.String() {
+		t.Errorf("Expected a member_left system message by bob, got %v", system)
+	}
+
+	if err := svc.RequireGroupMember(context.Background(), group.ID, bob.ID); !errors.Is(err, apperrors.ErrNotGroupMember) {
+		t.Errorf("Expected bob to have left, got %v", err)
+	}
+	if err := svc.LeaveGroup(context.Background(), group.ID, bob.ID); !errors.Is(err, apperrors.ErrNotGroupMember) {
+		t.Errorf("Expected ErrNotGroupMember leaving twice, got %v", err)
+	}
+}
+
+func TestLeaveGroupAsLastAdminPromotesEarliestMember(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	carol := testutil.CreateUser(t, db, "carol")
+	group := createGroup(t, svc, alice, bob, carol)
+	if _, err := db.Exec("UPDATE group_members SET joined_at = joined_at + INTERVAL '1 minute' WHERE group_id = $1 AND user_id = $2", group.ID, carol.ID); err != nil {
+		t.Fatalf("Failed to make carol join later: %v", err)
+	}
+	carolClient := testutil.ConnectClient(t, hub, carol.ID)
+
+	if err := svc.LeaveGroup(context.Background(), group.ID, alice.ID); err != nil {
+		t.Fatalf("LeaveGroup failed: %v", err)
+	}
+
+	var role string
+	if err := db.QueryRow("SELECT role FROM group_members WHERE group_id = $1 AND user_id = $2", group.ID, bob.ID).Scan(&role); err != nil {
+		t.Fatalf("Failed to get bob's role: %v", err)
+	}
+	if role != "admin" {
+		t.Errorf("Expected bob, who joined first, to be promoted, got %s", role)
+	}
+	if _, err := svc.GetGroup(context.Background(), group.ID, bob.ID); err != nil {
+		t.Errorf("Expected the group to outlive its creator: %v", err)
+	}
+	events := testutil.ExpectEvents(t, carolClient, websocket.EventGroupMembershipChanged, websocket.EventGroupMembershipChanged, websocket.EventNewMessage)
+	if payload := events[websocket.EventGroupMembershipChanged][1].Payload.(map[string]interface{}); payload["action"] != "promoted" || payload["user_id"] != bob.ID.String() {
+		t.Errorf("Expected bob's promotion, got %v", payload)
+	}
+}
+
+func TestLeaveGroupAsLastMemberDeletesGroup(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	group := createGroup(t, svc, alice, bob)
+	_, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         bob.ID,
+		GroupID:          &group.ID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "text",
+	})
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	for _, user := range []models.User{bob, alice} {
+		if err := svc.LeaveGroup(context.Background(), group.ID, user.ID); err != nil {
+			t.Fatalf("LeaveGroup failed: %v", err)
+		}
+	}
+
+	var groups, messages int
+	if err := db.QueryRow("SELECT COUNT(*) FROM groups WHERE id = $1", group.ID).Scan(&groups); err != nil {
+		t.Fatalf("Failed to count groups: %v", err)
+	}
+	if err := db.QueryRow("SELECT COUNT(*) FROM messages WHERE group_id = $1", group.ID).Scan(&messages); err != nil {
+		t.Fatalf("Failed to count messages: %v", err)
+	}
+	if groups != 0 || messages != 0 {
+		t.Errorf("Expected the group and its messages to be deleted, got %d groups and %d messages", groups, messages)
+	}
+}
+
+func TestOfflineMemberReceivesGroupMessageOnReconnect(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	group := createGroup(t, svc, alice, bob)
+
+	message, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         alice.ID,
+		GroupID:          &group.ID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "text",
+	})
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	// Wait for the relay to record the missed delivery
+	deadline := time.Now().Add(time.Second)
+	for {
+		var attempts int
+		err := db.QueryRow("SELECT attempts FROM message_deliveries WHERE message_id = $1 AND user_id = $2 AND pushed_at IS NULL", message.ID, bob.ID).Scan(&attempts)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a missed delivery to be recorded for bob: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	bobClient := testutil.ConnectClient(t, hub, bob.ID)
+	event := testutil.ExpectEvent(t, bobClient, websocket.EventNewMessage)
+	if payload := event.Payload.(map[string]interface{}); payload["id"] != message.ID.String() {
+		t.Errorf("Expected message %s to be replayed, got %v", message.ID, payload["id"])
+	}
+}
+
+func TestTransferGroupOwnership(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	group := createGroup(t, svc, alice, bob)
+	bobClient := testutil.ConnectClient(t, hub, bob.ID)
+
+	updated, err := svc.TransferGroupOwnership(context.Background(), service.TransferOwnershipInput{
+		GroupID:             group.ID,
+		UserID:              alice.ID,
+		NewOwnerID:          bob.ID,
+		DemotePreviousOwner: true,
+	})
+	if err != nil {
+		t.Fatalf("TransferGroupOwnership failed: %v", err)
+	}
+	if updated.CreatedBy != bob.ID {
+		t.Errorf("Expected bob to own the group, got %s", updated.CreatedBy)
+	}
+
+	roles := map[uuid.UUID]string{}
+	rows, err := db.Query("SELECT user_id, role FROM group_members WHERE group_id = $1", group.ID)
+	if err != nil {
+		t.Fatalf("Failed to fetch roles: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userID uuid.UUID
+		var role string
+		if err := rows.Scan(&userID, &role); err != nil {
+			t.Fatalf("Failed to scan role: %v", err)
+		}
+		roles[userID] = role
+	}
+	if roles[bob.ID] != "admin" || roles[alice.ID] != "member" {
+		t.Errorf("Expected bob admin and alice member, got %v", roles)
+	}
+
+	var audited int
+	if err := db.QueryRow("SELECT COUNT(*) FROM group_audit_log WHERE group_id = $1 AND action = 'ownership_transferred' AND actor_id = $2 AND target_id = $3", group.ID, alice.ID, bob.ID).Scan(&audited); err != nil {
+		t.Fatalf("Failed to fetch audit log: %v", err)
+	}
+	if audited != 1 {
+		t.Errorf("Expected one audit log entry, got %d", audited)
+	}
+
+	event := testutil.ExpectEvent(t, bobClient, websocket.EventGroupUpdated)
+	if payload := event.Payload.(map[string]interface{}); payload["created_by"] != bob.ID.String() {
+		t.Errorf("Expected the event to name bob as owner, got %v", payload["created_by"])
+	}
+}
+
+func TestTransferGroupOwnershipRequiresOwner(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	carol := testutil.CreateUser(t, db, "carol")
+	group := createGroup(t, svc, alice, bob, carol)
+
+	_, err := svc.TransferGroupOwnership(context.Background(), service.TransferOwnershipInput{
+		GroupID:    group.ID,
+		UserID:     bob.ID,
+		NewOwnerID: carol.ID,
+	})
+	if !errors.Is(err, apperrors.ErrForbidden) {
+		t.Errorf("Expected ErrForbidden for a non-owner, got %v", err)
+	}
+}
+
+func TestAddGroupMembersPostsSystemMessage(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	carol := testutil.CreateUser(t, db, "carol")
+	group := createGroup(t, svc, alice, bob)
+	bobClient := testutil.ConnectClient(t, hub, bob.ID)
+
+	added, err := svc.AddGroupMembers(context.Background(), group.ID, alice.ID, []uuid.UUID{carol.ID, bob.ID})
+	if err != nil {
+		t.Fatalf("AddGroupMembers failed: %v", err)
+	}
+	if len(added) != 1 || added[0] != carol.ID {
+		t.Fatalf("Expected only carol to be added, got %v", added)
+	}
+
+	event := testutil.ExpectEvent(t, bobClient, "new_message")
+	payload := event.Payload.(map[string]interface{})
+	if payload["message_type"] != "system" {
+		t.Fatalf("Expected a system message, got %v", payload["message_type"])
+	}
+	system, _ := payload["system"].(map[string]interface{})
+	if system["action"] != "member_added" || system["actor_id"] != alice.ID.String() {
+		t.Errorf("Expected a member_added event by alice, got %v", system)
+	}
+
+	if _, err := svc.AddGroupMembers(context.Background(), group.ID, bob.ID, []uuid.UUID{uuid.New()}); !errors.Is(err, apperrors.ErrForbidden) {
+		t.Errorf("Expected ErrForbidden for a non-admin, got %v", err)
+	}
+}
+
+func TestSystemMessagesOutliveTheirActor(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	carol := testutil.CreateUser(t, db, "carol")
+	group := createGroup(t, svc, alice, bob)
+	bobClient := testutil.ConnectClient(t, hub, bob.ID)
+
+	if _, err := svc.AddGroupMembers(context.Background(), group.ID, alice.ID, []uuid.UUID{carol.ID}); err != nil {
+		t.Fatalf("AddGroupMembers failed: %v", err)
+	}
+	event := testutil.ExpectEvent(t, bobClient, "new_message")
+	messageID := uuid.MustParse(event.Payload.(map[string]interface{})["id"].(string))
+
+	if err := svc.DeleteMessage(context.Background(), alice.ID, messageID); !errors.Is(err, apperrors.ErrMessageNotFound) {
+		t.Errorf("Expected the actor not to be able to delete a system message, got %v", err)
+	}
+	if err := svc.DeleteAccount(context.Background(), alice.ID); err != nil {
+		t.Fatalf("DeleteAccount failed: %v", err)
+	}
+
+	var remaining int
+	if err := db.QueryRow("SELECT COUNT(*) FROM messages WHERE id = $1 AND deleted_at IS NULL", messageID).Scan(&remaining); err != nil {
+		t.Fatalf("Failed to count messages: %v", err)
+	}
+	if remaining != 1 {
+		t.Error("Expected the system message to survive its actor's account deletion")
+	}
+}
+
+func TestSendMessageRejectsSystemType(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	group := createGroup(t, svc, alice)
+
+	_, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         alice.ID,
+		GroupID:          &group.ID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "system",
+	})
+	if !errors.Is(err, apperrors.ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput, got %v", err)
+	}
+}
+
+func TestPromoteConversationToGroup(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	carol := testutil.CreateUser(t, db, "carol")
+	carolClient := testutil.ConnectClient(t, hub, carol.ID)
+
+	if _, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         alice.ID,
+		RecipientID:      &bob.ID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "text",
+	}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	group, err := svc.PromoteConversationToGroup(context.Background(), service.PromoteConversationInput{
+		UserID:    alice.ID,
+		PeerID:    bob.ID,
+		Name:      "Trip",
+		MemberIDs: []uuid.UUID{carol.ID, bob.ID},
+	})
+	if err != nil {
+		t.Fatalf("PromoteConversationToGroup failed: %v", err)
+	}
+
+	roles := map[uuid.UUID]string{}
+	rows, err := db.Query("SELECT user_id, role FROM group_members WHERE group_id = $1", group.ID)
+	if err != nil {
+		t.Fatalf("Failed to fetch members: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userID uuid.UUID
+		var role string
+		if err := rows.Scan(&userID, &role); err != nil {
+			t.Fatalf("Failed to scan member: %v", err)
+		}
+		roles[userID] = role
+	}
+	if len(roles) != 3 || roles[alice.ID] != "admin" || roles[bob.ID] != "member" || roles[carol.ID] != "member" {
+		t.Errorf("Expected alice as admin with bob and carol as members, got %v", roles)
+	}
+
+	// The group starts fresh: its only message is the system message
+	event := testutil.ExpectEvent(t, carolClient, "new_message")
+	system, _ := event.Payload.(map[string]interface{})["system"].(map[string]interface{})
+	if system["action"] != "conversation_promoted" {
+		t.Errorf("Expected a conversation_promoted system message, got %v", event.Payload)
+	}
+	var groupMessages int
+	if err := db.QueryRow("SELECT COUNT(*) FROM messages WHERE group_id = $1", group.ID).Scan(&groupMessages); err != nil {
+		t.Fatalf("Failed to count group messages: %v", err)
+	}
+	if groupMessages != 1 {
+		t.Errorf("Expected the direct history to stay behind, got %d group messages", groupMessages)
+	}
+}
+
+func TestPromoteConversationToGroupRequiresExistingConversation(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	carol := testutil.CreateUser(t, db, "carol")
+
+	_, err := svc.PromoteConversationToGroup(context.Background(), service.PromoteConversationInput{
+		UserID:    alice.ID,
+		PeerID:    bob.ID,
+		Name:      "Trip",
+		MemberIDs: []uuid.UUID{carol.ID},
+	})
+	if !errors.Is(err, apperrors.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound without any direct messages, got %v", err)
+	}
+}
+
+func TestListGroupsReturnsOnlyTheCallersGroups(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	carol := testutil.CreateUser(t, db, "carol")
+
+	own := createGroup(t, svc, alice, bob)
+	joined := createGroup(t, svc, bob, alice, carol)
+	createGroup(t, svc, bob, carol)
+
+	// bob's message makes the group alice joined the most recently active one
+	if _, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         bob.ID,
+		GroupID:          &joined.ID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "text",
+	}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	groups, err := svc.ListGroups(context.Background(), alice.ID)
+	if err != nil {
+		t.Fatalf("ListGroups failed: %v", err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("Expected alice's 2 groups, got %d", len(groups))
+	}
+	if groups[0].ID != joined.ID || groups[1].ID != own.ID {
+		t.Fatalf("Expected the most recently active group first, got %s then %s", groups[0].ID, groups[1].ID)
+	}
+	if groups[0].Role != "member" || groups[0].MemberCount != 3 || groups[0].UnreadCount != 1 {
+		t.Errorf("Unexpected summary of the joined group: %+v", groups[0])
+	}
+	if groups[1].Role != "admin" || groups[1].MemberCount != 2 || groups[1].UnreadCount != 0 {
+		t.Errorf("Unexpected summary of alice's own group: %+v", groups[1])
+	}
+
+	none, err := svc.ListGroups(context.Background(), testutil.CreateUser(t, db, "dave").ID)
+	if err != nil || len(none) != 0 {
+		t.Errorf("Expected no groups for a user in none, got %v (%v)", none, err)
+	}
+}
+
+func TestEncryptedGroupMetadataIsStoredAndReturned(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	ctx := context.Background()
+
+	group, err := svc.CreateGroup(ctx, service.CreateGroupInput{
+		CreatorID:         alice.ID,
+		MemberIDs:         []uuid.UUID{bob.ID},
+		EncryptedMetadata: "sealed-v1",
+	})
+	if err != nil {
+		t.Fatalf("CreateGroup failed: %v", err)
+	}
+
+	fetched, err := svc.GetGroup(ctx, group.ID, bob.ID)
+	if err != nil {
+		t.Fatalf("GetGroup failed: %v", err)
+	}
+	if fetched.EncryptedMetadata != "sealed-v1" || fetched.Name != "" || fetched.Description != "" {
+		t.Errorf("Expected only encrypted metadata, got %+v", fetched)
+	}
+
+	sealed := "sealed-v2"
+	if _, err := svc.UpdateGroup(ctx, service.UpdateGroupInput{GroupID: group.ID, UserID: alice.ID, EncryptedMetadata: &sealed}); err != nil {
+		t.Fatalf("UpdateGroup failed: %v", err)
+	}
+	groups, err := svc.ListGroups(ctx, bob.ID)
+	if err != nil {
+		t.Fatalf("ListGroups failed: %v", err)
+	}
+	if len(groups) != 1 || groups[0].EncryptedMetadata != sealed {
+		t.Errorf("Expected the updated encrypted metadata, got %+v", groups)
+	}
+
+	// The plaintext name cannot come back once the metadata is encrypted
+	name := "Friends"
+	_, err = svc.UpdateGroup(ctx, service.UpdateGroupInput{GroupID: group.ID, UserID: alice.ID, Name: &name})
+	if !errors.Is(err, apperrors.ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for a plaintext rename, got %v", err)
+	}
+}
+
+func TestUpdateGroupOptsIntoEncryptedMetadata(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	group := createGroup(t, svc, alice)
+
+	sealed := "sealed"
+	updated, err := svc.UpdateGroup(context.Background(), service.UpdateGroupInput{GroupID: group.ID, UserID: alice.ID, EncryptedMetadata: &sealed})
+	if err != nil {
+		t.Fatalf("UpdateGroup failed: %v", err)
+	}
+	if updated.EncryptedMetadata != sealed || updated.Name != "" {
+		t.Errorf("Expected the plaintext name to be dropped, got %+v", updated)
+	}
+}
+
+func TestEncryptedGroupMetadataValidation(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+
+	tests := []struct {
+		name  string
+		input service.CreateGroupInput
+	}{
+		{"neither name nor metadata", service.CreateGroupInput{}},
+		{"plaintext name as well", service.CreateGroupInput{Name: "Friends", EncryptedMetadata: "sealed"}},
+		{"plaintext description as well", service.CreateGroupInput{Description: "Weekend plans", EncryptedMetadata: "sealed"}},
+		{"oversized metadata", service.CreateGroupInput{EncryptedMetadata: strings.Repeat("a", 4097)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := tt.input
+			in.CreatorID = alice.ID
+			if _, err := svc.CreateGroup(context.Background(), in); !errors.Is(err, apperrors.ErrInvalidInput) {
+				t.Errorf("Expected ErrInvalidInput, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRemovedOwnerDeletingAccountKeepsGroup(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	carol := testutil.CreateUser(t, db, "carol")
+	group := createGroup(t, svc, alice, bob, carol)
+	if _, err := db.Exec("UPDATE group_members SET role = 'admin' WHERE group_id = $1 AND user_id = $2", group.ID, bob.ID); err != nil {
+		t.Fatalf("Failed to promote bob: %v", err)
+	}
+
+	if err := svc.RemoveGroupMember(context.Background(), group.ID, bob.ID, alice.ID); err != nil {
+		t.Fatalf("RemoveGroupMember failed: %v", err)
+	}
+	var ownerID uuid.UUID
+	if err := db.QueryRow("SELECT created_by FROM groups WHERE id = $1", group.ID).Scan(&ownerID); err != nil {
+		t.Fatalf("Failed to fetch group: %v", err)
+	}
+	if ownerID != bob.ID {
+		t.Errorf("Expected ownership to move to bob, the remaining admin, got %s", ownerID)
+	}
+
+	// Groups removed owners still own, from before removals moved ownership
+	// on, are handed over when they delete their account
+	if _, err := db.Exec("UPDATE groups SET created_by = $2 WHERE id = $1", group.ID, alice.ID); err != nil {
+		t.Fatalf("Failed to restore the old owner: %v", err)
+	}
+	if err := svc.DeleteAccount(context.Background(), alice.ID); err != nil {
+		t.Fatalf("DeleteAccount failed: %v", err)
+	}
+	if err := db.QueryRow("SELECT created_by FROM groups WHERE id = $1", group.ID).Scan(&ownerID); err != nil {
+		t.Fatalf("Expected the group to survive its former owner's account: %v", err)
+	}
+	if ownerID != bob.ID {
+		t.Errorf("Expected ownership to move to bob, got %s", ownerID)
+	}
+}
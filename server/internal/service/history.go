@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/database"
+	"e2ee-messenger/server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// MessageHistoryQuery selects a page of a conversation's messages. Exactly
+// one of RecipientID and GroupID must be set.
+type MessageHistoryQuery struct {
+	RecipientID *uuid.UUID
+	GroupID     *uuid.UUID
+	// Bounds of the page: an RFC3339 timestamp, the ID of a message in the
+	// conversation, or a next_cursor. Empty when not given.
+	Before string
+	After  string
+	// Limit and, from the older "cursor" parameter, the position to page
+	// backwards from
+	Page database.Page
+}
+
+// MessageHistory returns a page of a conversation's messages. Pages run
+// newest first from Before (or the page's cursor), stopping at After if
+// given; with only After, they run oldest first from it. Either way each
+// page is listed oldest first, and its NextCursor continues in the same
+// direction when passed back as Before or After respectively. Deleted
+// messages are listed as tombstones, and expired ones are left out. Only
+// members may read a group's messages.
+func (s *Service) MessageHistory(ctx context.Context, userID uuid.UUID, q MessageHistoryQuery) (*models.MessagePage, error) {
+	if (q.RecipientID == nil) == (q.GroupID == nil) {
+		return nil, fmt.Errorf("either recipient_id or group_id is required: %w", apperrors.ErrInvalidInput)
+	}
+	page := q.Page
+	if page.After != nil && q.Before != "" {
+		return nil, fmt.Errorf("only one of cursor and before may be given: %w", apperrors.ErrInvalidInput)
+	}
+
+	var query, conversation string
+	var args []interface{}
+	if q.GroupID != nil {
+		// Only members may read a group's messages, as only they may send them
+		if err := s.RequireGroupMember(ctx, *q.GroupID, userID); err != nil {
+			return nil, err
+		}
+		args = []interface{}{*q.GroupID}
+		conversation = "m.group_id = $1"
+		query = `
+			SELECT m.id, m.sender_id, m.group_id, m.encrypted_content, m.message_type, m.system_payload, m.priority, m.edited_at, m.expires_at, m.deleted_at IS NOT NULL, m.created_at, u.id, u.username, u.avatar_url
+			FROM messages m
+			LEFT JOIN users u ON m.sender_id = u.id
+			WHERE `
+	} else {
+		args = []interface{}{userID, *q.RecipientID}
+		conversation = "((m.sender_id = $1 AND m.recipient_id = $2) OR (m.sender_id = $2 AND m.recipient_id = $1))"
+		query = `
+			SELECT m.id, m.sender_id, m.recipient_id, m.encrypted_content, m.message_type, m.priority, m.view_once, m.consumed_at, m.edited_at, m.expires_at, m.deleted_at IS NOT NULL, m.created_at
+			FROM messages m
+			WHERE `
+	}
+
+	// A timestamp bound sorts before every message created at that instant
+	// for "before", and after every one of them for "after", so neither
+	// includes it
+	before, err := s.messageBound(ctx, "before", q.Before, uuid.Nil, conversation, args)
+	if err != nil {
+		return nil, err
+	}
+	after, err := s.messageBound(ctx, "after", q.After, uuid.Max, conversation, args)
+	if err != nil {
+		return nil, err
+	}
+	if before != nil {
+		page.After = before
+	}
+	if after != nil && page.After == nil {
+		page.After = after
+		page.Ascending = true
+	} else {
+		page.Until = after
+	}
+
+	bounds, boundArgs := page.Where("m.created_at", "m.id", len(args)+1)
+	args = append(args, boundArgs...)
+	limit, limitArg := page.LimitClause(len(args) + 1)
+	args = append(args, limitArg)
+	// Disappeared messages may linger until the expiry sweeper gets to them
+	query += conversation + " AND (m.expires_at IS NULL OR m.expires_at > NOW()) AND " + bounds + `
+			` + page.OrderBy("m.created_at", "m.id") + `
+			` + limit
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := []models.Message{}
+	for rows.Next() {
+		var message models.Message
+		if q.GroupID != nil {
+			// System messages have no sender
+			var senderID uuid.NullUUID
+			var username, avatarURL sql.NullString
+			err = rows.Scan(&message.ID, &message.SenderID, &message.GroupID, &message.EncryptedContent, &message.MessageType, &message.System, &message.Priority, &message.EditedAt, &message.ExpiresAt, &message.Deleted, &message.CreatedAt, &senderID, &username, &avatarURL)
+			if senderID.Valid {
+				message.Sender = &models.User{ID: senderID.UUID, Username: username.String, AvatarURL: avatarURL.String}
+			}
+		} else {
+			err = rows.Scan(&message.ID, &message.SenderID, &message.RecipientID, &message.EncryptedContent, &message.MessageType, &message.Priority, &message.ViewOnce, &message.ConsumedAt, &message.EditedAt, &message.ExpiresAt, &message.Deleted, &message.CreatedAt)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		messages = append(messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch messages: %w", err)
+	}
+
+	// Rows paged backwards come newest first; the page lists them oldest first
+	messages, next := database.Next(page, messages, func(message models.Message) database.Cursor {
+		return database.Cursor{CreatedAt: message.CreatedAt, ID: message.ID}
+	})
+	if !page.Ascending {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+
+	// Direct messages carry their status either way; group messages only
+	// when the current user sent them
+	var statusIDs []uuid.UUID
+	for _, message := range messages {
+		if message.GroupID == nil || message.SenderID == userID {
+			statusIDs = append(statusIDs, message.ID)
+		}
+	}
+	statuses, err := s.MessageStatuses(ctx, statusIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i := range messages {
+		messages[i].Status = statuses[messages[i].ID]
+	}
+
+	return &models.MessagePage{Messages: messages, NextCursor: next}, nil
+}
+
+// messageBound parses the named "before" or "after" bound of a message
+// history query: an RFC3339 timestamp, the ID of a message in the
+// conversation, or a cursor from next_cursor. A timestamp becomes a cursor
+// with timestampID. It returns nil for an empty value, and
+// apperrors.ErrInvalidInput for a value that is none of those.
+// conversation is the condition on m selecting the conversation, with its
+// arguments in args.
+func (s *Service) messageBound(ctx context.Context, name, value string, timestampID uuid.UUID, conversation string, args []interface{}) (*database.Cursor, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if createdAt, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return &database.Cursor{CreatedAt: createdAt, ID: timestampID}, nil
+	}
+	if messageID, err := uuid.Parse(value); err == nil {
+		lookupArgs := append(append([]interface{}{}, args...), messageID)
+		var cursor database.Cursor
+		err := s.db.QueryRowContext(ctx, fmt.Sprintf(`
+			SELECT m.created_at, m.id FROM messages m WHERE m.id = $%d AND %s
+		`, len(lookupArgs), conversation), lookupArgs...).Scan(&cursor.CreatedAt, &cursor.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%s is not a message in this conversation: %w", name, apperrors.ErrInvalidInput)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up message: %w", err)
+		}
+		return &cursor, nil
+	}
+	cursor, err := database.DecodeCursor(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, apperrors.ErrInvalidInput)
+	}
+	return &cursor, nil
+}
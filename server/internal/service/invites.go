@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"time"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// maxInviteUses caps how many signups a single invite code allows
+const maxInviteUses = 1000
+
+// inviteEncoding renders invite codes without padding or ambiguous case
+var inviteEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// CreateInviteCode creates a random invite code that allows maxUses signups
+// until expiresAt, or forever when expiresAt is nil
+func (s *Service) CreateInviteCode(ctx context.Context, creatorID uuid.UUID, maxUses int, expiresAt *time.Time) (*models.InviteCode, error) {
+	if maxUses == 0 {
+		maxUses = 1
+	}
+	if maxUses < 1 || maxUses > maxInviteUses {
+		return nil, fmt.Errorf("max_uses must be between 1 and %d: %w", maxInviteUses, apperrors.ErrInvalidInput)
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("expires_at must be in the future: %w", apperrors.ErrInvalidInput)
+	}
+
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("failed to generate invite code: %w", err)
+	}
+	invite := models.InviteCode{
+		Code:      inviteEncoding.EncodeToString(buf),
+		CreatedBy: &creatorID,
+		MaxUses:   maxUses,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO invite_codes (code, created_by, max_uses, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, invite.Code, creatorID, invite.MaxUses, invite.ExpiresAt, invite.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create invite code: %w", err)
+	}
+	return &invite, nil
+}
+
+// ListInviteCodes returns every invite code, newest first, used up and
+// expired ones included
+func (s *Service) ListInviteCodes(ctx context.Context) ([]models.InviteCode, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT code, created_by, max_uses, use_count, expires_at, created_at
+		FROM invite_codes ORDER BY created_at DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch invite codes: %w", err)
+	}
+	defer rows.Close()
+
+	invites := []models.InviteCode{}
+	for rows.Next() {
+		var invite models.InviteCode
+		if err := rows.Scan(&invite.Code, &invite.CreatedBy, &invite.MaxUses, &invite.UseCount, &invite.ExpiresAt, &invite.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan invite code: %w", err)
+		}
+		invites = append(invites, invite)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate invite codes: %w", err)
+	}
+	return invites, nil
+}
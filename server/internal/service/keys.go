@@ -0,0 +1,377 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/websocket"
+
+	"github.com/google/uuid"
+)
+
+// KeyStatus summarizes the user's registered devices and unused one-time keys.
+// A device's key age is measured from the last time its public key was
+// uploaded. One-time keys uploaded without a device ID count towards the total
+// but not towards any single device.
+func (s *Service) KeyStatus(ctx context.Context, userID uuid.UUID) (*models.KeyStatusResponse, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT dk.device_id, dk.updated_at, COUNT(otk.id)
+		FROM device_keys dk
+		LEFT JOIN one_time_keys otk
+			ON otk.user_id = dk.user_id AND otk.device_id = dk.device_id AND otk.used = false
+		WHERE dk.user_id = $1
+		GROUP BY dk.device_id, dk.updated_at
+		ORDER BY dk.updated_at DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch device key status: %w", err)
+	}
+	defer rows.Close()
+
+	status := models.KeyStatusResponse{Devices: []models.DeviceKeyStatus{}}
+	now := time.Now()
+	for rows.Next() {
+		var device models.DeviceKeyStatus
+		if err := rows.Scan(&device.DeviceID, &device.KeyUpdatedAt, &device.UnusedOneTimeKeys); err != nil {
+			return nil, fmt.Errorf("failed to scan device key status: %w", err)
+		}
+		device.KeyAgeSeconds = int64(now.Sub(device.KeyUpdatedAt).Seconds())
+		status.Devices = append(status.Devices, device)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate device key status: %w", err)
+	}
+	status.DeviceCount = len(status.Devices)
+
+	err = s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM one_time_keys WHERE user_id = $1 AND used = false
+	`, userID).Scan(&status.UnusedOneTimeKeys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count one-time keys: %w", err)
+	}
+
+	return &status, nil
+}
+
+// NotifySessionInitiated sends a "session_initiated" event to the target,
+// whose one-time key the initiator just received, so first contact and
+// unexpected bootstraps do not go unnoticed
+func (s *Service) NotifySessionInitiated(ctx context.Context, initiatorID, targetID uuid.UUID) error {
+	initiator, err := s.GetUser(ctx, initiatorID)
+	if err != nil {
+		return err
+	}
+	s.hub.SendToUser(targetID.String(), websocket.SessionInitiatedEvent(*initiator))
+	return nil
+}
+
+// CheckPrekeySupply tells the user's clients to upload more one-time keys
+// when fewer than PrekeyLowThreshold unused ones are left. It is called after
+// one of their keys was claimed.
+func (s *Service) CheckPrekeySupply(ctx context.Context, userID uuid.UUID) error {
+	var remaining int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM one_time_keys WHERE user_id = $1 AND used = false
+	`, userID).Scan(&remaining)
+	if err != nil {
+		return fmt.Errorf("failed to count one-time keys: %w", err)
+	}
+	if remaining < s.cfg.PrekeyLowThreshold {
+		s.hub.SendToUser(userID.String(), websocket.PrekeyLowEvent(remaining))
+	}
+	return nil
+}
+
+// identityChangeWindow is how long after a device replaced its public key the
+// change is reported by SessionStatus
+const identityChangeWindow = 7 * 24 * time.Hour
+
+// SessionStatus reports which key material the server holds for the other
+// participants of a conversation: their devices and key ages, whether
+// one-time keys are left and whether an identity key changed recently. Only
+// participants may ask, which for a direct conversation means the two users
+// have exchanged messages.
+func (s *Service) SessionStatus(ctx context.Context, userID, conversationID uuid.UUID) (*models.SessionStatus, error) {
+	convType, err := s.resolveConversation(ctx, userID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	var participantIDs []uuid.UUID
+	if convType == "group" {
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT user_id FROM group_members WHERE group_id = $1 AND user_id != $2 ORDER BY joined_at
+		`, conversationID, userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch group members: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var memberID uuid.UUID
+			if err := rows.Scan(&memberID); err != nil {
+				return nil, fmt.Errorf("failed to scan group member: %w", err)
+			}
+			participantIDs = append(participantIDs, memberID)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to iterate group members: %w", err)
+		}
+	} else {
+		var exchanged bool
+		err := s.db.QueryRowContext(ctx, `
+			SELECT EXISTS (
+				SELECT 1 FROM messages
+				WHERE (sender_id = $1 AND recipient_id = $2) OR (sender_id = $2 AND recipient_id = $1)
+			)
+		`, userID, conversationID).Scan(&exchanged)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up conversation: %w", err)
+		}
+		if !exchanged {
+			return nil, fmt.Errorf("conversation not found: %w", apperrors.ErrNotFound)
+		}
+		participantIDs = []uuid.UUID{conversationID}
+	}
+
+	status := models.SessionStatus{ConversationID: conversationID, Type: convType, Participants: []models.ParticipantSessionStatus{}}
+	if len(participantIDs) == 0 {
+		return &status, nil
+	}
+	byUser := make(map[uuid.UUID]*models.ParticipantSessionStatus, len(participantIDs))
+	for _, participantID := range participantIDs {
+		byUser[participantID] = &models.ParticipantSessionStatus{UserID: participantID, Devices: []models.DeviceSessionStatus{}}
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT user_id, device_id, updated_at, key_changed_at
+		FROM device_keys WHERE user_id = ANY($1::uuid[])
+		ORDER BY updated_at DESC
+	`, uuidArray(participantIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch device keys: %w", err)
+	}
+	defer rows.Close()
+	now := time.Now()
+	for rows.Next() {
+		var participantID uuid.UUID
+		var device models.DeviceSessionStatus
+		var updatedAt time.Time
+		if err := rows.Scan(&participantID, &device.DeviceID, &updatedAt, &device.KeyChangedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan device key: %w", err)
+		}
+		device.KeyAgeSeconds = int64(now.Sub(updatedAt).Seconds())
+		participant := byUser[participantID]
+		participant.HasDeviceKeys = true
+		participant.Devices = append(participant.Devices, device)
+		if device.KeyChangedAt != nil && now.Sub(*device.KeyChangedAt) < identityChangeWindow {
+			participant.IdentityKeyChanged = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate device keys: %w", err)
+	}
+
+	otkRows, err := s.db.QueryContext(ctx, `
+		SELECT DISTINCT user_id FROM one_time_keys WHERE user_id = ANY($1::uuid[]) AND used = false
+	`, uuidArray(participantIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch one-time keys: %w", err)
+	}
+	defer otkRows.Close()
+	for otkRows.Next() {
+		var participantID uuid.UUID
+		if err := otkRows.Scan(&participantID); err != nil {
+			return nil, fmt.Errorf("failed to scan one-time key: %w", err)
+		}
+		byUser[participantID].OneTimeKeysAvailable = true
+	}
+	if err := otkRows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate one-time keys: %w", err)
+	}
+
+	for _, participantID := range participantIDs {
+		status.Participants = append(status.Participants, *byUser[participantID])
+	}
+	return &status, nil
+}
+
+// maxRotatedOneTimeKeys caps the size of a one-time key rotation batch
+const maxRotatedOneTimeKeys = 100
+
+// RotateOneTimeKeys deletes every unused one-time key of the user's device
+// and stores keys in their place. Both happen in one transaction, so
+// bootstraps see either the old set or the new one and never hand out a key
+// the device has already discarded. A key_id already held by another device,
+// or by a key that was handed out, is a conflict: it is never overwritten.
+func (s *Service) RotateOneTimeKeys(ctx context.Context, userID uuid.UUID, deviceID string, keys []models.OneTimeKeyRequest) ([]models.OneTimeKey, error) {
+	if deviceID == "" {
+		return nil, fmt.Errorf("device_id is required: %w", apperrors.ErrInvalidInput)
+	}
+	if len(keys) == 0 || len(keys) > maxRotatedOneTimeKeys {
+		return nil, fmt.Errorf("between 1 and %d keys are required: %w", maxRotatedOneTimeKeys, apperrors.ErrInvalidInput)
+	}
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if key.KeyID == "" || key.PublicKey == "" {
+			return nil, fmt.Errorf("every key needs a key_id and public_key: %w", apperrors.ErrInvalidInput)
+		}
+		if seen[key.KeyID] {
+			return nil, fmt.Errorf("duplicate key_id %q: %w", key.KeyID, apperrors.ErrInvalidInput)
+		}
+		seen[key.KeyID] = true
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM one_time_keys WHERE user_id = $1 AND device_id = $2 AND used = false
+	`, userID, deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete one-time keys: %w", err)
+	}
+
+	now := time.Now().UTC()
+	rotated := make([]models.OneTimeKey, 0, len(keys))
+	for _, key := range keys {
+		oneTimeKey := models.OneTimeKey{
+			ID:        uuid.New(),
+			UserID:    userID,
+			KeyID:     key.KeyID,
+			DeviceID:  deviceID,
+			PublicKey: key.PublicKey,
+			CreatedAt: now,
+		}
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO one_time_keys (id, user_id, key_id, public_key, used, created_at, device_id)
+			VALUES ($1, $2, $3, $4, false, $5, $6)
+			ON CONFLICT (user_id, key_id) DO NOTHING
+		`, oneTimeKey.ID, userID, oneTimeKey.KeyID, oneTimeKey.PublicKey, now, deviceID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store one-time key: %w", err)
+		}
+		if inserted, err := result.RowsAffected(); err != nil {
+			return nil, fmt.Errorf("failed to store one-time key: %w", err)
+		} else if inserted == 0 {
+			return nil, fmt.Errorf("key_id %q is already in use: %w", key.KeyID, apperrors.ErrConflict)
+		}
+		rotated = append(rotated, oneTimeKey)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit one-time key rotation: %w", err)
+	}
+	return rotated, nil
+}
+
+// AuthorizeKeyBootstrap checks that the requester may fetch the target's keys.
+// Fetching keys consumes one-time keys, so users can restrict it to their
+// contacts: users they have exchanged direct messages with or share a group
+// with. Users who blocked the requester never hand out keys to them.
+func (s *Service) AuthorizeKeyBootstrap(ctx context.Context, requesterID, targetID uuid.UUID) error {
+	if requesterID == targetID {
+		return nil
+	}
+
+	var allowAnyone, blocked, contact bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT u.allow_key_bootstrap,
+			EXISTS (SELECT 1 FROM blocks WHERE blocker_id = $1 AND blocked_id = $2),
+			EXISTS (
+				SELECT 1 FROM messages
+				WHERE (sender_id = $1 AND recipient_id = $2) OR (sender_id = $2 AND recipient_id = $1)
+			) OR EXISTS (
+				SELECT 1 FROM group_members a
+				JOIN group_members b ON b.group_id = a.group_id
+				WHERE a.user_id = $1 AND b.user_id = $2
+			)
+		FROM users u WHERE u.id = $1
+	`, targetID, requesterID).Scan(&allowAnyone, &blocked, &contact)
+	if errors.Is(err, sql.ErrNoRows) {
+		return apperrors.ErrUserNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check key bootstrap permission: %w", err)
+	}
+
+	if blocked || !(allowAnyone || contact) {
+		return fmt.Errorf("this user only shares keys with their contacts: %w", apperrors.ErrForbidden)
+	}
+	return nil
+}
+
+// ListDevices returns the devices that uploaded keys under the user's
+// account, most recently updated first
+func (s *Service) ListDevices(ctx context.Context, userID uuid.UUID) ([]models.Device, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT device_id, created_at, updated_at FROM device_keys
+		WHERE user_id = $1
+		ORDER BY updated_at DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch devices: %w", err)
+	}
+	defer rows.Close()
+
+	devices := []models.Device{}
+	for rows.Next() {
+		var device models.Device
+		if err := rows.Scan(&device.DeviceID, &device.CreatedAt, &device.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan device: %w", err)
+		}
+		devices = append(devices, device)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch devices: %w", err)
+	}
+	return devices, nil
+}
+
+// RevokeDevice removes one of the user's devices: its device key and all of
+// its one-time keys, so nobody can start a session with it anymore. Its push
+// token and refresh tokens go too, so a lost device stops being notified and
+// can't renew its access, and the access tokens issued with them are rejected.
+// The device's connections are closed and the user's other clients are sent a
+// "device_revoked" event. Devices the user doesn't have return
+// ErrDeviceNotFound.
+func (s *Service) RevokeDevice(ctx context.Context, userID uuid.UUID, deviceID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM device_keys WHERE user_id = $1 AND device_id = $2", userID, deviceID)
+	if err != nil {
+		return fmt.Errorf("failed to delete device key: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return apperrors.ErrDeviceNotFound
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM one_time_keys WHERE user_id = $1 AND device_id = $2", userID, deviceID); err != nil {
+		return fmt.Errorf("failed to delete one-time keys: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM push_tokens WHERE user_id = $1 AND device_id = $2", userID, deviceID); err != nil {
+		return fmt.Errorf("failed to remove push token: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND device_id = $2 AND revoked_at IS NULL
+	`, userID, deviceID); err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	s.hub.DisconnectDevice(userID.String(), deviceID, websocket.StatusSessionRevoked, "Device revoked")
+	s.hub.SendToUser(userID.String(), websocket.DeviceRevokedEvent(deviceID, time.Now().UTC()))
+	return nil
+}
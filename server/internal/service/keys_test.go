@@ -0,0 +1,241 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/service"
+	"e2ee-messenger/server/internal/testutil"
+	"e2ee-messenger/server/internal/websocket"
+)
+
+func TestKeyStatusCountsSeededKeys(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+
+	stale := time.Now().Add(-30 * 24 * time.Hour)
+	seed := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"INSERT INTO device_keys (user_id, device_id, public_key, updated_at) VALUES ($1, 'phone', 'pk-phone', NOW())", []interface{}{alice.ID}},
+		{"INSERT INTO device_keys (user_id, device_id, public_key, updated_at) VALUES ($1, 'laptop', 'pk-laptop', $2)", []interface{}{alice.ID, stale}},
+		{"INSERT INTO device_keys (user_id, device_id, public_key) VALUES ($1, 'phone', 'pk-bob')", []interface{}{bob.ID}},
+		{"INSERT INTO one_time_keys (user_id, key_id, public_key, device_id) VALUES ($1, 'k1', 'otk', 'phone'), ($1, 'k2', 'otk', 'phone'), ($1, 'k3', 'otk', 'laptop'), ($1, 'k4', 'otk', NULL)", []interface{}{alice.ID}},
+		{"INSERT INTO one_time_keys (user_id, key_id, public_key, device_id, used) VALUES ($1, 'k5', 'otk', 'phone', true)", []interface{}{alice.ID}},
+		{"INSERT INTO one_time_keys (user_id, key_id, public_key, device_id) VALUES ($1, 'k1', 'otk', 'phone')", []interface{}{bob.ID}},
+	}
+	for _, s := range seed {
+		if _, err := db.Exec(s.query, s.args...); err != nil {
+			t.Fatalf("Failed to seed keys: %v", err)
+		}
+	}
+
+	status, err := svc.KeyStatus(context.Background(), alice.ID)
+	if err != nil {
+		t.Fatalf("KeyStatus failed: %v", err)
+	}
+
+	if status.DeviceCount != 2 {
+		t.Errorf("Expected 2 devices, got %d", status.DeviceCount)
+	}
+	if status.UnusedOneTimeKeys != 4 {
+		t.Errorf("Expected 4 unused one-time keys, got %d", status.UnusedOneTimeKeys)
+	}
+
+	perDevice := map[string]int{}
+	for _, device := range status.Devices {
+		perDevice[device.DeviceID] = device.UnusedOneTimeKeys
+		if device.DeviceID == "laptop" && device.KeyAgeSeconds < int64((29*24*time.Hour).Seconds()) {
+			t.Errorf("Expected the laptop key to be about 30 days old, got %ds", device.KeyAgeSeconds)
+		}
+	}
+	if perDevice["phone"] != 2 || perDevice["laptop"] != 1 {
+		t.Errorf("Expected phone=2 laptop=1 unused keys, got %v", perDevice)
+	}
+}
+
+func TestAuthorizeKeyBootstrap(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	mallory := testutil.CreateUser(t, db, "mallory")
+	ctx := context.Background()
+
+	// Anyone may fetch keys by default
+	if err := svc.AuthorizeKeyBootstrap(ctx, mallory.ID, alice.ID); err != nil {
+		t.Errorf("Expected bootstrap to be open by default, got %v", err)
+	}
+
+	off := false
+	if _, err := svc.UpdatePrivacySettings(ctx, alice.ID, models.UpdatePrivacySettingsRequest{AllowKeyBootstrap: &off}); err != nil {
+		t.Fatalf("UpdatePrivacySettings failed: %v", err)
+	}
+	if err := svc.AuthorizeKeyBootstrap(ctx, mallory.ID, alice.ID); !errors.Is(err, apperrors.ErrForbidden) {
+		t.Errorf("Expected a stranger to be refused, got %v", err)
+	}
+
+	// Having exchanged messages makes bob a contact
+	if _, err := svc.SendMessage(ctx, service.SendMessageInput{
+		SenderID:         alice.ID,
+		RecipientID:      &bob.ID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "text",
+	}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if err := svc.AuthorizeKeyBootstrap(ctx, bob.ID, alice.ID); err != nil {
+		t.Errorf("Expected a contact to be allowed, got %v", err)
+	}
+
+	// Blocking overrides both
+	if err := svc.BlockUser(ctx, alice.ID, bob.ID); err != nil {
+		t.Fatalf("BlockUser failed: %v", err)
+	}
+	if err := svc.AuthorizeKeyBootstrap(ctx, bob.ID, alice.ID); !errors.Is(err, apperrors.ErrForbidden) {
+		t.Errorf("Expected a blocked user to be refused, got %v", err)
+	}
+}
+
+func TestSessionStatusReportsPeerKeyState(t *testing.T) {
+	svc, db, _ := setupService(t)
+	ctx := context.Background()
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	carol := testutil.CreateUser(t, db, "carol")
+
+	// Strangers are not participants of a direct conversation
+	if _, err := svc.SessionStatus(ctx, alice.ID, bob.ID); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound before any message, got %v", err)
+	}
+	sendText(t, svc, alice, bob)
+
+	seed := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"INSERT INTO device_keys (user_id, device_id, public_key, key_changed_at) VALUES ($1, 'phone', 'pk-new', NOW())", []interface{}{bob.ID}},
+		{"INSERT INTO device_keys (user_id, device_id, public_key, key_changed_at) VALUES ($1, 'laptop', 'pk', $2)", []interface{}{carol.ID, time.Now().Add(-30 * 24 * time.Hour)}},
+		{"INSERT INTO one_time_keys (user_id, key_id, public_key, device_id) VALUES ($1, 'k1', 'otk', 'laptop')", []interface{}{carol.ID}},
+	}
+	for _, s := range seed {
+		if _, err := db.Exec(s.query, s.args...); err != nil {
+			t.Fatalf("Failed to seed keys: %v", err)
+		}
+	}
+
+	status, err := svc.SessionStatus(ctx, alice.ID, bob.ID)
+	if err != nil {
+		t.Fatalf("SessionStatus failed: %v", err)
+	}
+	if status.Type != "dm" || len(status.Participants) != 1 {
+		t.Fatalf("Expected one DM participant, got %+v", status)
+	}
+	if p := status.Participants[0]; !p.HasDeviceKeys || !p.IdentityKeyChanged || p.OneTimeKeysAvailable {
+		t.Errorf("Expected bob to have a recently changed key and no one-time keys, got %+v", p)
+	}
+
+	group := createGroup(t, svc, alice, bob, carol)
+	status, err = svc.SessionStatus(ctx, alice.ID, group.ID)
+	if err != nil {
+		t.Fatalf("SessionStatus failed: %v", err)
+	}
+	if len(status.Participants) != 2 {
+		t.Fatalf("Expected the two other members, got %d", len(status.Participants))
+	}
+	for _, p := range status.Participants {
+		if p.UserID == carol.ID && (p.IdentityKeyChanged || !p.OneTimeKeysAvailable || len(p.Devices) != 1) {
+			t.Errorf("Expected carol's old key change to be ignored and her one-time key counted, got %+v", p)
+		}
+	}
+}
+
+func TestRevokeDeviceRemovesItsKeys(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	ctx := context.Background()
+
+	for _, seed := range []string{
+		"INSERT INTO device_keys (user_id, device_id, public_key) VALUES ($1, 'phone', 'pk-phone'), ($1, 'laptop', 'pk-laptop')",
+		"INSERT INTO one_time_keys (user_id, key_id, public_key, device_id) VALUES ($1, 'p1', 'otk', 'phone'), ($1, 'l1', 'otk', 'laptop')",
+	} {
+		if _, err := db.Exec(seed, alice.ID); err != nil {
+			t.Fatalf("Failed to seed keys: %v", err)
+		}
+	}
+	laptop := testutil.ConnectClient(t, hub, alice.ID)
+
+	devices, err := svc.ListDevices(ctx, alice.ID)
+	if err != nil || len(devices) != 2 {
+		t.Fatalf("Expected 2 devices, got %v (%v)", devices, err)
+	}
+
+	if err := svc.RevokeDevice(ctx, bob.ID, "phone"); !errors.Is(err, apperrors.ErrDeviceNotFound) {
+		t.Fatalf("Expected ErrDeviceNotFound for someone else's device, got %v", err)
+	}
+	if err := svc.RevokeDevice(ctx, alice.ID, "phone"); err != nil {
+		t.Fatalf("RevokeDevice failed: %v", err)
+	}
+
+	event := testutil.ExpectEvent(t, laptop, websocket.EventDeviceRevoked)
+	if deviceID := event.Payload.(map[string]interface{})["device_id"]; deviceID != "phone" {
+		t.Errorf("Expected the phone to be revoked, got %v", deviceID)
+	}
+	devices, _ = svc.ListDevices(ctx, alice.ID)
+	if len(devices) != 1 || devices[0].DeviceID != "laptop" {
+		t.Errorf("Expected only the laptop left, got %v", devices)
+	}
+	var keys int
+	if err := db.QueryRow("SELECT COUNT(*) FROM one_time_keys WHERE user_id = $1 AND device_id = 'phone'", alice.ID).Scan(&keys); err != nil {
+		t.Fatalf("Failed to count keys: %v", err)
+	}
+	if keys != 0 {
+		t.Errorf("Expected the phone's one-time keys to be gone, got %d", keys)
+	}
+	if err := svc.RevokeDevice(ctx, alice.ID, "phone"); !errors.Is(err, apperrors.ErrDeviceNotFound) {
+		t.Errorf("Expected ErrDeviceNotFound for a revoked device, got %v", err)
+	}
+}
+
+func TestRotateOneTimeKeysNeverOverwritesOtherKeys(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	ctx := context.Background()
+
+	seed := "INSERT INTO one_time_keys (user_id, key_id, public_key, device_id, used) VALUES ($1, 'laptop-1', 'pk-laptop', 'laptop', false), ($1, 'used-1', 'pk-used', 'phone', true)"
+	if _, err := db.Exec(seed, alice.ID); err != nil {
+		t.Fatalf("Failed to seed keys: %v", err)
+	}
+
+	for _, keyID := range []string{"laptop-1", "used-1"} {
+		keys := []models.OneTimeKeyRequest{{KeyID: "fresh", PublicKey: "pk-fresh"}, {KeyID: keyID, PublicKey: "pk-phone"}}
+		if _, err := svc.RotateOneTimeKeys(ctx, alice.ID, "phone", keys); !errors.Is(err, apperrors.ErrConflict) {
+			t.Errorf("Expected ErrConflict rotating in %s, got %v", keyID, err)
+		}
+	}
+
+	var publicKey, deviceID string
+	var used bool
+	for keyID, want := range map[string]string{"laptop-1": "pk-laptop", "used-1": "pk-used"} {
+		err := db.QueryRow("SELECT public_key, device_id, used FROM one_time_keys WHERE user_id = $1 AND key_id = $2", alice.ID, keyID).Scan(&publicKey, &deviceID, &used)
+		if err != nil {
+			t.Fatalf("Failed to load %s: %v", keyID, err)
+		}
+		if publicKey != want {
+			t.Errorf("Expected %s to keep %s, got %s on %s (used=%v)", keyID, want, publicKey, deviceID, used)
+		}
+	}
+	var fresh int
+	if err := db.QueryRow("SELECT COUNT(*) FROM one_time_keys WHERE user_id = $1 AND key_id = 'fresh'", alice.ID).Scan(&fresh); err != nil {
+		t.Fatalf("Failed to count keys: %v", err)
+	}
+	if fresh != 0 {
+		t.Error("Expected a failed rotation to store nothing")
+	}
+}
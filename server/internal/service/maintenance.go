@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Maintenance returns the stored maintenance-mode switch
+func (s *Service) Maintenance(ctx context.Context) (bool, string, error) {
+	var enabled bool
+	var message string
+	err := s.db.QueryRowContext(ctx, "SELECT enabled, message FROM maintenance").Scan(&enabled, &message)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to load maintenance mode: %w", err)
+	}
+	return enabled, message, nil
+}
+
+// SetMaintenance stores the maintenance-mode switch, which every instance
+// picks up in RunMaintenanceSync. It reports whether enabled changed.
+func (s *Service) SetMaintenance(ctx context.Context, enabled bool, message string) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var wasEnabled bool
+	if err := tx.QueryRowContext(ctx, "SELECT enabled FROM maintenance FOR UPDATE").Scan(&wasEnabled); err != nil {
+		return false, fmt.Errorf("failed to load maintenance mode: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE maintenance SET enabled = $1, message = $2, updated_at = NOW()
+	`, enabled, message)
+	if err != nil {
+		return false, fmt.Errorf("failed to store maintenance mode: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return wasEnabled != enabled, nil
+}
+
+// RunMaintenanceSync passes the stored maintenance-mode switch to apply now
+// and then periodically, so changes made on another instance reach this one,
+// until ctx is cancelled
+func (s *Service) RunMaintenanceSync(ctx context.Context, apply func(enabled bool, message string)) {
+	ticker := time.NewTicker(s.cfg.MaintenanceSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		if !s.databaseDown() {
+			if enabled, message, err := s.Maintenance(ctx); err != nil {
+				log.Printf("Maintenance sync error: %v", err)
+			} else {
+				apply(enabled, message)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"e2ee-messenger/server/internal/config"
+	"e2ee-messenger/server/internal/service"
+	"e2ee-messenger/server/internal/testutil"
+)
+
+func TestMaintenanceReachesEveryInstance(t *testing.T) {
+	svc, db, _ := setupService(t)
+	ctx := context.Background()
+	// The switch is not truncated between tests
+	t.Cleanup(func() { svc.SetMaintenance(context.Background(), false, "") })
+
+	// A second instance sharing the database
+	cfg := config.Load()
+	cfg.MaintenanceSyncInterval = 10 * time.Millisecond
+	other := service.New(db, testutil.NewHub(t), cfg)
+	applied := make(chan bool, 16)
+	syncCtx, cancel := context.WithCancel(ctx)
+	t.Cleanup(cancel)
+	go other.RunMaintenanceSync(syncCtx, func(enabled bool, message string) {
+		applied <- enabled && message == "Upgrading the database"
+	})
+
+	changed, err := svc.SetMaintenance(ctx, true, "Upgrading the database")
+	if err != nil {
+		t.Fatalf("SetMaintenance failed: %v", err)
+	}
+	if !changed {
+		t.Error("Expected enabling maintenance to report a change")
+	}
+	if changed, _ := svc.SetMaintenance(ctx, true, "Upgrading the database"); changed {
+		t.Error("Expected enabling it again not to report a change")
+	}
+
+	deadline := time.After(2 * time.Second)
+	for enabled := false; !enabled; {
+		select {
+		case enabled = <-applied:
+		case <-deadline:
+			t.Fatal("Expected the other instance to pick up maintenance mode")
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"e2ee-messenger/server/internal/database"
+	"e2ee-messenger/server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// mediaCondition matches attachments that belong in a media gallery
+const mediaCondition = "(a.mime_type LIKE 'image/%' OR a.mime_type LIKE 'video/%')"
+
+// ConversationMedia returns a page of the conversation's messages carrying
+// images or videos, newest first, each with its media attachments. Messages
+// past their disappearing timer are left out even before they are swept. Only
+// participants may list a conversation's media.
+func (s *Service) ConversationMedia(ctx context.Context, userID, conversationID uuid.UUID, page database.Page) (*models.MediaPage, error) {
+	convType, err := s.resolveConversation(ctx, userID, conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	var conversation string
+	var args []interface{}
+	if convType == "group" {
+		conversation = "m.group_id = $1"
+		args = []interface{}{conversationID}
+	} else {
+		conversation = "((m.sender_id = $1 AND m.recipient_id = $2) OR (m.sender_id = $2 AND m.recipient_id = $1))"
+		args = []interface{}{userID, conversationID}
+	}
+	after, afterArgs := page.Where("m.created_at", "m.id", len(args)+1)
+	args = append(args, afterArgs...)
+	limit, limitArg := page.LimitClause(len(args) + 1)
+	args = append(args, limitArg)
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT m.id, m.sender_id, m.recipient_id, m.group_id, m.encrypted_content, m.message_type, m.created_at
+		FROM messages m
+		WHERE `+conversation+` AND m.deleted_at IS NULL AND (m.expires_at IS NULL OR m.expires_at > NOW()) AND `+after+`
+		  AND EXISTS (SELECT 1 FROM attachments a WHERE a.message_id = m.id AND `+mediaCondition+`)
+		ORDER BY m.created_at DESC, m.id DESC
+		`+limit, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch media messages: %w", err)
+	}
+	defer rows.Close()
+
+	var items []models.MediaItem
+	for rows.Next() {
+		var message models.Message
+		if err := rows.Scan(&message.ID, &message.SenderID, &message.RecipientID, &message.GroupID, &message.EncryptedContent, &message.MessageType, &message.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan media message: %w", err)
+		}
+		items = append(items, models.MediaItem{Message: message, Attachments: []models.Attachment{}})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate media messages: %w", err)
+	}
+
+	items, next := database.Next(page, items, func(item models.MediaItem) database.Cursor {
+		return database.Cursor{CreatedAt: item.Message.CreatedAt, ID: item.Message.ID}
+	})
+	result := &models.MediaPage{Items: []models.MediaItem{}, NextCursor: next}
+	if len(items) == 0 {
+		return result, nil
+	}
+
+	byMessage := make(map[uuid.UUID]int, len(items))
+	messageIDs := make([]uuid.UUID, len(items))
+	for i, item := range items {
+		byMessage[item.Message.ID] = i
+		messageIDs[i] = item.Message.ID
+	}
+	// Storage paths are server internals and stay out of the response
+	attachmentRows, err := s.db.QueryContext(ctx, `
+		SELECT a.id, a.message_id, a.file_name, a.file_size, a.mime_type, a.encrypted_key, COALESCE(a.content_hash, ''), a.created_at
+		FROM attachments a
+		WHERE a.message_id = ANY($1::uuid[]) AND `+mediaCondition+`
+		ORDER BY a.created_at
+	`, uuidArray(messageIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch media attachments: %w", err)
+	}
+	defer attachmentRows.Close()
+	for attachmentRows.Next() {
+		var attachment models.Attachment
+		err := attachmentRows.Scan(&attachment.ID, &attachment.MessageID, &attachment.FileName, &attachment.FileSize,
+			&attachment.MimeType, &attachment.EncryptedKey, &attachment.ContentHash, &attachment.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan media attachment: %w", err)
+		}
+		item := &items[byMessage[attachment.MessageID]]
+		item.Attachments = append(item.Attachments, attachment)
+	}
+	if err := attachmentRows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate media attachments: %w", err)
+	}
+
+	result.Items = items
+	return result, nil
+}
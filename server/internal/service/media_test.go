@@ -0,0 +1,81 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"e2ee-messenger/server/internal/database"
+	"e2ee-messenger/server/internal/testutil"
+
+	"github.com/google/uuid"
+)
+
+func TestConversationMediaListsImagesAndVideosNewestFirst(t *testing.T) {
+	svc, db, _ := setupService(t)
+	ctx := context.Background()
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+
+	// Oldest first: three media messages, a PDF and a text message
+	seed := func(messageType, mimeType string, age time.Duration) uuid.UUID {
+		t.Helper()
+		var messageID uuid.UUID
+		err := db.QueryRow(`
+			INSERT INTO messages (sender_id, recipient_id, encrypted_content, message_type, created_at)
+			VALUES ($1, $2, 'ciphertext', $3, $4) RETURNING id
+		`, alice.ID, bob.ID, messageType, time.Now().Add(-age)).Scan(&messageID)
+		if err != nil {
+			t.Fatalf("Failed to seed message: %v", err)
+		}
+		if mimeType != "" {
+			_, err = db.Exec(`
+				INSERT INTO attachments (message_id, file_name, file_size, mime_type, storage_path, encrypted_key)
+				VALUES ($1, 'file', 1, $2, '/tmp/file', 'key')
+			`, messageID, mimeType)
+			if err != nil {
+				t.Fatalf("Failed to seed attachment: %v", err)
+			}
+		}
+		return messageID
+	}
+	photo := seed("file", "image/jpeg", 5*time.Hour)
+	video := seed("file", "video/mp4", 4*time.Hour)
+	seed("file", "application/pdf", 3*time.Hour)
+	seed("text", "", 2*time.Hour)
+	latest := seed("file", "image/png", time.Hour)
+	// A disappearing photo the expiry sweeper has not deleted yet
+	expired := seed("file", "image/gif", 30*time.Minute)
+	if _, err := db.Exec("UPDATE messages SET expires_at = NOW() - INTERVAL '1 minute' WHERE id = $1", expired); err != nil {
+		t.Fatalf("Failed to expire message: %v", err)
+	}
+
+	first, err := svc.ConversationMedia(ctx, bob.ID, alice.ID, database.Page{Limit: 2})
+	if err != nil {
+		t.Fatalf("ConversationMedia failed: %v", err)
+	}
+	if len(first.Items) != 2 || first.Items[0].Message.ID != latest || first.Items[1].Message.ID != video {
+		t.Fatalf("Expected the PNG then the video, got %+v", first.Items)
+	}
+	if len(first.Items[0].Attachments) != 1 || first.Items[0].Attachments[0].StoragePath != "" {
+		t.Errorf("Expected one attachment without its storage path, got %+v", first.Items[0].Attachments)
+	}
+	if first.NextCursor == "" {
+		t.Fatal("Expected a cursor for the next page")
+	}
+
+	cursor, err := database.DecodeCursor(first.NextCursor)
+	if err != nil {
+		t.Fatalf("Failed to decode cursor: %v", err)
+	}
+	second, err := svc.ConversationMedia(ctx, bob.ID, alice.ID, database.Page{Limit: 2, After: &cursor})
+	if err != nil {
+		t.Fatalf("ConversationMedia failed: %v", err)
+	}
+	if len(second.Items) != 1 || second.Items[0].Message.ID != photo {
+		t.Fatalf("Expected only the photo on the last page, got %+v", second.Items)
+	}
+	if second.NextCursor != "" {
+		t.Errorf("Expected no cursor after the last page, got %q", second.NextCursor)
+	}
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"e2ee-messenger/server/internal/websocket"
+
+	"github.com/google/uuid"
+)
+
+// Statuses shown next to a sent message, besides the DeliveryPending and
+// DeliveryFailed warnings for direct messages nobody received
+const (
+	StatusSent      = "sent"
+	StatusDelivered = "delivered"
+	StatusRead      = "read"
+)
+
+// messageStatusQuery computes the status of each of the messages in $1. A
+// direct message is "read" once its recipient sent a read receipt and
+// "delivered" once they sent any receipt. A group message takes the lowest
+// state across the members who were in the group when it was sent: "read"
+// only when all of them read it.
+const messageStatusQuery = `
+	SELECT m.id, m.sender_id,
+		CASE WHEN m.group_id IS NULL THEN
+			CASE
+				WHEN EXISTS (SELECT 1 FROM receipts r WHERE r.message_id = m.id AND r.user_id = m.recipient_id AND r.type = 'read') THEN 'read'
+				WHEN EXISTS (SELECT 1 FROM receipts r WHERE r.message_id = m.id AND r.user_id = m.recipient_id) THEN 'delivered'
+				ELSE m.delivery_status
+			END
+		ELSE (
+			SELECT CASE
+				WHEN COUNT(*) = 0 THEN 'sent'
+				WHEN BOOL_AND(acks.has_read) THEN 'read'
+				WHEN BOOL_AND(acks.has_any) THEN 'delivered'
+				ELSE 'sent'
+			END
+			FROM (
+				SELECT
+					EXISTS (SELECT 1 FROM receipts r WHERE r.message_id = m.id AND r.user_id = gm.user_id AND r.type = 'read') AS has_read,
+					EXISTS (SELECT 1 FROM receipts r WHERE r.message_id = m.id AND r.user_id = gm.user_id) AS has_any
+				FROM group_members gm
+				WHERE gm.group_id = m.group_id AND gm.user_id != m.sender_id AND gm.joined_at <= m.created_at
+			) acks
+		) END
+	FROM messages m
+	WHERE m.id = ANY($1::uuid[])
+`
+
+// messageStatus is a message's computed status and who sent it
+type messageStatus struct {
+	senderID uuid.UUID
+	status   string
+}
+
+// messageStatuses computes the status of each of the given messages
+func (s *Service) messageStatuses(ctx context.Context, messageIDs []uuid.UUID) (map[uuid.UUID]messageStatus, error) {
+	statuses := make(map[uuid.UUID]messageStatus, len(messageIDs))
+	if len(messageIDs) == 0 {
+		return statuses, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, messageStatusQuery, uuidArray(messageIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute message statuses: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID uuid.UUID
+		var status messageStatus
+		if err := rows.Scan(&messageID, &status.senderID, &status.status); err != nil {
+			return nil, fmt.Errorf("failed to scan message status: %w", err)
+		}
+		statuses[messageID] = status
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to compute message statuses: %w", err)
+	}
+	return statuses, nil
+}
+
+// MessageStatuses returns the "sent", "delivered" or "read" status of each of
+// the given messages, or a delivery warning for direct messages nobody received
+func (s *Service) MessageStatuses(ctx context.Context, messageIDs []uuid.UUID) (map[uuid.UUID]string, error) {
+	statuses, err := s.messageStatuses(ctx, messageIDs)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[uuid.UUID]string, len(statuses))
+	for messageID, status := range statuses {
+		result[messageID] = status.status
+	}
+	return result, nil
+}
+
+// notifyStatusChanges recomputes the status of messages that were just
+// acknowledged and sends each sender one "message_status" event per new
+// status, for the messages whose status differs from before
+func (s *Service) notifyStatusChanges(ctx context.Context, before map[uuid.UUID]messageStatus, messageIDs []uuid.UUID) error {
+	after, err := s.messageStatuses(ctx, messageIDs)
+	if err != nil {
+		return err
+	}
+
+	type change struct {
+		senderID uuid.UUID
+		status   string
+	}
+	var order []change
+	changed := make(map[change][]uuid.UUID)
+	for _, messageID := range messageIDs {
+		now, ok := after[messageID]
+		if !ok || before[messageID].status == now.status {
+			continue
+		}
+		key := change{now.senderID, now.status}
+		if _, seen := changed[key]; !seen {
+			order = append(order, key)
+		}
+		changed[key] = append(changed[key], messageID)
+	}
+
+	for _, key := range order {
+		s.hub.SendToUser(key.senderID.String(), websocket.MessageStatusEvent(key.status, changed[key]))
+	}
+	return nil
+}
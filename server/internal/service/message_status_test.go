@@ -0,0 +1,117 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"e2ee-messenger/server/internal/service"
+	"e2ee-messenger/server/internal/testutil"
+	"e2ee-messenger/server/internal/websocket"
+
+	"github.com/google/uuid"
+)
+
+// expectStatusEvent checks that the sender was told the message reached status
+func expectStatusEvent(t *testing.T, client *websocket.Client, messageID uuid.UUID, status string) {
+	t.Helper()
+	event := testutil.ExpectEvent(t, client, websocket.EventMessageStatus)
+	payload := event.Payload.(map[string]interface{})
+	ids := payload["message_ids"].([]interface{})
+	if payload["status"] != status || len(ids) != 1 || ids[0] != messageID.String() {
+		t.Errorf("Expected status %s for %s, got %v", status, messageID, payload)
+	}
+}
+
+func TestDirectMessageStatusTransitions(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	aliceClient := testutil.ConnectClient(t, hub, alice.ID)
+
+	message := sendText(t, svc, alice, bob)
+	status := func() string {
+		t.Helper()
+		statuses, err := svc.MessageStatuses(context.Background(), []uuid.UUID{message.ID})
+		if err != nil {
+			t.Fatalf("MessageStatuses failed: %v", err)
+		}
+		return statuses[message.ID]
+	}
+
+	if got := status(); got != service.StatusSent {
+		t.Errorf("Expected a stored message to be %s, got %s", service.StatusSent, got)
+	}
+
+	if _, err := svc.SendReceipt(context.Background(), bob.ID, message.ID, "delivered"); err != nil {
+		t.Fatalf("SendReceipt failed: %v", err)
+	}
+	testutil.ExpectEvent(t, aliceClient, websocket.EventMessageReceipt)
+	expectStatusEvent(t, aliceClient, message.ID, service.StatusDelivered)
+	if got := status(); got != service.StatusDelivered {
+		t.Errorf("Expected %s, got %s", service.StatusDelivered, got)
+	}
+
+	if _, err := svc.SendReceipt(context.Background(), bob.ID, message.ID, "read"); err != nil {
+		t.Fatalf("SendReceipt failed: %v", err)
+	}
+	testutil.ExpectEvent(t, aliceClient, websocket.EventMessageReceipt)
+	expectStatusEvent(t, aliceClient, message.ID, service.StatusRead)
+	if got := status(); got != service.StatusRead {
+		t.Errorf("Expected %s, got %s", service.StatusRead, got)
+	}
+
+	// A repeated receipt changes nothing, so no status event follows
+	if _, err := svc.SendReceipt(context.Background(), bob.ID, message.ID, "read"); err != nil {
+		t.Fatalf("SendReceipt failed: %v", err)
+	}
+	testutil.ExpectEvent(t, aliceClient, websocket.EventMessageReceipt)
+	testutil.ExpectNoEvent(t, aliceClient)
+}
+
+func TestGroupMessageStatusIsTheLowestAcrossMembers(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	carol := testutil.CreateUser(t, db, "carol")
+	group := createGroup(t, svc, alice, bob, carol)
+	aliceClient := testutil.ConnectClient(t, hub, alice.ID)
+
+	message, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         alice.ID,
+		GroupID:          &group.ID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "text",
+	})
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	steps := []struct {
+		reader  uuid.UUID
+		receipt string
+		want    string
+	}{
+		// bob read it, but carol has not even received it
+		{bob.ID, "read", service.StatusSent},
+		{carol.ID, "delivered", service.StatusDelivered},
+		{carol.ID, "read", service.StatusRead},
+	}
+	for _, step := range steps {
+		if _, err := svc.SendBulkReceipts(context.Background(), step.reader, []uuid.UUID{message.ID}, step.receipt); err != nil {
+			t.Fatalf("SendBulkReceipts failed: %v", err)
+		}
+		testutil.ExpectEvent(t, aliceClient, websocket.EventMessageReceipts)
+		if step.want != service.StatusSent {
+			expectStatusEvent(t, aliceClient, message.ID, step.want)
+		}
+		testutil.ExpectNoEvent(t, aliceClient)
+
+		statuses, err := svc.MessageStatuses(context.Background(), []uuid.UUID{message.ID})
+		if err != nil {
+			t.Fatalf("MessageStatuses failed: %v", err)
+		}
+		if statuses[message.ID] != step.want {
+			t.Errorf("After %s marked it %s, expected %s, got %s", step.reader, step.receipt, step.want, statuses[message.ID])
+		}
+	}
+}
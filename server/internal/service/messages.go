@@ -0,0 +1,430 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/database"
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/websocket"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// SendMessageInput describes a message to store and deliver. Exactly one of
+// RecipientID and GroupID must be set.
+type SendMessageInput struct {
+	SenderID         uuid.UUID
+	RecipientID      *uuid.UUID
+	GroupID          *uuid.UUID
+	EncryptedContent string
+	MessageType      string
+	// Group members mentioned in the message (group messages only)
+	Mentions []uuid.UUID
+	// Encryption scheme the content was produced with; empty when untagged
+	EncryptionScheme string
+	// PriorityNormal (the default when empty) or PriorityHigh
+	Priority string
+	// Wait for an online recipient to confirm delivery before returning.
+	// Ignored when instances share events over Redis, see SendMessage.
+	WaitForDelivery bool
+	// Wipe the message once the recipient reads it (direct messages only)
+	ViewOnce bool
+}
+
+// SendMessage stores an encrypted message and notifies its recipients. The
+// returned message has status "sent"; with WaitForDelivery it is
+// "delivered" once an online recipient sent a receipt for a direct message
+// within DeliveryWaitTimeout, and "queued" otherwise. Waiting needs the
+// recipient's connection and receipt on this instance, so with REDIS_URL set
+// WaitForDelivery is ignored and the status is always "sent".
+func (s *Service) SendMessage(ctx context.Context, in SendMessageInput) (*models.Message, error) {
+	// A message must have either a recipient or a group
+	if (in.RecipientID == nil) == (in.GroupID == nil) {
+		return nil, fmt.Errorf("message must have a recipient_id or a group_id: %w", apperrors.ErrInvalidInput)
+	}
+	if in.MessageType == "system" {
+		return nil, fmt.Errorf("system messages are generated by the server: %w", apperrors.ErrInvalidInput)
+	}
+	if in.EncryptionScheme != "" && !validScheme.MatchString(in.EncryptionScheme) {
+		return nil, fmt.Errorf("invalid encryption_scheme: %w", apperrors.ErrInvalidInput)
+	}
+	if in.Priority == "" {
+		in.Priority = PriorityNormal
+	}
+	if in.Priority != PriorityNormal && in.Priority != PriorityHigh {
+		return nil, fmt.Errorf("priority must be normal or high: %w", apperrors.ErrInvalidInput)
+	}
+	if in.ViewOnce && in.GroupID != nil {
+		return nil, fmt.Errorf("only direct messages can be view-once: %w", apperrors.ErrInvalidInput)
+	}
+
+	message := models.Message{
+		ID:               uuid.New(),
+		SenderID:         in.SenderID,
+		RecipientID:      in.RecipientID,
+		GroupID:          in.GroupID,
+		EncryptedContent: in.EncryptedContent,
+		MessageType:      in.MessageType,
+		Priority:         in.Priority,
+		CreatedAt:        time.Now().UTC(),
+		ViewOnce:         in.ViewOnce,
+	}
+	if message.GroupID != nil && len(in.Mentions) > 0 {
+		message.Mentions = in.Mentions
+	}
+
+	if message.GroupID != nil {
+		// Verify the sender is a member of the group
+		if err := s.RequireGroupMember(ctx, *message.GroupID, message.SenderID); err != nil {
+			return nil, err
+		}
+	}
+	if message.Priority == PriorityHigh {
+		if err := s.authorizeHighPriority(ctx, message); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if in.EncryptionScheme != "" {
+		if err := s.agreeSchemeTx(ctx, tx, messageKey(message), in.EncryptionScheme, message.SenderID); err != nil {
+			return nil, err
+		}
+	}
+
+	ttl, err := conversationTTL(ctx, tx, messageKey(message))
+	if err != nil {
+		return nil, err
+	}
+	if ttl > 0 {
+		expiresAt := message.CreatedAt.Add(ttl)
+		message.ExpiresAt = &expiresAt
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO messages (id, sender_id, recipient_id, group_id, encrypted_content, message_type, mentioned_user_ids, priority, created_at, view_once, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7::uuid[], $8, $9, $10, $11)
+	`, message.ID, message.SenderID, message.RecipientID, message.GroupID, message.EncryptedContent, message.MessageType,
+		uuidArray(message.Mentions), message.Priority, message.CreatedAt, message.ViewOnce, message.ExpiresAt)
+	if err != nil {
+		return nil, insertMessageError(err)
+	}
+
+	// Listen before committing, so a receipt cannot arrive unnoticed
+	waitForDelivery := in.WaitForDelivery && s.cfg.RedisURL == ""
+	var delivered <-chan struct{}
+	if waitForDelivery && message.RecipientID != nil && message.MessageType != "file" && s.hub.IsOnline(message.RecipientID.String()) {
+		var stop func()
+		delivered, stop = s.expectDelivery(message.ID, *message.RecipientID)
+		defer stop()
+	}
+
+	// Queue the real-time notification with the message, but only if it's not a
+	// file message. File message notifications are queued by AddAttachment after
+	// the upload is complete.
+	if message.MessageType != "file" {
+		if err := enqueueNewMessage(ctx, tx, message.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit message: %w", err)
+	}
+	s.wakeOutboxRelay()
+	s.emitMessageSent(message)
+
+	message.Status = "sent"
+	if waitForDelivery {
+		message.Status = s.awaitDelivery(ctx, delivered)
+	}
+	return &message, nil
+}
+
+// insertMessageError maps a failed message insert to a domain error when it
+// references a recipient or group that does not exist (any more)
+func insertMessageError(err error) error {
+	switch constraint, _ := database.ForeignKeyViolation(err); constraint {
+	case "messages_recipient_id_fkey":
+		return apperrors.ErrRecipientNotFound
+	case "messages_group_id_fkey":
+		return apperrors.ErrGroupNotFound
+	}
+	return fmt.Errorf("failed to insert message: %w", err)
+}
+
+// AddAttachment records an uploaded attachment and queues the "new_message"
+// event for its message now that the file is available. The file at
+// uploadPath, whose SHA-256 is attachment.ContentHash, becomes the stored
+// blob for that content unless an identical blob is already stored, in which
+// case it is discarded and the attachment shares the existing one.
+func (s *Service) AddAttachment(ctx context.Context, attachment models.Attachment, uploadPath string) error {
+	defer os.Remove(uploadPath)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	attachment.StoragePath, err = s.retainBlobTx(ctx, tx, attachment.ContentHash, uploadPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO attachments (message_id, file_name, file_size, mime_type, storage_path, encrypted_key, content_hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, attachment.MessageID, attachment.FileName, attachment.FileSize, attachment.MimeType, attachment.StoragePath, attachment.EncryptedKey, attachment.ContentHash)
+	if err != nil {
+		return fmt.Errorf("failed to insert attachment: %w", err)
+	}
+
+	if err := enqueueNewMessage(ctx, tx, attachment.MessageID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit attachment: %w", err)
+	}
+	s.wakeOutboxRelay()
+	return nil
+}
+
+// loadMessage fetches a single message by ID. Deleted and expired messages
+// are not found.
+func (s *Service) loadMessage(ctx context.Context, messageID uuid.UUID) (*models.Message, error) {
+	var message models.Message
+	var mentions pq.StringArray
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, sender_id, recipient_id, group_id, encrypted_content, message_type, mentioned_user_ids, system_payload, priority, view_once, consumed_at, edited_at, expires_at, created_at
+		FROM messages WHERE id = $1 AND deleted_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+	`, messageID).Scan(
+		&message.ID, &message.SenderID, &message.RecipientID, &message.GroupID, &message.EncryptedContent, &message.MessageType, &mentions, &message.System, &message.Priority, &message.ViewOnce, &message.ConsumedAt, &message.EditedAt, &message.ExpiresAt, &message.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, apperrors.ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch message: %w", err)
+	}
+	if message.Mentions, err = parseUUIDArray(mentions); err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// redeliverLimit is how many redeliveries a user may ask for per minute
+const redeliverLimit = 10
+
+// RedeliverMessage pushes a message's "new_message" event to its recipients
+// again. Only the message's sender may ask for this.
+func (s *Service) RedeliverMessage(ctx context.Context, userID, messageID uuid.UUID) error {
+	message, err := s.loadMessage(ctx, messageID)
+	if err != nil {
+		return err
+	}
+	if message.SenderID != userID {
+		return fmt.Errorf("only the sender can redeliver a message: %w", apperrors.ErrForbidden)
+	}
+	if !s.redeliverLimiter.Allow(userID.String()) {
+		return apperrors.ErrRateLimited
+	}
+
+	if message.GroupID == nil {
+		// Sent again even though it was queued before
+		if s.hub.SendToUser(message.RecipientID.String(), websocket.NewMessageEvent(*message)) == 0 {
+			s.pushToUser(ctx, *message.RecipientID, s.withSender(ctx, *message))
+		}
+		return nil
+	}
+	return s.NotifyNewMessage(ctx, *message)
+}
+
+// NotifyNewMessage sends a "new_message" WebSocket event to the relevant
+// recipients. Each group member's delivery attempt is recorded, and direct
+// messages stay pending until they are queued on one of the recipient's
+// connections, so whoever was offline gets the message replayed when they
+// reconnect. An error means the message may not have been sent; the caller
+// may retry without it being sent twice.
+func (s *Service) NotifyNewMessage(ctx context.Context, message models.Message) error {
+	if message.GroupID == nil {
+		// Sent along with anything older still pending for the recipient,
+		// unless a replay already got to it
+		offline, err := s.replayMissedDirectMessages(ctx, *message.RecipientID)
+		if err != nil {
+			return err
+		}
+		if offline {
+			// Sent by the instance they are connected to, if any, or
+			// replayed when they reconnect
+			s.hub.WakeUser(message.RecipientID.String())
+			s.pushToUser(ctx, *message.RecipientID, s.withSender(ctx, message))
+		}
+		return nil
+	}
+
+	// Notify the other members, honoring their notification levels unless
+	// the message is high-priority. Members who muted the group or blocked
+	// the sender are skipped; the message is still stored for them.
+	message = s.withSender(ctx, message)
+	actorID := message.SenderID
+	if message.System != nil {
+		// System messages have no sender; whoever caused the event is left out
+		actorID = message.System.ActorID
+	}
+	deliveries, err := s.SendToGroup(ctx, GroupFanout{
+		GroupID:      *message.GroupID,
+		ActorID:      actorID,
+		RespectMutes: true,
+		Mentions:     message.Mentions,
+		Urgent:       message.Priority == PriorityHigh,
+	}, websocket.NewMessageEvent(message))
+	if err != nil {
+		return err
+	}
+	memberIDs := make([]uuid.UUID, len(deliveries))
+	pushed := make([]bool, len(deliveries))
+	for i, delivery := range deliveries {
+		memberIDs[i], pushed[i] = delivery.UserID, delivery.Delivered
+		if !delivery.Delivered {
+			s.pushToUser(ctx, delivery.UserID, message)
+		}
+	}
+
+	// The pushes already happened, so a failure here must not trigger a retry
+	if err := s.recordGroupDeliveries(ctx, message.ID, memberIDs, pushed); err != nil {
+		log.Printf("Failed to record deliveries of message %s: %v", message.ID, err)
+	}
+	return nil
+}
+
+// withSender fills in the sender's profile, which group message events and
+// push notifications carry so recipients can show who wrote
+func (s *Service) withSender(ctx context.Context, message models.Message) models.Message {
+	if message.SenderID == uuid.Nil {
+		// System messages have no sender
+		return message
+	}
+	var sender models.User
+	var avatarURL sql.NullString
+	err := s.db.QueryRowContext(ctx, "SELECT id, username, avatar_url FROM users WHERE id = $1", message.SenderID).Scan(&sender.ID, &sender.Username, &avatarURL)
+	if err != nil {
+		log.Printf("Could not fetch sender info for group notification: %v", err)
+		// Proceed without sender info if it fails
+		return message
+	}
+	if avatarURL.Valid {
+		sender.AvatarURL = avatarURL.String
+	}
+	message.Sender = &sender
+	return message
+}
+
+// recordGroupDeliveries records one delivery attempt of a group message per
+// member, and whether the event was pushed to any of the member's connections.
+// Members reached only by push notification still count as missed, so the
+// message is replayed once they connect.
+func (s *Service) recordGroupDeliveries(ctx context.Context, messageID uuid.UUID, memberIDs []uuid.UUID, pushed []bool) error {
+	if len(memberIDs) == 0 {
+		return nil
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO message_deliveries (message_id, user_id, attempts, last_attempt_at, pushed_at)
+		SELECT $1, d.user_id, 1, $4::timestamptz, CASE WHEN d.pushed THEN $4::timestamptz END
+		FROM unnest($2::uuid[], $3::boolean[]) AS d(user_id, pushed)
+		ON CONFLICT (message_id, user_id) DO UPDATE SET
+			attempts = message_deliveries.attempts + 1,
+			last_attempt_at = EXCLUDED.last_attempt_at,
+			pushed_at = COALESCE(message_deliveries.pushed_at, EXCLUDED.pushed_at)
+	`, messageID, uuidArray(memberIDs), pq.BoolArray(pushed), time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record group deliveries: %w", err)
+	}
+	return nil
+}
+
+// missedReplayBatch is how many missed messages are fetched at a time
+const missedReplayBatch = 100
+
+// replayOnConnect replays missed messages to a user who just connected
+func (s *Service) replayOnConnect(userID string) {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return
+	}
+	if err := s.ReplayMissedMessages(context.Background(), id); err != nil {
+		log.Printf("Failed to replay missed messages for user %s: %v", userID, err)
+	}
+}
+
+// ReplayMissedMessages pushes the direct messages sent while the user was
+// offline, then the group messages whose real-time event never reached the
+// user, for groups they are still a member of. Each kind is sent oldest
+// first. It stops early if the user goes offline again.
+func (s *Service) ReplayMissedMessages(ctx context.Context, userID uuid.UUID) error {
+	if _, err := s.replayMissedDirectMessages(ctx, userID); err != nil {
+		return err
+	}
+
+	for {
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT d.message_id FROM message_deliveries d
+			JOIN messages m ON m.id = d.message_id
+			JOIN group_members gm ON gm.group_id = m.group_id AND gm.user_id = d.user_id
+			WHERE d.user_id = $1 AND d.pushed_at IS NULL
+			ORDER BY m.created_at ASC
+			LIMIT $2
+		`, userID, missedReplayBatch)
+		if err != nil {
+			return fmt.Errorf("failed to get missed messages: %w", err)
+		}
+		var messageIDs []uuid.UUID
+		for rows.Next() {
+			var messageID uuid.UUID
+			if err := rows.Scan(&messageID); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan missed message: %w", err)
+			}
+			messageIDs = append(messageIDs, messageID)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to get missed messages: %w", err)
+		}
+
+		for _, messageID := range messageIDs {
+			message, err := s.loadMessage(ctx, messageID)
+			if errors.Is(err, apperrors.ErrMessageNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			pushed := s.hub.SendToUser(userID.String(), websocket.NewMessageEvent(s.withSender(ctx, *message))) > 0
+			if err := s.recordGroupDeliveries(ctx, messageID, []uuid.UUID{userID}, []bool{pushed}); err != nil {
+				return err
+			}
+			if !pushed {
+				return nil
+			}
+		}
+		if len(messageIDs) < missedReplayBatch {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/websocket"
+
+	"github.com/google/uuid"
+)
+
+// Direct messages are replayed to users who were offline when they were
+// sent. Rather than queueing copies, each direct message records in
+// queued_at when it was queued on one of its recipient's connections, and
+// those without one are pending. New messages go out the same way as
+// replays, from the instance the recipient is connected to, so each is
+// queued once; from there the hub redelivers it until it is acked,
+// including to the user's next connection.
+
+// pendingDirectMessage is a direct message waiting to be replayed
+type pendingDirectMessage struct {
+	id        uuid.UUID
+	createdAt time.Time
+}
+
+// replayMissedDirectMessages pushes the direct messages pending for the
+// user, oldest first, when they are connected to this instance. Each batch
+// is claimed by setting queued_at before it is sent, so two replays running
+// at once do not both send a message. If the user is not connected here or
+// goes offline midway, or a message fails to load, the messages that were
+// not sent are left pending; offline reports the former.
+func (s *Service) replayMissedDirectMessages(ctx context.Context, userID uuid.UUID) (offline bool, err error) {
+	for {
+		if !s.hub.IsOnline(userID.String()) {
+			return true, nil
+		}
+		batch, err := s.claimPendingDirectMessages(ctx, userID)
+		if err != nil || len(batch) == 0 {
+			return false, err
+		}
+
+		for i, pending := range batch {
+			message, err := s.loadMessage(ctx, pending.id)
+			if errors.Is(err, apperrors.ErrMessageNotFound) {
+				continue
+			}
+			if err == nil && s.hub.SendToUser(userID.String(), websocket.NewMessageEvent(*message)) > 0 {
+				continue
+			}
+
+			// Give back the rest of the batch, which was not sent
+			unsent := make([]uuid.UUID, 0, len(batch)-i)
+			for _, pending := range batch[i:] {
+				unsent = append(unsent, pending.id)
+			}
+			_, releaseErr := s.db.ExecContext(ctx, `
+				UPDATE messages SET queued_at = NULL WHERE id = ANY($1::uuid[])
+			`, uuidArray(unsent))
+			if err != nil {
+				return false, err
+			}
+			if releaseErr != nil {
+				return false, fmt.Errorf("failed to release missed direct messages: %w", releaseErr)
+			}
+			return true, nil
+		}
+	}
+}
+
+// claimPendingDirectMessages sets queued_at on the next batch of direct
+// messages pending for the user and returns them, oldest first. Messages
+// another replay is claiming are skipped.
+func (s *Service) claimPendingDirectMessages(ctx context.Context, userID uuid.UUID) ([]pendingDirectMessage, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE messages SET queued_at = NOW()
+		WHERE id IN (
+			SELECT id FROM messages
+			WHERE recipient_id = $1 AND group_id IS NULL AND queued_at IS NULL
+			  AND deleted_at IS NULL AND consumed_at IS NULL
+			  AND (expires_at IS NULL OR expires_at > NOW())
+			ORDER BY created_at ASC, id ASC
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		) AND queued_at IS NULL
+		RETURNING id, created_at
+	`, userID, missedReplayBatch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim missed direct messages: %w", err)
+	}
+	defer rows.Close()
+
+	var batch []pendingDirectMessage
+	for rows.Next() {
+		var pending pendingDirectMessage
+		if err := rows.Scan(&pending.id, &pending.createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan missed direct message: %w", err)
+		}
+		batch = append(batch, pending)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to claim missed direct messages: %w", err)
+	}
+
+	// RETURNING does not keep the subquery's order
+	sort.Slice(batch, func(i, j int) bool {
+		if !batch[i].createdAt.Equal(batch[j].createdAt) {
+			return batch[i].createdAt.Before(batch[j].createdAt)
+		}
+		return batch[i].id.String() < batch[j].id.String()
+	})
+	return batch, nil
+}
+
+// replayOnWake replays the direct messages pending for a user connected to
+// this instance, when one was sent to them through another instance
+func (s *Service) replayOnWake(userID string) {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return
+	}
+	if _, err := s.replayMissedDirectMessages(context.Background(), id); err != nil {
+		log.Printf("Failed to replay direct messages for user %s: %v", userID, err)
+	}
+}
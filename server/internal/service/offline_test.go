@@ -0,0 +1,111 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"e2ee-messenger/server/internal/testutil"
+	"e2ee-messenger/server/internal/websocket"
+)
+
+func TestOfflineDirectMessagesAreReplayedOnce(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+
+	first := sendText(t, svc, alice, bob)
+	second := sendText(t, svc, alice, bob)
+
+	// Both devices register at once; each replays from the same cursor
+	phone := testutil.ConnectClient(t, hub, bob.ID)
+	testutil.ConnectClient(t, hub, bob.ID)
+
+	for _, want := range []string{first.ID.String(), second.ID.String()} {
+		event := testutil.ExpectEvent(t, phone, websocket.EventNewMessage)
+		if id := event.Payload.(map[string]interface{})["id"]; id != want {
+			t.Errorf("Expected message %s to be replayed in order, got %v", want, id)
+		}
+	}
+	testutil.ExpectNoEvent(t, phone)
+
+	var pending int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM messages WHERE recipient_id = $1 AND queued_at IS NULL
+	`, bob.ID).Scan(&pending)
+	if err != nil {
+		t.Fatalf("Failed to count pending messages: %v", err)
+	}
+	if pending != 0 {
+		t.Errorf("Expected both messages to be queued, %d still pending", pending)
+	}
+}
+
+func TestReconnectGetsEachMissedMessageOnce(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+
+	missed := sendText(t, svc, alice, bob)
+
+	// Sent while the replay of the missed message may still be running
+	phone := testutil.ConnectClient(t, hub, bob.ID)
+	live := sendText(t, svc, alice, bob)
+	expectMessagesOnce(t, phone, missed.ID.String(), live.ID.String())
+
+	// The phone goes away without acking anything, and one more message
+	// comes in around the time it does
+	hub.Unregister(phone)
+	late := sendText(t, svc, alice, bob)
+
+	// Un-acked messages come back from the hub, the rest from the cursor,
+	// and none from both
+	tablet := testutil.ConnectClient(t, hub, bob.ID)
+	expectMessagesOnce(t, tablet, missed.ID.String(), live.ID.String(), late.ID.String())
+}
+
+func TestMessageCommittedAfterNewerOnesIsStillReplayed(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+
+	phone := testutil.ConnectClient(t, hub, bob.ID)
+	newer := sendText(t, svc, alice, bob)
+	testutil.ExpectEvent(t, phone, websocket.EventNewMessage)
+
+	// Stamped before the message above but committed after it was sent
+	var late string
+	err := db.QueryRow(`
+		INSERT INTO messages (sender_id, recipient_id, encrypted_content, message_type, created_at)
+		VALUES ($1, $2, 'ciphertext', 'text', $3)
+		RETURNING id
+	`, alice.ID, bob.ID, newer.CreatedAt.Add(-time.Second)).Scan(&late)
+	if err != nil {
+		t.Fatalf("Failed to insert message: %v", err)
+	}
+
+	if err := svc.ReplayMissedMessages(context.Background(), bob.ID); err != nil {
+		t.Fatalf("ReplayMissedMessages failed: %v", err)
+	}
+	expectMessagesOnce(t, phone, late)
+}
+
+// expectMessagesOnce checks that the client gets a new_message event for each
+// of the messages, in any order, and nothing else
+func expectMessagesOnce(t *testing.T, client *websocket.Client, messageIDs ...string) {
+	t.Helper()
+	eventTypes := make([]string, len(messageIDs))
+	for i := range eventTypes {
+		eventTypes[i] = websocket.EventNewMessage
+	}
+	got := make(map[string]int)
+	for _, event := range testutil.ExpectEvents(t, client, eventTypes...)[websocket.EventNewMessage] {
+		got[event.Payload.(map[string]interface{})["id"].(string)]++
+	}
+	for _, id := range messageIDs {
+		if got[id] != 1 {
+			t.Errorf("Expected message %s once, got it %d times", id, got[id])
+		}
+	}
+	testutil.ExpectNoEvent(t, client)
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/tracing"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// Outbox event types
+	outboxNewMessage = "new_message"
+
+	// Number of outbox rows relayed per batch
+	outboxBatchSize = 100
+)
+
+// enqueueNewMessage writes a "new_message" outbox row inside the caller's
+// transaction, so the event exists if and only if the message does. The
+// row carries the caller's trace, which the relay continues.
+func enqueueNewMessage(ctx context.Context, tx *sql.Tx, messageID uuid.UUID) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO outbox (event_type, message_id, trace_parent) VALUES ($1, $2, NULLIF($3, ''))
+	`, outboxNewMessage, messageID, tracing.TraceParent(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to queue notification: %w", err)
+	}
+	return nil
+}
+
+// wakeOutboxRelay nudges the relay to pick up freshly committed events
+// instead of waiting for its next poll
+func (s *Service) wakeOutboxRelay() {
+	select {
+	case s.outboxSignal <- struct{}{}:
+	default:
+	}
+}
+
+// RunOutboxRelay delivers outbox events until ctx is cancelled. It runs
+// whenever an event is committed, and polls so that events left behind by a
+// crash are picked up too.
+func (s *Service) RunOutboxRelay(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.OutboxRelayInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.outboxSignal:
+		}
+		if s.databaseDown() {
+			continue
+		}
+
+		// Keep going while full batches come back
+		for {
+			relayed, err := s.RelayOutbox(ctx)
+			if err != nil {
+				log.Printf("Outbox relay error: %v", err)
+				break
+			}
+			if relayed < outboxBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// relayNewMessage delivers a "new_message" event within the trace of the
+// request that sent the message. It reports false, without an error, when
+// delivery failed in a way the next run may retry.
+func (s *Service) relayNewMessage(ctx context.Context, eventID, messageID uuid.UUID, traceParent string) (bool, error) {
+	ctx, span := tracing.Tracer().Start(tracing.WithTraceParent(ctx, traceParent), "outbox deliver new_message",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attribute.String("message.id", messageID.String())),
+	)
+	defer span.End()
+
+	message, err := s.loadMessage(ctx, messageID)
+	if errors.Is(err, apperrors.ErrMessageNotFound) {
+		// Deleted before we got to it; nothing left to deliver
+		return true, nil
+	}
+	if err != nil {
+		span.RecordError(err)
+		return false, err
+	}
+	if err := s.NotifyNewMessage(ctx, *message); err != nil {
+		log.Printf("Failed to relay outbox event %s: %v", eventID, err)
+		span.RecordError(err)
+		return false, nil
+	}
+	return true, nil
+}
+
+// RelayOutbox delivers one batch of pending outbox events over the hub and
+// marks them delivered. Rows are locked with SKIP LOCKED so several relays
+// can run side by side. It returns the number of events relayed.
+func (s *Service) RelayOutbox(ctx context.Context) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, event_type, message_id, COALESCE(trace_parent, '')
+		FROM outbox
+		WHERE delivered_at IS NULL
+		ORDER BY created_at ASC
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`, outboxBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch outbox: %w", err)
+	}
+
+	type outboxEvent struct {
+		id          uuid.UUID
+		eventType   string
+		messageID   uuid.UUID
+		traceParent string
+	}
+	var events []outboxEvent
+	for rows.Next() {
+		var event outboxEvent
+		if err := rows.Scan(&event.id, &event.eventType, &event.messageID, &event.traceParent); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan outbox event: %w", err)
+		}
+		events = append(events, event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read outbox: %w", err)
+	}
+
+	for _, event := range events {
+		switch event.eventType {
+		case outboxNewMessage:
+			delivered, err := s.relayNewMessage(ctx, event.id, event.messageID, event.traceParent)
+			if err != nil {
+				return 0, err
+			}
+			// Leave the event undelivered so the next run retries it
+			if !delivered {
+				continue
+			}
+		default:
+			log.Printf("Unknown outbox event type %q", event.eventType)
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE outbox SET delivered_at = $1 WHERE id = $2", time.Now().UTC(), event.id); err != nil {
+			return 0, fmt.Errorf("failed to mark outbox event delivered: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit outbox batch: %w", err)
+	}
+	return len(events), nil
+}
@@ -0,0 +1,81 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"e2ee-messenger/server/internal/config"
+	"e2ee-messenger/server/internal/service"
+	"e2ee-messenger/server/internal/testutil"
+)
+
+func TestOutboxRelaysNewMessage(t *testing.T) {
+	db := testutil.NewDB(t)
+	hub := testutil.NewHub(t)
+	// No relay is running, so the event stays in the outbox until we relay it
+	svc := service.New(db, hub, config.Load())
+
+	sender := testutil.CreateUser(t, db, "alice")
+	recipient := testutil.CreateUser(t, db, "bob")
+	recipientClient := testutil.ConnectClient(t, hub, recipient.ID)
+
+	message, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         sender.ID,
+		RecipientID:      &recipient.ID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "text",
+	})
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	var pending int
+	if err := db.QueryRow("SELECT COUNT(*) FROM outbox WHERE message_id = $1 AND delivered_at IS NULL", message.ID).Scan(&pending); err != nil {
+		t.Fatalf("Failed to query outbox: %v", err)
+	}
+	if pending != 1 {
+		t.Fatalf("Expected one pending outbox row for the message, got %d", pending)
+	}
+	testutil.ExpectNoEvent(t, recipientClient)
+
+	relayed, err := svc.RelayOutbox(context.Background())
+	if err != nil {
+		t.Fatalf("RelayOutbox failed: %v", err)
+	}
+	if relayed != 1 {
+		t.Errorf("Expected 1 relayed event, got %d", relayed)
+	}
+	testutil.ExpectEvent(t, recipientClient, "new_message")
+
+	if err := db.QueryRow("SELECT COUNT(*) FROM outbox WHERE delivered_at IS NULL").Scan(&pending); err != nil {
+		t.Fatalf("Failed to query outbox: %v", err)
+	}
+	if pending != 0 {
+		t.Errorf("Expected the outbox to be drained, %d rows still pending", pending)
+	}
+}
+
+func TestFileMessageWaitsForAttachment(t *testing.T) {
+	db := testutil.NewDB(t)
+	svc := service.New(db, testutil.NewHub(t), config.Load())
+	sender := testutil.CreateUser(t, db, "alice")
+	recipient := testutil.CreateUser(t, db, "bob")
+
+	message, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         sender.ID,
+		RecipientID:      &recipient.ID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "file",
+	})
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	var queued int
+	if err := db.QueryRow("SELECT COUNT(*) FROM outbox WHERE message_id = $1", message.ID).Scan(&queued); err != nil {
+		t.Fatalf("Failed to query outbox: %v", err)
+	}
+	if queued != 0 {
+		t.Errorf("Expected no outbox row before the attachment is uploaded, got %d", queued)
+	}
+}
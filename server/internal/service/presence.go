@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/websocket"
+
+	"github.com/google/uuid"
+)
+
+// maxPresenceQuery caps how many users GetPresence looks up at once
+const maxPresenceQuery = 100
+
+// Presence statuses
+const (
+	PresenceOnline  = "online"
+	PresenceOffline = "offline"
+)
+
+// sharesConversation is a condition on u.id that holds when u shares a
+// direct conversation or a group with the user $1
+const sharesConversation = `(
+	EXISTS (
+		SELECT 1 FROM messages
+		WHERE (sender_id = $1 AND recipient_id = u.id) OR (sender_id = u.id AND recipient_id = $1)
+	) OR EXISTS (
+		SELECT 1 FROM group_members a
+		JOIN group_members b ON b.group_id = a.group_id
+		WHERE a.user_id = $1 AND b.user_id = u.id
+	)
+)`
+
+// presenceChangedOnHub is called by the hub when a user's first client
+// connects or their last one goes away
+func (s *Service) presenceChangedOnHub(userID string, online bool) {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return
+	}
+	// A quick reconnect can deliver the changes out of order; only the one
+	// matching the current state is acted on
+	if s.hub.IsOnlineAnywhere(userID) != online {
+		return
+	}
+	if err := s.UpdatePresence(context.Background(), id, online); err != nil {
+		log.Printf("Failed to update presence of user %s: %v", userID, err)
+	}
+}
+
+// UpdatePresence records that the user went online or offline, setting
+// last_seen when they go offline, and sends a "presence_changed" event to
+// everyone who shares a conversation with them, except users they blocked.
+// The event is not redelivered; clients that miss it ask GetPresence.
+func (s *Service) UpdatePresence(ctx context.Context, userID uuid.UUID, online bool) error {
+	presence := models.Presence{UserID: userID, Status: PresenceOnline}
+	if !online {
+		presence.Status = PresenceOffline
+		lastSeen := time.Now().UTC()
+		if _, err := s.db.ExecContext(ctx, "UPDATE users SET last_seen = $2 WHERE id = $1", userID, lastSeen); err != nil {
+			return fmt.Errorf("failed to record last seen: %w", err)
+		}
+		presence.LastSeen = &lastSeen
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT u.id FROM users u
+		WHERE u.id != $1 AND `+sharesConversation+`
+		  AND NOT EXISTS (SELECT 1 FROM blocks WHERE blocker_id = $1 AND blocked_id = u.id)
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get contacts: %w", err)
+	}
+	defer rows.Close()
+	var contactIDs []uuid.UUID
+	for rows.Next() {
+		var contactID uuid.UUID
+		if err := rows.Scan(&contactID); err != nil {
+			return fmt.Errorf("failed to scan contact: %w", err)
+		}
+		contactIDs = append(contactIDs, contactID)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to get contacts: %w", err)
+	}
+
+	event := websocket.PresenceChangedEvent(presence)
+	for _, contactID := range contactIDs {
+		s.hub.SendEphemeral(contactID.String(), event)
+	}
+	return nil
+}
+
+// GetPresence returns the presence of the given users. Only users who share
+// a conversation with the requester and have not blocked them are included.
+func (s *Service) GetPresence(ctx context.Context, requesterID uuid.UUID, userIDs []uuid.UUID) ([]models.Presence, error) {
+	if len(userIDs) == 0 || len(userIDs) > maxPresenceQuery {
+		return nil, fmt.Errorf("between 1 and %d user_ids are required: %w", maxPresenceQuery, apperrors.ErrInvalidInput)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT u.id, u.last_seen FROM users u
+		WHERE u.id = ANY($2::uuid[]) AND u.id != $1 AND `+sharesConversation+`
+		  AND NOT EXISTS (SELECT 1 FROM blocks WHERE blocker_id = u.id AND blocked_id = $1)
+		ORDER BY u.id
+	`, requesterID, uuidArray(userIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get presence: %w", err)
+	}
+	defer rows.Close()
+
+	presences := []models.Presence{}
+	for rows.Next() {
+		var presence models.Presence
+		var lastSeen sql.NullTime
+		if err := rows.Scan(&presence.UserID, &lastSeen); err != nil {
+			return nil, fmt.Errorf("failed to scan presence: %w", err)
+		}
+		presence.Status = PresenceOffline
+		if s.hub.IsOnlineAnywhere(presence.UserID.String()) {
+			presence.Status = PresenceOnline
+		}
+		if lastSeen.Valid {
+			lastSeen := lastSeen.Time.UTC()
+			presence.LastSeen = &lastSeen
+		}
+		presences = append(presences, presence)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get presence: %w", err)
+	}
+	return presences, nil
+}
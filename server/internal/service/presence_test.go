@@ -0,0 +1,47 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"e2ee-messenger/server/internal/service"
+	"e2ee-messenger/server/internal/testutil"
+
+	"github.com/google/uuid"
+)
+
+func TestPresenceReachesContactsOnly(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	stranger := testutil.CreateUser(t, db, "stranger")
+	sendText(t, svc, alice, bob)
+	ctx := context.Background()
+
+	aliceClient := testutil.ConnectClient(t, hub, alice.ID)
+	bobClient := testutil.ConnectClient(t, hub, bob.ID)
+	event := testutil.ExpectEvent(t, aliceClient, "presence_changed")
+	if payload := event.Payload.(map[string]interface{}); payload["user_id"] != bob.ID.String() || payload["status"] != service.PresenceOnline {
+		t.Errorf("Expected bob to come online, got %v", payload)
+	}
+	if event.ID != "" {
+		t.Errorf("Expected presence events to go out without an envelope ID, got %s", event.ID)
+	}
+
+	hub.Unregister(bobClient)
+	event = testutil.ExpectEvent(t, aliceClient, "presence_changed")
+	if payload := event.Payload.(map[string]interface{}); payload["status"] != service.PresenceOffline || payload["last_seen"] == nil {
+		t.Errorf("Expected bob to go offline with a last seen time, got %v", payload)
+	}
+
+	presences, err := svc.GetPresence(ctx, alice.ID, []uuid.UUID{bob.ID, stranger.ID})
+	if err != nil {
+		t.Fatalf("GetPresence failed: %v", err)
+	}
+	if len(presences) != 1 || presences[0].UserID != bob.ID {
+		t.Fatalf("Expected only bob's presence, got %+v", presences)
+	}
+	if presences[0].Status != service.PresenceOffline || presences[0].LastSeen == nil {
+		t.Errorf("Expected bob offline with a last seen time, got %+v", presences[0])
+	}
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// Message priorities. High-priority messages, such as safety alerts or call
+// invites, notify recipients even when they muted the conversation.
+const (
+	PriorityNormal = "normal"
+	PriorityHigh   = "high"
+)
+
+// authorizeHighPriority checks that the sender may bypass the recipients'
+// mute settings. In a direct conversation the recipient must have written to
+// the sender before and must not have blocked them, so strangers cannot use
+// it to spam; in a group only admins may.
+func (s *Service) authorizeHighPriority(ctx context.Context, message models.Message) error {
+	if message.GroupID != nil {
+		var role string
+		err := s.db.QueryRowContext(ctx, `
+			SELECT role FROM group_members WHERE group_id = $1 AND user_id = $2
+		`, message.GroupID, message.SenderID).Scan(&role)
+		if errors.Is(err, sql.ErrNoRows) {
+			return apperrors.ErrNotGroupMember
+		}
+		if err != nil {
+			return fmt.Errorf("failed to check group role: %w", err)
+		}
+		if role != "admin" {
+			return fmt.Errorf("only group admins can send high-priority messages: %w", apperrors.ErrForbidden)
+		}
+		return nil
+	}
+
+	var contact bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM messages WHERE sender_id = $1 AND recipient_id = $2 AND deleted_at IS NULL
+		) AND NOT EXISTS (
+			SELECT 1 FROM blocks WHERE blocker_id = $1 AND blocked_id = $2
+		)
+	`, message.RecipientID, message.SenderID).Scan(&contact)
+	if err != nil {
+		return fmt.Errorf("failed to check high-priority permission: %w", err)
+	}
+	if !contact {
+		return fmt.Errorf("high-priority messages can only be sent to users who have written to you: %w", apperrors.ErrForbidden)
+	}
+	return nil
+}
+
+// conversationMuted reports whether the user muted the conversation a
+// message belongs to, either with muted_until or, for groups, by setting its
+// notification level to "none"
+func (s *Service) conversationMuted(ctx context.Context, userID uuid.UUID, message models.Message) (bool, error) {
+	conversationID := message.SenderID
+	if message.GroupID != nil {
+		conversationID = *message.GroupID
+	}
+
+	var muted bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM conversation_settings
+			WHERE user_id = $1 AND conversation_id = $2 AND muted_until > NOW()
+		) OR EXISTS (
+			SELECT 1 FROM group_members
+			WHERE user_id = $1 AND group_id = $2 AND notification_level = 'none'
+		)
+	`, userID, conversationID).Scan(&muted)
+	if err != nil {
+		return false, fmt.Errorf("failed to check mute settings: %w", err)
+	}
+	return muted, nil
+}
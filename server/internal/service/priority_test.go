@@ -0,0 +1,142 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/push"
+	"e2ee-messenger/server/internal/service"
+	"e2ee-messenger/server/internal/testutil"
+)
+
+func TestHighPriorityMessageReachesMutedRecipient(t *testing.T) {
+	svc, db, _ := setupService(t)
+	sender := &recordingSender{}
+	svc.SetPushSender(sender)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	ctx := context.Background()
+
+	if _, err := svc.RegisterPushToken(ctx, bob.ID, "phone", "fcm", "bob-token"); err != nil {
+		t.Fatalf("RegisterPushToken failed: %v", err)
+	}
+	mutedUntil := time.Now().Add(time.Hour)
+	if _, err := svc.UpdateConversationSettings(ctx, bob.ID, alice.ID, models.UpdateConversationSettingsRequest{MutedUntil: &mutedUntil}); err != nil {
+		t.Fatalf("UpdateConversationSettings failed: %v", err)
+	}
+	// Bob has written to alice, so she may reach him with high priority
+	sendText(t, svc, bob, alice)
+
+	send := func(priority string) *models.Message {
+		t.Helper()
+		message, err := svc.SendMessage(ctx, service.SendMessageInput{
+			SenderID:         alice.ID,
+			RecipientID:      &bob.ID,
+			EncryptedContent: "ciphertext",
+			MessageType:      "text",
+			Priority:         priority,
+		})
+		if err != nil {
+			t.Fatalf("SendMessage failed: %v", err)
+		}
+		return message
+	}
+	send(service.PriorityNormal)
+	urgent := send(service.PriorityHigh)
+
+	// The outbox relay handles the messages in order, so once the urgent
+	// push arrives the normal one has been skipped
+	var sent []push.Payload
+	for deadline := time.Now().Add(time.Second); len(sent) == 0 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		sender.mu.Lock()
+		sent = append([]push.Payload(nil), sender.sent...)
+		sender.mu.Unlock()
+	}
+	if len(sent) != 1 || sent[0].MessageID != urgent.ID {
+		t.Fatalf("Expected only the high-priority message %s to be pushed, got %v", urgent.ID, sent)
+	}
+	if sent[0].Priority != service.PriorityHigh {
+		t.Errorf("Expected the push to be marked high priority, got %q", sent[0].Priority)
+	}
+}
+
+func TestHighPriorityGroupMessageReachesSilencedMember(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	group := createGroup(t, svc, alice, bob)
+
+	if err := svc.SetNotificationLevel(context.Background(), group.ID, bob.ID, "none"); err != nil {
+		t.Fatalf("SetNotificationLevel failed: %v", err)
+	}
+	bobClient := testutil.ConnectClient(t, hub, bob.ID)
+
+	if _, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         alice.ID,
+		GroupID:          &group.ID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "text",
+		Priority:         service.PriorityHigh,
+	}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	event := testutil.ExpectEvent(t, bobClient, "new_message")
+	if payload, _ := event.Payload.(map[string]interface{}); payload["priority"] != service.PriorityHigh {
+		t.Errorf("Expected a high-priority message, got %v", event.Payload)
+	}
+}
+
+func TestHighPriorityIsRestricted(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	mallory := testutil.CreateUser(t, db, "mallory")
+	group := createGroup(t, svc, alice, bob)
+
+	tests := []struct {
+		name  string
+		input service.SendMessageInput
+	}{
+		{"stranger in a direct conversation", service.SendMessageInput{SenderID: mallory.ID, RecipientID: &bob.ID}},
+		{"group member who is not an admin", service.SendMessageInput{SenderID: bob.ID, GroupID: &group.ID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := tt.input
+			in.EncryptedContent = "ciphertext"
+			in.MessageType = "text"
+			in.Priority = service.PriorityHigh
+			if _, err := svc.SendMessage(context.Background(), in); !errors.Is(err, apperrors.ErrForbidden) {
+				t.Errorf("Expected ErrForbidden, got %v", err)
+			}
+		})
+	}
+
+	// Mallory writing first does not make her a contact of bob's
+	sendText(t, svc, mallory, bob)
+	_, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         mallory.ID,
+		RecipientID:      &bob.ID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "text",
+		Priority:         service.PriorityHigh,
+	})
+	if !errors.Is(err, apperrors.ErrForbidden) {
+		t.Errorf("Expected ErrForbidden, got %v", err)
+	}
+
+	_, err = svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         alice.ID,
+		RecipientID:      &bob.ID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "text",
+		Priority:         "urgent",
+	})
+	if !errors.Is(err, apperrors.ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for an unknown priority, got %v", err)
+	}
+}
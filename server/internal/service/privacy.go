@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// PrivacySettings returns the user's privacy preferences
+func (s *Service) PrivacySettings(ctx context.Context, userID uuid.UUID) (*models.PrivacySettings, error) {
+	var settings models.PrivacySettings
+	err := s.db.QueryRowContext(ctx, `
+		SELECT send_read_receipts, allow_key_bootstrap FROM users WHERE id = $1
+	`, userID).Scan(&settings.SendReadReceipts, &settings.AllowKeyBootstrap)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperrors.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch privacy settings: %w", err)
+	}
+	return &settings, nil
+}
+
+// UpdatePrivacySettings changes the user's privacy preferences. Nil fields are left unchanged.
+func (s *Service) UpdatePrivacySettings(ctx context.Context, userID uuid.UUID, req models.UpdatePrivacySettingsRequest) (*models.PrivacySettings, error) {
+	var sendReadReceipts, allowKeyBootstrap sql.NullBool
+	if req.SendReadReceipts != nil {
+		sendReadReceipts = sql.NullBool{Bool: *req.SendReadReceipts, Valid: true}
+	}
+	if req.AllowKeyBootstrap != nil {
+		allowKeyBootstrap = sql.NullBool{Bool: *req.AllowKeyBootstrap, Valid: true}
+	}
+
+	var settings models.PrivacySettings
+	err := s.db.QueryRowContext(ctx, `
+		UPDATE users SET
+			send_read_receipts = COALESCE($1, send_read_receipts),
+			allow_key_bootstrap = COALESCE($2, allow_key_bootstrap),
+			updated_at = NOW()
+		WHERE id = $3
+		RETURNING send_read_receipts, allow_key_bootstrap
+	`, sendReadReceipts, allowKeyBootstrap, userID).Scan(&settings.SendReadReceipts, &settings.AllowKeyBootstrap)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperrors.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update privacy settings: %w", err)
+	}
+	return &settings, nil
+}
+
+// suppressesReceipt reports whether a receipt of the given type from the user
+// must be dropped because of their privacy settings
+func (s *Service) suppressesReceipt(ctx context.Context, userID uuid.UUID, receiptType string) (bool, error) {
+	if receiptType != "read" {
+		return false, nil
+	}
+	settings, err := s.PrivacySettings(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return !settings.SendReadReceipts, nil
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/push"
+
+	"github.com/google/uuid"
+)
+
+// maxPushTokenLength bounds the provider tokens we store
+const maxPushTokenLength = 4096
+
+// SetPushSender replaces the push provider notifications are sent through
+func (s *Service) SetPushSender(sender push.Sender) {
+	s.pushSender = sender
+}
+
+// RegisterPushToken stores the push token of one of the user's devices,
+// replacing the device's previous token. A token can only belong to one
+// device, so it is taken away from whichever account registered it before.
+func (s *Service) RegisterPushToken(ctx context.Context, userID uuid.UUID, deviceID, platform, token string) (*models.PushToken, error) {
+	if deviceID == "" || len(deviceID) > 255 {
+		return nil, fmt.Errorf("device_id must be between 1 and 255 characters: %w", apperrors.ErrInvalidInput)
+	}
+	if platform != "apns" && platform != "fcm" {
+		return nil, fmt.Errorf("platform must be one of apns, fcm: %w", apperrors.ErrInvalidInput)
+	}
+	if token == "" || len(token) > maxPushTokenLength {
+		return nil, fmt.Errorf("token must be between 1 and %d characters: %w", maxPushTokenLength, apperrors.ErrInvalidInput)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM push_tokens WHERE token = $1 AND NOT (user_id = $2 AND device_id = $3)
+	`, token, userID, deviceID); err != nil {
+		return nil, fmt.Errorf("failed to release push token: %w", err)
+	}
+
+	pushToken := models.PushToken{UserID: userID, DeviceID: deviceID, Platform: platform, Token: token}
+	now := time.Now().UTC()
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO push_tokens (user_id, device_id, platform, token, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $5)
+		ON CONFLICT (user_id, device_id) DO UPDATE SET
+			platform = EXCLUDED.platform, token = EXCLUDED.token, updated_at = EXCLUDED.updated_at
+		RETURNING created_at, updated_at
+	`, userID, deviceID, platform, token, now).Scan(&pushToken.CreatedAt, &pushToken.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store push token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return &pushToken, nil
+}
+
+// RemovePushToken unregisters the push token of one of the user's devices
+func (s *Service) RemovePushToken(ctx context.Context, userID uuid.UUID, deviceID string) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM push_tokens WHERE user_id = $1 AND device_id = $2", userID, deviceID)
+	if err != nil {
+		return fmt.Errorf("failed to remove push token: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("no push token registered for this device: %w", apperrors.ErrNotFound)
+	}
+	return nil
+}
+
+// pushToUser sends a push notification about the message to each of the
+// user's registered devices. Failures are logged; the message itself is
+// still delivered when the user next connects.
+func (s *Service) pushToUser(ctx context.Context, userID uuid.UUID, message models.Message) {
+	if message.Priority != PriorityHigh {
+		muted, err := s.conversationMuted(ctx, userID, message)
+		if err != nil {
+			log.Printf("Failed to check whether user %s muted message %s: %v", userID, message.ID, err)
+		}
+		if muted {
+			return
+		}
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT user_id, device_id, platform, token, created_at, updated_at
+		FROM push_tokens WHERE user_id = $1
+	`, userID)
+	if err != nil {
+		log.Printf("Failed to get push tokens for user %s: %v", userID, err)
+		return
+	}
+	var tokens []models.PushToken
+	for rows.Next() {
+		var token models.PushToken
+		if err := rows.Scan(&token.UserID, &token.DeviceID, &token.Platform, &token.Token, &token.CreatedAt, &token.UpdatedAt); err == nil {
+			tokens = append(tokens, token)
+		}
+	}
+	rows.Close()
+
+	payload := push.NotificationPayload(message)
+	for _, token := range tokens {
+		err := s.pushSender.Send(ctx, token, payload)
+		if errors.Is(err, push.ErrInvalidToken) {
+			s.invalidatePushToken(ctx, token)
+		} else if err != nil {
+			log.Printf("Failed to push to user %s device %s: %v", userID, token.DeviceID, err)
+		}
+	}
+}
+
+// invalidatePushToken forgets a token the provider rejected. A token the
+// device refreshed in the meantime is kept.
+func (s *Service) invalidatePushToken(ctx context.Context, token models.PushToken) {
+	_, err := s.db.ExecContext(ctx, `
+		DELETE FROM push_tokens WHERE user_id = $1 AND device_id = $2 AND token = $3
+	`, token.UserID, token.DeviceID, token.Token)
+	if err != nil {
+		log.Printf("Failed to remove invalid push token of user %s device %s: %v", token.UserID, token.DeviceID, err)
+		return
+	}
+	log.Printf("Removed invalid push token of user %s device %s", token.UserID, token.DeviceID)
+}
@@ -0,0 +1,188 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/database"
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/push"
+	"e2ee-messenger/server/internal/service"
+	"e2ee-messenger/server/internal/testutil"
+)
+
+// recordingSender remembers the notifications it was asked to send
+type recordingSender struct {
+	mu   sync.Mutex
+	sent []push.Payload
+}
+
+func (r *recordingSender) Send(ctx context.Context, token models.PushToken, payload push.Payload) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, payload)
+	return nil
+}
+
+func TestRegisterPushToken(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	ctx := context.Background()
+
+	if _, err := svc.RegisterPushToken(ctx, alice.ID, "phone", "apns", "token-1"); err != nil {
+		t.Fatalf("RegisterPushToken failed: %v", err)
+	}
+	// Refreshing the device's token replaces it
+	token, err := svc.RegisterPushToken(ctx, alice.ID, "phone", "apns", "token-2")
+	if err != nil {
+		t.Fatalf("RegisterPushToken failed: %v", err)
+	}
+	if token.Token != "token-2" {
+		t.Errorf("Expected the refreshed token, got %s", token.Token)
+	}
+
+	// A token moves with the device when another account registers it
+	if _, err := svc.RegisterPushToken(ctx, bob.ID, "tablet", "fcm", "token-2"); err != nil {
+		t.Fatalf("RegisterPushToken failed: %v", err)
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM push_tokens WHERE token = 'token-2'").Scan(&count); err != nil {
+		t.Fatalf("Failed to count tokens: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected the token to belong to one device, got %d", count)
+	}
+
+	if _, err := svc.RegisterPushToken(ctx, alice.ID, "phone", "sms", "token-3"); !errors.Is(err, apperrors.ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for an unknown platform, got %v", err)
+	}
+
+	if err := svc.RemovePushToken(ctx, bob.ID, "tablet"); err != nil {
+		t.Fatalf("RemovePushToken failed: %v", err)
+	}
+	if err := svc.RemovePushToken(ctx, bob.ID, "tablet"); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a removed token, got %v", err)
+	}
+}
+
+func TestOfflineRecipientIsPushed(t *testing.T) {
+	svc, db, _ := setupService(t)
+	sender := &recordingSender{}
+	svc.SetPushSender(sender)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+
+	if _, err := svc.RegisterPushToken(context.Background(), bob.ID, "phone", "fcm", "bob-token"); err != nil {
+		t.Fatalf("RegisterPushToken failed: %v", err)
+	}
+	message, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         alice.ID,
+		RecipientID:      &bob.ID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "text",
+	})
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	// The outbox relay sends the push in the background
+	var sent []push.Payload
+	for deadline := time.Now().Add(time.Second); len(sent) == 0 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		sender.mu.Lock()
+		sent = append([]push.Payload(nil), sender.sent...)
+		sender.mu.Unlock()
+	}
+	if len(sent) == 0 || sent[0].MessageID != message.ID {
+		t.Fatalf("Expected a push for message %s, got %v", message.ID, sent)
+	}
+	if sent[0].SenderUsername != "alice" {
+		t.Errorf("Expected the push to name alice, got %q", sent[0].SenderUsername)
+	}
+}
+
+// rejectingSender reports every token as no longer registered
+type rejectingSender struct{ attempts chan models.PushToken }
+
+func (r *rejectingSender) Send(ctx context.Context, token models.PushToken, payload push.Payload) error {
+	r.attempts <- token
+	return fmt.Errorf("provider said Unregistered: %w", push.ErrInvalidToken)
+}
+
+// pushTokenCount counts the user's registered push tokens
+func pushTokenCount(t *testing.T, db *database.DB, user models.User) int {
+	t.Helper()
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM push_tokens WHERE user_id = $1", user.ID).Scan(&count); err != nil {
+		t.Fatalf("Failed to count tokens: %v", err)
+	}
+	return count
+}
+
+func TestPushTokensArePerDevice(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	ctx := context.Background()
+
+	for _, device := range []string{"phone", "tablet"} {
+		if _, err := svc.RegisterPushToken(ctx, alice.ID, device, "fcm", device+"-token"); err != nil {
+			t.Fatalf("RegisterPushToken failed: %v", err)
+		}
+	}
+	if err := svc.RemovePushToken(ctx, alice.ID, "phone"); err != nil {
+		t.Fatalf("RemovePushToken failed: %v", err)
+	}
+
+	var device string
+	if err := db.QueryRow("SELECT device_id FROM push_tokens WHERE user_id = $1", alice.ID).Scan(&device); err != nil {
+		t.Fatalf("Failed to read the remaining token: %v", err)
+	}
+	if device != "tablet" {
+		t.Errorf("Expected the tablet's token to remain, got %s", device)
+	}
+}
+
+func TestInvalidPushTokenIsRemoved(t *testing.T) {
+	svc, db, _ := setupService(t)
+	sender := &rejectingSender{attempts: make(chan models.PushToken, 1)}
+	svc.SetPushSender(sender)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+
+	if _, err := svc.RegisterPushToken(context.Background(), bob.ID, "phone", "apns", "stale-token"); err != nil {
+		t.Fatalf("RegisterPushToken failed: %v", err)
+	}
+	sendText(t, svc, alice, bob)
+
+	select {
+	case <-sender.attempts:
+	case <-time.After(time.Second):
+		t.Fatal("Expected a push attempt")
+	}
+	for deadline := time.Now().Add(time.Second); pushTokenCount(t, db, bob) > 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the rejected token to be removed")
+		}
+	}
+}
+
+func TestLogoutAllRemovesPushTokens(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	ctx := context.Background()
+
+	if _, err := svc.RegisterPushToken(ctx, alice.ID, "phone", "apns", "phone-token"); err != nil {
+		t.Fatalf("RegisterPushToken failed: %v", err)
+	}
+	if err := svc.LogoutAll(ctx, alice.ID); err != nil {
+		t.Fatalf("LogoutAll failed: %v", err)
+	}
+	if n := pushTokenCount(t, db, alice); n != 0 {
+		t.Errorf("Expected no push tokens after logging out, got %d", n)
+	}
+}
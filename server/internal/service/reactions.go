@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/websocket"
+
+	"github.com/google/uuid"
+)
+
+// maxReactionLength caps an emoji's size in bytes. Emoji joined with zero
+// width joiners or skin tone modifiers take several code points.
+const maxReactionLength = 64
+
+// maxReactionsPerUser caps how many different emoji a user can react to one
+// message with
+const maxReactionsPerUser = 20
+
+// checkReaction rejects anything but a single emoji of up to
+// maxReactionLength bytes
+func checkReaction(emoji string) error {
+	if len(emoji) > maxReactionLength || !isEmoji(emoji) {
+		return fmt.Errorf("emoji must be a single emoji of up to %d bytes: %w", maxReactionLength, apperrors.ErrInvalidInput)
+	}
+	return nil
+}
+
+// AddReaction reacts to a message with an emoji, and sends everyone who can
+// see the message a "reaction_added" event. Reacting again with the same
+// emoji returns the existing reaction without notifying anyone. Users can
+// react to a message with up to maxReactionsPerUser emoji. Callers check
+// that the user is part of the message's conversation.
+func (s *Service) AddReaction(ctx context.Context, userID, messageID uuid.UUID, emoji string) (*models.Reaction, error) {
+	if err := checkReaction(emoji); err != nil {
+		return nil, err
+	}
+	message, err := s.loadMessage(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Serialize the user's reactions so concurrent requests cannot exceed
+	// the limit
+	if _, err := tx.ExecContext(ctx, "SELECT 1 FROM users WHERE id = $1 FOR UPDATE", userID); err != nil {
+		return nil, fmt.Errorf("failed to lock user: %w", err)
+	}
+	reaction := models.Reaction{MessageID: messageID, UserID: userID, Emoji: emoji}
+	err = tx.QueryRowContext(ctx, `
+		SELECT created_at FROM reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3
+	`, messageID, userID, emoji).Scan(&reaction.CreatedAt)
+	if err == nil {
+		return &reaction, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to fetch reaction: %w", err)
+	}
+
+	var count int
+	err = tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM reactions WHERE message_id = $1 AND user_id = $2
+	`, messageID, userID).Scan(&count)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count reactions: %w", err)
+	}
+	if count >= maxReactionsPerUser {
+		return nil, apperrors.ErrReactionLimit
+	}
+
+	reaction.CreatedAt = time.Now().UTC()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO reactions (message_id, user_id, emoji, created_at) VALUES ($1, $2, $3, $4)
+	`, messageID, userID, emoji, reaction.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add reaction: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit reaction: %w", err)
+	}
+
+	s.notifyMessageParticipants(ctx, *message, websocket.ReactionAddedEvent(reaction))
+	return &reaction, nil
+}
+
+// RemoveReaction takes back the user's reaction to a message, and sends
+// everyone who can see the message a "reaction_removed" event. Callers check
+// that the user is part of the message's conversation.
+func (s *Service) RemoveReaction(ctx context.Context, userID, messageID uuid.UUID, emoji string) error {
+	message, err := s.loadMessage(ctx, messageID)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.db.ExecContext(ctx, `
+		DELETE FROM reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3
+	`, messageID, userID, emoji)
+	if err != nil {
+		return fmt.Errorf("failed to remove reaction: %w", err)
+	}
+	if removed, _ := result.RowsAffected(); removed == 0 {
+		return fmt.Errorf("reaction not found: %w", apperrors.ErrNotFound)
+	}
+
+	s.notifyMessageParticipants(ctx, *message, websocket.ReactionRemovedEvent(messageID, userID, emoji))
+	return nil
+}
+
+// notifyMessageParticipants sends an event about a message to everyone who
+// can see it. Members' notification levels do not apply.
+func (s *Service) notifyMessageParticipants(ctx context.Context, message models.Message, event websocket.Message) {
+	if message.GroupID == nil {
+		s.hub.SendToUser(message.RecipientID.String(), event)
+		s.hub.SendToUser(message.SenderID.String(), event)
+		return
+	}
+	if _, err := s.SendToGroup(ctx, GroupFanout{GroupID: *message.GroupID}, event); err != nil {
+		log.Printf("Failed to notify group %s of a %s event: %v", *message.GroupID, event.Type, err)
+	}
+}
@@ -0,0 +1,88 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/testutil"
+	"e2ee-messenger/server/internal/websocket"
+)
+
+func TestReactionsAreAddedOnceAndRemoved(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	message := sendText(t, svc, alice, bob)
+	aliceClient := testutil.ConnectClient(t, hub, alice.ID)
+	ctx := context.Background()
+
+	reaction, err := svc.AddReaction(ctx, bob.ID, message.ID, "👍")
+	if err != nil {
+		t.Fatalf("AddReaction failed: %v", err)
+	}
+	event := testutil.ExpectEvent(t, aliceClient, websocket.EventReactionAdded)
+	if payload := event.Payload.(map[string]interface{}); payload["emoji"] != "👍" || payload["user_id"] != bob.ID.String() {
+		t.Errorf("Expected bob's reaction, got %v", payload)
+	}
+
+	again, err := svc.AddReaction(ctx, bob.ID, message.ID, "👍")
+	if err != nil {
+		t.Fatalf("AddReaction failed: %v", err)
+	}
+	if !again.CreatedAt.Equal(reaction.CreatedAt) {
+		t.Errorf("Expected the existing reaction, got %+v", again)
+	}
+	testutil.ExpectNoEvent(t, aliceClient)
+
+	if err := svc.RemoveReaction(ctx, bob.ID, message.ID, "👍"); err != nil {
+		t.Fatalf("RemoveReaction failed: %v", err)
+	}
+	testutil.ExpectEvent(t, aliceClient, websocket.EventReactionRemoved)
+	if err := svc.RemoveReaction(ctx, bob.ID, message.ID, "👍"); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound removing it twice, got %v", err)
+	}
+}
+
+func TestAddReactionRejectsMalformedEmoji(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	message := sendText(t, svc, alice, bob)
+
+	for _, emoji := range []string{"", "a", "+1", "👍 👍", "👍👍", "🇵", "\x1b[31m", string(make([]byte, 65))} {
+		if _, err := svc.AddReaction(context.Background(), bob.ID, message.ID, emoji); !errors.Is(err, apperrors.ErrInvalidInput) {
+			t.Errorf("Expected ErrInvalidInput for %q, got %v", emoji, err)
+		}
+	}
+	for _, emoji := range []string{"❤️", "👍🏽", "👩🏽‍💻", "🏳️‍🌈", "🇵🇹", "1️⃣"} {
+		if _, err := svc.AddReaction(context.Background(), bob.ID, message.ID, emoji); err != nil {
+			t.Errorf("Expected %q to be accepted, got %v", emoji, err)
+		}
+	}
+}
+
+func TestAddReactionIsLimitedPerUser(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	message := sendText(t, svc, alice, bob)
+	ctx := context.Background()
+
+	for i := 0; i < 20; i++ {
+		if _, err := svc.AddReaction(ctx, bob.ID, message.ID, string(rune(0x1f600+i))); err != nil {
+			t.Fatalf("AddReaction failed: %v", err)
+		}
+	}
+	if _, err := svc.AddReaction(ctx, bob.ID, message.ID, "🎉"); !errors.Is(err, apperrors.ErrReactionLimit) {
+		t.Errorf("Expected ErrReactionLimit past the limit, got %v", err)
+	}
+	// Reacting again with one of them is still fine, as is someone else reacting
+	if _, err := svc.AddReaction(ctx, bob.ID, message.ID, "😀"); err != nil {
+		t.Errorf("Expected an existing reaction to be returned, got %v", err)
+	}
+	if _, err := svc.AddReaction(ctx, alice.ID, message.ID, "🎉"); err != nil {
+		t.Errorf("Expected other users to react, got %v", err)
+	}
+}
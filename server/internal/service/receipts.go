@@ -0,0 +1,218 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/websocket"
+
+	"github.com/google/uuid"
+)
+
+// maxBulkReceipts caps how many messages can be acknowledged in one call
+const maxBulkReceipts = 500
+
+// SendReceipt records a receipt for a message and tells its sender, along
+// with a "message_status" event when the message's status changed. Read
+// receipts from users who turned them off are silently dropped. Either way a
+// read is synced to the reader's own devices.
+func (s *Service) SendReceipt(ctx context.Context, userID, messageID uuid.UUID, receiptType string) (*models.Receipt, error) {
+	if receiptType != "delivered" && receiptType != "read" {
+		return nil, fmt.Errorf("receipt type must be delivered or read: %w", apperrors.ErrInvalidInput)
+	}
+
+	receipt := models.Receipt{
+		ID:        uuid.New(),
+		MessageID: messageID,
+		UserID:    userID,
+		Type:      receiptType,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	// View-once messages are wiped on read even when the receipt is not kept
+	if receiptType == "read" {
+		if err := s.consumeViewOnce(ctx, userID, []uuid.UUID{messageID}); err != nil {
+			return nil, err
+		}
+	}
+
+	suppressed, err := s.suppressesReceipt(ctx, userID, receiptType)
+	if err != nil {
+		return nil, err
+	}
+	if suppressed {
+		if receiptType == "read" {
+			s.syncReadPosition(ctx, userID, []uuid.UUID{messageID}, receipt.CreatedAt)
+		}
+		return &receipt, nil
+	}
+
+	before, err := s.messageStatuses(ctx, []uuid.UUID{messageID})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO receipts (id, message_id, user_id, type, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (message_id, user_id, type) DO NOTHING
+	`, receipt.ID, receipt.MessageID, receipt.UserID, receipt.Type, receipt.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert receipt: %w", err)
+	}
+	s.confirmDelivery(userID, []uuid.UUID{messageID})
+
+	// Send real-time notification to sender
+	var senderID uuid.UUID
+	err = s.db.QueryRowContext(ctx, "SELECT sender_id FROM messages WHERE id = $1", messageID).Scan(&senderID)
+	if err == nil && senderID != uuid.Nil {
+		s.hub.SendToUser(senderID.String(), websocket.ReceiptEvent(receipt))
+	}
+	if err := s.notifyStatusChanges(ctx, before, []uuid.UUID{messageID}); err != nil {
+		log.Printf("Failed to send status of message %s: %v", messageID, err)
+	}
+	if receiptType == "read" {
+		s.syncReadPosition(ctx, userID, []uuid.UUID{messageID}, receipt.CreatedAt)
+	}
+
+	return &receipt, nil
+}
+
+// SendBulkReceipts records a receipt of the given type for every message the
+// user received. Messages the user is not a recipient of are silently skipped,
+// as are read receipts from users who turned them off.
+// Each sender gets a single aggregated "message_receipts" event, plus
+// "message_status" events for messages whose status changed, and reads are
+// synced to the reader's own devices.
+func (s *Service) SendBulkReceipts(ctx context.Context, userID uuid.UUID, messageIDs []uuid.UUID, receiptType string) ([]models.Receipt, error) {
+	if receiptType != "delivered" && receiptType != "read" {
+		return nil, fmt.Errorf("receipt type must be delivered or read: %w", apperrors.ErrInvalidInput)
+	}
+	if len(messageIDs) == 0 || len(messageIDs) > maxBulkReceipts {
+		return nil, fmt.Errorf("between 1 and %d message_ids are required: %w", maxBulkReceipts, apperrors.ErrInvalidInput)
+	}
+
+	if receiptType == "read" {
+		if err := s.consumeViewOnce(ctx, userID, messageIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	suppressed, err := s.suppressesReceipt(ctx, userID, receiptType)
+	if err != nil {
+		return nil, err
+	}
+	createdAt := time.Now().UTC()
+	if suppressed {
+		if receiptType == "read" {
+			s.syncReadPosition(ctx, userID, messageIDs, createdAt)
+		}
+		return []models.Receipt{}, nil
+	}
+
+	before, err := s.messageStatuses(ctx, messageIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		WITH inserted AS (
+			INSERT INTO receipts (message_id, user_id, type, created_at)
+			SELECT m.id, $1::uuid, $2::varchar, $3::timestamptz
+			FROM messages m
+			WHERE m.id = ANY($4::uuid[])
+			  AND m.deleted_at IS NULL
+			  AND m.sender_id != $1
+			  AND (
+				m.recipient_id = $1
+				OR EXISTS (SELECT 1 FROM group_members gm WHERE gm.group_id = m.group_id AND gm.user_id = $1)
+			  )
+			ON CONFLICT (message_id, user_id, type) DO NOTHING
+			RETURNING id, message_id
+		)
+		SELECT i.id, i.message_id, m.sender_id
+		FROM inserted i
+		JOIN messages m ON m.id = i.message_id
+	`, userID, receiptType, createdAt, uuidArray(messageIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert receipts: %w", err)
+	}
+	defer rows.Close()
+
+	receipts := []models.Receipt{}
+	bySender := make(map[uuid.UUID][]uuid.UUID)
+	for rows.Next() {
+		receipt := models.Receipt{UserID: userID, Type: receiptType, CreatedAt: createdAt}
+		var senderID uuid.UUID
+		if err := rows.Scan(&receipt.ID, &receipt.MessageID, &senderID); err != nil {
+			return nil, fmt.Errorf("failed to scan receipt: %w", err)
+		}
+		receipts = append(receipts, receipt)
+		bySender[senderID] = append(bySender[senderID], receipt.MessageID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read receipts: %w", err)
+	}
+
+	// Send one aggregated notification per sender
+	for senderID, acknowledged := range bySender {
+		s.hub.SendToUser(senderID.String(), websocket.BulkReceiptEvent(userID, receiptType, acknowledged, createdAt))
+	}
+	acknowledged := make([]uuid.UUID, len(receipts))
+	for i, receipt := range receipts {
+		acknowledged[i] = receipt.MessageID
+	}
+	s.confirmDelivery(userID, acknowledged)
+	if err := s.notifyStatusChanges(ctx, before, acknowledged); err != nil {
+		log.Printf("Failed to send message statuses: %v", err)
+	}
+	if receiptType == "read" {
+		s.syncReadPosition(ctx, userID, messageIDs, createdAt)
+	}
+
+	return receipts, nil
+}
+
+// syncReadPosition sends a "read_position" event per conversation to all of
+// the reader's devices, so the device the messages were read on is not the
+// only one that knows. Messages the user did not receive are skipped.
+func (s *Service) syncReadPosition(ctx context.Context, userID uuid.UUID, messageIDs []uuid.UUID, readAt time.Time) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT m.id, COALESCE(m.group_id, m.sender_id)
+		FROM messages m
+		WHERE m.id = ANY($2::uuid[])
+		  AND m.sender_id != $1
+		  AND (
+			m.recipient_id = $1
+			OR EXISTS (SELECT 1 FROM group_members gm WHERE gm.group_id = m.group_id AND gm.user_id = $1)
+		  )
+		ORDER BY m.created_at ASC
+	`, userID, uuidArray(messageIDs))
+	if err != nil {
+		log.Printf("Failed to look up read messages for user %s: %v", userID, err)
+		return
+	}
+	defer rows.Close()
+
+	var conversations []uuid.UUID
+	byConversation := make(map[uuid.UUID][]uuid.UUID)
+	for rows.Next() {
+		var messageID, conversationID uuid.UUID
+		if err := rows.Scan(&messageID, &conversationID); err != nil {
+			log.Printf("Failed to scan read message: %v", err)
+			return
+		}
+		if _, seen := byConversation[conversationID]; !seen {
+			conversations = append(conversations, conversationID)
+		}
+		byConversation[conversationID] = append(byConversation[conversationID], messageID)
+	}
+
+	for _, conversationID := range conversations {
+		s.hub.SendToUser(userID.String(), websocket.ReadPositionEvent(conversationID, byConversation[conversationID], readAt))
+	}
+}
@@ -0,0 +1,158 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/service"
+	"e2ee-messenger/server/internal/testutil"
+	"e2ee-messenger/server/internal/websocket"
+
+	"github.com/google/uuid"
+)
+
+func TestSendBulkReceipts(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	carol := testutil.CreateUser(t, db, "carol")
+	aliceClient := testutil.ConnectClient(t, hub, alice.ID)
+
+	send := func(from, to uuid.UUID) uuid.UUID {
+		t.Helper()
+		message, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+			SenderID:         from,
+			RecipientID:      &to,
+			EncryptedContent: "ciphertext",
+			MessageType:      "text",
+		})
+		if err != nil {
+			t.Fatalf("SendMessage failed: %v", err)
+		}
+		return message.ID
+	}
+
+	toBob1 := send(alice.ID, bob.ID)
+	toBob2 := send(alice.ID, bob.ID)
+	toCarol := send(alice.ID, carol.ID)
+	fromBob := send(bob.ID, alice.ID)
+	testutil.ExpectEvent(t, aliceClient, "new_message")
+
+	receipts, err := svc.SendBulkReceipts(context.Background(), bob.ID, []uuid.UUID{toBob1, toBob2, toCarol, fromBob}, "delivered")
+	if err != nil {
+		t.Fatalf("SendBulkReceipts failed: %v", err)
+	}
+
+	// Only the two messages actually addressed to bob are acknowledged
+	if len(receipts) != 2 {
+		t.Fatalf("Expected 2 receipts, got %d", len(receipts))
+	}
+	for _, receipt := range receipts {
+		if receipt.MessageID != toBob1 && receipt.MessageID != toBob2 {
+			t.Errorf("Unexpected receipt for message %s", receipt.MessageID)
+		}
+	}
+
+	event := testutil.ExpectEvent(t, aliceClient, "message_receipts")
+	payload := event.Payload.(map[string]interface{})
+	if ids := payload["message_ids"].([]interface{}); len(ids) != 2 {
+		t.Errorf("Expected an aggregated event for 2 messages, got %d", len(ids))
+	}
+
+	// Repeating the call is a no-op thanks to ON CONFLICT DO NOTHING
+	receipts, err = svc.SendBulkReceipts(context.Background(), bob.ID, []uuid.UUID{toBob1, toBob2}, "delivered")
+	if err != nil {
+		t.Fatalf("SendBulkReceipts failed: %v", err)
+	}
+	if len(receipts) != 0 {
+		t.Errorf("Expected duplicate receipts to be skipped, got %d", len(receipts))
+	}
+}
+
+func TestReadReceiptsSuppressedWhenDisabled(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	aliceClient := testutil.ConnectClient(t, hub, alice.ID)
+
+	message, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         alice.ID,
+		RecipientID:      &bob.ID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "text",
+	})
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	off := false
+	if _, err := svc.UpdatePrivacySettings(context.Background(), bob.ID, models.UpdatePrivacySettingsRequest{SendReadReceipts: &off}); err != nil {
+		t.Fatalf("UpdatePrivacySettings failed: %v", err)
+	}
+
+	if _, err := svc.SendReceipt(context.Background(), bob.ID, message.ID, "read"); err != nil {
+		t.Fatalf("SendReceipt failed: %v", err)
+	}
+	receipts, err := svc.SendBulkReceipts(context.Background(), bob.ID, []uuid.UUID{message.ID}, "read")
+	if err != nil {
+		t.Fatalf("SendBulkReceipts failed: %v", err)
+	}
+	if len(receipts) != 0 {
+		t.Errorf("Expected no bulk read receipts, got %d", len(receipts))
+	}
+
+	var readCount int
+	if err := db.QueryRow("SELECT COUNT(*) FROM receipts WHERE message_id = $1 AND type = 'read'", message.ID).Scan(&readCount); err != nil {
+		t.Fatalf("Failed to count receipts: %v", err)
+	}
+	if readCount != 0 {
+		t.Errorf("Expected no read receipt to be recorded, got %d", readCount)
+	}
+
+	// The new_message event was for bob; alice hears nothing about the read
+	testutil.ExpectNoEvent(t, aliceClient)
+
+	// Delivered receipts still flow
+	if _, err := svc.SendReceipt(context.Background(), bob.ID, message.ID, "delivered"); err != nil {
+		t.Fatalf("SendReceipt failed: %v", err)
+	}
+	testutil.ExpectEvent(t, aliceClient, "message_receipt")
+}
+
+func TestReadSyncsToReadersOtherDevices(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	phone := testutil.ConnectClient(t, hub, bob.ID)
+	laptop := testutil.ConnectClient(t, hub, bob.ID)
+
+	message, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         alice.ID,
+		RecipientID:      &bob.ID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "text",
+	})
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	testutil.ExpectEvent(t, phone, websocket.EventNewMessage)
+	testutil.ExpectEvent(t, laptop, websocket.EventNewMessage)
+
+	// Reading on the phone reaches the laptop too
+
+	if _, err := svc.SendReceipt(context.Background(), bob.ID, message.ID, "read"); err != nil {
+		t.Fatalf("SendReceipt failed: %v", err)
+	}
+
+	for _, device := range []*websocket.Client{phone, laptop} {
+		event := testutil.ExpectEvent(t, device, websocket.EventReadPosition)
+		payload := event.Payload.(map[string]interface{})
+		if payload["conversation_id"] != alice.ID.String() {
+			t.Errorf("Expected the DM with alice, got %v", payload["conversation_id"])
+		}
+		if ids := payload["message_ids"].([]interface{}); len(ids) != 1 || ids[0] != message.ID.String() {
+			t.Errorf("Expected message %s to be read, got %v", message.ID, ids)
+		}
+	}
+}
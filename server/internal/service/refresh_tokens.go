@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"e2ee-messenger/server/internal/apperrors"
+
+	"github.com/google/uuid"
+)
+
+// RefreshedToken is the result of rotating a refresh token
+type RefreshedToken struct {
+	UserID   uuid.UUID
+	DeviceID string
+	FamilyID uuid.UUID
+	// Replaces the token that was presented, which can't be used again
+	RefreshToken string
+}
+
+// newRefreshToken returns a random refresh token and the hash it is stored under
+func newRefreshToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashRefreshToken(token), nil
+}
+
+// hashRefreshToken hashes a refresh token for storage. Tokens are random, so
+// an unsalted hash is enough to keep a database leak from exposing them.
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// insertRefreshTokenTx stores a new refresh token in family and returns it
+func (s *Service) insertRefreshTokenTx(ctx context.Context, tx *sql.Tx, userID uuid.UUID, deviceID string, familyID uuid.UUID) (string, error) {
+	token, hash, err := newRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO refresh_tokens (user_id, device_id, family_id, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, userID, deviceID, familyID, hash, time.Now().Add(s.cfg.RefreshTokenTTL))
+	if err != nil {
+		return "", fmt.Errorf("failed to store refresh token: %w", err)
+	}
+	return token, nil
+}
+
+// IssueRefreshToken starts a new token family for a login on deviceID and
+// returns its first refresh token and the family's ID
+func (s *Service) IssueRefreshToken(ctx context.Context, userID uuid.UUID, deviceID string) (string, uuid.UUID, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", uuid.Nil, fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	familyID := uuid.New()
+	token, err := s.insertRefreshTokenTx(ctx, tx, userID, deviceID, familyID)
+	if err != nil {
+		return "", uuid.Nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", uuid.Nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return token, familyID, nil
+}
+
+// IsRefreshTokenFamilyRevoked reports whether a refresh token family was
+// revoked, which also revokes the access tokens issued with it
+func (s *Service) IsRefreshTokenFamilyRevoked(ctx context.Context, familyID uuid.UUID) (bool, error) {
+	var revoked bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM refresh_tokens WHERE family_id = $1 AND revoked_at IS NOT NULL)
+	`, familyID).Scan(&revoked)
+	if err != nil {
+		return false, fmt.Errorf("failed to check refresh token family: %w", err)
+	}
+	return revoked, nil
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token of one of the user's
+// logins, so none of them can be exchanged for access tokens any more
+func (s *Service) RevokeRefreshTokenFamily(ctx context.Context, userID, familyID uuid.UUID) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE user_id = $1 AND family_id = $2 AND revoked_at IS NULL
+	`, userID, familyID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh token family: %w", err)
+	}
+	return nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one in the same
+// family. Unknown, expired and revoked tokens return ErrUnauthorized. A token
+// that was already rotated has been used twice, so it was probably stolen:
+// its whole family is revoked, logging out both the thief and the victim's
+// device, and ErrUnauthorized is returned.
+func (s *Service) RotateRefreshToken(ctx context.Context, token string) (*RefreshedToken, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var (
+		id                   uuid.UUID
+		result               RefreshedToken
+		expiresAt            time.Time
+		rotatedAt, revokedAt sql.NullTime
+	)
+	// The row lock makes concurrent uses of one token count as reuse
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, user_id, device_id, family_id, expires_at, rotated_at, revoked_at
+		FROM refresh_tokens WHERE token_hash = $1
+		FOR UPDATE
+	`, hashRefreshToken(token)).Scan(&id, &result.UserID, &result.DeviceID, &result.FamilyID, &expiresAt, &rotatedAt, &revokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperrors.ErrUnauthorized
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up refresh token: %w", err)
+	}
+
+	if revokedAt.Valid || !time.Now().Before(expiresAt) {
+		return nil, apperrors.ErrUnauthorized
+	}
+	if rotatedAt.Valid {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL
+		`, result.FamilyID); err != nil {
+			return nil, fmt.Errorf("failed to revoke refresh token family: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		log.Printf("Refresh token reused for user %s, revoked its family %s", result.UserID, result.FamilyID)
+		return nil, apperrors.ErrUnauthorized
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET rotated_at = NOW() WHERE id = $1", id); err != nil {
+		return nil, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+	result.RefreshToken, err = s.insertRefreshTokenTx(ctx, tx, result.UserID, result.DeviceID, result.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return &result, nil
+}
+
+// PurgeExpiredRefreshTokens deletes refresh tokens past their expiry, which
+// can no longer be used or reused. It returns how many were deleted.
+func (s *Service) PurgeExpiredRefreshTokens(ctx context.Context) (int64, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM refresh_tokens WHERE expires_at < NOW()")
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge refresh tokens: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return n, nil
+}
@@ -0,0 +1,61 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/testutil"
+)
+
+func TestRefreshTokensRotate(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	ctx := context.Background()
+
+	first, _, err := svc.IssueRefreshToken(ctx, alice.ID, "phone")
+	if err != nil {
+		t.Fatalf("IssueRefreshToken failed: %v", err)
+	}
+	refreshed, err := svc.RotateRefreshToken(ctx, first)
+	if err != nil {
+		t.Fatalf("RotateRefreshToken failed: %v", err)
+	}
+	if refreshed.UserID != alice.ID || refreshed.DeviceID != "phone" {
+		t.Errorf("Expected alice's phone, got %s on %q", refreshed.UserID, refreshed.DeviceID)
+	}
+	if refreshed.RefreshToken == first {
+		t.Error("Expected a new refresh token")
+	}
+	if _, err := svc.RotateRefreshToken(ctx, refreshed.RefreshToken); err != nil {
+		t.Errorf("Expected the new refresh token to work, got %v", err)
+	}
+
+	if _, err := svc.RotateRefreshToken(ctx, "not-a-token"); !errors.Is(err, apperrors.ErrUnauthorized) {
+		t.Errorf("Expected ErrUnauthorized for an unknown token, got %v", err)
+	}
+}
+
+func TestReusedRefreshTokenRevokesItsFamily(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	ctx := context.Background()
+
+	stolen, _, _ := svc.IssueRefreshToken(ctx, alice.ID, "phone")
+	other, _, _ := svc.IssueRefreshToken(ctx, alice.ID, "laptop")
+	refreshed, err := svc.RotateRefreshToken(ctx, stolen)
+	if err != nil {
+		t.Fatalf("RotateRefreshToken failed: %v", err)
+	}
+
+	if _, err := svc.RotateRefreshToken(ctx, stolen); !errors.Is(err, apperrors.ErrUnauthorized) {
+		t.Fatalf("Expected the rotated token to be refused, got %v", err)
+	}
+	if _, err := svc.RotateRefreshToken(ctx, refreshed.RefreshToken); !errors.Is(err, apperrors.ErrUnauthorized) {
+		t.Errorf("Expected reuse to revoke the rest of the family, got %v", err)
+	}
+	if _, err := svc.RotateRefreshToken(ctx, other); err != nil {
+		t.Errorf("Expected other logins to keep working, got %v", err)
+	}
+}
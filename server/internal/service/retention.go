@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// retentionBatchSize caps how many messages one purge transaction handles
+const retentionBatchSize = 500
+
+// RunRetentionJanitor purges expired messages and attachments until ctx is
+// cancelled. It returns straight away when no retention window is configured
+// and DeleteOnRead is off.
+func (s *Service) RunRetentionJanitor(ctx context.Context) {
+	if s.cfg.MessageRetention <= 0 && s.cfg.MediaRetention <= 0 && !s.cfg.DeleteOnRead {
+		return
+	}
+
+	ticker := time.NewTicker(s.cfg.RetentionCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if s.databaseDown() {
+				continue
+			}
+			if _, err := s.PurgeExpired(ctx); err != nil {
+				log.Printf("Retention janitor error: %v", err)
+			}
+		}
+	}
+}
+
+// PurgeExpired applies the retention windows: attachments older than
+// MediaRetention are deleted with their files (and their messages too when
+// MediaRetentionDeleteMessages is set), then every message older than
+// MessageRetention is deleted. Messages without attachments are only subject
+// to MessageRetention. With DeleteOnRead, messages every recipient has read
+// are deleted with their attachments first. It returns the number of messages
+// affected.
+func (s *Service) PurgeExpired(ctx context.Context) (int, error) {
+	now := time.Now()
+	total := 0
+	if s.cfg.DeleteOnRead {
+		n, err := s.purgeMessages(ctx, readByAllQuery, nil, true)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	if s.cfg.MediaRetention > 0 {
+		n, err := s.purgeMessages(ctx, expiredQuery, []interface{}{now.Add(-s.cfg.MediaRetention), true}, s.cfg.MediaRetentionDeleteMessages)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	if s.cfg.MessageRetention > 0 {
+		n, err := s.purgeMessages(ctx, expiredQuery, []interface{}{now.Add(-s.cfg.MessageRetention), false}, true)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// expiredQuery selects messages sent before $1, only those with attachments
+// when $2 is set
+const expiredQuery = `
+	SELECT m.id FROM messages m
+	WHERE m.created_at < $1
+	  AND (NOT $2 OR EXISTS (SELECT 1 FROM attachments a WHERE a.message_id = m.id))
+	ORDER BY m.created_at
+	LIMIT $3
+	FOR UPDATE SKIP LOCKED
+`
+
+// readByAllQuery selects messages with a read receipt from every recipient:
+// the other party of a direct message, or every member of the group but the
+// sender who was in it when the message was sent, as in messageStatusQuery. A
+// group message nobody else received is kept. System messages carry no ciphertext and are left alone.
+// Users who turned read receipts off never produce one, so messages to them
+// are kept until a retention window expires them.
+const readByAllQuery = `
+	SELECT m.id FROM messages m
+	WHERE m.message_type != 'system'
+	  AND CASE
+		WHEN m.recipient_id IS NOT NULL THEN EXISTS (
+			SELECT 1 FROM receipts r
+			WHERE r.message_id = m.id AND r.user_id = m.recipient_id AND r.type = 'read'
+		)
+		WHEN m.group_id IS NOT NULL THEN EXISTS (
+			SELECT 1 FROM group_members gm
+			WHERE gm.group_id = m.group_id AND gm.user_id != m.sender_id AND gm.joined_at <= m.created_at
+		) AND NOT EXISTS (
+			SELECT 1 FROM group_members gm
+			WHERE gm.group_id = m.group_id AND gm.user_id != m.sender_id AND gm.joined_at <= m.created_at
+			  AND NOT EXISTS (
+				SELECT 1 FROM receipts r
+				WHERE r.message_id = m.id AND r.user_id = gm.user_id AND r.type = 'read'
+			  )
+		)
+		ELSE FALSE
+	  END
+	ORDER BY m.created_at
+	LIMIT $1
+	FOR UPDATE SKIP LOCKED
+`
+
+// purgeMessages deletes the attachments of the messages selected by query,
+// and deletes the messages themselves when deleteMessages is set. query takes
+// args followed by the batch size. It works in batches so no single
+// transaction holds too many locks.
+func (s *Service) purgeMessages(ctx context.Context, query string, args []interface{}, deleteMessages bool) (int, error) {
+	args = append(args, retentionBatchSize)
+	total := 0
+	for {
+		n, err := s.purgeBatch(ctx, query, args, deleteMessages)
+		total += n
+		if err != nil || n < retentionBatchSize {
+			return total, err
+		}
+	}
+}
+
+// purgeBatch purges up to retentionBatchSize messages in one transaction
+func (s *Service) purgeBatch(ctx context.Context, query string, args []interface{}, deleteMessages bool) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	expired, err := selectMessageIDsTx(ctx, tx, query, args)
+	if err != nil || len(expired) == 0 {
+		return 0, err
+	}
+
+	files, err := deleteAttachmentsTx(ctx, tx, expired)
+	if err != nil {
+		return 0, err
+	}
+	if deleteMessages {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM messages WHERE id = ANY($1::uuid[])", uuidArray(expired)); err != nil {
+			return 0, fmt.Errorf("failed to delete expired messages: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit purge: %w", err)
+	}
+	s.removeReleasedFiles(ctx, files)
+	return len(expired), nil
+}
+
+// selectMessageIDsTx runs a query selecting message IDs within tx
+func selectMessageIDsTx(ctx context.Context, tx *sql.Tx, query string, args []interface{}) ([]uuid.UUID, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch expired messages: %w", err)
+	}
+	defer rows.Close()
+	var messageIDs []uuid.UUID
+	for rows.Next() {
+		var messageID uuid.UUID
+		if err := rows.Scan(&messageID); err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		messageIDs = append(messageIDs, messageID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to fetch expired messages: %w", err)
+	}
+	return messageIDs, nil
+}
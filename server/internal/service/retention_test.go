@@ -0,0 +1,165 @@
+package service_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/service"
+	"e2ee-messenger/server/internal/testutil"
+
+	"github.com/google/uuid"
+)
+
+func TestMediaRetentionRemovesOldPhotosButKeepsText(t *testing.T) {
+	t.Setenv("ATTACHMENTS_DIR", t.TempDir())
+	t.Setenv("MEDIA_RETENTION", "720h")
+	t.Setenv("MEDIA_RETENTION_DELETE_MESSAGES", "true")
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+
+	send := func(messageType string) *models.Message {
+		t.Helper()
+		message, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+			SenderID:         alice.ID,
+			RecipientID:      &bob.ID,
+			EncryptedContent: "ciphertext",
+			MessageType:      messageType,
+		})
+		if err != nil {
+			t.Fatalf("SendMessage failed: %v", err)
+		}
+		return message
+	}
+	photo := send("file")
+	text := send("text")
+
+	content := []byte("encrypted-photo")
+	sum := sha256.Sum256(content)
+	upload := filepath.Join(t.TempDir(), "upload")
+	if err := os.WriteFile(upload, content, 0644); err != nil {
+		t.Fatalf("Failed to write upload: %v", err)
+	}
+	err := svc.AddAttachment(context.Background(), models.Attachment{
+		MessageID:    photo.ID,
+		FileName:     "photo.jpg",
+		FileSize:     int64(len(content)),
+		MimeType:     "image/jpeg",
+		EncryptedKey: "encrypted-key",
+		ContentHash:  hex.EncodeToString(sum[:]),
+	}, upload)
+	if err != nil {
+		t.Fatalf("AddAttachment failed: %v", err)
+	}
+	var storagePath string
+	if err := db.QueryRow("SELECT storage_path FROM attachments WHERE message_id = $1", photo.ID).Scan(&storagePath); err != nil {
+		t.Fatalf("Failed to fetch attachment: %v", err)
+	}
+
+	// Nothing is inside the window yet
+	if purged, err := svc.PurgeExpired(context.Background()); err != nil || purged != 0 {
+		t.Fatalf("Expected nothing to be purged yet, got %d (%v)", purged, err)
+	}
+
+	if _, err := db.Exec("UPDATE messages SET created_at = $1", time.Now().Add(-31*24*time.Hour)); err != nil {
+		t.Fatalf("Failed to age messages: %v", err)
+	}
+	if purged, err := svc.PurgeExpired(context.Background()); err != nil || purged != 1 {
+		t.Fatalf("Expected 1 message to be purged, got %d (%v)", purged, err)
+	}
+
+	exists := func(messageID uuid.UUID) bool {
+		t.Helper()
+		var exists bool
+		if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM messages WHERE id = $1)", messageID).Scan(&exists); err != nil {
+			t.Fatalf("Failed to check message: %v", err)
+		}
+		return exists
+	}
+	if exists(photo.ID) {
+		t.Error("Expected the expired photo message to be deleted")
+	}
+	if !exists(text.ID) {
+		t.Error("Expected the text message of the same age to survive")
+	}
+	if _, err := os.Stat(storagePath); !os.IsNotExist(err) {
+		t.Errorf("Expected the photo file to be removed, got %v", err)
+	}
+}
+
+func TestDeleteOnReadPurgesMessagesReadByEveryRecipient(t *testing.T) {
+	t.Setenv("DELETE_ON_READ", "true")
+	svc, db, _ := setupService(t)
+	ctx := context.Background()
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	carol := testutil.CreateUser(t, db, "carol")
+
+	read := func(user models.User, message *models.Message) {
+		t.Helper()
+		if _, err := svc.SendReceipt(ctx, user.ID, message.ID, "read"); err != nil {
+			t.Fatalf("SendReceipt failed: %v", err)
+		}
+	}
+	readDirect := sendText(t, svc, alice, bob)
+	unreadDirect := sendText(t, svc, alice, bob)
+	read(bob, readDirect)
+
+	// Dave joins after the messages were sent, so they never needed his receipt
+	dave := testutil.CreateUser(t, db, "dave")
+	group := createGroup(t, svc, alice, bob, carol, dave)
+	if _, err := db.Exec("UPDATE group_members SET joined_at = joined_at + INTERVAL '1 minute' WHERE group_id = $1 AND user_id = $2", group.ID, dave.ID); err != nil {
+		t.Fatalf("Failed to move dave's join time: %v", err)
+	}
+	// Nobody but the sender received a message to a group of one
+	soloGroup := createGroup(t, svc, alice)
+	sendTo := func(group *models.Group) *models.Message {
+		t.Helper()
+		message, err := svc.SendMessage(ctx, service.SendMessageInput{
+			SenderID:         alice.ID,
+			GroupID:          &group.ID,
+			EncryptedContent: "ciphertext",
+			MessageType:      "text",
+		})
+		if err != nil {
+			t.Fatalf("SendMessage failed: %v", err)
+		}
+		return message
+	}
+	readByAll := sendTo(group)
+	readBySome := sendTo(group)
+	unreceived := sendTo(soloGroup)
+	read(bob, readByAll)
+	read(carol, readByAll)
+	read(bob, readBySome)
+
+	if purged, err := svc.PurgeExpired(ctx); err != nil || purged != 2 {
+		t.Fatalf("Expected 2 messages to be purged, got %d (%v)", purged, err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		message *models.Message
+		kept    bool
+	}{
+		{"read direct message", readDirect, false},
+		{"unread direct message", unreadDirect, true},
+		{"group message read by everyone", readByAll, false},
+		{"group message read by some", readBySome, true},
+		{"group message without recipients", unreceived, true},
+	} {
+		var exists bool
+		if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM messages WHERE id = $1)", tt.message.ID).Scan(&exists); err != nil {
+			t.Fatalf("Failed to check message: %v", err)
+		}
+		if exists != tt.kept {
+			t.Errorf("%s: expected kept=%v, got %v", tt.name, tt.kept, exists)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// Message content is encrypted, so search only covers what the server can
+// read: usernames, and the names of the caller's groups. Groups with
+// client-encrypted metadata have no name to match.
+
+// Search query length bounds, in characters. Trigram indexes only help with
+// queries of three characters or more.
+const (
+	minSearchQuery = 3
+	maxSearchQuery = 64
+)
+
+// maxSearchResults caps how many users and groups a search returns together
+const maxSearchResults = 20
+
+// likeEscaper escapes the LIKE wildcards in a search query
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Search finds users, except those who blocked the caller, and groups the
+// caller belongs to whose name contains query, ignoring case. Results whose
+// conversation with the caller had the most recent message come first,
+// followed by those without one, alphabetically.
+func (s *Service) Search(ctx context.Context, userID uuid.UUID, query string) ([]models.SearchResult, error) {
+	query = strings.TrimSpace(query)
+	if n := utf8.RuneCountInString(query); n < minSearchQuery || n > maxSearchQuery {
+		return nil, fmt.Errorf("q must be between %d and %d characters: %w", minSearchQuery, maxSearchQuery, apperrors.ErrInvalidInput)
+	}
+	pattern := "%" + likeEscaper.Replace(query) + "%"
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT 'dm', u.id, u.username, u.avatar_url, NULL, NULL, NULL, NULL,
+			last.id, last.encrypted_content, last.message_type, last.created_at
+		FROM users u
+		LEFT JOIN LATERAL (
+			SELECT m.id, m.encrypted_content, m.message_type, m.created_at FROM messages m
+			WHERE m.group_id IS NULL
+			  AND ((m.sender_id = $1 AND m.recipient_id = u.id) OR (m.sender_id = u.id AND m.recipient_id = $1))
+			  AND m.deleted_at IS NULL AND (m.expires_at IS NULL OR m.expires_at > NOW())
+			ORDER BY m.created_at DESC
+			LIMIT 1
+		) last ON TRUE
+		WHERE u.username ILIKE $2 AND u.id != $1
+		  AND NOT EXISTS (SELECT 1 FROM blocks WHERE blocker_id = u.id AND blocked_id = $1)
+
+		UNION ALL
+
+		SELECT 'group', g.id, g.name, NULL, g.description, g.created_by, g.created_at, g.updated_at,
+			last.id, last.encrypted_content, last.message_type, last.created_at
+		FROM groups g
+		JOIN group_members gm ON gm.group_id = g.id AND gm.user_id = $1
+		LEFT JOIN LATERAL (
+			SELECT m.id, m.encrypted_content, m.message_type, m.created_at FROM messages m
+			WHERE m.group_id = g.id
+			  AND m.deleted_at IS NULL AND (m.expires_at IS NULL OR m.expires_at > NOW())
+			ORDER BY m.created_at DESC
+			LIMIT 1
+		) last ON TRUE
+		WHERE g.name ILIKE $2
+
+		ORDER BY 12 DESC NULLS LAST, 3, 2
+		LIMIT $3
+	`, userID, pattern, maxSearchResults)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search: %w", err)
+	}
+	defer rows.Close()
+
+	results := []models.SearchResult{}
+	for rows.Next() {
+		var result models.SearchResult
+		var id uuid.UUID
+		var name string
+		var avatarURL, description sql.NullString
+		var createdBy *uuid.UUID
+		var groupCreatedAt, groupUpdatedAt sql.NullTime
+		var messageID *uuid.UUID
+		var content, messageType sql.NullString
+		var sentAt sql.NullTime
+		err := rows.Scan(&result.Type, &id, &name, &avatarURL, &description, &createdBy, &groupCreatedAt, &groupUpdatedAt,
+			&messageID, &content, &messageType, &sentAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan search result: %w", err)
+		}
+
+		if result.Type == "group" {
+			result.Group = &models.Group{ID: id, Name: name, Description: description.String, CreatedAt: groupCreatedAt.Time, UpdatedAt: groupUpdatedAt.Time}
+			if createdBy != nil {
+				result.Group.CreatedBy = *createdBy
+			}
+		} else {
+			result.User = &models.User{ID: id, Username: name, AvatarURL: avatarURL.String}
+		}
+		if messageID != nil {
+			result.LastMessage = &models.Message{
+				ID:               *messageID,
+				EncryptedContent: content.String,
+				MessageType:      messageType.String,
+				CreatedAt:        sentAt.Time,
+			}
+		}
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to search: %w", err)
+	}
+	return results, nil
+}
@@ -0,0 +1,67 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/testutil"
+)
+
+func TestSearchRanksByLastInteraction(t *testing.T) {
+	svc, db, _ := setupService(t)
+	ctx := context.Background()
+	alice := testutil.CreateUser(t, db, "alice")
+	frida := testutil.CreateUser(t, db, "frida")
+	fritz := testutil.CreateUser(t, db, "fritz")
+	frieda := testutil.CreateUser(t, db, "frieda")
+	group := createGroup(t, svc, alice, frida)
+	createGroup(t, svc, frieda, fritz) // alice is not a member
+
+	sendText(t, svc, alice, frida)
+	latest := sendText(t, svc, fritz, alice)
+	if err := svc.BlockUser(ctx, frieda.ID, alice.ID); err != nil {
+		t.Fatalf("BlockUser failed: %v", err)
+	}
+
+	results, err := svc.Search(ctx, alice.ID, "FRI")
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("Expected fritz, frida and alice's group, got %+v", results)
+	}
+	if results[0].User == nil || results[0].User.ID != fritz.ID {
+		t.Errorf("Expected fritz first, got %+v", results[0])
+	}
+	if results[0].LastMessage == nil || results[0].LastMessage.ID != latest.ID {
+		t.Errorf("Expected fritz's result to carry the latest message, got %+v", results[0].LastMessage)
+	}
+	if results[1].User == nil || results[1].User.ID != frida.ID {
+		t.Errorf("Expected frida second, got %+v", results[1])
+	}
+	if results[2].Type != "group" || results[2].Group == nil || results[2].Group.ID != group.ID {
+		t.Errorf("Expected alice's group last, got %+v", results[2])
+	}
+}
+
+func TestSearchValidatesAndEscapesQuery(t *testing.T) {
+	svc, db, _ := setupService(t)
+	ctx := context.Background()
+	alice := testutil.CreateUser(t, db, "alice")
+	testutil.CreateUser(t, db, "fred")
+
+	if _, err := svc.Search(ctx, alice.ID, " fr "); !errors.Is(err, apperrors.ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput for a query too short for trigrams, got %v", err)
+	}
+
+	// Wildcards are matched literally
+	results, err := svc.Search(ctx, alice.ID, "_ed")
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("Expected no match for a literal underscore, got %+v", results)
+	}
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"e2ee-messenger/server/internal/config"
+	"e2ee-messenger/server/internal/database"
+	"e2ee-messenger/server/internal/push"
+	"e2ee-messenger/server/internal/ratelimit"
+	"e2ee-messenger/server/internal/webhook"
+	"e2ee-messenger/server/internal/websocket"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Service contains the business logic behind the HTTP handlers. Methods take
+// plain inputs and return domain errors from the apperrors package, so they
+// can be exercised without building HTTP requests.
+type Service struct {
+	db  *database.DB
+	hub *websocket.Hub
+	cfg *config.Config
+
+	// Wakes the outbox relay when new events are committed
+	outboxSignal chan struct{}
+
+	// Limits how often a user can ask for messages to be redelivered
+	redeliverLimiter *ratelimit.Limiter
+
+	// Notifies offline users' devices of new messages
+	pushSender push.Sender
+
+	// Reports server events to operator webhooks; nil when none are configured
+	webhooks *webhook.Dispatcher
+
+	// Sends waiting for their direct message to be delivered
+	deliveryWaiters deliveryWaiters
+}
+
+// New creates a new service instance. Users are replayed the group messages
+// they missed while offline whenever they connect to hub, events the hub
+// gives up on are recorded as dead letters, typing events clients send are
+// relayed to their conversations, and users' contacts hear when they go
+// online or offline.
+func New(db *database.DB, hub *websocket.Hub, cfg *config.Config) *Service {
+	s := &Service{
+		db:           db,
+		hub:          hub,
+		cfg:          cfg,
+		outboxSignal: make(chan struct{}, 1),
+
+		redeliverLimiter: ratelimit.New(redeliverLimit, time.Minute),
+		pushSender:       push.LogSender{},
+	}
+	if hub != nil {
+		hub.OnConnect(s.replayOnConnect)
+		hub.OnWake(s.replayOnWake)
+		hub.OnDeadLetter(s.recordDeadLetterOnHub)
+		hub.OnTyping(s.relayTypingOnHub)
+		hub.OnPresence(s.presenceChangedOnHub)
+	}
+	return s
+}
+
+// databaseDown reports whether the database circuit breaker is open.
+// Background jobs skip their runs while it is, rather than piling up errors.
+func (s *Service) databaseDown() bool {
+	_, down := s.db.Unavailable()
+	return down
+}
+
+// uuidArray converts IDs into a Postgres array parameter. Use it with an
+// explicit ::uuid[] cast in the query.
+func uuidArray(ids []uuid.UUID) pq.StringArray {
+	if ids == nil {
+		return nil
+	}
+	arr := make(pq.StringArray, len(ids))
+	for i, id := range ids {
+		arr[i] = id.String()
+	}
+	return arr
+}
+
+// parseUUIDArray converts a scanned Postgres uuid[] back into IDs
+func parseUUIDArray(arr pq.StringArray) ([]uuid.UUID, error) {
+	if len(arr) == 0 {
+		return nil, nil
+	}
+	ids := make([]uuid.UUID, len(arr))
+	for i, s := range arr {
+		id, err := uuid.Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid uuid in array: %w", err)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
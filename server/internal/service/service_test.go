@@ -0,0 +1,214 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/config"
+	"e2ee-messenger/server/internal/database"
+	"e2ee-messenger/server/internal/service"
+	"e2ee-messenger/server/internal/testutil"
+	"e2ee-messenger/server/internal/websocket"
+
+	"github.com/google/uuid"
+)
+
+// setupService creates a service with its outbox relay running
+func setupService(t *testing.T) (*service.Service, *database.DB, *websocket.Hub) {
+	t.Helper()
+	db := testutil.NewDB(t)
+	hub := testutil.NewHub(t)
+	cfg := config.Load()
+	cfg.JWTSecret = "test-secret"
+	svc := service.New(db, hub, cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go svc.RunOutboxRelay(ctx)
+
+	return svc, db, hub
+}
+
+func TestSendMessage(t *testing.T) {
+	svc, db, hub := setupService(t)
+	sender := testutil.CreateUser(t, db, "alice")
+	recipient := testutil.CreateUser(t, db, "bob")
+	recipientClient := testutil.ConnectClient(t, hub, recipient.ID)
+
+	message, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         sender.ID,
+		RecipientID:      &recipient.ID,
+		EncryptedContent: "encrypted-message-content",
+		MessageType:      "text",
+	})
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	if message.SenderID != sender.ID {
+		t.Errorf("Expected sender ID %s, got %s", sender.ID, message.SenderID)
+	}
+	if message.RecipientID == nil || *message.RecipientID != recipient.ID {
+		t.Errorf("Expected recipient ID %s, got %v", recipient.ID, message.RecipientID)
+	}
+
+	testutil.ExpectEvent(t, recipientClient, "new_message")
+}
+
+func TestSendMessageRequiresExactlyOneTarget(t *testing.T) {
+	svc, _, _ := setupService(t)
+
+	_, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         uuid.New(),
+		EncryptedContent: "encrypted-message-content",
+		MessageType:      "text",
+	})
+	if !errors.Is(err, apperrors.ErrInvalidInput) {
+		t.Errorf("Expected ErrInvalidInput, got %v", err)
+	}
+}
+
+func TestSendGroupMessageRequiresMembership(t *testing.T) {
+	svc, db, _ := setupService(t)
+	owner := testutil.CreateUser(t, db, "alice")
+	outsider := testutil.CreateUser(t, db, "mallory")
+
+	group, err := svc.CreateGroup(context.Background(), service.CreateGroupInput{CreatorID: owner.ID, Name: "Friends"})
+	if err != nil {
+		t.Fatalf("CreateGroup failed: %v", err)
+	}
+
+	_, err = svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         outsider.ID,
+		GroupID:          &group.ID,
+		EncryptedContent: "encrypted-message-content",
+		MessageType:      "text",
+	})
+	if !errors.Is(err, apperrors.ErrNotGroupMember) {
+		t.Errorf("Expected ErrNotGroupMember, got %v", err)
+	}
+}
+
+func TestCreateGroup(t *testing.T) {
+	svc, db, _ := setupService(t)
+	owner := testutil.CreateUser(t, db, "alice")
+	member := testutil.CreateUser(t, db, "bob")
+
+	group, err := svc.CreateGroup(context.Background(), service.CreateGroupInput{
+		CreatorID: owner.ID,
+		Name:      "Friends",
+		MemberIDs: []uuid.UUID{member.ID, owner.ID},
+	})
+	if err != nil {
+		t.Fatalf("CreateGroup failed: %v", err)
+	}
+
+	if group.CreatedBy != owner.ID {
+		t.Errorf("Expected group owner %s, got %s", owner.ID, group.CreatedBy)
+	}
+
+	var ownerRole string
+	if err := db.QueryRow("SELECT role FROM group_members WHERE group_id = $1 AND user_id = $2", group.ID, owner.ID).Scan(&ownerRole); err != nil {
+		t.Fatalf("Failed to fetch owner membership: %v", err)
+	}
+	if ownerRole != "admin" {
+		t.Errorf("Expected creator to be admin, got %s", ownerRole)
+	}
+
+	if err := svc.RequireGroupMember(context.Background(), group.ID, member.ID); err != nil {
+		t.Errorf("Expected %s to be a member: %v", member.Username, err)
+	}
+}
+
+func TestRedeliverMessage(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+
+	message, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         alice.ID,
+		RecipientID:      &bob.ID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "text",
+	})
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	// bob connects after the original push went out and has it replayed
+	bobClient := testutil.ConnectClient(t, hub, bob.ID)
+	testutil.ExpectEvent(t, bobClient, "new_message")
+	testutil.ExpectNoEvent(t, bobClient)
+
+	if err := svc.RedeliverMessage(context.Background(), alice.ID, message.ID); err != nil {
+		t.Fatalf("RedeliverMessage failed: %v", err)
+	}
+	event := testutil.ExpectEvent(t, bobClient, "new_message")
+	if payload := event.Payload.(map[string]interface{}); payload["id"] != message.ID.String() {
+		t.Errorf("Expected message %s to be redelivered, got %v", message.ID, payload["id"])
+	}
+
+	if err := svc.RedeliverMessage(context.Background(), bob.ID, message.ID); !errors.Is(err, apperrors.ErrForbidden) {
+		t.Errorf("Expected only the sender to redeliver, got %v", err)
+	}
+}
+
+func TestRedeliverMessageIsRateLimited(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+
+	message, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         alice.ID,
+		RecipientID:      &bob.ID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "text",
+	})
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	for i := 0; i < 100 && err == nil; i++ {
+		err = svc.RedeliverMessage(context.Background(), alice.ID, message.ID)
+	}
+	if !errors.Is(err, apperrors.ErrRateLimited) {
+		t.Errorf("Expected ErrRateLimited, got %v", err)
+	}
+}
+
+func TestMessageTimestampsAreUTC(t *testing.T) {
+	svc, db, _ := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+
+	message, err := svc.SendMessage(context.Background(), service.SendMessageInput{
+		SenderID:         alice.ID,
+		RecipientID:      &bob.ID,
+		EncryptedContent: "ciphertext",
+		MessageType:      "text",
+	})
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if message.CreatedAt.Location() != time.UTC {
+		t.Errorf("Expected a UTC timestamp, got %s", message.CreatedAt.Location())
+	}
+
+	// Timestamps read back from the database are UTC as well
+	var stored time.Time
+	if err := db.QueryRow("SELECT created_at FROM messages WHERE id = $1", message.ID).Scan(&stored); err != nil {
+		t.Fatalf("Failed to fetch message: %v", err)
+	}
+	encoded, err := json.Marshal(stored)
+	if err != nil {
+		t.Fatalf("Failed to encode timestamp: %v", err)
+	}
+	if !strings.HasSuffix(string(encoded), `Z"`) {
+		t.Errorf("Expected an RFC3339 timestamp with a Z suffix, got %s", encoded)
+	}
+}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/websocket"
+
+	"github.com/google/uuid"
+)
+
+// maxListedSessions caps how many sessions are returned, newest first
+const maxListedSessions = 50
+
+// SessionMetadata is what we record about a websocket connection
+type SessionMetadata struct {
+	RemoteAddr string
+	UserAgent  string
+	Origin     string
+}
+
+// OpenSession records a new websocket connection for the user on this instance
+func (s *Service) OpenSession(ctx context.Context, userID uuid.UUID, meta SessionMetadata) (*models.Session, error) {
+	now := time.Now().UTC()
+	session := models.Session{
+		ID:           uuid.New(),
+		UserID:       userID,
+		RemoteAddr:   meta.RemoteAddr,
+		UserAgent:    meta.UserAgent,
+		Origin:       meta.Origin,
+		ConnectedAt:  now,
+		LastActiveAt: now,
+		Active:       true,
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO sessions (id, user_id, remote_addr, user_agent, origin, connected_at, last_active_at, instance_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, session.ID, session.UserID, session.RemoteAddr, session.UserAgent, session.Origin, session.ConnectedAt, session.LastActiveAt, s.cfg.InstanceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record session: %w", err)
+	}
+
+	return &session, nil
+}
+
+// ReopenSession marks a closed session of the user as active again for a
+// client that resumed it, recording where it reconnected from and that this
+// instance now holds it
+func (s *Service) ReopenSession(ctx context.Context, userID, sessionID uuid.UUID, meta SessionMetadata) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE sessions SET closed_at = NULL, last_active_at = $3, remote_addr = $4, user_agent = $5, origin = $6, instance_id = $7
+		WHERE id = $1 AND user_id = $2
+	`, sessionID, userID, time.Now().UTC(), meta.RemoteAddr, meta.UserAgent, meta.Origin, s.cfg.InstanceID)
+	if err != nil {
+		return fmt.Errorf("failed to reopen session: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("session not found: %w", apperrors.ErrNotFound)
+	}
+	return nil
+}
+
+// CloseSession marks a session as closed, recording when it was last active
+func (s *Service) CloseSession(ctx context.Context, sessionID uuid.UUID, lastActive time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE sessions SET last_active_at = GREATEST(last_active_at, $2), closed_at = $3
+		WHERE id = $1
+	`, sessionID, lastActive, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to close session: %w", err)
+	}
+	return nil
+}
+
+// ListSessions returns the user's most recent sessions, newest first
+func (s *Service) ListSessions(ctx context.Context, userID uuid.UUID) ([]models.Session, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, user_id, remote_addr, user_agent, origin, connected_at, last_active_at, closed_at
+		FROM sessions
+		WHERE user_id = $1
+		ORDER BY connected_at DESC
+		LIMIT $2
+	`, userID, maxListedSessions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		var session models.Session
+		var closedAt sql.NullTime
+		if err := rows.Scan(&session.ID, &session.UserID, &session.RemoteAddr, &session.UserAgent, &session.Origin,
+			&session.ConnectedAt, &session.LastActiveAt, &closedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		if closedAt.Valid {
+			session.ClosedAt = &closedAt.Time
+		}
+		session.Active = !closedAt.Valid
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// LogoutAll revokes every access and refresh token the user holds and closes
+// their websocket connections, forcing each of their devices to log in again.
+// Their push tokens are unregistered too, so logged-out devices stop being
+// notified.
+// Tokens carry their issue time in whole seconds, so tokens issued later
+// within the same second are revoked too.
+func (s *Service) LogoutAll(ctx context.Context, userID uuid.UUID) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE users SET tokens_valid_after = date_trunc('second', NOW()) WHERE id = $1
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke tokens: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return apperrors.ErrUserNotFound
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM push_tokens WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("failed to remove push tokens: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM refresh_tokens WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("failed to remove refresh tokens: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	s.hub.DisconnectUser(userID.String(), websocket.StatusSessionRevoked, "logged out")
+	return nil
+}
+
+// TokensValidAfter returns the time at or before which the user's tokens
+// were revoked, or the zero time if they never were
+func (s *Service) TokensValidAfter(ctx context.Context, userID uuid.UUID) (time.Time, error) {
+	var validAfter sql.NullTime
+	err := s.db.QueryRowContext(ctx, "SELECT tokens_valid_after FROM users WHERE id = $1", userID).Scan(&validAfter)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, apperrors.ErrUserNotFound
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to check token revocation: %w", err)
+	}
+	return validAfter.Time, nil
+}
+
+// RevokeToken revokes a single token, identified by its jti claim, until it
+// expires. Revoking a token that is already revoked returns ErrUnauthorized.
+func (s *Service) RevokeToken(ctx context.Context, userID uuid.UUID, tokenID string, expiresAt time.Time) error {
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO revoked_tokens (token_id, user_id, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (token_id) DO NOTHING
+	`, tokenID, userID, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return apperrors.ErrUnauthorized
+	}
+	return nil
+}
+
+// IsTokenRevoked reports whether the token with the given jti was revoked
+func (s *Service) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	var revoked bool
+	err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE token_id = $1)", tokenID).Scan(&revoked)
+	if err != nil {
+		return false, fmt.Errorf("failed to check token revocation: %w", err)
+	}
+	return revoked, nil
+}
+
+// RunRevokedTokenJanitor periodically forgets revoked tokens that have
+// expired, and expired refresh tokens, until ctx is cancelled
+func (s *Service) RunRevokedTokenJanitor(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.RevokedTokenPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if s.databaseDown() {
+				continue
+			}
+			if _, err := s.PurgeRevokedTokens(ctx); err != nil {
+				log.Printf("Revoked token janitor error: %v", err)
+			}
+			if _, err := s.PurgeExpiredRefreshTokens(ctx); err != nil {
+				log.Printf("Revoked token janitor error: %v", err)
+			}
+		}
+	}
+}
+
+// PurgeRevokedTokens deletes revocations of tokens that have expired, which
+// the auth middleware rejects anyway. It returns how many were deleted.
+func (s *Service) PurgeRevokedTokens(ctx context.Context) (int64, error) {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM revoked_tokens WHERE expires_at < NOW()")
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge revoked tokens: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return n, nil
+}
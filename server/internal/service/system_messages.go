@@ -0,0 +1,41 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"e2ee-messenger/server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// System message actions
+const (
+	systemMemberAdded   = "member_added"
+	systemMemberJoined  = "member_joined"
+	systemMemberRemoved = "member_removed"
+	systemMemberLeft    = "member_left"
+	systemGroupRenamed  = "group_renamed"
+
+	systemConversationPromoted = "conversation_promoted"
+)
+
+// insertSystemMessageTx posts a server-authored "system" message describing a
+// group event into the group's timeline within tx, and queues it for delivery
+// like any other message. The event is stored as plaintext in system_payload
+// and the message carries no encrypted content or sender, so it cannot be
+// mistaken for user content and its actor cannot delete it. Callers wake the
+// outbox relay once tx commits.
+func insertSystemMessageTx(ctx context.Context, tx *sql.Tx, groupID uuid.UUID, event models.SystemEvent) error {
+	messageID := uuid.New()
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO messages (id, group_id, encrypted_content, message_type, system_payload, created_at)
+		VALUES ($1, $2, '', 'system', $3, $4)
+	`, messageID, groupID, event, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to insert system message: %w", err)
+	}
+	return enqueueNewMessage(ctx, tx, messageID)
+}
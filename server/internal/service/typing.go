@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"e2ee-messenger/server/internal/websocket"
+
+	"github.com/google/uuid"
+)
+
+// relayTypingOnHub is called by the hub for every typing event a client sends
+func (s *Service) relayTypingOnHub(userID string, typing websocket.Typing) {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return
+	}
+	if err := s.RelayTyping(context.Background(), id, typing); err != nil {
+		log.Printf("Failed to relay typing event from user %s: %v", userID, err)
+	}
+}
+
+// RelayTyping tells the other side of a direct conversation, or the other
+// members of a group, that the user is typing. Nothing is stored, and the
+// event is not redelivered to anyone who misses it. Group typing events need
+// the user to be a member, and direct ones are not relayed to a recipient who
+// blocked the user.
+func (s *Service) RelayTyping(ctx context.Context, userID uuid.UUID, typing websocket.Typing) error {
+	if typing.GroupID != uuid.Nil {
+		if err := s.RequireGroupMember(ctx, typing.GroupID, userID); err != nil {
+			return err
+		}
+		_, err := s.SendToGroup(ctx, GroupFanout{GroupID: typing.GroupID, ActorID: userID, Ephemeral: true}, websocket.TypingEvent(userID, &typing.GroupID))
+		return err
+	}
+
+	var blocked bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM blocks WHERE blocker_id = $1 AND blocked_id = $2)
+	`, typing.RecipientID, userID).Scan(&blocked)
+	if err != nil {
+		return fmt.Errorf("failed to check block: %w", err)
+	}
+	if !blocked && typing.RecipientID != userID {
+		s.hub.SendEphemeral(typing.RecipientID.String(), websocket.TypingEvent(userID, nil))
+	}
+	return nil
+}
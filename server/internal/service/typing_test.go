@@ -0,0 +1,42 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/testutil"
+	"e2ee-messenger/server/internal/websocket"
+)
+
+func TestRelayTypingRespectsMembershipAndBlocks(t *testing.T) {
+	svc, db, hub := setupService(t)
+	alice := testutil.CreateUser(t, db, "alice")
+	bob := testutil.CreateUser(t, db, "bob")
+	mallory := testutil.CreateUser(t, db, "mallory")
+	group := createGroup(t, svc, alice, bob)
+	bobClient := testutil.ConnectClient(t, hub, bob.ID)
+	ctx := context.Background()
+
+	if err := svc.RelayTyping(ctx, alice.ID, websocket.Typing{GroupID: group.ID}); err != nil {
+		t.Fatalf("RelayTyping failed: %v", err)
+	}
+	event := testutil.ExpectEvent(t, bobClient, websocket.EventTyping)
+	payload := event.Payload.(map[string]interface{})
+	if payload["user_id"] != alice.ID.String() || payload["group_id"] != group.ID.String() {
+		t.Errorf("Expected alice typing in the group, got %v", payload)
+	}
+
+	if err := svc.RelayTyping(ctx, mallory.ID, websocket.Typing{GroupID: group.ID}); !errors.Is(err, apperrors.ErrNotGroupMember) {
+		t.Errorf("Expected ErrNotGroupMember for an outsider, got %v", err)
+	}
+
+	if err := svc.BlockUser(ctx, bob.ID, mallory.ID); err != nil {
+		t.Fatalf("BlockUser failed: %v", err)
+	}
+	if err := svc.RelayTyping(ctx, mallory.ID, websocket.Typing{RecipientID: bob.ID}); err != nil {
+		t.Fatalf("RelayTyping failed: %v", err)
+	}
+	testutil.ExpectNoEvent(t, bobClient)
+}
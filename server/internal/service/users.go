@@ -0,0 +1,236 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"e2ee-messenger/server/internal/apperrors"
+	"e2ee-messenger/server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// GetUser fetches a user by ID, including their password hash
+func (s *Service) GetUser(ctx context.Context, userID uuid.UUID) (*models.User, error) {
+	var user models.User
+	var avatarURL sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, username, email, password, avatar_url, created_at, updated_at
+		FROM users WHERE id = $1
+	`, userID).Scan(&user.ID, &user.Username, &user.Email, &user.Password, &avatarURL, &user.CreatedAt, &user.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apperrors.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+	user.AvatarURL = avatarURL.String
+	return &user, nil
+}
+
+// DeleteAccount permanently deletes a user. Their group memberships are
+// wound down first, as if they had left each group, so no group is left
+// without an admin and the remaining members are told. Groups they still
+// own without being a member are handed to a member too. The attachments of
+// the messages going with them release their blobs, like any other deleted
+// attachment. The released files and their avatar file are removed once the
+// deletion has committed. Everything else the user owns is removed by ON DELETE CASCADE.
+func (s *Service) DeleteAccount(ctx context.Context, userID uuid.UUID) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var avatarURL sql.NullString
+	err = tx.QueryRowContext(ctx, "SELECT avatar_url FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&avatarURL)
+	if errors.Is(err, sql.ErrNoRows) {
+		return apperrors.ErrUserNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	// Leave groups in a stable order so concurrent deletions lock them consistently
+	rows, err := tx.QueryContext(ctx, "SELECT group_id FROM group_members WHERE user_id = $1 ORDER BY group_id", userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user groups: %w", err)
+	}
+	var groupIDs []uuid.UUID
+	for rows.Next() {
+		var groupID uuid.UUID
+		if err := rows.Scan(&groupID); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan group: %w", err)
+		}
+		groupIDs = append(groupIDs, groupID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to get user groups: %w", err)
+	}
+
+	var files releasedFiles
+	departures := make([]groupDeparture, 0, len(groupIDs))
+	for _, groupID := range groupIDs {
+		departure, err := departGroupTx(ctx, tx, groupID, userID)
+		if err != nil {
+			return err
+		}
+		files.merge(departure.files)
+		departures = append(departures, departure)
+	}
+
+	// Groups the user was removed from may still name them as owner, from
+	// before removals moved ownership on
+	rows, err = tx.QueryContext(ctx, "SELECT id FROM groups WHERE created_by = $1 ORDER BY id FOR UPDATE", userID)
+	if err != nil {
+		return fmt.Errorf("failed to get owned groups: %w", err)
+	}
+	var ownedIDs []uuid.UUID
+	for rows.Next() {
+		var groupID uuid.UUID
+		if err := rows.Scan(&groupID); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan group: %w", err)
+		}
+		ownedIDs = append(ownedIDs, groupID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to get owned groups: %w", err)
+	}
+	for _, groupID := range ownedIDs {
+		if err := reassignOwnerTx(ctx, tx, groupID, userID); err != nil {
+			return err
+		}
+	}
+
+	// The cascade deletes every message the user sent or received, but
+	// only deleting their attachments here keeps the blob reference counts right
+	var messageIDs []uuid.UUID
+	rows, err = tx.QueryContext(ctx, `
+		SELECT DISTINCT m.id FROM messages m
+		JOIN attachments a ON a.message_id = m.id
+		WHERE m.sender_id = $1 OR m.recipient_id = $1
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user attachments: %w", err)
+	}
+	for rows.Next() {
+		var messageID uuid.UUID
+		if err := rows.Scan(&messageID); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan message: %w", err)
+		}
+		messageIDs = append(messageIDs, messageID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to get user attachments: %w", err)
+	}
+	messageFiles, err := deleteAttachmentsTx(ctx, tx, messageIDs)
+	if err != nil {
+		return err
+	}
+	files.merge(messageFiles)
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return apperrors.ErrUserNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	s.removeReleasedFiles(ctx, files)
+	if avatarURL.Valid {
+		s.removeAvatar(avatarURL.String)
+	}
+
+	for _, departure := range departures {
+		s.notifyDeparture(ctx, userID, departure)
+	}
+	return nil
+}
+
+// MoveLegacyAvatars moves the avatar files of users from legacyDir, where an
+// older default AVATARS_DIR kept them, into AvatarsDir so their /uploads/ URLs
+// keep working. Only files named by an avatar_url are moved, and none that
+// AvatarsDir already has. It returns how many files were moved.
+func (s *Service) MoveLegacyAvatars(ctx context.Context, legacyDir string) (int, error) {
+	legacy, err := filepath.Abs(legacyDir)
+	if err != nil {
+		return 0, fmt.Errorf("invalid legacy avatars directory: %w", err)
+	}
+	avatars, err := filepath.Abs(s.cfg.AvatarsDir)
+	if err != nil {
+		return 0, fmt.Errorf("invalid AVATARS_DIR: %w", err)
+	}
+	if legacy == avatars {
+		return 0, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT avatar_url FROM users WHERE avatar_url LIKE '/uploads/%'")
+	if err != nil {
+		return 0, fmt.Errorf("failed to list avatars: %w", err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var avatarURL string
+		if err := rows.Scan(&avatarURL); err != nil {
+			return 0, fmt.Errorf("failed to scan avatar: %w", err)
+		}
+		if name := filepath.Base(strings.TrimPrefix(avatarURL, "/uploads/")); name != "." && name != "/" {
+			names = append(names, name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to list avatars: %w", err)
+	}
+
+	moved := 0
+	for _, name := range names {
+		src := filepath.Join(legacy, name)
+		dst := filepath.Join(avatars, name)
+		if _, err := os.Stat(src); err != nil {
+			continue
+		}
+		if _, err := os.Stat(dst); err == nil {
+			continue
+		}
+		if err := os.MkdirAll(avatars, 0755); err != nil {
+			return moved, fmt.Errorf("failed to create avatars directory: %w", err)
+		}
+		if err := os.Rename(src, dst); err != nil {
+			return moved, fmt.Errorf("failed to move avatar %s: %w", name, err)
+		}
+		moved++
+	}
+	return moved, nil
+}
+
+// removeAvatar removes the file behind an avatar URL set by UploadAvatar.
+// Avatars hosted elsewhere are left alone. The account is already gone, so
+// failures are only logged.
+func (s *Service) removeAvatar(avatarURL string) {
+	name, ok := strings.CutPrefix(avatarURL, "/uploads/")
+	if !ok || name == "" {
+		return
+	}
+	path := filepath.Join(s.cfg.AvatarsDir, filepath.Base(name))
+	if err := os.Remove(path); err != nil {
+		log.Printf("Failed to remove avatar file %s: %v", path, err)
+	}
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"e2ee-messenger/server/internal/websocket"
+
+	"github.com/google/uuid"
+)
+
+// consumeViewOnce wipes the content and attachments of the view-once
+// messages among messageIDs that readerID received and has not opened
+// before, leaving a tombstone with consumed_at set. Both the sender and the
+// reader are sent a "message_consumed" event for each of them. Senders learn
+// of the read even when the reader turned read receipts off, as the
+// message disappearing for them would tell them anyway.
+func (s *Service) consumeViewOnce(ctx context.Context, readerID uuid.UUID, messageIDs []uuid.UUID) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	consumedAt := time.Now().UTC()
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE messages SET encrypted_content = '', consumed_at = $1
+		WHERE id = ANY($2::uuid[]) AND recipient_id = $3
+		  AND view_once AND consumed_at IS NULL AND deleted_at IS NULL
+		RETURNING id, sender_id
+	`, consumedAt, uuidArray(messageIDs), readerID)
+	if err != nil {
+		return fmt.Errorf("failed to consume view-once messages: %w", err)
+	}
+	senders := make(map[uuid.UUID]uuid.UUID)
+	var consumed []uuid.UUID
+	for rows.Next() {
+		var messageID, senderID uuid.UUID
+		if err := rows.Scan(&messageID, &senderID); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan consumed message: %w", err)
+		}
+		senders[messageID] = senderID
+		consumed = append(consumed, messageID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to consume view-once messages: %w", err)
+	}
+	if len(consumed) == 0 {
+		return nil
+	}
+
+	files, err := deleteAttachmentsTx(ctx, tx, consumed)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit view-once consumption: %w", err)
+	}
+	s.removeReleasedFiles(ctx, files)
+
+	for _, messageID := range consumed {
+		event := websocket.MessageConsumedEvent(messageID, readerID, consumedAt)
+		s.hub.SendToUser(senders[messageID].String(), event)
+		s.hub.SendToUser(readerID.String(), event)
+	}
+	return nil
+}
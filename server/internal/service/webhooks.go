@@ -0,0 +1,39 @@
+package service
+
+import (
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/webhook"
+)
+
+// SetWebhooks sets the dispatcher server events are reported through. A nil
+// dispatcher, the default, reports nothing.
+func (s *Service) SetWebhooks(d *webhook.Dispatcher) {
+	s.webhooks = d
+}
+
+// Webhooks returns the dispatcher set with SetWebhooks
+func (s *Service) Webhooks() *webhook.Dispatcher {
+	return s.webhooks
+}
+
+// emitMessageSent reports a sent message. Only its ID and types are
+// included, not who sent it to whom.
+func (s *Service) emitMessageSent(message models.Message) {
+	conversationType := "dm"
+	if message.GroupID != nil {
+		conversationType = "group"
+	}
+	s.webhooks.Emit(webhook.EventMessageSent, map[string]interface{}{
+		"message_id":        message.ID,
+		"conversation_type": conversationType,
+		"message_type":      message.MessageType,
+	})
+}
+
+// emitGroupCreated reports a new group and its size
+func (s *Service) emitGroupCreated(group *models.Group, memberCount int) {
+	s.webhooks.Emit(webhook.EventGroupCreated, map[string]interface{}{
+		"group_id":     group.ID,
+		"member_count": memberCount,
+	})
+}
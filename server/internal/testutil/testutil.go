@@ -0,0 +1,180 @@
+// Package testutil provides helpers for tests that need a real database.
+//
+// Database-backed tests run against the Postgres instance named by the
+// TEST_DATABASE_URL environment variable and are skipped when it is unset.
+// The database is wiped before each test, so never point it at real data.
+package testutil
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"e2ee-messenger/server/internal/database"
+	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/websocket"
+
+	"github.com/google/uuid"
+)
+
+// NewDB connects to the test database, runs migrations and clears all data
+func NewDB(t *testing.T) *database.DB {
+	t.Helper()
+
+	databaseURL := os.Getenv("TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("TEST_DATABASE_URL not set, skipping database test")
+	}
+
+	db, err := database.New(databaseURL)
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := database.Migrate(db); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	// Every other table references users or groups, so this clears everything
+	if _, err := db.Exec("TRUNCATE users, groups, attachment_blobs CASCADE"); err != nil {
+		t.Fatalf("Failed to reset test database: %v", err)
+	}
+
+	return db
+}
+
+// CreateUser inserts a user with the given username
+func CreateUser(t *testing.T, db *database.DB, username string) models.User {
+	t.Helper()
+
+	user := models.User{
+		ID:        uuid.New(),
+		Username:  username,
+		Email:     username + "@example.com",
+		Password:  "not-a-real-hash",
+		CreatedAt: time.Now().UTC(),
+		UpdatedAt: time.Now().UTC(),
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO users (id, username, email, password, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, user.ID, user.Username, user.Email, user.Password, user.CreatedAt, user.UpdatedAt)
+	if err != nil {
+		t.Fatalf("Failed to create user %s: %v", username, err)
+	}
+
+	return user
+}
+
+// NewHub starts a websocket hub for the test
+func NewHub(t *testing.T) *websocket.Hub {
+	t.Helper()
+	hub := websocket.NewHub()
+	go hub.Run()
+	return hub
+}
+
+// ConnectClient registers an in-process client for the user and waits until
+// the hub reports them online
+func ConnectClient(t *testing.T, hub *websocket.Hub, userID uuid.UUID) *websocket.Client {
+	t.Helper()
+
+	client := websocket.NewClient(hub, nil, userID.String())
+	hub.Register(client)
+
+	deadline := time.Now().Add(time.Second)
+	for !hub.IsOnline(userID.String()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Client for user %s never registered", userID)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return client
+}
+
+// ExpectEvent waits for the next event queued for the client and checks its
+// type. Presence events are skipped unless eventType asks for them: they
+// arrive whenever a contact connects, at times tests do not control.
+func ExpectEvent(t *testing.T, client *websocket.Client, eventType string) websocket.Message {
+	t.Helper()
+
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case data := <-client.Outbound():
+			msg := decodeEvent(t, data)
+			if msg.Type == websocket.EventPresenceChanged && eventType != websocket.EventPresenceChanged {
+				continue
+			}
+			if msg.Type != eventType {
+				t.Fatalf("Expected %s event, got %s", eventType, msg.Type)
+			}
+			return msg
+		case <-deadline:
+			t.Fatalf("Expected a %s event", eventType)
+			return websocket.Message{}
+		}
+	}
+}
+
+// ExpectEvents reads the given events from the client in any order, for
+// events sent from different goroutines, and returns them by type in the
+// order they arrived. A type listed twice is expected twice. Presence
+// events are skipped.
+func ExpectEvents(t *testing.T, client *websocket.Client, eventTypes ...string) map[string][]websocket.Message {
+	t.Helper()
+
+	pending := make(map[string]int, len(eventTypes))
+	for _, eventType := range eventTypes {
+		pending[eventType]++
+	}
+	received := make(map[string][]websocket.Message, len(pending))
+	deadline := time.After(time.Second)
+	for remaining := len(eventTypes); remaining > 0; remaining-- {
+		select {
+		case data := <-client.Outbound():
+			msg := decodeEvent(t, data)
+			if msg.Type == websocket.EventPresenceChanged {
+				remaining++
+				continue
+			}
+			if pending[msg.Type] == 0 {
+				t.Fatalf("Expected events %v, got an extra %s", eventTypes, msg.Type)
+			}
+			pending[msg.Type]--
+			received[msg.Type] = append(received[msg.Type], msg)
+		case <-deadline:
+			t.Fatalf("Expected events %v, still missing %v", eventTypes, pending)
+		}
+	}
+	return received
+}
+
+// ExpectNoEvent checks that nothing but presence events is queued for the client
+func ExpectNoEvent(t *testing.T, client *websocket.Client) {
+	t.Helper()
+
+	deadline := time.After(50 * time.Millisecond)
+	for {
+		select {
+		case data := <-client.Outbound():
+			if decodeEvent(t, data).Type != websocket.EventPresenceChanged {
+				t.Fatalf("Expected no event, got %s", data)
+			}
+		case <-deadline:
+			return
+		}
+	}
+}
+
+func decodeEvent(t *testing.T, data []byte) websocket.Message {
+	t.Helper()
+	var msg websocket.Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatalf("Failed to unmarshal event: %v", err)
+	}
+	return msg
+}
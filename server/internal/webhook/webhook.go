@@ -0,0 +1,187 @@
+// Package webhook POSTs server events to operator-configured URLs, so
+// analytics and moderation pipelines can follow what happens on the server.
+//
+// Events only carry metadata that is safe to hand to third parties: IDs,
+// types and counts. Ciphertext, usernames and who-talks-to-whom never leave
+// the server through a webhook.
+//
+// Each request is signed with HMAC-SHA256 over "<timestamp>.<body>" using the
+// shared secret. Receivers should recompute the signature from the
+// X-Webhook-Timestamp header and the raw body, compare it to
+// X-Webhook-Signature in constant time, and reject stale timestamps.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event types
+const (
+	EventUserSignedUp = "user.signed_up"
+	EventMessageSent  = "message.sent"
+	EventGroupCreated = "group.created"
+)
+
+// Event is the JSON body POSTed to each webhook URL
+type Event struct {
+	ID        uuid.UUID   `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// Config describes where events are sent and how failed deliveries are retried
+type Config struct {
+	URLs   []string
+	Secret string
+	// Deliveries are attempted up to MaxAttempts times, waiting Backoff
+	// before the first retry and twice as long before each one after it
+	MaxAttempts int
+	Backoff     time.Duration
+	// Used for the requests; defaults to a client with a 10 second timeout
+	Client *http.Client
+}
+
+// queueSize is how many events may wait for delivery to one URL before new
+// ones are dropped
+const queueSize = 256
+
+// Dispatcher delivers events to the configured URLs in the background. Each
+// URL has its own queue, so a slow or failing receiver does not hold up the
+// others. A nil Dispatcher discards events.
+type Dispatcher struct {
+	cfg    Config
+	queues map[string]chan []byte
+}
+
+// New creates a dispatcher for cfg. It returns nil when no URLs are
+// configured. Call Run to start delivering.
+func New(cfg Config) *Dispatcher {
+	if len(cfg.URLs) == 0 {
+		return nil
+	}
+	if cfg.MaxAttempts < 1 {
+		cfg.MaxAttempts = 1
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	d := &Dispatcher{cfg: cfg, queues: make(map[string]chan []byte)}
+	for _, url := range cfg.URLs {
+		d.queues[url] = make(chan []byte, queueSize)
+	}
+	return d
+}
+
+// Emit queues an event for every URL without blocking. data must only hold
+// non-sensitive metadata. Events are dropped when a URL's queue is full.
+func (d *Dispatcher) Emit(eventType string, data interface{}) {
+	if d == nil {
+		return
+	}
+	body, err := json.Marshal(Event{ID: uuid.New(), Type: eventType, CreatedAt: time.Now().UTC(), Data: data})
+	if err != nil {
+		log.Printf("Failed to encode %s webhook: %v", eventType, err)
+		return
+	}
+	for url, queue := range d.queues {
+		select {
+		case queue <- body:
+		default:
+			log.Printf("Webhook queue for %s is full, dropping %s event", url, eventType)
+		}
+	}
+}
+
+// Run delivers queued events until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	if d == nil {
+		return
+	}
+	for url, queue := range d.queues {
+		go func(url string, queue chan []byte) {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case body := <-queue:
+					if err := d.deliver(ctx, url, body); err != nil {
+						log.Printf("Giving up on webhook to %s: %v", url, err)
+					}
+				}
+			}
+		}(url, queue)
+	}
+	<-ctx.Done()
+}
+
+// deliver POSTs body to url, retrying with exponential backoff on network
+// errors, 5xx responses and 429. Other 4xx responses are not retried.
+func (d *Dispatcher) deliver(ctx context.Context, url string, body []byte) error {
+	backoff := d.cfg.Backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		var retry bool
+		retry, err = d.post(ctx, url, body)
+		if err == nil || !retry || attempt >= d.cfg.MaxAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post makes one delivery attempt, reporting whether a failure is worth retrying
+func (d *Dispatcher) post(ctx context.Context, url string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to build request: %w", err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", Sign(d.cfg.Secret, timestamp, body))
+
+	resp, err := d.cfg.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("receiver responded %s", resp.Status)
+	default:
+		return false, fmt.Errorf("receiver responded %s", resp.Status)
+	}
+}
+
+// Sign returns the X-Webhook-Signature value for a body sent at timestamp
+// (Unix seconds): "sha256=" followed by the hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with secret
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
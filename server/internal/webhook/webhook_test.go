@@ -0,0 +1,96 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	// Reference value from: printf '1700000000.{"a":1}' | openssl dgst -sha256 -hmac secret
+	got := Sign("secret", "1700000000", []byte(`{"a":1}`))
+	want := "sha256=49f24e537407743fa4a0242bb63b94b9a47ee99cbbe071ccd8a22550ae411686"
+	if got != want {
+		t.Fatalf("Expected signature %s, got %s", want, got)
+	}
+	if Sign("other", "1700000000", []byte(`{"a":1}`)) == got {
+		t.Error("Expected a different secret to change the signature")
+	}
+	if Sign("secret", "1700000001", []byte(`{"a":1}`)) == got {
+		t.Error("Expected a different timestamp to change the signature")
+	}
+}
+
+func TestDeliveryIsSignedAndRetried(t *testing.T) {
+	var attempts atomic.Int32
+	received := make(chan Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		want := Sign("secret", r.Header.Get("X-Webhook-Timestamp"), body)
+		if !hmac.Equal([]byte(r.Header.Get("X-Webhook-Signature")), []byte(want)) {
+			t.Errorf("Invalid signature %q", r.Header.Get("X-Webhook-Signature"))
+		}
+
+		// Fail the first two attempts
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var event Event
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("Failed to decode event: %v", err)
+		}
+		received <- event
+	}))
+	defer server.Close()
+
+	d := New(Config{URLs: []string{server.URL}, Secret: "secret", MaxAttempts: 3, Backoff: time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	d.Emit(EventGroupCreated, map[string]interface{}{"member_count": 3})
+
+	select {
+	case event := <-received:
+		if event.Type != EventGroupCreated {
+			t.Errorf("Expected a %s event, got %s", EventGroupCreated, event.Type)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the event to be delivered")
+	}
+	if n := attempts.Load(); n != 3 {
+		t.Errorf("Expected 3 attempts, got %d", n)
+	}
+}
+
+func TestClientErrorsAreNotRetried(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	d := New(Config{URLs: []string{server.URL}, Secret: "secret", MaxAttempts: 5, Backoff: time.Millisecond})
+	if err := d.deliver(context.Background(), server.URL, []byte(`{}`)); err == nil {
+		t.Fatal("Expected the delivery to fail")
+	}
+	if n := attempts.Load(); n != 1 {
+		t.Errorf("Expected 1 attempt, got %d", n)
+	}
+}
+
+func TestNilDispatcherDiscardsEvents(t *testing.T) {
+	d := New(Config{})
+	if d != nil {
+		t.Fatal("Expected no dispatcher without URLs")
+	}
+	d.Emit(EventUserSignedUp, nil)
+}
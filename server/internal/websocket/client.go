@@ -2,8 +2,10 @@ package websocket
 
 import (
 	"context"
+	"encoding/json"
 	"log"
 	"net/http"
+	"sort"
 	"time"
 
 	"nhooyr.io/websocket"
@@ -22,24 +24,67 @@ const (
 
 	// Maximum message size allowed from peer
 	maxMessageSize = 512
+
+	// Time the client has to acknowledge an envelope before it is redelivered
+	ackTimeout = 30 * time.Second
+
+	// How often un-acked envelopes are checked for redelivery
+	redeliveryPeriod = ackTimeout / 3
+
+	// Maximum number of delivery attempts before the client is told to resync
+	maxDeliveryAttempts = 5
+
+	// Maximum number of un-acked envelopes tracked per connection
+	maxPendingAcks = 256
+
+	// Size of a connection's control event queue
+	controlBufferSize = 64
+
+	// Close code sent to clients disconnected for being idle
+	StatusIdleTimeout websocket.StatusCode = 4000
+
+	// Close code sent to clients whose user logged out everywhere
+	StatusSessionRevoked websocket.StatusCode = 4001
 )
 
-// ServeWS handles websocket requests from clients
-func ServeWS(hub *Hub, w http.ResponseWriter, r *http.Request, userID string) {
+// pendingEnvelope is an outbound event awaiting acknowledgement from the client
+type pendingEnvelope struct {
+	id       string
+	data     []byte
+	sentAt   time.Time
+	attempts int
+}
+
+// ServeWS handles websocket requests from clients for session.UserID on
+// session.DeviceID, which DisconnectDevice closes. onClose, if not nil, is
+// called once the connection is gone with the time of the client's last
+// inbound activity, or right away if the upgrade fails. When
+// session.SessionID is set, the client's first event is a "resume_token" it
+// can use to resume that session after reconnecting.
+func ServeWS(hub *Hub, w http.ResponseWriter, r *http.Request, session ResumeState, onClose func(lastActive time.Time)) {
+	log.Printf("WebSocket connection from %s for user %s (host=%q origin=%q)", r.RemoteAddr, session.UserID, r.Host, r.Header.Get("Origin"))
+
 	// Upgrade connection to websocket
 	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
 		InsecureSkipVerify: true, // In production, implement proper origin checking
 	})
 	if err != nil {
 		log.Printf("WebSocket upgrade failed: %v", err)
+		if onClose != nil {
+			onClose(time.Now())
+		}
 		return
 	}
 
-	client := &Client{
-		hub:    hub,
-		conn:   conn,
-		send:   make(chan []byte, 256),
-		userID: userID,
+	client := NewClient(hub, conn, session.UserID)
+	client.deviceID = session.DeviceID
+	client.onClose = onClose
+	if session.SessionID != "" {
+		token, window := hub.issueResumeToken(client, session)
+		if token != "" {
+			data, _ := json.Marshal(ResumeTokenEvent(token, window))
+			client.trySendControl(data)
+		}
 	}
 
 	client.hub.register <- client
@@ -50,9 +95,204 @@ func ServeWS(hub *Hub, w http.ResponseWriter, r *http.Request, userID string) {
 	go client.readPump()
 }
 
+// NewClient creates a client for the given user. conn may be nil for an
+// in-process client that only reads events from Outbound.
+func NewClient(hub *Hub, conn *websocket.Conn, userID string) *Client {
+	client := &Client{
+		hub:     hub,
+		conn:    conn,
+		send:    make(chan []byte, 256),
+		userID:  userID,
+		pending: make(map[string]*pendingEnvelope),
+
+		lastActive: time.Now(),
+		typingSent: make(map[string]time.Time),
+	}
+	client.control = client.send
+	if conn != nil {
+		client.control = make(chan []byte, controlBufferSize)
+	}
+	if hub != nil {
+		client.idleTimeout = hub.IdleTimeout()
+		client.limiter = newInboundLimiter(hub.InboundLimits(), time.Now())
+	}
+	client.touch(time.Now())
+	return client
+}
+
+// touch records meaningful activity on the connection. Pings and pongs do not
+// count, so a client that only keeps the connection alive still goes idle.
+func (c *Client) touch(now time.Time) {
+	c.lastActivity.Store(now.UnixNano())
+}
+
+// idleSince reports whether there has been no activity for the idle timeout
+func (c *Client) idleSince(now time.Time) bool {
+	if c.idleTimeout <= 0 {
+		return false
+	}
+	return now.Sub(time.Unix(0, c.lastActivity.Load())) >= c.idleTimeout
+}
+
+// Outbound returns the channel of encoded events queued for the client
+func (c *Client) Outbound() <-chan []byte {
+	return c.send
+}
+
+// controlEvents are about the connection rather than any message, so they may
+// overtake the message backlog. Every other event, receipts and deletions
+// included, refers to messages and keeps its order behind new_message.
+var controlEvents = map[string]bool{
+	EventPong:            true,
+	EventResyncRequired:  true,
+	EventResumeToken:     true,
+	EventMaintenance:     true,
+	EventPresenceChanged: true,
+	EventTyping:          true,
+}
+
+// trySend queues a message-related event for the client without blocking. It
+// returns false when the send buffer is full or the channel has already been
+// closed.
+func (c *Client) trySend(data []byte) bool {
+	return c.enqueue(c.send, data)
+}
+
+// trySendControl queues a connection-level event, which is written ahead of
+// queued messages. It returns false when the control queue is full or closed.
+func (c *Client) trySendControl(data []byte) bool {
+	return c.enqueue(c.control, data)
+}
+
+func (c *Client) enqueue(queue chan []byte, data []byte) bool {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+	if c.closed {
+		return false
+	}
+	select {
+	case queue <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+// queued returns the number of events waiting to be written
+func (c *Client) queued() int {
+	if c.control == c.send {
+		return len(c.send)
+	}
+	return len(c.send) + len(c.control)
+}
+
+// closeSend closes the send and control channels. It is safe to call more
+// than once and from any goroutine.
+func (c *Client) closeSend() {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.send)
+		if c.control != c.send {
+			close(c.control)
+		}
+	}
+}
+
+// Acknowledge confirms delivery of the envelope with the given ID
+func (c *Client) Acknowledge(id string) bool {
+	return c.acknowledge(id)
+}
+
+// track records an envelope as awaiting acknowledgement. It returns false when
+// the outstanding-ack buffer is full, in which case the buffer and the new
+// envelope are dead-lettered and the client has to resync over the REST API.
+func (c *Client) track(id string, data []byte, now time.Time) bool {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+
+	if len(c.pending) >= maxPendingAcks {
+		discarded := append(c.sortedPending(), &pendingEnvelope{id: id, data: data, sentAt: now})
+		c.hub.deadLetter(c.userID, DeadLetterAckBufferOverflow, discarded)
+		c.pending = make(map[string]*pendingEnvelope)
+		return false
+	}
+
+	c.pending[id] = &pendingEnvelope{id: id, data: data, sentAt: now, attempts: 1}
+	return true
+}
+
+// acknowledge purges an envelope once the client confirms it was received
+func (c *Client) acknowledge(id string) bool {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+
+	if _, ok := c.pending[id]; !ok {
+		return false
+	}
+	delete(c.pending, id)
+	return true
+}
+
+// dueForRedelivery returns the envelopes whose ack timeout has elapsed, oldest
+// first, and marks them as sent again. If any envelope has used up its delivery
+// attempts the whole buffer is dead-lettered and exhausted is true.
+func (c *Client) dueForRedelivery(now time.Time) (due [][]byte, exhausted bool) {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+
+	var expired []*pendingEnvelope
+	for _, env := range c.pending {
+		if now.Sub(env.sentAt) < ackTimeout {
+			continue
+		}
+		if env.attempts >= maxDeliveryAttempts {
+			c.hub.deadLetter(c.userID, DeadLetterAckTimeout, c.sortedPending())
+			c.pending = make(map[string]*pendingEnvelope)
+			return nil, true
+		}
+		expired = append(expired, env)
+	}
+
+	sort.Slice(expired, func(i, j int) bool { return expired[i].sentAt.Before(expired[j].sentAt) })
+	for _, env := range expired {
+		env.sentAt = now
+		env.attempts++
+		due = append(due, env.data)
+	}
+	return due, false
+}
+
+// drainPending removes and returns all un-acked envelopes, oldest first
+func (c *Client) drainPending() []*pendingEnvelope {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+
+	envelopes := c.sortedPending()
+	c.pending = make(map[string]*pendingEnvelope)
+	return envelopes
+}
+
+// sortedPending returns the un-acked envelopes, oldest first. The caller must
+// hold pendingMutex.
+func (c *Client) sortedPending() []*pendingEnvelope {
+	envelopes := make([]*pendingEnvelope, 0, len(c.pending))
+	for _, env := range c.pending {
+		envelopes = append(envelopes, env)
+	}
+	sort.Slice(envelopes, func(i, j int) bool { return envelopes[i].sentAt.Before(envelopes[j].sentAt) })
+	return envelopes
+}
+
 // readPump pumps messages from the websocket connection to the hub
 func (c *Client) readPump() {
 	defer func() {
+		// Finish with the session before unregistering, which makes its
+		// resumption token redeemable
+		if c.onClose != nil {
+			c.onClose(c.lastActive)
+		}
 		c.hub.unregister <- c
 		c.conn.Close(websocket.StatusNormalClosure, "")
 	}()
@@ -77,16 +317,43 @@ func (c *Client) readPump() {
 			break
 		}
 
+		// Acks are never dropped, as that would only cause redeliveries
+		if msg.Type != "ack" {
+			allowed, violated := c.limiter.allow(time.Now())
+			if violated {
+				log.Printf("Closing WebSocket for user %s: too many events", c.userID)
+				c.conn.Close(websocket.StatusPolicyViolation, "too many events")
+				return
+			}
+			if !allowed {
+				continue
+			}
+		}
+
+		c.lastActive = time.Now()
+		if msg.Type != "ping" {
+			c.touch(c.lastActive)
+		}
+
 		// Handle different message types
 		switch msg.Type {
 		case "ping":
 			// Respond to ping with pong
-			select {
-			case c.send <- []byte(`{"type":"pong","payload":{"timestamp":"` + time.Now().Format(time.RFC3339) + `"}}`):
-			default:
-				close(c.send)
+			pong, _ := json.Marshal(PongEvent(time.Now().UTC()))
+			if !c.trySendControl(pong) {
+				c.closeSend()
 				return
 			}
+		case "ack":
+			// Purge the acknowledged envelope so it is not redelivered
+			if payload, ok := msg.Payload.(map[string]interface{}); ok {
+				if id, ok := payload["id"].(string); ok {
+					c.acknowledge(id)
+				}
+			}
+		case "typing":
+			// Relayed to the conversation but never stored
+			c.handleTyping(msg.Payload, c.lastActive)
 		case "message_received":
 			// Handle message received acknowledgment
 			log.Printf("Message received acknowledgment from user %s", c.userID)
@@ -96,31 +363,62 @@ func (c *Client) readPump() {
 	}
 }
 
+// write writes one queued event to the connection. ok is false once the hub
+// closed the queues, in which case the connection is closed. It reports
+// whether the write pump should carry on.
+func (c *Client) write(message []byte, ok bool) bool {
+	if !ok {
+		// The hub closed the channel
+		c.conn.Close(websocket.StatusNormalClosure, "")
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), writeWait)
+	defer cancel()
+	if err := c.conn.Write(ctx, websocket.MessageText, message); err != nil {
+		log.Printf("WebSocket write error for user %s: %v", c.userID, err)
+		return false
+	}
+	return true
+}
+
 // writePump pumps messages from the hub to the websocket connection
 func (c *Client) writePump() {
 	ticker := time.NewTicker(pingPeriod)
+	redeliveryTicker := time.NewTicker(redeliveryPeriod)
+	var idleCheck <-chan time.Time
+	if c.idleTimeout > 0 {
+		idleTicker := time.NewTicker(c.idleTimeout / 4)
+		defer idleTicker.Stop()
+		idleCheck = idleTicker.C
+	}
 	defer func() {
 		ticker.Stop()
+		redeliveryTicker.Stop()
 		c.conn.Close(websocket.StatusNormalClosure, "")
 	}()
 
 	for {
+		// Control events go out ahead of any message backlog
 		select {
-		case message, ok := <-c.send:
-			ctx, cancel := context.WithTimeout(context.Background(), writeWait)
-			if !ok {
-				// The hub closed the channel
-				c.conn.Close(websocket.StatusNormalClosure, "")
-				cancel()
+		case message, ok := <-c.control:
+			if !c.write(message, ok) {
 				return
 			}
+			continue
+		default:
+		}
 
-			if err := c.conn.Write(ctx, websocket.MessageText, message); err != nil {
-				log.Printf("WebSocket write error for user %s: %v", c.userID, err)
-				cancel()
+		select {
+		case message, ok := <-c.control:
+			if !c.write(message, ok) {
+				return
+			}
+
+		case message, ok := <-c.send:
+			if !c.write(message, ok) {
 				return
 			}
-			cancel()
 
 		case <-ticker.C:
 			ctx, cancel := context.WithTimeout(context.Background(), writeWait)
@@ -130,6 +428,28 @@ func (c *Client) writePump() {
 				return
 			}
 			cancel()
+
+		case now := <-idleCheck:
+			if c.idleSince(now) {
+				log.Printf("Closing idle WebSocket for user %s", c.userID)
+				c.conn.Close(StatusIdleTimeout, "idle timeout")
+				return
+			}
+
+		case now := <-redeliveryTicker.C:
+			due, exhausted := c.dueForRedelivery(now)
+			if exhausted {
+				due = [][]byte{resyncRequired("ack_timeout")}
+			}
+			for _, message := range due {
+				ctx, cancel := context.WithTimeout(context.Background(), writeWait)
+				if err := c.conn.Write(ctx, websocket.MessageText, message); err != nil {
+					log.Printf("WebSocket redelivery error for user %s: %v", c.userID, err)
+					cancel()
+					return
+				}
+				cancel()
+			}
 		}
 	}
 }
@@ -0,0 +1,325 @@
+package websocket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+)
+
+// dial connects a real websocket client to a server running ServeWS
+func dial(t *testing.T, hub *Hub, userID string) *websocket.Conn {
+	t.Helper()
+	return dialSession(t, hub, userID, "")
+}
+
+// dialSession connects a real websocket client for the given session
+func dialSession(t *testing.T, hub *Hub, userID, sessionID string) *websocket.Conn {
+	t.Helper()
+	return dialState(t, hub, ResumeState{UserID: userID, SessionID: sessionID})
+}
+
+// dialState connects a real websocket client for the given session state
+func dialState(t *testing.T, hub *Hub, session ResumeState) *websocket.Conn {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ServeWS(hub, w, r, session, nil)
+	}))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.Dial(context.Background(), "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close(websocket.StatusNormalClosure, "") })
+	return conn
+}
+
+func TestIdleClientIsClosedDespitePings(t *testing.T) {
+	hub := startHub(t)
+	hub.SetIdleTimeout(200 * time.Millisecond)
+	conn := dial(t, hub, "user-1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Keep the connection alive without doing anything meaningful
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := wsjson.Write(ctx, conn, Message{Type: "ping"}); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		if _, _, err := conn.Read(ctx); err != nil {
+			if status := websocket.CloseStatus(err); status != StatusIdleTimeout {
+				t.Fatalf("Expected close status %d, got %d (%v)", StatusIdleTimeout, status, err)
+			}
+			return
+		}
+	}
+}
+
+func TestClientIsKeptOpenWithoutIdleTimeout(t *testing.T) {
+	hub := startHub(t)
+	conn := dial(t, hub, "user-1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if _, _, err := conn.Read(ctx); websocket.CloseStatus(err) != -1 {
+		t.Errorf("Expected the connection to stay open, got %v", err)
+	}
+}
+
+// readResumeToken reads the resumption token a new connection is sent first
+func readResumeToken(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var msg struct {
+		Type    string             `json:"type"`
+		Payload ResumeTokenPayload `json:"payload"`
+	}
+	if err := wsjson.Read(ctx, conn, &msg); err != nil {
+		t.Fatalf("Failed to read resume token: %v", err)
+	}
+	if msg.Type != EventResumeToken || msg.Payload.Token == "" {
+		t.Fatalf("Expected a resume_token event first, got %+v", msg)
+	}
+	return msg.Payload.Token
+}
+
+func TestResumeTokenResumesDroppedSession(t *testing.T) {
+	hub := startHub(t)
+	conn := dialSession(t, hub, "user-1", "session-1")
+	token := readResumeToken(t, conn)
+
+	if _, ok := hub.Resume(token); ok {
+		t.Fatal("Expected the token to be unusable while its connection is open")
+	}
+
+	conn.Close(websocket.StatusNormalClosure, "")
+	waitFor(t, func() bool { return !hub.IsOnline("user-1") })
+
+	state, ok := hub.Resume(token)
+	if !ok || state != (ResumeState{UserID: "user-1", SessionID: "session-1"}) {
+		t.Fatalf("Expected to resume session-1 of user-1, got %+v (ok=%v)", state, ok)
+	}
+	if _, ok := hub.Resume(token); ok {
+		t.Error("Expected a resume token to work only once")
+	}
+}
+
+func TestStaleResumeTokenIsRejected(t *testing.T) {
+	hub := startHub(t)
+	hub.SetResumeWindow(10 * time.Millisecond)
+	conn := dialSession(t, hub, "user-1", "session-1")
+	token := readResumeToken(t, conn)
+
+	conn.Close(websocket.StatusNormalClosure, "")
+	waitFor(t, func() bool { return !hub.IsOnline("user-1") })
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok := hub.Resume(token); ok {
+		t.Error("Expected a token past its resume window to be rejected")
+	}
+	if _, ok := hub.Resume("not-a-token"); ok {
+		t.Error("Expected an unknown token to be rejected")
+	}
+}
+
+func TestFloodingClientIsThrottledAndClosed(t *testing.T) {
+	hub := startHub(t)
+	hub.SetInboundLimits(InboundLimits{Rate: 1, Burst: 5, MaxDropped: 20})
+	conn := dial(t, hub, "user-1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	const sent = 100
+	go func() {
+		for i := 0; i < sent; i++ {
+			if err := wsjson.Write(ctx, conn, Message{Type: "ping"}); err != nil {
+				return
+			}
+		}
+	}()
+
+	pongs := 0
+	for {
+		var msg Message
+		err := wsjson.Read(ctx, conn, &msg)
+		if err != nil {
+			if status := websocket.CloseStatus(err); status != websocket.StatusPolicyViolation {
+				t.Fatalf("Expected close status %d, got %d (%v)", websocket.StatusPolicyViolation, status, err)
+			}
+			break
+		}
+		if msg.Type == "pong" {
+			pongs++
+		}
+	}
+
+	if pongs == 0 || pongs >= sent {
+		t.Errorf("Expected some but not all of %d pings to be answered, got %d pongs", sent, pongs)
+	}
+}
+
+func TestDisconnectUserClosesConnectionsAndResumeTokens(t *testing.T) {
+	hub := startHub(t)
+	conn := dialSession(t, hub, "user-1", "session-1")
+	token := readResumeToken(t, conn)
+	other := dial(t, hub, "user-2")
+
+	if n := hub.DisconnectUser("user-1", StatusSessionRevoked, "logged out"); n != 1 {
+		t.Fatalf("Expected 1 connection to be closed, got %d", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, _, err := conn.Read(ctx); websocket.CloseStatus(err) != StatusSessionRevoked {
+		t.Fatalf("Expected close status %d, got %v", StatusSessionRevoked, err)
+	}
+	waitFor(t, func() bool { return !hub.IsOnline("user-1") })
+	if _, ok := hub.Resume(token); ok {
+		t.Error("Expected the resume token to be revoked")
+	}
+
+	shortCtx, shortCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer shortCancel()
+	if _, _, err := other.Read(shortCtx); websocket.CloseStatus(err) != -1 {
+		t.Errorf("Expected other users to stay connected, got %v", err)
+	}
+}
+
+func TestDisconnectDeviceLeavesOtherDevicesConnected(t *testing.T) {
+	hub := startHub(t)
+	lost := dialState(t, hub, ResumeState{UserID: "user-1", SessionID: "session-1", DeviceID: "phone"})
+	lostToken := readResumeToken(t, lost)
+	kept := dialState(t, hub, ResumeState{UserID: "user-1", SessionID: "session-2", DeviceID: "laptop"})
+	keptToken := readResumeToken(t, kept)
+
+	if n := hub.DisconnectDevice("user-1", "phone", StatusSessionRevoked, "Device revoked"); n != 1 {
+		t.Fatalf("Expected 1 connection to be closed, got %d", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, _, err := lost.Read(ctx); websocket.CloseStatus(err) != StatusSessionRevoked {
+		t.Fatalf("Expected close status %d, got %v", StatusSessionRevoked, err)
+	}
+	hub.userMutex.Lock()
+	_, lostKept := hub.resumptions[lostToken]
+	_, keptKept := hub.resumptions[keptToken]
+	hub.userMutex.Unlock()
+	if lostKept {
+		t.Error("Expected the revoked device's resume token to be revoked")
+	}
+	if !keptKept {
+		t.Error("Expected the other device's resume token to stay valid")
+	}
+	if !hub.IsOnline("user-1") {
+		t.Error("Expected the user to stay online on the other device")
+	}
+}
+
+func TestControlEventsAreWrittenAheadOfMessages(t *testing.T) {
+	hub := startHub(t)
+	accepted := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			t.Errorf("Failed to accept: %v", err)
+			return
+		}
+		accepted <- conn
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.Dial(context.Background(), "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close(websocket.StatusNormalClosure, "") })
+
+	// Queue a message backlog and then a control event before anything is written
+	client := NewClient(hub, <-accepted, "user-1")
+	for i := 0; i < 5; i++ {
+		if !client.trySend([]byte(`{"type":"new_message"}`)) {
+			t.Fatal("Failed to queue message")
+		}
+	}
+	if !client.trySendControl([]byte(`{"type":"pong"}`)) {
+		t.Fatal("Failed to queue control event")
+	}
+	go client.writePump()
+	t.Cleanup(client.closeSend)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	var event Message
+	if err := wsjson.Read(ctx, conn, &event); err != nil {
+		t.Fatalf("Failed to read event: %v", err)
+	}
+	if event.Type != "pong" {
+		t.Errorf("Expected the control event first, got %s", event.Type)
+	}
+}
+
+func TestTypingIsDebouncedPerConversation(t *testing.T) {
+	hub := startHub(t)
+	relayed := make(chan Typing, 10)
+	hub.OnTyping(func(userID string, typing Typing) { relayed <- typing })
+	conn := dial(t, hub, "user-1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	bob, carol := uuid.New(), uuid.New()
+	for _, recipientID := range []uuid.UUID{bob, bob, bob, carol} {
+		event := Message{Type: "typing", Payload: map[string]string{"recipient_id": recipientID.String()}}
+		if err := wsjson.Write(ctx, conn, event); err != nil {
+			t.Fatalf("Failed to send typing event: %v", err)
+		}
+	}
+
+	got := make(map[uuid.UUID]int)
+	for i := 0; i < 2; i++ {
+		select {
+		case typing := <-relayed:
+			got[typing.RecipientID]++
+		case <-ctx.Done():
+			t.Fatalf("Expected 2 relayed typing events, got %v", got)
+		}
+	}
+	select {
+	case typing := <-relayed:
+		t.Errorf("Expected repeated typing events within a second to be dropped, got another for %s", typing.RecipientID)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if got[bob] != 1 || got[carol] != 1 {
+		t.Errorf("Expected one typing event per conversation, got %v", got)
+	}
+}
+
+func TestFailedUpgradeStillCallsOnClose(t *testing.T) {
+	hub := startHub(t)
+	closed := false
+	r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	ServeWS(hub, httptest.NewRecorder(), r, ResumeState{UserID: uuid.NewString(), SessionID: uuid.NewString()}, func(time.Time) {
+		closed = true
+	})
+	if !closed {
+		t.Error("Expected onClose to be called when the upgrade fails")
+	}
+}
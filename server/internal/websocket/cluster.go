@@ -0,0 +1,142 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// PubSub carries hub events between server instances, so an event sent on
+// one instance reaches the user's clients connected to any of them. Each
+// instance publishes the events it sends and delivers those published by
+// the others to its own clients.
+type PubSub interface {
+	// Publish sends data to every subscribed instance
+	Publish(ctx context.Context, data []byte) error
+	// Subscribe calls handler with every published payload until ctx is
+	// done or the subscription fails
+	Subscribe(ctx context.Context, handler func(data []byte)) error
+}
+
+// publishQueueSize is how many events may wait to be published before new
+// ones are dropped
+const publishQueueSize = 1024
+
+// publishTimeout bounds a single Publish call
+const publishTimeout = 5 * time.Second
+
+// clusterDisconnect is the type of the cluster event asking every instance
+// to close a user's connections
+const clusterDisconnect = "disconnect"
+
+// clusterWake is the type of the cluster event asking the instances a user
+// is connected to to call their OnWake function
+const clusterWake = "wake"
+
+// clusterEvent is what a hub publishes: an event as it was written to the
+// local clients, the user it was for, or none for a broadcast, and the
+// instance it came from. Disconnects carry the device, if only one is
+// disconnected, and the close code and reason instead of an event.
+type clusterEvent struct {
+	Origin   string               `json:"origin"`
+	UserID   string               `json:"user_id,omitempty"`
+	ID       string               `json:"id,omitempty"`
+	Type     string               `json:"type"`
+	Data     json.RawMessage      `json:"data,omitempty"`
+	DeviceID string               `json:"device_id,omitempty"`
+	Code     websocket.StatusCode `json:"code,omitempty"`
+	Reason   string               `json:"reason,omitempty"`
+}
+
+// UsePubSub shares the hub's events with the other instances subscribed to
+// ps until ctx is done. Without it the hub only reaches its own clients,
+// which is all a single instance needs. Call it before the hub is used.
+//
+// Counts returned by SendToUser and DisconnectUser, and IsOnline, still only
+// cover this instance's clients; UsePresence shares presence. Events
+// published while the subscription is being re-established are missed;
+// reconnecting clients resync as usual.
+func (h *Hub) UsePubSub(ctx context.Context, ps PubSub) {
+	h.outbound = make(chan []byte, publishQueueSize)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case data := <-h.outbound:
+				publishCtx, cancel := context.WithTimeout(ctx, publishTimeout)
+				if err := ps.Publish(publishCtx, data); err != nil && ctx.Err() == nil {
+					log.Printf("Failed to publish hub event: %v", err)
+				}
+				cancel()
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			err := ps.Subscribe(ctx, h.receive)
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Hub subscription ended, resubscribing: %v", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}()
+}
+
+// publish queues an event for the other instances without blocking
+func (h *Hub) publish(event clusterEvent) {
+	if h.outbound == nil {
+		return
+	}
+	event.Origin = h.instanceID
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error marshaling hub event: %v", err)
+		return
+	}
+	select {
+	case h.outbound <- data:
+	default:
+		log.Printf("Hub publish queue is full, dropping %s event", event.Type)
+	}
+}
+
+// receive delivers an event published by another instance to the local clients
+func (h *Hub) receive(data []byte) {
+	var event clusterEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		log.Printf("Ignoring malformed hub event: %v", err)
+		return
+	}
+	if event.Origin == h.instanceID {
+		return
+	}
+	if event.UserID == "" {
+		h.broadcast <- event.Data
+		return
+	}
+	if event.Type == clusterDisconnect {
+		h.disconnectLocal(event.UserID, event.DeviceID, event.Code, event.Reason)
+		return
+	}
+	if event.Type == clusterWake {
+		h.callbackMutex.RLock()
+		onWake := h.onWake
+		h.callbackMutex.RUnlock()
+		if onWake != nil && h.IsOnline(event.UserID) {
+			go onWake(event.UserID)
+		}
+		return
+	}
+	h.sendLocal(event.UserID, event.ID, event.Type, event.Data)
+}
@@ -0,0 +1,229 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// memoryPubSub hands every published payload to all subscribers, as a
+// Redis channel would
+type memoryPubSub struct {
+	mu          sync.Mutex
+	subscribers []func([]byte)
+}
+
+func (m *memoryPubSub) Publish(ctx context.Context, data []byte) error {
+	m.mu.Lock()
+	subscribers := append([]func([]byte){}, m.subscribers...)
+	m.mu.Unlock()
+	for _, handler := range subscribers {
+		handler(data)
+	}
+	return nil
+}
+
+func (m *memoryPubSub) Subscribe(ctx context.Context, handler func([]byte)) error {
+	m.mu.Lock()
+	m.subscribers = append(m.subscribers, handler)
+	m.mu.Unlock()
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (m *memoryPubSub) subscriberCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.subscribers)
+}
+
+// startClusteredHubs runs n hubs sharing one pub/sub for the duration of the test
+func startClusteredHubs(t *testing.T, n int) []*Hub {
+	t.Helper()
+	ps := &memoryPubSub{}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	hubs := make([]*Hub, n)
+	for i := range hubs {
+		hubs[i] = NewHub()
+		hubs[i].UsePubSub(ctx, ps)
+		go hubs[i].Run()
+	}
+	waitFor(t, func() bool { return ps.subscriberCount() == n })
+	return hubs
+}
+
+func TestSendToUserReachesClientOnAnotherHub(t *testing.T) {
+	hubs := startClusteredHubs(t, 2)
+	userID := uuid.NewString()
+	client := registerClient(t, hubs[1], userID)
+
+	if n := hubs[0].SendToUser(userID, PongEvent(time.Now())); n != 0 {
+		t.Errorf("Expected no local clients on the sending hub, got %d", n)
+	}
+
+	msg := receive(t, client)
+	if msg.Type != EventPong || msg.ID == "" {
+		t.Fatalf("Expected the pong event with an envelope ID, got %+v", msg)
+	}
+	// The envelope is tracked under the same ID, so the client can ack it
+	if !client.acknowledge(msg.ID) {
+		t.Error("Expected the remote client to track the envelope")
+	}
+}
+
+func TestSendToUserIsNotDeliveredTwiceLocally(t *testing.T) {
+	hubs := startClusteredHubs(t, 2)
+	userID := uuid.NewString()
+	client := registerClient(t, hubs[0], userID)
+
+	if n := hubs[0].SendToUser(userID, PongEvent(time.Now())); n != 1 {
+		t.Fatalf("Expected the event to be queued for the local client, got %d", n)
+	}
+	receive(t, client)
+	select {
+	case data := <-client.send:
+		t.Fatalf("Expected a single delivery, got another: %s", data)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestBroadcastReachesEveryHub(t *testing.T) {
+	hubs := startClusteredHubs(t, 2)
+	local := registerClient(t, hubs[0], uuid.NewString())
+	remote := registerClient(t, hubs[1], uuid.NewString())
+
+	hubs[0].Broadcast(MaintenanceEvent(true, "upgrading"))
+
+	for _, client := range []*Client{local, remote} {
+		if msg := receive(t, client); msg.Type != EventMaintenance {
+			t.Errorf("Expected a maintenance event, got %s", msg.Type)
+		}
+	}
+}
+
+func TestDisconnectUserReachesClientOnAnotherHub(t *testing.T) {
+	hubs := startClusteredHubs(t, 2)
+	userID := uuid.NewString()
+	registerClient(t, hubs[1], userID)
+
+	if n := hubs[0].DisconnectUser(userID, StatusSessionRevoked, "logged out"); n != 0 {
+		t.Errorf("Expected no local connections on the disconnecting hub, got %d", n)
+	}
+	waitFor(t, func() bool { return !hubs[1].IsOnline(userID) })
+}
+
+func TestWakeUserReachesOnlyHubsTheUserIsConnectedTo(t *testing.T) {
+	hubs := startClusteredHubs(t, 3)
+	userID := uuid.NewString()
+	registerClient(t, hubs[1], userID)
+
+	woken := make(chan int, len(hubs))
+	for i, hub := range hubs {
+		i := i
+		hub.OnWake(func(string) { woken <- i })
+	}
+
+	hubs[0].WakeUser(userID)
+	select {
+	case i := <-woken:
+		if i != 1 {
+			t.Errorf("Expected the hub the user is connected to to be woken, got hub %d", i)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the user's hub to be woken")
+	}
+	select {
+	case i := <-woken:
+		t.Errorf("Expected a single hub to be woken, hub %d was too", i)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// memoryPresence is a PresenceStore keeping the instances each user is
+// connected to in memory
+type memoryPresence struct {
+	mu        sync.Mutex
+	instances map[string]map[string]bool
+}
+
+func (m *memoryPresence) Join(ctx context.Context, instanceID, userID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.instances[userID] == nil {
+		m.instances[userID] = make(map[string]bool)
+	}
+	first := len(m.instances[userID]) == 0
+	m.instances[userID][instanceID] = true
+	return first, nil
+}
+
+func (m *memoryPresence) Leave(ctx context.Context, instanceID, userID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.instances[userID], instanceID)
+	return len(m.instances[userID]) == 0, nil
+}
+
+func (m *memoryPresence) Online(ctx context.Context, userID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.instances[userID]) > 0, nil
+}
+
+func (m *memoryPresence) Refresh(ctx context.Context, instanceID string, userIDs []string) error {
+	return nil
+}
+
+func TestPresenceIsSharedAcrossHubs(t *testing.T) {
+	hubs := startClusteredHubs(t, 2)
+	store := &memoryPresence{instances: make(map[string]map[string]bool)}
+	changes := make(chan bool, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	for _, hub := range hubs {
+		hub.UsePresence(ctx, store)
+		hub.OnPresence(func(userID string, online bool) { changes <- online })
+	}
+
+	expectChanges := func(want ...bool) {
+		t.Helper()
+		for _, w := range want {
+			select {
+			case online := <-changes:
+				if online != w {
+					t.Fatalf("Expected online=%v, got %v", w, online)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("Expected a presence change to online=%v", w)
+			}
+		}
+		select {
+		case online := <-changes:
+			t.Fatalf("Expected no other change, got online=%v", online)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	userID := uuid.NewString()
+	phone := registerClient(t, hubs[0], userID)
+	expectChanges(true)
+	laptop := registerClient(t, hubs[1], userID)
+	expectChanges()
+
+	hubs[0].Unregister(phone)
+	expectChanges()
+	if !hubs[0].IsOnlineAnywhere(userID) {
+		t.Error("Expected the user to be online on the other hub")
+	}
+
+	hubs[1].Unregister(laptop)
+	expectChanges(false)
+	if hubs[0].IsOnlineAnywhere(userID) {
+		t.Error("Expected the user to be offline everywhere")
+	}
+}
@@ -0,0 +1,74 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// Reasons an event is dead-lettered
+const (
+	// The connection had too many un-acked events to track another one
+	DeadLetterAckBufferOverflow = "ack_buffer_overflow"
+	// The client never acked the event within its delivery attempts
+	DeadLetterAckTimeout = "ack_timeout"
+	// The connection's send buffer was full while replaying held events
+	DeadLetterSendBufferFull = "send_buffer_full"
+)
+
+// DeadLetter describes an event the hub gave up delivering to a user. The
+// client is told to resync in these cases, but the record shows what it
+// missed and why.
+type DeadLetter struct {
+	UserID    string
+	EventID   string
+	EventType string
+	// ID of the message a new_message event carried
+	MessageID string
+	Reason    string
+}
+
+// OnDeadLetter sets a function called, in its own goroutine, for every event
+// the hub gives up delivering
+func (h *Hub) OnDeadLetter(fn func(DeadLetter)) {
+	h.callbackMutex.Lock()
+	defer h.callbackMutex.Unlock()
+	h.onDeadLetter = fn
+}
+
+// deadLetter logs the envelopes as undeliverable and hands them to the
+// OnDeadLetter function. It does not block, so it may be called with
+// userMutex or pendingMutex held.
+func (h *Hub) deadLetter(userID, reason string, envelopes []*pendingEnvelope) {
+	for _, env := range envelopes {
+		// Resync markers have no ID and carry nothing to lose
+		if env.id == "" {
+			continue
+		}
+		letter := DeadLetter{UserID: userID, EventID: env.id, Reason: reason}
+		var event struct {
+			Type    string          `json:"type"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		if err := json.Unmarshal(env.data, &event); err == nil {
+			letter.EventType = event.Type
+			if event.Type == EventNewMessage {
+				var message struct {
+					ID string `json:"id"`
+				}
+				json.Unmarshal(event.Payload, &message)
+				letter.MessageID = message.ID
+			}
+		}
+		log.Printf("Dead-lettered %s event %s for user %s: %s", letter.EventType, letter.EventID, userID, reason)
+
+		if h == nil {
+			continue
+		}
+		h.callbackMutex.RLock()
+		onDeadLetter := h.onDeadLetter
+		h.callbackMutex.RUnlock()
+		if onDeadLetter != nil {
+			go onDeadLetter(letter)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package websocket
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"e2ee-messenger/server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// Server-to-client event types
+const (
+	EventNewMessage      = "new_message"
+	EventMessageReceipt  = "message_receipt"
+	EventMessageReceipts = "message_receipts"
+	EventPong            = "pong"
+	EventResyncRequired  = "resync_required"
+
+	EventGroupMembershipChanged = "group_membership_changed"
+	EventMessageStatus          = "message_status"
+	EventGroupUpdated           = "group_updated"
+	EventReadPosition           = "read_position"
+	EventMessagesDeleted        = "messages_deleted"
+	EventResumeToken            = "resume_token"
+	EventMaintenance            = "maintenance"
+	EventSessionInitiated       = "session_initiated"
+	EventMessageConsumed        = "message_consumed"
+	EventGroupInvitation        = "group_invitation"
+	EventPrekeyLow              = "prekey_low"
+	EventDeviceRevoked          = "device_revoked"
+	EventMessageEdited          = "message_edited"
+	EventMessageDeleted         = "message_deleted"
+	EventTyping                 = "typing"
+	EventPresenceChanged        = "presence_changed"
+	EventReactionAdded          = "reaction_added"
+	EventReactionRemoved        = "reaction_removed"
+	EventMessageTTLChanged      = "message_ttl_changed"
+)
+
+// ReceiptPayload is sent to a message's sender when a receipt is recorded
+type ReceiptPayload struct {
+	MessageID uuid.UUID `json:"message_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BulkReceiptPayload aggregates receipts for several messages from one user
+type BulkReceiptPayload struct {
+	MessageIDs []uuid.UUID `json:"message_ids"`
+	UserID     uuid.UUID   `json:"user_id"`
+	Type       string      `json:"type"`
+	CreatedAt  time.Time   `json:"created_at"`
+}
+
+// PongPayload answers a client ping
+type PongPayload struct {
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ResyncPayload tells a client why it has to refetch its state
+type ResyncPayload struct {
+	Reason string `json:"reason"`
+}
+
+// GroupMembershipPayload announces that someone joined or left a group, or
+// was promoted to admin, along with the group's new member count
+type GroupMembershipPayload struct {
+	GroupID     uuid.UUID `json:"group_id"`
+	UserID      uuid.UUID `json:"user_id"`
+	Action      string    `json:"action"` // "added", "joined", "removed", "left", "promoted"
+	ChangedBy   uuid.UUID `json:"changed_by"`
+	MemberCount int       `json:"member_count"`
+}
+
+// ReadPositionPayload tells a user's devices which messages of a
+// conversation the user read on one of them
+type ReadPositionPayload struct {
+	ConversationID uuid.UUID   `json:"conversation_id"`
+	MessageIDs     []uuid.UUID `json:"message_ids"`
+	ReadAt         time.Time   `json:"read_at"`
+}
+
+// MessageStatusPayload tells a sender that the status of some of their
+// messages changed: "delivered" or "read" as receipts arrive, or
+// "delivery_pending" and "delivery_failed" for direct messages nobody received
+type MessageStatusPayload struct {
+	MessageIDs []uuid.UUID `json:"message_ids"`
+	Status     string      `json:"status"`
+}
+
+// MessagesDeletedPayload tells a conversation's participants that some of
+// its messages were deleted by their sender, or expired. Expired messages
+// have no DeletedBy.
+type MessagesDeletedPayload struct {
+	MessageIDs []uuid.UUID `json:"message_ids"`
+	DeletedBy  *uuid.UUID  `json:"deleted_by,omitempty"`
+	Expired    bool        `json:"expired,omitempty"`
+}
+
+// ResumeTokenPayload hands a new connection the token that resumes its
+// session if it drops, and how many seconds the token lasts after that
+type ResumeTokenPayload struct {
+	Token               string `json:"token"`
+	ResumeWindowSeconds int    `json:"resume_window_seconds"`
+}
+
+// MaintenancePayload tells clients the server entered or left maintenance
+// mode, during which it only serves reads
+type MaintenancePayload struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message,omitempty"`
+}
+
+// SessionInitiatedPayload tells a user that someone fetched their keys to
+// start an encrypted session with them, and who. Only the initiator's public
+// profile is included.
+type SessionInitiatedPayload struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Username  string    `json:"username"`
+	AvatarURL string    `json:"avatar_url,omitempty"`
+}
+
+// MessageConsumedPayload tells both sides of a view-once message that the
+// recipient opened it and its content is gone
+type MessageConsumedPayload struct {
+	MessageID  uuid.UUID `json:"message_id"`
+	ConsumedBy uuid.UUID `json:"consumed_by"`
+	ConsumedAt time.Time `json:"consumed_at"`
+}
+
+// PrekeyLowPayload tells a user how many unused one-time keys the server
+// still holds for them, once that drops below the replenish threshold
+type PrekeyLowPayload struct {
+	Remaining int `json:"remaining"`
+}
+
+// DeviceRevokedPayload tells a user's clients that one of their devices was
+// revoked, so sessions established with its keys should be dropped
+type DeviceRevokedPayload struct {
+	DeviceID  string    `json:"device_id"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
+// MessageDeletedPayload tells a conversation's participants that its sender
+// unsent a message, which is left as a tombstone
+type MessageDeletedPayload struct {
+	MessageID uuid.UUID `json:"message_id"`
+}
+
+// ReactionRemovedPayload tells a conversation's participants that a user
+// took back their reaction to a message
+type ReactionRemovedPayload struct {
+	MessageID uuid.UUID `json:"message_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Emoji     string    `json:"emoji"`
+}
+
+// MessageTTLChangedPayload tells a conversation's participants that its
+// disappearing message timer changed, and who changed it. Zero turns it off.
+type MessageTTLChangedPayload struct {
+	ConversationID    uuid.UUID `json:"conversation_id"`
+	MessageTTLSeconds int       `json:"message_ttl_seconds"`
+	ChangedBy         uuid.UUID `json:"changed_by"`
+}
+
+// TypingPayload tells a user that someone is typing to them, or in one of
+// their groups when GroupID is set
+type TypingPayload struct {
+	UserID  uuid.UUID  `json:"user_id"`
+	GroupID *uuid.UUID `json:"group_id,omitempty"`
+}
+
+// eventPayloads registers every outbound event type with its payload type
+var eventPayloads = map[string]reflect.Type{
+	EventNewMessage:      reflect.TypeOf(models.Message{}),
+	EventMessageReceipt:  reflect.TypeOf(ReceiptPayload{}),
+	EventMessageReceipts: reflect.TypeOf(BulkReceiptPayload{}),
+	EventPong:            reflect.TypeOf(PongPayload{}),
+	EventResyncRequired:  reflect.TypeOf(ResyncPayload{}),
+
+	EventGroupMembershipChanged: reflect.TypeOf(GroupMembershipPayload{}),
+	EventMessageStatus:          reflect.TypeOf(MessageStatusPayload{}),
+	EventGroupUpdated:           reflect.TypeOf(models.Group{}),
+	EventReadPosition:           reflect.TypeOf(ReadPositionPayload{}),
+	EventMessagesDeleted:        reflect.TypeOf(MessagesDeletedPayload{}),
+	EventResumeToken:            reflect.TypeOf(ResumeTokenPayload{}),
+	EventMaintenance:            reflect.TypeOf(MaintenancePayload{}),
+	EventSessionInitiated:       reflect.TypeOf(SessionInitiatedPayload{}),
+	EventMessageConsumed:        reflect.TypeOf(MessageConsumedPayload{}),
+	EventGroupInvitation:        reflect.TypeOf(models.GroupInvitation{}),
+	EventPrekeyLow:              reflect.TypeOf(PrekeyLowPayload{}),
+	EventDeviceRevoked:          reflect.TypeOf(DeviceRevokedPayload{}),
+	EventMessageEdited:          reflect.TypeOf(models.Message{}),
+	EventMessageDeleted:         reflect.TypeOf(MessageDeletedPayload{}),
+	EventTyping:                 reflect.TypeOf(TypingPayload{}),
+	EventPresenceChanged:        reflect.TypeOf(models.Presence{}),
+	EventReactionAdded:          reflect.TypeOf(models.Reaction{}),
+	EventReactionRemoved:        reflect.TypeOf(ReactionRemovedPayload{}),
+	EventMessageTTLChanged:      reflect.TypeOf(MessageTTLChangedPayload{}),
+}
+
+// Validate checks that the event type is registered and carries the payload
+// type registered for it
+func (m Message) Validate() error {
+	expected, ok := eventPayloads[m.Type]
+	if !ok {
+		return fmt.Errorf("unknown event type %q", m.Type)
+	}
+	if actual := reflect.TypeOf(m.Payload); actual != expected {
+		return fmt.Errorf("event %q has payload %v, expected %v", m.Type, actual, expected)
+	}
+	return nil
+}
+
+// NewMessageEvent announces a new message to its recipients
+func NewMessageEvent(message models.Message) Message {
+	return Message{Type: EventNewMessage, Payload: message}
+}
+
+// ReceiptEvent tells a sender that a recipient got or read their message
+func ReceiptEvent(receipt models.Receipt) Message {
+	return Message{Type: EventMessageReceipt, Payload: ReceiptPayload{
+		MessageID: receipt.MessageID,
+		UserID:    receipt.UserID,
+		Type:      receipt.Type,
+		CreatedAt: receipt.CreatedAt,
+	}}
+}
+
+// BulkReceiptEvent tells a sender that a recipient got or read several messages
+func BulkReceiptEvent(userID uuid.UUID, receiptType string, messageIDs []uuid.UUID, createdAt time.Time) Message {
+	return Message{Type: EventMessageReceipts, Payload: BulkReceiptPayload{
+		MessageIDs: messageIDs,
+		UserID:     userID,
+		Type:       receiptType,
+		CreatedAt:  createdAt,
+	}}
+}
+
+// PongEvent answers a client ping
+func PongEvent(timestamp time.Time) Message {
+	return Message{Type: EventPong, Payload: PongPayload{Timestamp: timestamp}}
+}
+
+// ResyncRequiredEvent tells a client that reliable delivery was abandoned
+func ResyncRequiredEvent(reason string) Message {
+	return Message{Type: EventResyncRequired, Payload: ResyncPayload{Reason: reason}}
+}
+
+// GroupMembershipChangedEvent tells group members who joined or left and how many members remain
+func GroupMembershipChangedEvent(payload GroupMembershipPayload) Message {
+	return Message{Type: EventGroupMembershipChanged, Payload: payload}
+}
+
+// MessageStatusEvent tells a sender that the status of their messages changed
+func MessageStatusEvent(status string, messageIDs []uuid.UUID) Message {
+	return Message{Type: EventMessageStatus, Payload: MessageStatusPayload{MessageIDs: messageIDs, Status: status}}
+}
+
+// GroupUpdatedEvent tells group members that the group's details changed
+func GroupUpdatedEvent(group models.Group) Message {
+	return Message{Type: EventGroupUpdated, Payload: group}
+}
+
+// ReadPositionEvent syncs a user's read position across their devices
+func ReadPositionEvent(conversationID uuid.UUID, messageIDs []uuid.UUID, readAt time.Time) Message {
+	return Message{Type: EventReadPosition, Payload: ReadPositionPayload{
+		ConversationID: conversationID,
+		MessageIDs:     messageIDs,
+		ReadAt:         readAt,
+	}}
+}
+
+// MessagesDeletedEvent tells a user which messages they can see were deleted
+func MessagesDeletedEvent(deletedBy uuid.UUID, messageIDs []uuid.UUID) Message {
+	return Message{Type: EventMessagesDeleted, Payload: MessagesDeletedPayload{MessageIDs: messageIDs, DeletedBy: &deletedBy}}
+}
+
+// MessagesExpiredEvent tells a user which messages they can see expired
+func MessagesExpiredEvent(messageIDs []uuid.UUID) Message {
+	return Message{Type: EventMessagesDeleted, Payload: MessagesDeletedPayload{MessageIDs: messageIDs, Expired: true}}
+}
+
+// ResumeTokenEvent gives a connection its session resumption token
+func ResumeTokenEvent(token string, window time.Duration) Message {
+	return Message{Type: EventResumeToken, Payload: ResumeTokenPayload{Token: token, ResumeWindowSeconds: int(window.Seconds())}}
+}
+
+// MaintenanceEvent tells clients whether the server is in maintenance mode
+func MaintenanceEvent(enabled bool, message string) Message {
+	payload := MaintenancePayload{Enabled: enabled}
+	if enabled {
+		payload.Message = message
+	}
+	return Message{Type: EventMaintenance, Payload: payload}
+}
+
+// SessionInitiatedEvent tells a user that initiator is starting a session with them
+func SessionInitiatedEvent(initiator models.User) Message {
+	return Message{Type: EventSessionInitiated, Payload: SessionInitiatedPayload{
+		UserID:    initiator.ID,
+		Username:  initiator.Username,
+		AvatarURL: initiator.AvatarURL,
+	}}
+}
+
+// MessageConsumedEvent tells a view-once message's participants it was opened
+func MessageConsumedEvent(messageID, consumedBy uuid.UUID, consumedAt time.Time) Message {
+	return Message{Type: EventMessageConsumed, Payload: MessageConsumedPayload{MessageID: messageID, ConsumedBy: consumedBy, ConsumedAt: consumedAt}}
+}
+
+// GroupInvitationEvent tells a user they were invited to join a group
+func GroupInvitationEvent(invitation models.GroupInvitation) Message {
+	return Message{Type: EventGroupInvitation, Payload: invitation}
+}
+
+// PrekeyLowEvent asks a user's clients to upload more one-time keys
+func PrekeyLowEvent(remaining int) Message {
+	return Message{Type: EventPrekeyLow, Payload: PrekeyLowPayload{Remaining: remaining}}
+}
+
+// DeviceRevokedEvent tells a user's clients that one of their devices was revoked
+func DeviceRevokedEvent(deviceID string, revokedAt time.Time) Message {
+	return Message{Type: EventDeviceRevoked, Payload: DeviceRevokedPayload{DeviceID: deviceID, RevokedAt: revokedAt}}
+}
+
+// MessageEditedEvent tells a message's participants it now has new content
+func MessageEditedEvent(message models.Message) Message {
+	return Message{Type: EventMessageEdited, Payload: message}
+}
+
+// MessageDeletedEvent tells a user that a message they can see was unsent
+// or expired
+func MessageDeletedEvent(messageID uuid.UUID) Message {
+	return Message{Type: EventMessageDeleted, Payload: MessageDeletedPayload{MessageID: messageID}}
+}
+
+// TypingEvent tells a user that userID is typing, in groupID if it is not nil
+func TypingEvent(userID uuid.UUID, groupID *uuid.UUID) Message {
+	return Message{Type: EventTyping, Payload: TypingPayload{UserID: userID, GroupID: groupID}}
+}
+
+// PresenceChangedEvent tells a user that one of their contacts went online or offline
+func PresenceChangedEvent(presence models.Presence) Message {
+	return Message{Type: EventPresenceChanged, Payload: presence}
+}
+
+// ReactionAddedEvent tells a message's participants someone reacted to it
+func ReactionAddedEvent(reaction models.Reaction) Message {
+	return Message{Type: EventReactionAdded, Payload: reaction}
+}
+
+// ReactionRemovedEvent tells a message's participants a reaction to it was taken back
+func ReactionRemovedEvent(messageID, userID uuid.UUID, emoji string) Message {
+	return Message{Type: EventReactionRemoved, Payload: ReactionRemovedPayload{MessageID: messageID, UserID: userID, Emoji: emoji}}
+}
+
+// MessageTTLChangedEvent tells a participant of the conversation they see as
+// conversationID that its disappearing message timer changed
+func MessageTTLChangedEvent(conversationID uuid.UUID, ttl time.Duration, changedBy uuid.UUID) Message {
+	return Message{Type: EventMessageTTLChanged, Payload: MessageTTLChangedPayload{
+		ConversationID:    conversationID,
+		MessageTTLSeconds: int(ttl / time.Second),
+		ChangedBy:         changedBy,
+	}}
+}
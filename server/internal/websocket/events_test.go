@@ -0,0 +1,90 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"e2ee-messenger/server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+func TestEventConstructorsAreValid(t *testing.T) {
+	now := time.Now()
+	events := []Message{
+		NewMessageEvent(models.Message{ID: uuid.New()}),
+		ReceiptEvent(models.Receipt{MessageID: uuid.New(), UserID: uuid.New(), Type: "read", CreatedAt: now}),
+		BulkReceiptEvent(uuid.New(), "delivered", []uuid.UUID{uuid.New()}, now),
+		PongEvent(now),
+		ResyncRequiredEvent("ack_buffer_overflow"),
+		MessagesDeletedEvent(uuid.New(), []uuid.UUID{uuid.New()}),
+		ResumeTokenEvent("token", time.Minute),
+		MaintenanceEvent(true, "Upgrading"),
+		SessionInitiatedEvent(models.User{ID: uuid.New(), Username: "alice"}),
+		MessageConsumedEvent(uuid.New(), uuid.New(), now),
+		GroupInvitationEvent(models.GroupInvitation{ID: uuid.New(), Group: models.Group{ID: uuid.New()}, InvitedBy: uuid.New(), CreatedAt: now}),
+		PrekeyLowEvent(3),
+		DeviceRevokedEvent("phone", now),
+		MessageEditedEvent(models.Message{ID: uuid.New()}),
+		MessageDeletedEvent(uuid.New()),
+		TypingEvent(uuid.New(), nil),
+		PresenceChangedEvent(models.Presence{UserID: uuid.New(), Status: "online"}),
+		ReactionAddedEvent(models.Reaction{MessageID: uuid.New(), UserID: uuid.New(), Emoji: "👍", CreatedAt: now}),
+		ReactionRemovedEvent(uuid.New(), uuid.New(), "👍"),
+		MessagesExpiredEvent([]uuid.UUID{uuid.New()}),
+		MessageTTLChangedEvent(uuid.New(), time.Hour, uuid.New()),
+	}
+
+	for _, event := range events {
+		if err := event.Validate(); err != nil {
+			t.Errorf("Expected %s to be valid: %v", event.Type, err)
+		}
+	}
+}
+
+func TestValidateRejectsUnknownAndMismatchedEvents(t *testing.T) {
+	if err := (Message{Type: "surprise", Payload: ResyncPayload{}}).Validate(); err == nil {
+		t.Error("Expected an unregistered event type to be rejected")
+	}
+	if err := (Message{Type: EventMessageReceipt, Payload: map[string]interface{}{"message_id": "x"}}).Validate(); err == nil {
+		t.Error("Expected an untyped payload to be rejected")
+	}
+}
+
+func TestSendToUserDropsInvalidEvents(t *testing.T) {
+	hub := startHub(t)
+	client := registerClient(t, hub, "alice")
+
+	hub.SendToUser("alice", Message{Type: EventNewMessage, Payload: "hello"})
+
+	select {
+	case data := <-client.send:
+		t.Fatalf("Expected invalid event to be dropped, got %s", data)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestReceiptEventShape(t *testing.T) {
+	messageID, userID := uuid.New(), uuid.New()
+	data, err := json.Marshal(ReceiptEvent(models.Receipt{MessageID: messageID, UserID: userID, Type: "read", CreatedAt: time.Now()}))
+	if err != nil {
+		t.Fatalf("Failed to marshal receipt event: %v", err)
+	}
+
+	var decoded struct {
+		Type    string                 `json:"type"`
+		Payload map[string]interface{} `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal receipt event: %v", err)
+	}
+	if decoded.Type != EventMessageReceipt {
+		t.Errorf("Expected type %s, got %s", EventMessageReceipt, decoded.Type)
+	}
+	for _, field := range []string{"message_id", "user_id", "type", "created_at"} {
+		if _, ok := decoded.Payload[field]; !ok {
+			t.Errorf("Expected payload field %s", field)
+		}
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"encoding/json"
 	"log"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/google/uuid"
 	"nhooyr.io/websocket"
 )
 
@@ -25,8 +28,59 @@ type Hub struct {
 	// User-specific message routing
 	userClients map[string]map[*Client]bool
 
-	// Mutex for userClients map
+	// Un-acked envelopes left behind by disconnected clients, redelivered
+	// to the user's next connection
+	undelivered map[string][]*pendingEnvelope
+
+	// Mutex for userClients and undelivered
 	userMutex sync.RWMutex
+
+	// Mutex for the callbacks below. Nothing else is locked while it is
+	// held, so they can be read with userMutex or pendingMutex held.
+	callbackMutex sync.RWMutex
+
+	// Called, in its own goroutine, whenever a client registers
+	onConnect func(userID string)
+
+	// Called, in its own goroutine, when another instance wakes a user
+	// connected to this one
+	onWake func(userID string)
+
+	// Called, in its own goroutine, for every event given up on
+	onDeadLetter func(DeadLetter)
+
+	// Called, in its own goroutine, for every typing event clients send
+	onTyping func(userID string, typing Typing)
+
+	// Called, in its own goroutine, when a user's first client connects or
+	// their last one goes away
+	onPresence func(userID string, online bool)
+
+	// Connections without activity for this long are closed; zero disables this
+	idleTimeout time.Duration
+
+	// Limits on the events each connection may send
+	inboundLimits InboundLimits
+
+	// Unused resumption tokens, guarded by userMutex, and how long they last
+	// once their connection drops
+	resumptions  map[string]*resumption
+	resumeWindow time.Duration
+
+	// Identifies this hub among the instances sharing a PubSub
+	instanceID string
+
+	// Events waiting to be published to the other instances; nil without
+	// a PubSub
+	outbound chan []byte
+
+	// Shares presence with the other instances; nil without one. Changes
+	// wait in presenceUpdates, guarded by presenceMutex, until they are
+	// recorded.
+	presence        PresenceStore
+	presenceUpdates []presenceUpdate
+	presenceMutex   sync.Mutex
+	presenceSignal  chan struct{}
 }
 
 // Client represents a websocket client
@@ -35,10 +89,49 @@ type Client struct {
 	conn   *websocket.Conn
 	send   chan []byte
 	userID string
+	// Device the connection was authenticated for; empty when unknown
+	deviceID string
+
+	// Connection-level control events queue here and are written ahead of
+	// send, so they are not stuck behind a message backlog.
+	// In-process clients have a single queue: control is send.
+	control chan []byte
+
+	// Guards send and control so they are never written to or closed once closed
+	sendMutex sync.Mutex
+	closed    bool
+
+	// Envelopes sent to this connection that have not been acked yet
+	pending      map[string]*pendingEnvelope
+	pendingMutex sync.Mutex
+
+	// Time of the last inbound frame, owned by readPump
+	lastActive time.Time
+
+	// Unix nanoseconds of the last inbound message or outbound event other
+	// than pings and pongs, and how long it may be ago before the
+	// connection is closed as idle
+	lastActivity atomic.Int64
+	idleTimeout  time.Duration
+
+	// Throttles inbound events; nil when unlimited
+	limiter *inboundLimiter
+
+	// When a typing event was last relayed for each conversation, owned by
+	// readPump
+	typingSent map[string]time.Time
+
+	// Called once the connection has closed
+	onClose func(lastActive time.Time)
+
+	// Resumption token issued to this connection, if any
+	resumeToken string
 }
 
-// Message represents a websocket message
+// Message represents a websocket message. ID is the envelope ID the client
+// must echo back in an "ack" message to confirm delivery.
 type Message struct {
+	ID      string      `json:"id,omitempty"`
 	Type    string      `json:"type"`
 	Payload interface{} `json:"payload"`
 }
@@ -51,9 +144,22 @@ func NewHub() *Hub {
 		register:    make(chan *Client),
 		unregister:  make(chan *Client),
 		userClients: make(map[string]map[*Client]bool),
+		undelivered: make(map[string][]*pendingEnvelope),
+
+		resumptions:  make(map[string]*resumption),
+		resumeWindow: defaultResumeWindow,
+
+		instanceID: uuid.NewString(),
 	}
 }
 
+// resyncRequired builds the event telling a client that reliable delivery was
+// abandoned and it must refetch its state over the REST API
+func resyncRequired(reason string) []byte {
+	data, _ := json.Marshal(ResyncRequiredEvent(reason))
+	return data
+}
+
 // Run starts the hub
 func (h *Hub) Run() {
 	for {
@@ -63,72 +169,298 @@ func (h *Hub) Run() {
 			h.userMutex.Lock()
 			if h.userClients[client.userID] == nil {
 				h.userClients[client.userID] = make(map[*Client]bool)
+				h.presenceChanged(client.userID, true)
 			}
 			h.userClients[client.userID][client] = true
+			envelopes := h.undelivered[client.userID]
+			delete(h.undelivered, client.userID)
 			h.userMutex.Unlock()
 			log.Printf("Client registered for user %s", client.userID)
 
+			// Redeliver whatever a previous connection never acknowledged
+			h.deliver(client, envelopes)
+			h.callbackMutex.RLock()
+			onConnect := h.onConnect
+			h.callbackMutex.RUnlock()
+			if onConnect != nil {
+				go onConnect(client.userID)
+			}
+
 		case client := <-h.unregister:
 			if _, ok := h.clients[client]; ok {
 				delete(h.clients, client)
-				close(client.send)
 				h.userMutex.Lock()
-				if userClients, exists := h.userClients[client.userID]; exists {
-					delete(userClients, client)
-					if len(userClients) == 0 {
-						delete(h.userClients, client.userID)
-					}
-				}
+				h.detach(client)
+				h.keepUndelivered(client.userID, client.drainPending())
+				h.startResumeWindow(client, time.Now())
 				h.userMutex.Unlock()
+				client.closeSend()
 				log.Printf("Client unregistered for user %s", client.userID)
 			}
 
 		case message := <-h.broadcast:
 			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
+				if !client.trySendControl(message) {
+					h.drop(client)
 				}
 			}
 		}
 	}
 }
 
-// SendToUser sends a message to all clients of a specific user
-func (h *Hub) SendToUser(userID string, message interface{}) {
+// detach removes the client from the per-user routing table. The caller must
+// hold userMutex.
+func (h *Hub) detach(client *Client) {
+	if userClients, exists := h.userClients[client.userID]; exists && userClients[client] {
+		delete(userClients, client)
+		if len(userClients) == 0 {
+			delete(h.userClients, client.userID)
+			h.presenceChanged(client.userID, false)
+		}
+	}
+}
+
+// drop stops routing to a client that cannot keep up and closes its send
+// channel. The client stays in h.clients, which only Run touches, until its
+// pumps exit and unregister it; that path hands its un-acked envelopes on.
+func (h *Hub) drop(client *Client) {
+	h.userMutex.Lock()
+	h.detach(client)
+	h.userMutex.Unlock()
+	client.closeSend()
+	log.Printf("Dropped slow client for user %s", client.userID)
+}
+
+// Register adds a client to the hub
+func (h *Hub) Register(client *Client) {
+	h.register <- client
+}
+
+// Unregister removes a client from the hub and closes its send channel
+func (h *Hub) Unregister(client *Client) {
+	h.unregister <- client
+}
+
+// OnConnect sets a function called with the user's ID whenever one of their
+// clients connects, once the client is ready to receive events
+func (h *Hub) OnConnect(fn func(userID string)) {
+	h.callbackMutex.Lock()
+	defer h.callbackMutex.Unlock()
+	h.onConnect = fn
+}
+
+// OnWake sets a function called with the user's ID when another instance
+// calls WakeUser for a user with clients connected to this one
+func (h *Hub) OnWake(fn func(userID string)) {
+	h.callbackMutex.Lock()
+	defer h.callbackMutex.Unlock()
+	h.onWake = fn
+}
+
+// WakeUser asks the other instances the user is connected to to call their
+// OnWake function for them, so work that needs the user's connection is done
+// where it is. It does nothing without a PubSub.
+func (h *Hub) WakeUser(userID string) {
+	h.publish(clusterEvent{UserID: userID, Type: clusterWake})
+}
+
+// SetIdleTimeout closes connections that have neither sent nor been sent
+// anything but pings for d. Zero disables the timeout. It applies to
+// connections made after the call.
+func (h *Hub) SetIdleTimeout(d time.Duration) {
+	h.userMutex.Lock()
+	defer h.userMutex.Unlock()
+	h.idleTimeout = d
+}
+
+// IdleTimeout returns the idle timeout set with SetIdleTimeout
+func (h *Hub) IdleTimeout() time.Duration {
+	h.userMutex.RLock()
+	defer h.userMutex.RUnlock()
+	return h.idleTimeout
+}
+
+// SetInboundLimits throttles the events each connection may send. It
+// applies to connections made after the call.
+func (h *Hub) SetInboundLimits(limits InboundLimits) {
+	h.userMutex.Lock()
+	defer h.userMutex.Unlock()
+	h.inboundLimits = limits
+}
+
+// InboundLimits returns the limits set with SetInboundLimits
+func (h *Hub) InboundLimits() InboundLimits {
+	h.userMutex.RLock()
+	defer h.userMutex.RUnlock()
+	return h.inboundLimits
+}
+
+// DisconnectUser closes all of the user's connections with code and reason
+// and forgets their resumption tokens, so none of their sessions can be
+// picked up again. Like SendToUser it reaches the user's connections on
+// other instances when the hub uses a PubSub. It returns the number of local
+// connections closed.
+func (h *Hub) DisconnectUser(userID string, code websocket.StatusCode, reason string) int {
+	return h.DisconnectDevice(userID, "", code, reason)
+}
+
+// DisconnectDevice is DisconnectUser for the connections and resumption
+// tokens of one of the user's devices. An empty deviceID means all of them.
+func (h *Hub) DisconnectDevice(userID, deviceID string, code websocket.StatusCode, reason string) int {
+	h.publish(clusterEvent{UserID: userID, DeviceID: deviceID, Type: clusterDisconnect, Code: code, Reason: reason})
+	return h.disconnectLocal(userID, deviceID, code, reason)
+}
+
+// disconnectLocal closes the user's connections to this instance from
+// deviceID, or from every device when it is empty, and forgets the
+// resumption tokens it issued them
+func (h *Hub) disconnectLocal(userID, deviceID string, code websocket.StatusCode, reason string) int {
+	h.userMutex.Lock()
+	var clients []*Client
+	for client := range h.userClients[userID] {
+		if deviceID == "" || client.deviceID == deviceID {
+			clients = append(clients, client)
+		}
+	}
+	for _, client := range clients {
+		h.detach(client)
+	}
+	for token, r := range h.resumptions {
+		if r.state.UserID == userID && (deviceID == "" || r.state.DeviceID == deviceID) {
+			delete(h.resumptions, token)
+		}
+	}
+	h.userMutex.Unlock()
+
+	for _, client := range clients {
+		if client.conn == nil {
+			client.closeSend()
+			continue
+		}
+		// Closing waits for the peer's half of the handshake; the read pump
+		// unregisters the client once it is done
+		go client.conn.Close(code, reason)
+	}
+	return len(clients)
+}
+
+// IsOnline reports whether the user has at least one connected client
+func (h *Hub) IsOnline(userID string) bool {
+	h.userMutex.RLock()
+	defer h.userMutex.RUnlock()
+	return len(h.userClients[userID]) > 0
+}
+
+// keepUndelivered holds un-acked envelopes for the user's next connection.
+// The caller must hold userMutex.
+func (h *Hub) keepUndelivered(userID string, envelopes []*pendingEnvelope) {
+	if len(envelopes) == 0 {
+		return
+	}
+	kept := append(h.undelivered[userID], envelopes...)
+	if len(kept) > maxPendingAcks {
+		// Too much to replay reliably; the next connection is told to resync
+		h.deadLetter(userID, DeadLetterAckBufferOverflow, kept)
+		kept = []*pendingEnvelope{{data: resyncRequired("ack_buffer_overflow")}}
+	}
+	h.undelivered[userID] = kept
+}
+
+// deliver sends previously un-acked envelopes to a newly registered client
+func (h *Hub) deliver(client *Client, envelopes []*pendingEnvelope) {
+	now := time.Now()
+	for i, env := range envelopes {
+		data := env.data
+		if env.id != "" && !client.track(env.id, env.data, now) {
+			data = resyncRequired("ack_buffer_overflow")
+		}
+		if !client.trySend(data) {
+			log.Printf("Dropping redelivery for user %s: send buffer full", client.userID)
+			h.deadLetter(client.userID, DeadLetterSendBufferFull, envelopes[i:])
+			return
+		}
+		client.touch(now)
+	}
+}
+
+// SendToUser sends a message to all clients of a specific user, including
+// those connected to other instances when the hub uses a PubSub. Each
+// message is stamped with an envelope ID and redelivered until the client
+// acks it. It returns the number of local clients the message was queued
+// for, zero when the user is not connected to this instance.
+func (h *Hub) SendToUser(userID string, message Message) int {
+	if err := message.Validate(); err != nil {
+		log.Printf("Refusing to send invalid event to user %s: %v", userID, err)
+		return 0
+	}
+
+	message.ID = uuid.NewString()
 	data, err := json.Marshal(message)
 	if err != nil {
 		log.Printf("Error marshaling message: %v", err)
-		return
+		return 0
 	}
 
-	h.userMutex.RLock()
-	userClients, exists := h.userClients[userID]
-	h.userMutex.RUnlock()
+	h.publish(clusterEvent{UserID: userID, ID: message.ID, Type: message.Type, Data: data})
+	return h.sendLocal(userID, message.ID, message.Type, data)
+}
 
-	if !exists {
-		return
+// SendEphemeral sends a message to all clients of a specific user like
+// SendToUser, but fire-and-forget: the message has no envelope ID, is never
+// redelivered and is not held for the user's next connection. It is meant
+// for events that are stale by the time they could be redelivered, such as
+// typing indicators.
+func (h *Hub) SendEphemeral(userID string, message Message) int {
+	if err := message.Validate(); err != nil {
+		log.Printf("Refusing to send invalid event to user %s: %v", userID, err)
+		return 0
 	}
 
-	for client := range userClients {
-		select {
-		case client.send <- data:
-		default:
-			close(client.send)
-			delete(h.clients, client)
-			h.userMutex.Lock()
-			delete(userClients, client)
-			if len(userClients) == 0 {
-				delete(h.userClients, userID)
-			}
-			h.userMutex.Unlock()
+	message.ID = ""
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error marshaling message: %v", err)
+		return 0
+	}
+
+	h.publish(clusterEvent{UserID: userID, Type: message.Type, Data: data})
+	return h.sendLocal(userID, "", message.Type, data)
+}
+
+// sendLocal queues an encoded event for the user's clients connected to
+// this instance and returns how many it was queued for. Events without a
+// messageID are not tracked for acknowledgement.
+func (h *Hub) sendLocal(userID, messageID, messageType string, data []byte) int {
+	// Snapshot the user's clients so the map is never read without the lock
+	h.userMutex.RLock()
+	clients := make([]*Client, 0, len(h.userClients[userID]))
+	for client := range h.userClients[userID] {
+		clients = append(clients, client)
+	}
+	h.userMutex.RUnlock()
+
+	now := time.Now()
+	queued := 0
+	for _, client := range clients {
+		payload := data
+		if messageID != "" && !client.track(messageID, data, now) {
+			payload = resyncRequired("ack_buffer_overflow")
+		}
+		queue := client.trySend
+		if controlEvents[messageType] {
+			queue = client.trySendControl
+		}
+		if !queue(payload) {
+			h.drop(client)
+			continue
 		}
+		client.touch(now)
+		queued++
 	}
+	return queued
 }
 
-// Broadcast sends a message to all connected clients
+// Broadcast sends a message to all connected clients, on every instance
 func (h *Hub) Broadcast(message interface{}) {
 	data, err := json.Marshal(message)
 	if err != nil {
@@ -136,5 +468,6 @@ func (h *Hub) Broadcast(message interface{}) {
 		return
 	}
 
+	h.publish(clusterEvent{Type: "broadcast", Data: data})
 	h.broadcast <- data
 }
@@ -0,0 +1,385 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"e2ee-messenger/server/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// startHub runs a hub for the duration of the test
+func startHub(t *testing.T) *Hub {
+	t.Helper()
+	hub := NewHub()
+	go hub.Run()
+	return hub
+}
+
+// registerClient registers a connection-less client and waits until the hub knows about it
+func registerClient(t *testing.T, hub *Hub, userID string) *Client {
+	t.Helper()
+	client := NewClient(hub, nil, userID)
+	hub.register <- client
+	waitFor(t, func() bool {
+		hub.userMutex.RLock()
+		defer hub.userMutex.RUnlock()
+		return hub.userClients[userID][client]
+	})
+	return client
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before deadline")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func receive(t *testing.T, client *Client) Message {
+	t.Helper()
+	select {
+	case data := <-client.send:
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("Failed to unmarshal message: %v", err)
+		}
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("Expected a message on the send channel")
+	}
+	return Message{}
+}
+
+func TestAckPurgesPendingEnvelope(t *testing.T) {
+	hub := startHub(t)
+	client := registerClient(t, hub, "alice")
+
+	hub.SendToUser("alice", NewMessageEvent(models.Message{EncryptedContent: "hello"}))
+	msg := receive(t, client)
+	if msg.ID == "" {
+		t.Fatal("Expected envelope ID on outbound message")
+	}
+
+	if !client.acknowledge(msg.ID) {
+		t.Fatal("Expected envelope to be pending before ack")
+	}
+
+	due, exhausted := client.dueForRedelivery(time.Now().Add(ackTimeout))
+	if exhausted || len(due) != 0 {
+		t.Errorf("Expected nothing to redeliver after ack, got %d envelopes", len(due))
+	}
+}
+
+func TestRedeliversUnackedEnvelope(t *testing.T) {
+	hub := startHub(t)
+	client := registerClient(t, hub, "alice")
+
+	hub.SendToUser("alice", NewMessageEvent(models.Message{EncryptedContent: "hello"}))
+	msg := receive(t, client)
+
+	due, _ := client.dueForRedelivery(time.Now())
+	if len(due) != 0 {
+		t.Fatalf("Expected no redelivery before the ack timeout, got %d", len(due))
+	}
+
+	due, exhausted := client.dueForRedelivery(time.Now().Add(ackTimeout))
+	if exhausted || len(due) != 1 {
+		t.Fatalf("Expected one envelope to redeliver, got %d", len(due))
+	}
+
+	var redelivered Message
+	if err := json.Unmarshal(due[0], &redelivered); err != nil {
+		t.Fatalf("Failed to unmarshal redelivered message: %v", err)
+	}
+	if redelivered.ID != msg.ID {
+		t.Errorf("Expected redelivery of %s, got %s", msg.ID, redelivered.ID)
+	}
+}
+
+func TestRedeliveryGivesUpAfterMaxAttempts(t *testing.T) {
+	client := NewClient(nil, nil, "alice")
+	now := time.Now()
+	client.track("env-1", []byte(`{}`), now)
+
+	for i := 1; i < maxDeliveryAttempts; i++ {
+		now = now.Add(ackTimeout)
+		if _, exhausted := client.dueForRedelivery(now); exhausted {
+			t.Fatalf("Gave up after %d attempts, expected %d", i, maxDeliveryAttempts)
+		}
+	}
+
+	if _, exhausted := client.dueForRedelivery(now.Add(ackTimeout)); !exhausted {
+		t.Error("Expected redelivery to be exhausted")
+	}
+}
+
+func TestRedeliversOnReconnect(t *testing.T) {
+	hub := startHub(t)
+	first := registerClient(t, hub, "alice")
+
+	hub.SendToUser("alice", NewMessageEvent(models.Message{EncryptedContent: "hello"}))
+	msg := receive(t, first)
+
+	// Drop the connection without acking
+	hub.unregister <- first
+	waitFor(t, func() bool {
+		hub.userMutex.RLock()
+		defer hub.userMutex.RUnlock()
+		return len(hub.undelivered["alice"]) == 1
+	})
+
+	second := registerClient(t, hub, "alice")
+	redelivered := receive(t, second)
+	if redelivered.ID != msg.ID {
+		t.Errorf("Expected redelivery of %s on reconnect, got %s", msg.ID, redelivered.ID)
+	}
+}
+
+func TestPendingOverflowRequiresResync(t *testing.T) {
+	client := NewClient(nil, nil, "alice")
+	now := time.Now()
+	for i := 0; i < maxPendingAcks; i++ {
+		if !client.track(fmt.Sprintf("env-%d", i), []byte(`{}`), now) {
+			t.Fatalf("Tracking failed before the buffer was full (at %d)", i)
+		}
+	}
+
+	if client.track("one-too-many", []byte(`{}`), now) {
+		t.Fatal("Expected tracking to fail once the buffer is full")
+	}
+	if len(client.drainPending()) != 0 {
+		t.Error("Expected the pending buffer to be discarded on overflow")
+	}
+
+	var msg Message
+	if err := json.Unmarshal(resyncRequired("ack_buffer_overflow"), &msg); err != nil {
+		t.Fatalf("Failed to unmarshal resync event: %v", err)
+	}
+	if msg.Type != "resync_required" {
+		t.Errorf("Expected resync_required event, got %s", msg.Type)
+	}
+}
+
+func TestConcurrentDropAndUnregisterDoesNotPanic(t *testing.T) {
+	hub := startHub(t)
+
+	var clients []*Client
+	for i := 0; i < 20; i++ {
+		client := registerClient(t, hub, "alice")
+		// Fill the send buffer so the next event drops the client
+		for client.trySend([]byte(`{}`)) {
+		}
+		clients = append(clients, client)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hub.SendToUser("alice", NewMessageEvent(models.Message{EncryptedContent: "hello"}))
+		}()
+	}
+	for _, client := range clients {
+		wg.Add(1)
+		go func(client *Client) {
+			defer wg.Done()
+			hub.Unregister(client)
+			hub.Unregister(client)
+		}(client)
+	}
+	wg.Wait()
+
+	if hub.IsOnline("alice") {
+		t.Error("Expected every client to be dropped or unregistered")
+	}
+	for _, client := range clients {
+		if client.trySend([]byte(`{}`)) {
+			t.Error("Expected send to fail on a closed client")
+		}
+	}
+}
+
+func TestSendToUserReportsQueuedClients(t *testing.T) {
+	hub := startHub(t)
+	event := NewMessageEvent(models.Message{EncryptedContent: "hello"})
+
+	if queued := hub.SendToUser("user-1", event); queued != 0 {
+		t.Errorf("Expected nothing queued for an offline user, got %d", queued)
+	}
+
+	registerClient(t, hub, "user-1")
+	registerClient(t, hub, "user-1")
+	if queued := hub.SendToUser("user-1", event); queued != 2 {
+		t.Errorf("Expected the event queued on both clients, got %d", queued)
+	}
+}
+
+func TestOnConnectRunsAfterRegistration(t *testing.T) {
+	hub := startHub(t)
+	connected := make(chan bool, 1)
+	hub.OnConnect(func(userID string) {
+		connected <- hub.IsOnline(userID)
+	})
+
+	registerClient(t, hub, "user-1")
+	select {
+	case online := <-connected:
+		if !online {
+			t.Error("Expected the user to be online when OnConnect runs")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected OnConnect to be called")
+	}
+}
+
+func TestStatsReflectsRegisteredClients(t *testing.T) {
+	hub := startHub(t)
+	registerClient(t, hub, "alice")
+	registerClient(t, hub, "alice")
+	bob := registerClient(t, hub, "bob")
+
+	hub.SendToUser("bob", PongEvent(time.Now()))
+
+	stats := hub.Stats()
+	if stats.Connections != 3 || stats.Users != 2 {
+		t.Fatalf("Expected 3 connections for 2 users, got %d for %d", stats.Connections, stats.Users)
+	}
+	if stats.UsersByConnectionCount[1] != 1 || stats.UsersByConnectionCount[2] != 1 {
+		t.Errorf("Expected one user with 1 connection and one with 2, got %v", stats.UsersByConnectionCount)
+	}
+	if top := stats.SendBuffers[0]; top.Queued != 1 || top.PendingAcks != 1 || top.Capacity != cap(bob.send) {
+		t.Errorf("Expected bob's buffer first with one queued event, got %+v", top)
+	}
+
+	hub.unregister <- bob
+	waitFor(t, func() bool { return hub.Stats().Connections == 2 })
+	if stats := hub.Stats(); stats.UsersWithUndelivered != 1 || stats.UndeliveredEvents != 1 {
+		t.Errorf("Expected bob's un-acked event to be held, got %+v", stats)
+	}
+}
+
+func TestExhaustedDeliveryIsDeadLettered(t *testing.T) {
+	hub := startHub(t)
+	letters := make(chan DeadLetter, 1)
+	hub.OnDeadLetter(func(letter DeadLetter) { letters <- letter })
+	client := registerClient(t, hub, "alice")
+
+	messageID := uuid.New()
+	hub.SendToUser("alice", NewMessageEvent(models.Message{ID: messageID, EncryptedContent: "hello"}))
+	msg := receive(t, client)
+
+	// The client never acks, so every attempt times out
+	now := time.Now()
+	for i := 0; i < maxDeliveryAttempts; i++ {
+		now = now.Add(ackTimeout)
+		client.dueForRedelivery(now)
+	}
+
+	select {
+	case letter := <-letters:
+		want := DeadLetter{UserID: "alice", EventID: msg.ID, EventType: EventNewMessage, MessageID: messageID.String(), Reason: DeadLetterAckTimeout}
+		if letter != want {
+			t.Errorf("Expected dead letter %+v, got %+v", want, letter)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a dead letter")
+	}
+}
+
+func TestPresenceChangesOnFirstAndLastClient(t *testing.T) {
+	hub := startHub(t)
+	changes := make(chan bool, 10)
+	hub.OnPresence(func(userID string, online bool) { changes <- online })
+
+	expect := func(want bool) {
+		t.Helper()
+		select {
+		case online := <-changes:
+			if online != want {
+				t.Fatalf("Expected online=%v, got %v", want, online)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected a presence change to online=%v", want)
+		}
+	}
+
+	phone := registerClient(t, hub, "user-1")
+	expect(true)
+	laptop := registerClient(t, hub, "user-1")
+
+	hub.Unregister(phone)
+	waitFor(t, func() bool { return hub.Stats().Connections == 1 })
+	hub.Unregister(laptop)
+	expect(false)
+
+	select {
+	case online := <-changes:
+		t.Errorf("Expected one change each way, got another online=%v", online)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestEphemeralEventsAreNotTrackedOrReplayed(t *testing.T) {
+	hub := startHub(t)
+	first := registerClient(t, hub, "alice")
+
+	hub.SendEphemeral("alice", TypingEvent(uuid.New(), nil))
+	msg := receive(t, first)
+	if msg.ID != "" {
+		t.Errorf("Expected no envelope ID on an ephemeral event, got %s", msg.ID)
+	}
+	if due, _ := first.dueForRedelivery(time.Now().Add(ackTimeout)); len(due) != 0 {
+		t.Errorf("Expected nothing to redeliver, got %d envelopes", len(due))
+	}
+
+	// Drop the connection without acking
+	hub.unregister <- first
+	waitFor(t, func() bool { return !hub.IsOnline("alice") })
+
+	second := registerClient(t, hub, "alice")
+	select {
+	case data := <-second.send:
+		t.Errorf("Expected nothing replayed on reconnect, got %s", data)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMessageEventsKeepTheirOrderBehindNewMessages(t *testing.T) {
+	hub := startHub(t)
+	client := NewClient(hub, nil, "alice")
+	// Give the in-process client the separate control queue a connection has
+	client.control = make(chan []byte, controlBufferSize)
+	hub.register <- client
+	waitFor(t, func() bool { return hub.IsOnline("alice") })
+
+	messageID := uuid.New()
+	hub.SendToUser("alice", NewMessageEvent(models.Message{ID: messageID}))
+	hub.SendToUser("alice", MessageStatusEvent("delivered", []uuid.UUID{messageID}))
+	hub.SendToUser("alice", MessagesDeletedEvent(uuid.New(), []uuid.UUID{messageID}))
+	hub.SendEphemeral("alice", PresenceChangedEvent(models.Presence{UserID: uuid.New(), Status: "online"}))
+
+	for _, want := range []string{EventNewMessage, EventMessageStatus, EventMessagesDeleted} {
+		if msg := receive(t, client); msg.Type != want {
+			t.Errorf("Expected %s on the message queue, got %s", want, msg.Type)
+		}
+	}
+	select {
+	case data := <-client.control:
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil || msg.Type != EventPresenceChanged {
+			t.Errorf("Expected only presence on the control queue, got %s", data)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected presence on the control queue")
+	}
+}
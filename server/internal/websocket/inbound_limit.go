@@ -0,0 +1,54 @@
+package websocket
+
+import "time"
+
+// InboundLimits throttles the events a single connection may send. Each
+// connection gets a token bucket refilled at Rate events per second holding
+// up to Burst tokens; events arriving with the bucket empty are dropped. A
+// connection with more than MaxDropped dropped events outstanding is closed
+// with a policy violation. A zero Rate disables the limit.
+type InboundLimits struct {
+	Rate       float64
+	Burst      int
+	MaxDropped int
+}
+
+// inboundLimiter applies InboundLimits to one connection. It is only used by
+// the connection's readPump, so it needs no locking.
+type inboundLimiter struct {
+	limits  InboundLimits
+	tokens  float64
+	dropped float64
+	last    time.Time
+}
+
+func newInboundLimiter(limits InboundLimits, now time.Time) *inboundLimiter {
+	if limits.Rate <= 0 {
+		return nil
+	}
+	if limits.Burst < 1 {
+		limits.Burst = 1
+	}
+	return &inboundLimiter{limits: limits, tokens: float64(limits.Burst), last: now}
+}
+
+// allow reports whether an event arriving at now may be handled, and whether
+// the connection has dropped so many events it should be closed. Dropped
+// events count against the connection until they drain away at Rate, so
+// a client that backs off is forgiven.
+func (l *inboundLimiter) allow(now time.Time) (allowed, violated bool) {
+	if l == nil {
+		return true, false
+	}
+	refill := now.Sub(l.last).Seconds() * l.limits.Rate
+	l.last = now
+	l.tokens = min(l.tokens+refill, float64(l.limits.Burst))
+	l.dropped = max(l.dropped-refill, 0)
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, false
+	}
+	l.dropped++
+	return false, l.dropped > float64(l.limits.MaxDropped)
+}
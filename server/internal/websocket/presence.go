@@ -0,0 +1,174 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// PresenceStore records which instances users have clients connected to, so
+// presence covers every instance sharing it rather than only this one.
+// Entries an instance stops refreshing expire after PresenceTTL, so users of
+// an instance that went away without closing its connections are not shown
+// online for long.
+type PresenceStore interface {
+	// Join records that the user has clients connected to the instance and
+	// reports whether they had none on any instance before
+	Join(ctx context.Context, instanceID, userID string) (bool, error)
+	// Leave records that the user has no clients left on the instance and
+	// reports whether they have none on any instance now
+	Leave(ctx context.Context, instanceID, userID string) (bool, error)
+	// Online reports whether the user has clients connected to any instance
+	Online(ctx context.Context, userID string) (bool, error)
+	// Refresh keeps the instance's entries for the users from expiring
+	Refresh(ctx context.Context, instanceID string, userIDs []string) error
+}
+
+// PresenceTTL is how long a PresenceStore keeps entries that are not
+// refreshed. The hub refreshes its own three times as often.
+const PresenceTTL = 90 * time.Second
+
+// presenceTimeout bounds a single PresenceStore call
+const presenceTimeout = 5 * time.Second
+
+// presenceUpdate is a change of a user's presence on this instance, waiting
+// to be recorded in the PresenceStore
+type presenceUpdate struct {
+	userID string
+	online bool
+}
+
+// OnPresence sets a function called, in its own goroutine, when a user goes
+// online because their first client connected, or offline because their last
+// one went away. Calls may arrive out of order when a user reconnects
+// quickly; IsOnlineAnywhere tells which one is current.
+//
+// With a PresenceStore this follows the user's clients on every instance;
+// otherwise it only covers this instance's clients, like IsOnline.
+func (h *Hub) OnPresence(fn func(userID string, online bool)) {
+	h.callbackMutex.Lock()
+	defer h.callbackMutex.Unlock()
+	h.onPresence = fn
+}
+
+// UsePresence shares the hub's presence with the other instances using
+// store until ctx is done. Without it presence only covers this instance's
+// clients, which is all a single instance needs. Call it before the hub is
+// used.
+func (h *Hub) UsePresence(ctx context.Context, store PresenceStore) {
+	h.presence = store
+	h.presenceSignal = make(chan struct{}, 1)
+	go h.sharePresence(ctx)
+}
+
+// IsOnlineAnywhere is IsOnline for the clients connected to every instance
+// sharing the hub's PresenceStore. Without one, or if it fails, it only
+// covers this instance's clients.
+func (h *Hub) IsOnlineAnywhere(userID string) bool {
+	if h.presence == nil || h.IsOnline(userID) {
+		return h.IsOnline(userID)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), presenceTimeout)
+	defer cancel()
+	online, err := h.presence.Online(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to get shared presence of user %s: %v", userID, err)
+		return false
+	}
+	return online
+}
+
+// presenceChanged hands a change of presence on this instance to the
+// OnPresence function, through the PresenceStore if there is one. It does
+// not block, so it may be called with userMutex held.
+func (h *Hub) presenceChanged(userID string, online bool) {
+	if h.presence == nil {
+		h.notifyPresence(userID, online)
+		return
+	}
+	// Queued rather than recorded right away, so the store sees the
+	// changes in the order they happened
+	h.presenceMutex.Lock()
+	h.presenceUpdates = append(h.presenceUpdates, presenceUpdate{userID: userID, online: online})
+	h.presenceMutex.Unlock()
+	select {
+	case h.presenceSignal <- struct{}{}:
+	default:
+	}
+}
+
+// notifyPresence calls the OnPresence function in its own goroutine
+func (h *Hub) notifyPresence(userID string, online bool) {
+	h.callbackMutex.RLock()
+	onPresence := h.onPresence
+	h.callbackMutex.RUnlock()
+	if onPresence != nil {
+		go onPresence(userID, online)
+	}
+}
+
+// sharePresence records queued presence changes in the PresenceStore, in
+// order, and refreshes the entries of the users connected to this instance,
+// until ctx is done. Only changes of the user's presence on every instance
+// are handed to the OnPresence function; if the store fails, this
+// instance's change is. Refreshing in between changes keeps it from
+// bringing back an entry a change removed.
+func (h *Hub) sharePresence(ctx context.Context) {
+	ticker := time.NewTicker(PresenceTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.refreshPresence(ctx)
+			continue
+		case <-h.presenceSignal:
+		}
+
+		h.presenceMutex.Lock()
+		updates := h.presenceUpdates
+		h.presenceUpdates = nil
+		h.presenceMutex.Unlock()
+
+		for _, update := range updates {
+			callCtx, cancel := context.WithTimeout(ctx, presenceTimeout)
+			var changed bool
+			var err error
+			if update.online {
+				changed, err = h.presence.Join(callCtx, h.instanceID, update.userID)
+			} else {
+				changed, err = h.presence.Leave(callCtx, h.instanceID, update.userID)
+			}
+			cancel()
+			if err != nil {
+				log.Printf("Failed to share presence of user %s: %v", update.userID, err)
+				changed = true
+			}
+			if changed {
+				h.notifyPresence(update.userID, update.online)
+			}
+		}
+	}
+}
+
+// refreshPresence keeps the PresenceStore entries of the users connected to
+// this instance from expiring
+func (h *Hub) refreshPresence(ctx context.Context) {
+	h.userMutex.RLock()
+	userIDs := make([]string, 0, len(h.userClients))
+	for userID := range h.userClients {
+		userIDs = append(userIDs, userID)
+	}
+	h.userMutex.RUnlock()
+	if len(userIDs) == 0 {
+		return
+	}
+
+	refreshCtx, cancel := context.WithTimeout(ctx, presenceTimeout)
+	defer cancel()
+	if err := h.presence.Refresh(refreshCtx, h.instanceID, userIDs); err != nil {
+		log.Printf("Failed to refresh shared presence: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package websocket
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// defaultResumeWindow is how long after a connection drops its resumption
+// token still works, unless changed with SetResumeWindow
+const defaultResumeWindow = 2 * time.Minute
+
+// ResumeState is the session a resumption token lets a reconnecting client
+// pick up again without authenticating from scratch
+type ResumeState struct {
+	UserID    string
+	SessionID string
+	DeviceID  string
+}
+
+// resumption is an issued, unused resumption token
+type resumption struct {
+	state  ResumeState
+	client *Client
+	// Zero while the connection the token was issued to is open
+	expiresAt time.Time
+}
+
+// SetResumeWindow sets how long after a connection drops its resumption token
+// can still be used. It applies to connections that drop after the call.
+func (h *Hub) SetResumeWindow(d time.Duration) {
+	h.userMutex.Lock()
+	defer h.userMutex.Unlock()
+	h.resumeWindow = d
+}
+
+// issueResumeToken creates the resumption token for a new connection,
+// sweeping tokens that expired on the way
+func (h *Hub) issueResumeToken(client *Client, state ResumeState) (string, time.Duration) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", 0
+	}
+	token := hex.EncodeToString(buf)
+
+	now := time.Now()
+	h.userMutex.Lock()
+	defer h.userMutex.Unlock()
+	for issued, r := range h.resumptions {
+		if !r.expiresAt.IsZero() && now.After(r.expiresAt) {
+			delete(h.resumptions, issued)
+		}
+	}
+	h.resumptions[token] = &resumption{state: state, client: client}
+	client.resumeToken = token
+	return token, h.resumeWindow
+}
+
+// startResumeWindow starts the countdown on a closed connection's resumption
+// token. The caller must hold userMutex.
+func (h *Hub) startResumeWindow(client *Client, now time.Time) {
+	if r, ok := h.resumptions[client.resumeToken]; ok && r.client == client {
+		r.expiresAt = now.Add(h.resumeWindow)
+		r.client = nil
+	}
+}
+
+// Resume redeems a resumption token, returning the session it was issued
+// for. Tokens work once, from when their connection is gone until the resume
+// window has passed; callers fall back to full authentication when ok is
+// false.
+func (h *Hub) Resume(token string) (state ResumeState, ok bool) {
+	h.userMutex.Lock()
+	defer h.userMutex.Unlock()
+	r, exists := h.resumptions[token]
+	if !exists || r.expiresAt.IsZero() {
+		// The connection may still be open, and it owns the session until it closes
+		return ResumeState{}, false
+	}
+	delete(h.resumptions, token)
+	if time.Now().After(r.expiresAt) {
+		return ResumeState{}, false
+	}
+	return r.state, true
+}
@@ -0,0 +1,62 @@
+package websocket
+
+import "sort"
+
+// HubStats is a point-in-time snapshot of the hub for operational debugging.
+// It carries counts only, never user IDs.
+type HubStats struct {
+	Connections int `json:"connections"`
+	Users       int `json:"users"`
+	// Number of users by how many connections they have open
+	UsersByConnectionCount map[int]int `json:"users_by_connection_count"`
+	// Fill level of every connection's send buffer, fullest first
+	SendBuffers []BufferStats `json:"send_buffers"`
+	// Un-acked events held for users' next connection
+	UsersWithUndelivered int `json:"users_with_undelivered"`
+	UndeliveredEvents    int `json:"undelivered_events"`
+}
+
+// BufferStats describes one connection's outbound backlog
+type BufferStats struct {
+	Queued      int `json:"queued"`
+	Capacity    int `json:"capacity"`
+	PendingAcks int `json:"pending_acks"`
+}
+
+// Stats returns a snapshot of the connected clients and their buffers.
+// Clients dropped for being too slow no longer count as connected.
+func (h *Hub) Stats() HubStats {
+	stats := HubStats{UsersByConnectionCount: make(map[int]int)}
+
+	h.userMutex.RLock()
+	var clients []*Client
+	for _, userClients := range h.userClients {
+		stats.Users++
+		stats.UsersByConnectionCount[len(userClients)]++
+		for client := range userClients {
+			clients = append(clients, client)
+		}
+	}
+	for _, envelopes := range h.undelivered {
+		stats.UsersWithUndelivered++
+		stats.UndeliveredEvents += len(envelopes)
+	}
+	h.userMutex.RUnlock()
+
+	stats.Connections = len(clients)
+	stats.SendBuffers = make([]BufferStats, 0, len(clients))
+	for _, client := range clients {
+		client.pendingMutex.Lock()
+		pendingAcks := len(client.pending)
+		client.pendingMutex.Unlock()
+		stats.SendBuffers = append(stats.SendBuffers, BufferStats{
+			Queued:      client.queued(),
+			Capacity:    cap(client.send),
+			PendingAcks: pendingAcks,
+		})
+	}
+	sort.Slice(stats.SendBuffers, func(i, j int) bool {
+		return stats.SendBuffers[i].Queued > stats.SendBuffers[j].Queued
+	})
+	return stats
+}
@@ -0,0 +1,83 @@
+package websocket
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// typingInterval is how often a connection may relay "typing" for one
+// conversation; more frequent events are dropped
+const typingInterval = time.Second
+
+// maxTypingConversations bounds how many conversations a connection may be
+// typing in within one typingInterval
+const maxTypingConversations = 64
+
+// Typing is a client's "typing" event: its user is typing to RecipientID in
+// a direct conversation, or in the group GroupID. Exactly one is set.
+type Typing struct {
+	RecipientID uuid.UUID
+	GroupID     uuid.UUID
+}
+
+// OnTyping sets a function called, in its own goroutine, for every "typing"
+// event a client sends, after debouncing. It decides who the event is
+// relayed to; typing events are never stored.
+func (h *Hub) OnTyping(fn func(userID string, typing Typing)) {
+	h.callbackMutex.Lock()
+	defer h.callbackMutex.Unlock()
+	h.onTyping = fn
+}
+
+// handleTyping parses a "typing" event's payload and hands it to the
+// OnTyping function, at most once per typingInterval per conversation.
+// Malformed events are ignored.
+func (c *Client) handleTyping(payload interface{}, now time.Time) {
+	fields, ok := payload.(map[string]interface{})
+	if !ok {
+		return
+	}
+	recipientID, _ := fields["recipient_id"].(string)
+	groupID, _ := fields["group_id"].(string)
+
+	var typing Typing
+	var err error
+	switch {
+	case recipientID != "" && groupID == "":
+		typing.RecipientID, err = uuid.Parse(recipientID)
+	case groupID != "" && recipientID == "":
+		typing.GroupID, err = uuid.Parse(groupID)
+	default:
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	key := typing.RecipientID.String() + typing.GroupID.String()
+	if last, ok := c.typingSent[key]; ok && now.Sub(last) < typingInterval {
+		return
+	}
+	if len(c.typingSent) >= maxTypingConversations {
+		for k, last := range c.typingSent {
+			if now.Sub(last) >= typingInterval {
+				delete(c.typingSent, k)
+			}
+		}
+		if len(c.typingSent) >= maxTypingConversations {
+			return
+		}
+	}
+	c.typingSent[key] = now
+
+	if c.hub == nil {
+		return
+	}
+	c.hub.callbackMutex.RLock()
+	onTyping := c.hub.onTyping
+	c.hub.callbackMutex.RUnlock()
+	if onTyping != nil {
+		go onTyping(c.userID, typing)
+	}
+}
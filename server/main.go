@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -13,6 +14,10 @@ import (
 	"e2ee-messenger/server/internal/database"
 	"e2ee-messenger/server/internal/handlers"
 	authmiddleware "e2ee-messenger/server/internal/middleware"
+	"e2ee-messenger/server/internal/pubsub"
+	"e2ee-messenger/server/internal/service"
+	"e2ee-messenger/server/internal/tracing"
+	"e2ee-messenger/server/internal/webhook"
 	"e2ee-messenger/server/internal/websocket"
 
 	"github.com/go-chi/chi/v5"
@@ -29,9 +34,25 @@ func main() {
 
 	// Load configuration
 	cfg := config.Load()
+	if err := cfg.CheckStorageDirs(); err != nil {
+		log.Fatalf("Invalid storage configuration: %v", err)
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Endpoint:    cfg.OTLPEndpoint,
+		ServiceName: cfg.TracingServiceName,
+	})
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
 
 	// Initialize database
-	db, err := database.New(cfg.DatabaseURL)
+	db, err := database.Open(cfg.DatabaseURL, database.Options{
+		StatementTimeout:   cfg.DBStatementTimeout,
+		SlowQueryThreshold: cfg.DBSlowQueryThreshold,
+		BreakerThreshold:   cfg.DBBreakerThreshold,
+		BreakerCooldown:    cfg.DBBreakerCooldown,
+	})
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
@@ -41,19 +62,77 @@ func main() {
 	if err := database.Migrate(db); err != nil {
 		log.Fatalf("Failed to run migrations: %v", err)
 	}
+	if err := database.CloseOrphanedSessions(db, cfg.InstanceID); err != nil {
+		log.Printf("Warning: %v", err)
+	}
 
 	// Initialize WebSocket hub
 	hub := websocket.NewHub()
+	hub.SetIdleTimeout(cfg.WSIdleTimeout)
+	hub.SetResumeWindow(cfg.WSResumeWindow)
+	hub.SetInboundLimits(websocket.InboundLimits{
+		Rate:       float64(cfg.WSInboundRate),
+		Burst:      cfg.WSInboundBurst,
+		MaxDropped: cfg.WSInboundMaxDropped,
+	})
+
+	// Initialize services and their background workers
+	ctx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	// Share events with the other instances when running several
+	if cfg.RedisURL != "" {
+		redisPubSub, err := pubsub.NewRedis(ctx, cfg.RedisURL, cfg.RedisChannel)
+		if err != nil {
+			log.Fatalf("Failed to set up hub pub/sub: %v", err)
+		}
+		defer redisPubSub.Close()
+		hub.UsePubSub(ctx, redisPubSub)
+		hub.UsePresence(ctx, redisPubSub.Presence(websocket.PresenceTTL))
+	}
 	go hub.Run()
 
+	svc := service.New(db, hub, cfg)
+	if moved, err := svc.MoveLegacyAvatars(ctx, config.LegacyAvatarsDir); err != nil {
+		log.Printf("Warning: %v", err)
+	} else if moved > 0 {
+		log.Printf("Moved %d avatars from %s to %s", moved, config.LegacyAvatarsDir, cfg.AvatarsDir)
+	}
+	go svc.RunOutboxRelay(ctx)
+	go svc.RunDeliveryMonitor(ctx)
+	go svc.RunRetentionJanitor(ctx)
+	go svc.RunExpirySweeper(ctx)
+	go svc.RunRevokedTokenJanitor(ctx)
+
+	webhooks := webhook.New(webhook.Config{
+		URLs:        cfg.WebhookURLs,
+		Secret:      cfg.WebhookSecret,
+		MaxAttempts: cfg.WebhookMaxAttempts,
+		Backoff:     cfg.WebhookRetryBackoff,
+	})
+	svc.SetWebhooks(webhooks)
+	go webhooks.Run(ctx)
+
+	// MAINTENANCE_MODE turns maintenance on for every instance; otherwise the
+	// stored switch is left as the admins set it
+	if cfg.MaintenanceMode {
+		if _, err := svc.SetMaintenance(ctx, true, cfg.MaintenanceMessage); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+
 	// Initialize handlers
-	h := handlers.New(db, hub, cfg)
+	h := handlers.New(db, hub, cfg, svc)
+	go svc.RunMaintenanceSync(ctx, func(enabled bool, message string) {
+		h.Maintenance().Set(enabled, message)
+	})
 
 	// Setup router
 	r := chi.NewRouter()
 
 	// Middleware
 	r.Use(middleware.Logger)
+	r.Use(tracing.Middleware)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.Timeout(60 * time.Second))
 
@@ -61,67 +140,172 @@ func main() {
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"*"}, // In production, specify exact origins
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "traceparent", "tracestate"},
+		ExposedHeaders:   []string{"Link", "X-Next-Cursor"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
 
-	// Serve static files from the "uploads" directory
-	fs := http.FileServer(http.Dir("uploads"))
+	// Serve avatars from AvatarsDir
+	fs := http.FileServer(http.Dir(cfg.AvatarsDir))
 	r.Handle("/uploads/*", http.StripPrefix("/uploads/", fs))
 
 	// API routes
+	authenticate := authmiddleware.Auth(authmiddleware.AuthConfig{
+		Secret:               cfg.JWTSecret,
+		PreviousSecrets:      cfg.JWTPreviousSecrets,
+		PreviousSecretsUntil: cfg.JWTPreviousSecretsUntil,
+		Issuer:               cfg.JWTIssuer,
+		Audience:             cfg.JWTAudience,
+		ValidAfter:           svc.TokensValidAfter,
+		Revoked:              svc.IsTokenRevoked,
+		FamilyRevoked:        svc.IsRefreshTokenFamilyRevoked,
+	})
 	r.Route("/v1", func(r chi.Router) {
-		// Auth routes
+		r.Use(authmiddleware.RequireDatabase(db.Unavailable))
+
+		// Auth routes stay open during maintenance so admins can still log in
 		r.Route("/auth", func(r chi.Router) {
 			r.Post("/signup", h.Signup)
 			r.Post("/login", h.Login)
+			r.Post("/refresh", h.Refresh)
+			r.Get("/username-available", h.UsernameAvailable)
+			r.With(authenticate, authmiddleware.UserContext).Post("/logout", h.Logout)
+			r.With(authenticate, authmiddleware.UserContext).Post("/logout-all", h.LogoutAll)
 		})
 
-		// Protected routes
+		// Everything else but the admin endpoints is read-only during maintenance
 		r.Group(func(r chi.Router) {
-			r.Use(authmiddleware.Auth(cfg.JWTSecret))
-			r.Use(authmiddleware.UserContext)
+			r.Use(authmiddleware.ReadOnlyDuringMaintenance(h.Maintenance()))
 
-			// Profile
-			r.Put("/profile", h.UpdateProfile)
-			r.Post("/profile/avatar", h.UploadAvatar)
-			r.Delete("/profile", h.DeleteAccount)
-			r.Put("/profile/password", h.ChangePassword)
+			// Server capabilities
+			r.Get("/capabilities", h.GetCapabilities)
 
-			// Users & Chats
-			r.Get("/users", h.GetUsers)
-			r.Get("/chats", h.GetChats)
+			// Resuming a dropped WebSocket session authenticates with its resume token
+			r.Get("/ws/resume", h.ResumeWebSocket)
 
-			// Groups
-			r.Post("/groups", h.CreateGroup)
+			// Protected routes
+			r.Group(func(r chi.Router) {
+				r.Use(authenticate)
+				r.Use(authmiddleware.UserContext)
+				r.Use(authmiddleware.LoadUser(svc.GetUser))
 
-			// Key management
-			r.Route("/keys", func(r chi.Router) {
-				r.Post("/device", h.UploadDeviceKey)
-				r.Post("/one-time", h.UploadOneTimeKey)
-				r.Get("/bootstrap", h.GetBootstrapKeys)
-			})
+				// Profile
+				r.Put("/profile", h.UpdateProfile)
+				r.Post("/profile/avatar", h.UploadAvatar)
+				r.Delete("/profile", h.DeleteAccount)
+				r.Put("/profile/password", h.ChangePassword)
+				r.Get("/profile/privacy", h.GetPrivacySettings)
+				r.Put("/profile/privacy", h.UpdatePrivacySettings)
+				r.Get("/sessions", h.GetSessions)
 
-			// Messages
-			r.Route("/messages", func(r chi.Router) {
-				r.Post("/", h.SendMessage)
-				r.Post("/attachment", h.UploadAttachment)
-				r.Get("/attachment/{messageID}/{fileName}", h.DownloadAttachment)
-				r.Get("/", h.GetMessages)
-			})
+				// Users & Chats
+				r.Get("/users", h.GetUsers)
+				r.Post("/users/{userID}/block", h.BlockUser)
+				r.Delete("/users/{userID}/block", h.UnblockUser)
+				r.Get("/chats", h.GetChats)
+				r.Get("/link-preview", h.GetLinkPreview)
+
+				// Groups
+				r.Get("/groups", h.ListGroups)
+				r.Post("/groups", h.CreateGroup)
+				r.Get("/groups/{groupID}", h.GetGroup)
+				r.Put("/groups/{groupID}", h.UpdateGroup)
+				r.Put("/groups/{groupID}/notifications", h.SetGroupNotificationLevel)
+				r.Post("/groups/{groupID}/transfer-ownership", h.TransferGroupOwnership)
+				r.Get("/groups/{groupID}/members", h.ListGroupMembers)
+				r.Post("/groups/{groupID}/members", h.AddGroupMembers)
+				r.Post("/groups/{groupID}/invitations", h.InviteToGroup)
+				r.Get("/groups/invitations", h.ListGroupInvitations)
+				r.Post("/groups/invitations/{invitationID}/accept", h.AcceptGroupInvitation)
+				r.Post("/groups/invitations/{invitationID}/decline", h.DeclineGroupInvitation)
+				r.Delete("/groups/{groupID}/members/{userID}", h.RemoveGroupMember)
+				r.Post("/groups/{groupID}/leave", h.LeaveGroup)
+
+				// Conversations
+				r.Get("/conversations/{conversationID}/settings", h.GetConversationSettings)
+				r.Put("/conversations/{conversationID}/settings", h.UpdateConversationSettings)
+				r.Put("/conversations/{conversationID}/pin", h.PinConversation)
+				r.Delete("/conversations/{conversationID}/pin", h.UnpinConversation)
+				r.Put("/conversations/pins", h.ReorderPinnedConversations)
+				r.Post("/conversations/{conversationID}/archive", h.ArchiveConversation)
+				r.Delete("/conversations/{conversationID}/archive", h.UnarchiveConversation)
+				r.Post("/conversations/{conversationID}/promote-to-group", h.PromoteConversationToGroup)
+				r.Get("/conversations/{conversationID}/session-status", h.GetSessionStatus)
+				r.Get("/conversations/{conversationID}/media", h.GetConversationMedia)
+
+				// Key management
+				r.Route("/keys", func(r chi.Router) {
+					r.Post("/device", h.UploadDeviceKey)
+					r.Post("/one-time", h.UploadOneTimeKey)
+					r.Post("/one-time/rotate", h.RotateOneTimeKeys)
+					r.Get("/bootstrap", h.GetBootstrapKeys)
+					r.Get("/status", h.GetKeyStatus)
+					r.Get("/devices", h.ListDevices)
+					r.Delete("/devices/{deviceID}", h.RevokeDevice)
+				})
+
+				// Presence
+				r.Get("/presence", h.GetPresence)
+
+				// Search by username or group name
+				r.Get("/search", h.Search)
 
-			// Receipts
-			r.Post("/receipts", h.SendReceipt)
+				// Push notifications
+				r.Post("/devices/{deviceID}/push-token", h.RegisterPushToken)
+				r.Delete("/devices/{deviceID}/push-token", h.RemovePushToken)
 
-			// WebSocket
-			r.Get("/ws", h.WebSocketHandler)
+				// Messages
+				r.Route("/messages", func(r chi.Router) {
+					r.Post("/", h.SendMessage)
+					r.Post("/attachment", h.UploadAttachment)
+					r.Get("/attachment/{messageID}/{fileName}", h.DownloadAttachment)
+					r.Get("/{messageID}/attachments/archive", h.DownloadAttachmentArchive)
+					r.Post("/{messageID}/redeliver", h.RedeliverMessage)
+					r.Post("/delete", h.DeleteMessages)
+					r.Put("/{messageID}", h.EditMessage)
+					r.Delete("/{messageID}", h.DeleteMessage)
+					r.Post("/{messageID}/reactions", h.AddReaction)
+					r.Delete("/{messageID}/reactions/{emoji}", h.RemoveReaction)
+					r.Get("/", h.GetMessages)
+				})
+
+				// Receipts
+				r.Post("/receipts", h.SendReceipt)
+				r.Post("/receipts/bulk", h.SendBulkReceipts)
+
+				// WebSocket
+				r.Get("/ws", h.WebSocketHandler)
+			})
+		})
+
+		// Operations
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(authenticate)
+			r.Use(authmiddleware.UserContext)
+			r.Use(authmiddleware.LoadUser(svc.GetUser))
+			r.Use(authmiddleware.RequireAdmin(cfg.AdminUserIDs))
+			r.Get("/hub", h.GetHubStats)
+			r.Get("/maintenance", h.GetMaintenance)
+			r.Put("/maintenance", h.SetMaintenance)
+			r.Post("/invite-codes", h.CreateInviteCode)
+			r.Get("/invite-codes", h.ListInviteCodes)
 		})
 	})
 
+	// Advertise the features whose routes are mounted
+	if err := h.RegisterRoutes(r); err != nil {
+		log.Fatalf("Failed to list routes: %v", err)
+	}
+
 	// Health check
 	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
+		if retryAfter, down := db.Unavailable(); down {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("Database unavailable"))
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	})
@@ -146,14 +330,18 @@ func main() {
 	<-quit
 
 	log.Println("Shutting down server...")
+	stopWorkers()
 
 	// Graceful shutdown with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	if err := server.Shutdown(ctx); err != nil {
+	if err := server.Shutdown(shutdownCtx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Printf("Failed to flush traces: %v", err)
+	}
 
 	log.Println("Server exited")
 }
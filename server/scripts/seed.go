@@ -1,23 +1,23 @@
 package main
 
 import (
-	"fmt"
 	"log"
 	"time"
 
 	"e2ee-messenger/server/internal/database"
 	"e2ee-messenger/server/internal/models"
+	"e2ee-messenger/server/internal/passwordhash"
 
 	"github.com/google/uuid"
 	_ "github.com/lib/pq"
-	"golang.org/x/crypto/argon2"
 )
 
 func hashPassword(password string) string {
-	// Using Argon2id for password hashing
-	salt := []byte("random-salt-change-in-production") // In production, use random salt per user
-	hash := argon2.IDKey([]byte(password), salt, 1, 64*1024, 4, 32)
-	return fmt.Sprintf("%x", hash)
+	hash, err := passwordhash.Hash(password)
+	if err != nil {
+		log.Fatalf("Failed to hash password: %v", err)
+	}
+	return hash
 }
 
 func main() {